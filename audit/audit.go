@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
 )
 
 // Operation represents the type of an entry.
@@ -21,19 +22,56 @@ const (
 	// Required fields: Entity
 	OpPromulgate   Operation = "promulgate"
 	OpUnpromulgate Operation = "unpromulgate"
+
+	// OpDelete represents the deletion of an entity.
+	// Required fields: Entity
+	OpDelete Operation = "delete"
+
+	// OpPublish represents the publishing of an entity to
+	// one or more channels.
+	// Required fields: Entity, Channels
+	// Optional fields: Resources
+	OpPublish Operation = "publish"
+
+	// OpUploadArchive represents the upload of an entity archive.
+	// Required fields: Entity
+	// Optional fields: Channels
+	OpUploadArchive Operation = "upload-archive"
+
+	// OpSetExtraInfo represents the setting or removal of
+	// an extra-info key on an entity.
+	// Required fields: Entity, Channels, Key
+	OpSetExtraInfo Operation = "set-extra-info"
+
+	// OpSetCommonInfo represents the setting or removal of
+	// a common-info key on a base entity.
+	// Required fields: Entity, Channels, Key
+	OpSetCommonInfo Operation = "set-common-info"
+
+	// OpSetWebhooks represents the setting of the webhooks
+	// of a base entity.
+	// Required fields: Entity
+	OpSetWebhooks Operation = "set-webhooks"
+
+	// OpSetFeatured represents the setting of the list
+	// of featured charms and bundles.
+	OpSetFeatured Operation = "set-featured"
 )
 
 // ACL represents an access control list.
 type ACL struct {
-	Read  []string `json:"read,omitempty"`
-	Write []string `json:"write,omitempty"`
+	Read  []string `json:"read,omitempty" bson:"read,omitempty"`
+	Write []string `json:"write,omitempty" bson:"write,omitempty"`
 }
 
 // Entry represents an audit log entry.
 type Entry struct {
-	Time   time.Time  `json:"time"`
-	User   string     `json:"user"`
-	Op     Operation  `json:"op"`
-	Entity *charm.URL `json:"entity,omitempty"`
-	ACL    *ACL       `json:"acl,omitempty"`
+	Time      time.Time        `json:"time" bson:"time"`
+	User      string           `json:"user" bson:"user"`
+	Op        Operation        `json:"op" bson:"op"`
+	Entity    *charm.URL       `json:"entity,omitempty" bson:"entity,omitempty"`
+	ACL       *ACL             `json:"acl,omitempty" bson:"acl,omitempty"`
+	Channels  []params.Channel `json:"channels,omitempty" bson:"channels,omitempty"`
+	Resources map[string]int   `json:"resources,omitempty" bson:"resources,omitempty"`
+	Key       string           `json:"key,omitempty" bson:"key,omitempty"`
 }
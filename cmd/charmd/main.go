@@ -90,18 +90,32 @@ func serve(confPath string) error {
 
 	logger.Infof("setting up the API server")
 	cfg := charmstore.ServerParams{
-		AuthUsername:            conf.AuthUsername,
-		AuthPassword:            conf.AuthPassword,
-		IdentityLocation:        conf.IdentityLocation,
-		IdentityAPIURL:          conf.IdentityAPIURL,
-		TermsLocation:           conf.TermsLocation,
-		AgentUsername:           conf.AgentUsername,
-		AgentKey:                conf.AgentKey,
-		StatsCacheMaxAge:        conf.StatsCacheMaxAge.Duration,
-		MaxMgoSessions:          conf.MaxMgoSessions,
-		HTTPRequestWaitDuration: conf.RequestTimeout.Duration,
-		SearchCacheMaxAge:       conf.SearchCacheMaxAge.Duration,
-		PublicKeyLocator:        keyring,
+		AuthUsername:               conf.AuthUsername,
+		AuthPassword:               conf.AuthPassword,
+		IdentityLocation:           conf.IdentityLocation,
+		IdentityAPIURL:             conf.IdentityAPIURL,
+		TermsLocation:              conf.TermsLocation,
+		AgentUsername:              conf.AgentUsername,
+		AgentKey:                   conf.AgentKey,
+		StatsCacheMaxAge:           conf.StatsCacheMaxAge.Duration,
+		MaxMgoSessions:             conf.MaxMgoSessions,
+		HTTPRequestWaitDuration:    conf.RequestTimeout.Duration,
+		SearchCacheMaxAge:          conf.SearchCacheMaxAge.Duration,
+		TrendingRefreshInterval:    conf.TrendingRefreshInterval.Duration,
+		BlobStorage:                conf.BlobStorage,
+		BlobGCInterval:             conf.BlobGCInterval.Duration,
+		BlobGCGracePeriod:          conf.BlobGCGracePeriod.Duration,
+		BlobGCDryRun:               conf.BlobGCDryRun,
+		BlobScrubInterval:          conf.BlobScrubInterval.Duration,
+		MaxArchiveSize:             conf.MaxArchiveSize,
+		MaxArchiveUncompressedSize: conf.MaxArchiveUncompressedSize,
+		MaxArchiveFiles:            conf.MaxArchiveFiles,
+		MaxArchivePathDepth:        conf.MaxArchivePathDepth,
+		MaxArchiveCompressionRatio: conf.MaxArchiveCompressionRatio,
+		MaxArchiveMetadataSize:     conf.MaxArchiveMetadataSize,
+		MaxOpenUploads:             conf.MaxOpenUploads,
+		MinUploadPartSize:          conf.MinUploadPartSize,
+		PublicKeyLocator:           keyring,
 	}
 
 	if conf.AuditLogFile != "" {
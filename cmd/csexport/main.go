@@ -0,0 +1,218 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csexport command writes selected entities of a charm store,
+// together with their base entities and archive blobs, to a single
+// tar archive that can be loaded into another store with csimport.
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csexport"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+)
+
+var logger = loggo.GetLogger("csexport")
+
+var (
+	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+	owner         = flag.String("owner", "", "only export entities owned by the given user")
+	series        = flag.String("series", "", "only export entities supporting the given series")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path> <output path> [<id>...]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\nWhen no ids are specified, all the entities are exported.\n")
+		fmt.Fprintf(os.Stderr, "An output path of \"-\" writes the archive to the standard output.\n\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0), flag.Arg(1), flag.Args()[2:]); err != nil {
+		logger.Errorf("cannot export charm store: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(confPath, outPath string, ids []string) error {
+	logger.Debugf("reading config file %q", confPath)
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+	if conf.Database != "" {
+		db = session.DB(conf.Database)
+	}
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{
+		BlobStorage: conf.BlobStorage,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+
+	urls, err := selectEntities(store, ids)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var out io.Writer = os.Stdout
+	if outPath != "-" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := storedump.NewWriter(out)
+	var baseURLs []*charm.URL
+	seen := make(map[charm.URL]bool)
+	for _, url := range urls {
+		entity, err := exportEntity(store, w, url)
+		if err != nil {
+			return errgo.Notef(err, "cannot export %v", url)
+		}
+		if !seen[*entity.BaseURL] {
+			seen[*entity.BaseURL] = true
+			baseURLs = append(baseURLs, entity.BaseURL)
+		}
+	}
+	for _, url := range baseURLs {
+		baseEntity, err := store.FindBaseEntity(url, nil)
+		if err != nil {
+			return errgo.Notef(err, "cannot export base entity %v", url)
+		}
+		// Webhooks are specific to the exporting store, and the
+		// secrets they hold should not leak into the archive.
+		baseEntity.Webhooks = nil
+		if err := w.WriteBaseEntity(baseEntity); err != nil {
+			return errgo.Notef(err, "cannot export base entity %v", url)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Infof("exported %d entities and %d base entities", len(urls), len(baseURLs))
+	return nil
+}
+
+// selectEntities returns the ids of the entities to export in
+// upload order. When no ids are given, all the entities that
+// match the -owner and -series flags are selected. Otherwise,
+// each id selects the entities it matches, as for
+// Store.FindEntities.
+func selectEntities(store *charmstore.Store, ids []string) ([]*charm.URL, error) {
+	query := make(bson.D, 0, 3)
+	if *owner != "" {
+		query = append(query, bson.DocElem{"user", *owner})
+	}
+	if *series != "" {
+		query = append(query, bson.DocElem{"supportedseries", *series})
+	}
+	if len(ids) > 0 {
+		var matched []*charm.URL
+		for _, id := range ids {
+			url, err := charm.ParseURL(id)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			var entities []mongodoc.Entity
+			if err := store.EntitiesQuery(url).Select(bson.D{{"_id", 1}}).All(&entities); err != nil {
+				return nil, errgo.Notef(err, "cannot find entities matching %v", url)
+			}
+			if len(entities) == 0 {
+				return nil, errgo.Newf("no entities found matching %v", url)
+			}
+			for _, e := range entities {
+				matched = append(matched, e.URL)
+			}
+		}
+		query = append(query, bson.DocElem{"_id", bson.D{{"$in", matched}}})
+	}
+	var entities []mongodoc.Entity
+	if err := store.DB.Entities().Find(query).Select(bson.D{{"_id", 1}}).Sort("uploadtime", "_id").All(&entities); err != nil {
+		return nil, errgo.Notef(err, "cannot select entities")
+	}
+	urls := make([]*charm.URL, len(entities))
+	for i, e := range entities {
+		urls[i] = e.URL
+	}
+	return urls, nil
+}
+
+// exportEntity writes the entity with the given id and its blobs
+// to the given dump writer, and returns the entity.
+func exportEntity(store *charmstore.Store, w *storedump.Writer, url *charm.URL) (*mongodoc.Entity, error) {
+	var entity mongodoc.Entity
+	if err := store.DB.Entities().FindId(url).One(&entity); err != nil {
+		return nil, errgo.Notef(err, "cannot find entity")
+	}
+	id := charmstore.EntityResolvedURL(&entity)
+	blob, err := store.OpenBlob(id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer blob.Close()
+	var suffix []byte
+	if entity.PreV5BlobHash != entity.BlobHash {
+		suffix, err = readPreV5Suffix(store, &entity)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	if err := w.WriteEntity(&entity, blob, suffix); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	logger.Debugf("exported %v", url)
+	return &entity, nil
+}
+
+// readPreV5Suffix returns the data appended to the archive blob of
+// the given entity to make its pre-v5 blob.
+func readPreV5Suffix(store *charmstore.Store, entity *mongodoc.Entity) ([]byte, error) {
+	blob, err := store.OpenBlobPreV5(charmstore.EntityResolvedURL(entity))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer blob.Close()
+	if _, err := blob.Seek(entity.Size, 0); err != nil {
+		return nil, errgo.Notef(err, "cannot seek to pre-v5 suffix")
+	}
+	suffix, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read pre-v5 suffix")
+	}
+	return suffix, nil
+}
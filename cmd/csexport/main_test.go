@@ -0,0 +1,76 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csexport"
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jujutesting "github.com/juju/testing"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type csexportSuite struct {
+	jujutesting.IsolatedMgoSuite
+}
+
+var _ = gc.Suite(&csexportSuite{})
+
+func (s *csexportSuite) TestExportFromBlobStorage(c *gc.C) {
+	blobDir := c.MkDir()
+	pool, err := charmstore.NewPool(s.Session.DB("csexport_test"), nil, nil, charmstore.ServerParams{
+		BlobStorage: "file://" + blobDir,
+	})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err = store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	// The blob is only held in the configured blob storage.
+	n, err := store.DB.C("entitystore.files").Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+
+	dir := c.MkDir()
+	confPath := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(confPath, []byte(fmt.Sprintf(`
+mongo-url: %s
+api-addr: localhost:0
+auth-username: admin
+auth-password: password
+database: csexport_test
+blob-storage: file://%s
+`, jujutesting.MgoServer.Addr(), blobDir)), 0600)
+	c.Assert(err, gc.IsNil)
+	outPath := filepath.Join(dir, "dump")
+	err = run(confPath, outPath, nil)
+	c.Assert(err, gc.IsNil)
+
+	f, err := os.Open(outPath)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	r := storedump.NewReader(f)
+	item, err := r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.Entity.URL, gc.DeepEquals, charm.MustParseURL("~charmers/precise/wordpress-0"))
+	err = item.VerifyArchive()
+	c.Assert(err, gc.IsNil)
+	item, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.BaseEntity.URL, gc.DeepEquals, charm.MustParseURL("~charmers/wordpress"))
+	_, err = r.Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
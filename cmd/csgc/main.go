@@ -0,0 +1,98 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csgc command finds blobs in the charm store blob storage that
+// are not referenced by any entity or resource, and removes them.
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csgc"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+var logger = loggo.GetLogger("csgc")
+
+var (
+	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+	dryRun        = flag.Bool("dry-run", false, "Report orphaned blobs without removing them.")
+	gracePeriod   = flag.Duration("grace-period", charmstore.DefaultBlobGCGracePeriod, "Minimum age of the orphaned blobs to remove, so that blobs of uploads in progress are left alone.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := collect(flag.Arg(0)); err != nil {
+		logger.Errorf("cannot collect blob garbage: %v", err)
+		os.Exit(1)
+	}
+}
+
+func collect(confPath string) error {
+	if *gracePeriod <= 0 {
+		return errgo.Newf("grace period must be positive")
+	}
+	logger.Debugf("reading config file %q", confPath)
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	dbName := "juju"
+	if conf.Database != "" {
+		dbName = conf.Database
+	}
+	db := session.DB(dbName)
+
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{
+		BlobStorage: conf.BlobStorage,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	result, err := store.CollectBlobGarbage(charmstore.BlobGCParams{
+		GracePeriod: *gracePeriod,
+		DryRun:      *dryRun,
+	})
+	if result != nil {
+		for _, name := range result.Orphans {
+			fmt.Println(name)
+		}
+		for _, name := range result.Unrecognised {
+			logger.Warningf("unreferenced blob %s has an unrecognised name; not removing it", name)
+		}
+		logger.Infof("%d orphaned blobs found, %d removed, %d recent unreferenced blobs left alone", len(result.Orphans), result.Removed, result.Recent)
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -67,9 +67,14 @@ func run(confPath string) error {
 	}
 	defer session.Close()
 	db := session.DB("juju")
+	if conf.Database != "" {
+		db = session.DB(conf.Database)
+	}
 
 	logger.Infof("instantiating the store")
-	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{})
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{
+		BlobStorage: conf.BlobStorage,
+	})
 	if err != nil {
 		return errgo.Notef(err, "cannot create a new store")
 	}
@@ -0,0 +1,226 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csimport command loads an archive written by csexport into a
+// charm store. Archive blobs are checked against the hashes recorded
+// in the archive and each entity is added through the same path as an
+// upload, so that the usual validation applies. Imported entities are
+// added to the search index configured for the charm store.
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csimport"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+)
+
+var logger = loggo.GetLogger("csimport")
+
+var (
+	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path> <archive path>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\nAn archive path of \"-\" reads the archive from the standard input.\n\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		logger.Errorf("cannot import charm store archive: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(confPath, archivePath string) error {
+	logger.Debugf("reading config file %q", confPath)
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+	if conf.Database != "" {
+		db = session.DB(conf.Database)
+	}
+	// Imported entities are indexed in the same search index
+	// as the one used by the charm store server.
+	si := &charmstore.SearchIndex{
+		Index: "cs",
+	}
+	if conf.ESAddr != "" {
+		si.Database = &elasticsearch.Database{
+			Addr: conf.ESAddr,
+		}
+	} else {
+		si.Backend = charmstore.NewMongoSearchBackend(db)
+	}
+	pool, err := charmstore.NewPool(db, si, nil, charmstore.ServerParams{
+		BlobStorage: conf.BlobStorage,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+
+	var in io.Reader = os.Stdin
+	if archivePath != "-" {
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r := storedump.NewReader(in)
+	var numEntities, numBaseEntities int
+	for {
+		item, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if item.Entity != nil {
+			if err := importEntity(store, item); err != nil {
+				return errgo.Notef(err, "cannot import %v", item.Entity.URL)
+			}
+			numEntities++
+			continue
+		}
+		if err := importBaseEntity(store, item.BaseEntity); err != nil {
+			return errgo.Notef(err, "cannot import base entity %v", item.BaseEntity.URL)
+		}
+		numBaseEntities++
+	}
+	logger.Infof("imported %d entities and %d base entities", numEntities, numBaseEntities)
+	return nil
+}
+
+// importEntity uploads the entity in the given item to the store and
+// restores its published state, extra information and upload time.
+// Entities that already exist in the store are left unchanged.
+func importEntity(store *charmstore.Store, item *storedump.Item) error {
+	e := item.Entity
+	id := charmstore.EntityResolvedURL(e)
+	err := store.UploadEntity(id, item.Archive, e.BlobHash, e.Size, nil)
+	if errgo.Cause(err) == params.ErrDuplicateUpload {
+		logger.Infof("%v already exists, skipping", id)
+		return nil
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := verifyImportedEntity(store, item); err != nil {
+		if err := store.DeleteEntity(id); err != nil {
+			logger.Errorf("cannot remove %v after failed verification: %v", id, err)
+		}
+		return errgo.Mask(err)
+	}
+	set := bson.D{
+		{"development", e.Development},
+		{"stable", e.Stable},
+		{"uploadtime", e.UploadTime},
+	}
+	if len(e.ExtraInfo) > 0 {
+		set = append(set, bson.DocElem{"extrainfo", e.ExtraInfo})
+	}
+	if err := store.UpdateEntity(id, bson.D{{"$set", set}}); err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Debugf("imported %v", id)
+	return nil
+}
+
+// verifyImportedEntity checks that the archive read from the dump
+// matches the exported entity, and that the store has made the same
+// pre-v5 blob from it.
+func verifyImportedEntity(store *charmstore.Store, item *storedump.Item) error {
+	if err := item.VerifyArchive(); err != nil {
+		return errgo.Mask(err)
+	}
+	e := item.Entity
+	imported, err := store.FindEntity(charmstore.EntityResolvedURL(e), charmstore.FieldSelector("prev5blobhash"))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if imported.PreV5BlobHash != e.PreV5BlobHash {
+		return errgo.Newf("pre-v5 blob differs from the exported one (hash %s, expected %s)", imported.PreV5BlobHash, e.PreV5BlobHash)
+	}
+	return nil
+}
+
+// importBaseEntity restores the permissions, common information,
+// channel pointers and promulgation state of the given base entity,
+// which must have been created when importing its entities. Channel
+// pointers to entities that do not exist in the store are ignored.
+func importBaseEntity(store *charmstore.Store, b *mongodoc.BaseEntity) error {
+	id := &router.ResolvedURL{
+		URL:                 *b.URL,
+		PromulgatedRevision: -1,
+	}
+	set := bson.D{{"channelacls", b.ChannelACLs}}
+	if len(b.CommonInfo) > 0 {
+		set = append(set, bson.DocElem{"commoninfo", b.CommonInfo})
+	}
+	for ch, entities := range b.ChannelEntities {
+		for series, url := range entities {
+			n, err := store.DB.Entities().FindId(url).Count()
+			if err != nil {
+				return errgo.Notef(err, "cannot check for %v", url)
+			}
+			if n == 0 {
+				logger.Warningf("not setting %s/%s entity of %v to %v: entity not found", ch, series, b.URL, url)
+				continue
+			}
+			set = append(set, bson.DocElem{fmt.Sprintf("channelentities.%s.%s", ch, series), url})
+		}
+	}
+	if err := store.UpdateBaseEntity(id, bson.D{{"$set", set}}); err != nil {
+		return errgo.Mask(err)
+	}
+	if b.Promulgated {
+		if err := store.SetPromulgated(id, true); err != nil {
+			return errgo.Notef(err, "cannot promulgate")
+		}
+	}
+	// Base entities follow their entities in the archive, so the
+	// search index can be updated once the channels are restored.
+	if err := store.UpdateSearchBaseURL(b.URL); err != nil && errgo.Cause(err) != params.ErrNotFound {
+		return errgo.Notef(err, "cannot update search index")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csimport"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type csimportSuite struct {
+	jujutesting.IsolatedMgoSuite
+}
+
+var _ = gc.Suite(&csimportSuite{})
+
+func (s *csimportSuite) TestImportToBlobStorage(c *gc.C) {
+	dir := c.MkDir()
+	archivePath := filepath.Join(dir, "dump")
+	s.writeDump(c, archivePath, "~charmers/precise/wordpress-0", "wordpress")
+
+	blobDir := c.MkDir()
+	confPath := filepath.Join(dir, "config.yaml")
+	err := ioutil.WriteFile(confPath, []byte(fmt.Sprintf(`
+mongo-url: %s
+api-addr: localhost:0
+auth-username: admin
+auth-password: password
+database: csimport_test
+blob-storage: file://%s
+`, jujutesting.MgoServer.Addr(), blobDir)), 0600)
+	c.Assert(err, gc.IsNil)
+	err = run(confPath, archivePath)
+	c.Assert(err, gc.IsNil)
+
+	// The blob has been written to the configured blob storage
+	// rather than to GridFS.
+	files, err := ioutil.ReadDir(blobDir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(files) > 0, gc.Equals, true)
+	db := s.Session.DB("csimport_test")
+	n, err := db.C("entitystore.files").Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+
+	pool, err := charmstore.NewPool(db, &charmstore.SearchIndex{
+		Backend: charmstore.NewMongoSearchBackend(db),
+		Index:   "cs",
+	}, nil, charmstore.ServerParams{
+		BlobStorage: "file://" + blobDir,
+	})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	blob, err := store.OpenBlob(id)
+	c.Assert(err, gc.IsNil)
+	blob.Close()
+
+	// The stable entity has been indexed for search.
+	doc, err := store.ES.GetSearchDocument(&id.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.URL, jc.DeepEquals, &id.URL)
+}
+
+// writeDump writes a dump holding the charm with the given id,
+// published to the stable channel, and its base entity to the
+// given path. The charm is taken from a store
+// using GridFS.
+func (s *csimportSuite) writeDump(c *gc.C, path, id, name string) {
+	pool, err := charmstore.NewPool(s.Session.DB("csimport_source"), nil, nil, charmstore.ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	rurl := router.MustNewResolvedURL(id, -1)
+	err = store.AddCharmWithArchive(rurl, storetesting.Charms.CharmDir(name))
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(rurl, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+
+	f, err := os.Create(path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	w := storedump.NewWriter(f)
+	var entity mongodoc.Entity
+	err = store.DB.Entities().FindId(&rurl.URL).One(&entity)
+	c.Assert(err, gc.IsNil)
+	blob, err := store.OpenBlob(rurl)
+	c.Assert(err, gc.IsNil)
+	defer blob.Close()
+	err = w.WriteEntity(&entity, blob, nil)
+	c.Assert(err, gc.IsNil)
+	baseEntity, err := store.FindBaseEntity(entity.BaseURL, nil)
+	c.Assert(err, gc.IsNil)
+	err = w.WriteBaseEntity(baseEntity)
+	c.Assert(err, gc.IsNil)
+	err = w.Close()
+	c.Assert(err, gc.IsNil)
+}
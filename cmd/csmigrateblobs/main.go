@@ -0,0 +1,85 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csmigrateblobs command copies the blobs held in MongoDB GridFS
+// to the blob storage configured in the charm store configuration
+// file. It can be run while the charm store is serving, because blobs
+// that have not yet been copied are read from GridFS.
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csmigrateblobs"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+)
+
+var logger = loggo.GetLogger("csmigrateblobs")
+
+var (
+	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+	removeGridFS  = flag.Bool("remove-gridfs", false, "Remove each blob from GridFS once it has been copied.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := migrate(flag.Arg(0)); err != nil {
+		logger.Errorf("cannot migrate blobs: %v", err)
+		os.Exit(1)
+	}
+}
+
+func migrate(confPath string) error {
+	logger.Debugf("reading config file %q", confPath)
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	if conf.BlobStorage == "" {
+		return errgo.Newf("no blob-storage specified in config file %q", confPath)
+	}
+	backend, err := blobstore.NewBackend(conf.BlobStorage)
+	if err != nil {
+		return errgo.Notef(err, "cannot make blob storage backend")
+	}
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+	if conf.Database != "" {
+		db = session.DB(conf.Database)
+	}
+	n, err := blobstore.Migrate(db, blobstore.MigrateParams{
+		From:         blobstore.NewGridFSBackend(db, "entitystore"),
+		To:           backend,
+		RemoveSource: *removeGridFS,
+	})
+	if err != nil {
+		return errgo.Notef(err, "migration stopped after %d blobs", n)
+	}
+	logger.Infof("copied %d blobs to the configured blob storage", n)
+	return nil
+}
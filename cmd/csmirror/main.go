@@ -0,0 +1,195 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The csmirror command replicates the entities held in one charm store
+// into another one. It reads the source store's Mongo database
+// directly and uploads each entity into the destination store, so
+// that the usual validation applies to the copied archives. The
+// base entity permissions, channel pointers and promulgation state
+// are then brought in line with the source.
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csmirror"
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mirror"
+)
+
+var logger = loggo.GetLogger("csmirror")
+
+var (
+	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+	since         = flag.String("since", "", "only upload entities and resources uploaded after the given time (RFC3339); the state of existing base entities is always synchronised")
+	stateFile     = flag.String("state", "", "file holding the upload times of the last mirrored entity and resource revision, used when -since is not specified and updated after a successful run")
+	owner         = flag.String("owner", "", "only mirror entities owned by the given user")
+	series        = flag.String("series", "", "only mirror entities supporting the given series")
+	dryRun        = flag.Bool("n", false, "only log the changes that would be made")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <source config path> <destination config path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		logger.Errorf("cannot mirror charm store: %v", err)
+		os.Exit(1)
+	}
+}
+
+func run(srcConfPath, dstConfPath string) error {
+	after, resourcesAfter, err := sinceTimes()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	src, closeSrc, err := openStore(srcConfPath, false)
+	if err != nil {
+		return errgo.Notef(err, "cannot open source store")
+	}
+	defer closeSrc()
+	dst, closeDst, err := openStore(dstConfPath, true)
+	if err != nil {
+		return errgo.Notef(err, "cannot open destination store")
+	}
+	defer closeDst()
+
+	result, err := mirror.Run(mirror.Params{
+		Source:         src,
+		Dest:           dst,
+		Since:          after,
+		ResourcesSince: resourcesAfter,
+		Owner:          *owner,
+		Series:         *series,
+		DryRun:         *dryRun,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Infof("mirrored %d entities and %d resource revisions; updated %d base entities", result.Entities, result.Resources, result.BaseEntities)
+	if *stateFile != "" && !*dryRun && (result.LastUploadTime.After(after) || result.LastResourceUploadTime.After(resourcesAfter)) {
+		if err := writeState(*stateFile, result.LastUploadTime, result.LastResourceUploadTime); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// sinceTimes returns the upload times after which entities and
+// resource revisions respectively should be mirrored, as specified
+// by the -since and -state flags. The state file holds the entity
+// upload time on its first line and the resource upload time on its
+// second. A state file holding a single time, as written by earlier
+// versions, uses that time for both.
+func sinceTimes() (entities, resources time.Time, err error) {
+	if *since != "" {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			return time.Time{}, time.Time{}, errgo.Notef(err, "invalid -since value")
+		}
+		return t, t, nil
+	}
+	if *stateFile == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	data, err := ioutil.ReadFile(*stateFile)
+	if os.IsNotExist(err) {
+		return time.Time{}, time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, errgo.Notef(err, "cannot read state file")
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 || len(lines) > 2 {
+		return time.Time{}, time.Time{}, errgo.Newf("invalid state file %q", *stateFile)
+	}
+	times := make([]time.Time, len(lines))
+	for i, line := range lines {
+		times[i], err = time.Parse(time.RFC3339Nano, line)
+		if err != nil {
+			return time.Time{}, time.Time{}, errgo.Notef(err, "invalid time in state file %q", *stateFile)
+		}
+	}
+	return times[0], times[len(times)-1], nil
+}
+
+// writeState records the given entity and resource
+// upload times in the given state file.
+func writeState(path string, entities, resources time.Time) error {
+	data := entities.UTC().Format(time.RFC3339Nano) + "\n" + resources.UTC().Format(time.RFC3339Nano) + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+		return errgo.Notef(err, "cannot write state file")
+	}
+	return nil
+}
+
+// openStore opens the store whose database is described by the
+// configuration file at the given path. If search is true, the store
+// updates the search index used by the charm store server with that
+// configuration. The returned function must be called to release the
+// store's resources.
+func openStore(confPath string, search bool) (*charmstore.Store, func(), error) {
+	logger.Debugf("reading config file %q", confPath)
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	dbName := "juju"
+	if conf.Database != "" {
+		dbName = conf.Database
+	}
+	db := session.DB(dbName)
+	var si *charmstore.SearchIndex
+	if search {
+		si = &charmstore.SearchIndex{
+			Index: "cs",
+		}
+		if conf.ESAddr != "" {
+			si.Database = &elasticsearch.Database{
+				Addr: conf.ESAddr,
+			}
+		} else {
+			si.Backend = charmstore.NewMongoSearchBackend(db)
+		}
+	}
+	pool, err := charmstore.NewPool(db, si, nil, charmstore.ServerParams{
+		BlobStorage: conf.BlobStorage,
+	})
+	if err != nil {
+		session.Close()
+		return nil, nil, errgo.Notef(err, "cannot create a new store")
+	}
+	store := pool.Store()
+	return store, func() {
+		store.Close()
+		pool.Close()
+		session.Close()
+	}, nil
+}
@@ -21,10 +21,15 @@ import (
 var logger = loggo.GetLogger("essync")
 
 var (
-	index         = flag.String("index", "cs", "Name of index to populate.")
-	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
-	mapping       = flag.String("mapping", "", "No longer used.")
-	settings      = flag.String("settings", "", "No longer used.")
+	index            = flag.String("index", "cs", "Name of index to populate.")
+	loggingConfig    = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
+	mapping          = flag.String("mapping", "", "No longer used.")
+	settings         = flag.String("settings", "", "No longer used.")
+	batchSize        = flag.Int("batch-size", charmstore.DefaultSyncSearchBatchSize, "Number of base entities to index in each bulk request.")
+	concurrency      = flag.Int("concurrency", charmstore.DefaultSyncSearchConcurrency, "Number of bulk requests to run concurrently.")
+	progressInterval = flag.Duration("progress-interval", charmstore.DefaultSyncSearchProgressInterval, "Minimum interval between progress reports.")
+	resume           = flag.Bool("resume", false, "Resume an interrupted synchronisation from its last checkpoint instead of recreating the index.")
+	reindex          = flag.Bool("reindex", false, "Build a new index while the current one stays in use, then switch to it once its document count is verified. The previous index is kept for rollback.")
 )
 
 func main() {
@@ -77,7 +82,26 @@ func populate(confPath string) error {
 	}
 	store := pool.Store()
 	defer store.Close()
-	if err := store.SynchroniseElasticsearch(); err != nil {
+	p := charmstore.SyncSearchParams{
+		BatchSize:        *batchSize,
+		Concurrency:      *concurrency,
+		ProgressInterval: *progressInterval,
+		Resume:           *resume,
+	}
+	if *reindex {
+		if *resume {
+			return errgo.New("cannot use -resume with -reindex")
+		}
+		previous, err := store.ReindexElasticsearch(p)
+		if previous != "" {
+			logger.Infof("previous index %q kept; to roll back, point the %q alias at it again", previous, *index)
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot reindex elasticsearch")
+		}
+		return nil
+	}
+	if err := store.SynchroniseElasticsearch(p); err != nil {
 		return errgo.Notef(err, "cannot synchronise elasticsearch")
 	}
 	return nil
@@ -26,7 +26,7 @@ type Config struct {
 	APIAddr           string            `yaml:"api-addr,omitempty"`
 	AuthUsername      string            `yaml:"auth-username,omitempty"`
 	AuthPassword      string            `yaml:"auth-password,omitempty"`
-	ESAddr            string            `yaml:"elasticsearch-addr,omitempty"` // elasticsearch is optional
+	ESAddr            string            `yaml:"elasticsearch-addr,omitempty"` // elasticsearch is optional; without it, search uses MongoDB
 	IdentityPublicKey *bakery.PublicKey `yaml:"identity-public-key,omitempty"`
 	IdentityLocation  string            `yaml:"identity-location"`
 	TermsPublicKey    *bakery.PublicKey `yaml:"terms-public-key,omitempty"`
@@ -40,6 +40,34 @@ type Config struct {
 	StatsCacheMaxAge  DurationString  `yaml:"stats-cache-max-age,omitempty"`
 	SearchCacheMaxAge DurationString  `yaml:"search-cache-max-age,omitempty"`
 	Database          string          `yaml:"database,omitempty"`
+	// TrendingRefreshInterval holds the interval between refreshes of
+	// the recent download counts used to rank search results.
+	TrendingRefreshInterval DurationString `yaml:"trending-refresh-interval,omitempty"`
+	// BlobStorage holds the location of the storage that holds blob
+	// content. If it is empty, blobs are held in MongoDB GridFS.
+	BlobStorage string `yaml:"blob-storage,omitempty"`
+	// BlobGCInterval holds the interval between runs of the orphaned
+	// blob garbage collector. If it is zero, the collector is not run.
+	BlobGCInterval    DurationString `yaml:"blob-gc-interval,omitempty"`
+	BlobGCGracePeriod DurationString `yaml:"blob-gc-grace-period,omitempty"`
+	BlobGCDryRun      bool           `yaml:"blob-gc-dry-run,omitempty"`
+	// BlobScrubInterval holds the interval between runs of the blob
+	// integrity scrubber. If it is zero, the scrubber is not run.
+	BlobScrubInterval DurationString `yaml:"blob-scrub-interval,omitempty"`
+	// The following fields hold limits on uploaded archives. Zero
+	// values select the server defaults and negative values disable
+	// the corresponding limit. Sizes are in bytes.
+	MaxArchiveSize             int64 `yaml:"max-archive-size,omitempty"`
+	MaxArchiveUncompressedSize int64 `yaml:"max-archive-uncompressed-size,omitempty"`
+	MaxArchiveFiles            int   `yaml:"max-archive-files,omitempty"`
+	MaxArchivePathDepth        int   `yaml:"max-archive-path-depth,omitempty"`
+	MaxArchiveCompressionRatio int   `yaml:"max-archive-compression-ratio,omitempty"`
+	MaxArchiveMetadataSize     int64 `yaml:"max-archive-metadata-size,omitempty"`
+	// The following fields hold limits on chunked uploads. Zero
+	// values select the server defaults and negative values disable
+	// the corresponding limit.
+	MaxOpenUploads    int   `yaml:"max-open-uploads,omitempty"`
+	MinUploadPartSize int64 `yaml:"min-upload-part-size,omitempty"`
 }
 
 func (c *Config) validate() error {
@@ -48,6 +48,20 @@ agent-key:
   public: +qNbDWly3kRTDVv2UN03hrv/CBt4W6nxY5dHdw+KJFA=
 stats-cache-max-age: 1h
 search-cache-max-age: 15m
+trending-refresh-interval: 2h
+blob-storage: file:///var/lib/charmstore/blobs
+blob-gc-interval: 12h
+blob-gc-grace-period: 48h
+blob-gc-dry-run: true
+blob-scrub-interval: 168h
+max-archive-size: 104857600
+max-archive-uncompressed-size: 524288000
+max-archive-files: 1000
+max-archive-path-depth: 16
+max-archive-compression-ratio: -1
+max-archive-metadata-size: 65536
+max-open-uploads: 5
+min-upload-part-size: 1048576
 request-timeout: 500ms
 max-mgo-sessions: 10
 `
@@ -91,10 +105,24 @@ func (s *ConfigSuite) TestRead(c *gc.C) {
 				mustParseKey("lsvcDkapKoFxIyjX9/eQgb3s41KVwPMISFwAJdVCZ70="),
 			},
 		},
-		StatsCacheMaxAge:  config.DurationString{time.Hour},
-		RequestTimeout:    config.DurationString{500 * time.Millisecond},
-		MaxMgoSessions:    10,
-		SearchCacheMaxAge: config.DurationString{15 * time.Minute},
+		StatsCacheMaxAge:           config.DurationString{time.Hour},
+		RequestTimeout:             config.DurationString{500 * time.Millisecond},
+		MaxMgoSessions:             10,
+		SearchCacheMaxAge:          config.DurationString{15 * time.Minute},
+		TrendingRefreshInterval:    config.DurationString{2 * time.Hour},
+		BlobStorage:                "file:///var/lib/charmstore/blobs",
+		BlobGCInterval:             config.DurationString{12 * time.Hour},
+		BlobGCGracePeriod:          config.DurationString{48 * time.Hour},
+		BlobGCDryRun:               true,
+		BlobScrubInterval:          config.DurationString{168 * time.Hour},
+		MaxArchiveSize:             100 << 20,
+		MaxArchiveUncompressedSize: 500 << 20,
+		MaxArchiveFiles:            1000,
+		MaxArchivePathDepth:        16,
+		MaxArchiveCompressionRatio: -1,
+		MaxArchiveMetadataSize:     64 << 10,
+		MaxOpenUploads:             5,
+		MinUploadPartSize:          1 << 20,
 	})
 }
 
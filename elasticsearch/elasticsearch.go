@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/juju/loggo"
@@ -121,6 +122,126 @@ func (db *Database) Alias(i, a string) error {
 	return nil
 }
 
+// BulkIndex holds a document to be indexed as part of a bulk request.
+// If VersionType is not empty, Version holds the version of the
+// document, as for PutDocumentVersionWithType.
+type BulkIndex struct {
+	Index       string
+	Type        string
+	Id          string
+	Version     int64
+	VersionType string
+	Doc         interface{}
+}
+
+// bulkMetadata holds the action metadata line sent for each document
+// in a bulk request.
+type bulkMetadata struct {
+	Index       string `json:"_index"`
+	Type        string `json:"_type"`
+	Id          string `json:"_id"`
+	Version     *int64 `json:"_version,omitempty"`
+	VersionType string `json:"_version_type,omitempty"`
+}
+
+// BulkItem holds the result of one operation in a bulk request.
+type BulkItem struct {
+	Index   string          `json:"_index"`
+	Type    string          `json:"_type"`
+	Id      string          `json:"_id"`
+	Version int64           `json:"_version"`
+	Status  int             `json:"status"`
+	Error   json.RawMessage `json:"error"`
+}
+
+// Err returns the error that caused the operation to fail, or nil if it
+// succeeded. As for PutDocumentVersionWithType, ErrConflict is returned
+// if the document could not be stored due to a version mismatch.
+func (item BulkItem) Err() error {
+	if item.Status < 300 {
+		return nil
+	}
+	if item.Status == http.StatusConflict {
+		return ErrConflict
+	}
+	// Depending on the version of elasticsearch, the error is
+	// either a string or an object describing the error.
+	var msg string
+	if err := json.Unmarshal(item.Error, &msg); err != nil {
+		msg = string(item.Error)
+	}
+	return &ElasticSearchError{
+		Err:    msg,
+		Status: item.Status,
+	}
+}
+
+// Bulk indexes all the given documents in a single request and
+// returns the result of each operation in the same order. An error is
+// returned only if the request as a whole fails; the failure of an
+// individual operation is reported by the Err method of its item. See
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+// for further details.
+func (db *Database) Bulk(ops []BulkIndex) ([]BulkItem, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, op := range ops {
+		meta := bulkMetadata{
+			Index: op.Index,
+			Type:  op.Type,
+			Id:    op.Id,
+		}
+		if op.VersionType != "" {
+			version := op.Version
+			meta.Version = &version
+			meta.VersionType = op.VersionType
+		}
+		// Encode terminates each value with a newline, as
+		// required by the bulk API.
+		if err := enc.Encode(map[string]bulkMetadata{"index": meta}); err != nil {
+			return nil, errgo.Notef(err, "cannot marshal bulk action for %q", op.Id)
+		}
+		if err := enc.Encode(op.Doc); err != nil {
+			return nil, errgo.Notef(err, "cannot marshal document %q", op.Id)
+		}
+	}
+	var resp struct {
+		Items []map[string]BulkItem `json:"items"`
+	}
+	if err := db.doReader("POST", db.url("_bulk"), "application/x-ndjson", &buf, &resp); err != nil {
+		return nil, errgo.Notef(getError(err), "bulk request failed")
+	}
+	if len(resp.Items) != len(ops) {
+		return nil, errgo.Newf("bulk request returned %d results for %d operations", len(resp.Items), len(ops))
+	}
+	items := make([]BulkItem, len(resp.Items))
+	for i, item := range resp.Items {
+		// Each item holds a single result keyed by the name
+		// of the action.
+		for _, result := range item {
+			items[i] = result
+		}
+	}
+	return items, nil
+}
+
+// Count returns the number of documents in index/type_. If type_ is
+// empty, all the documents in the index are counted. See
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-count.html
+// for further details.
+func (db *Database) Count(index, type_ string) (int64, error) {
+	var resp struct {
+		Count int64 `json:"count"`
+	}
+	if err := db.get(db.url(index, type_, "_count"), nil, &resp); err != nil {
+		return 0, getError(err)
+	}
+	return resp.Count, nil
+}
+
 // Create document attempts to create a new document at index/type_/id with the
 // contents in doc. If the document already exists then CreateDocument will return
 // ErrConflict and return a non-nil error if any other error occurs.
@@ -337,23 +458,30 @@ func (db *Database) Search(index, type_ string, q QueryDSL) (SearchResult, error
 // marshaled as a json object and sent with the request. If v is non nil the response
 // body will be unmarshalled into the value it points to.
 func (db *Database) do(method, url string, body, v interface{}) error {
-	log.Debugf(">>> %s %s", method, url)
-	var r io.Reader
-	if body != nil {
-		b, err := json.Marshal(body)
-		if err != nil {
-			return errgo.Notef(err, "can not marshaling body")
-		}
-		log.Debugf(">>> %s", b)
-		r = bytes.NewReader(b)
+	if body == nil {
+		return db.doReader(method, url, "", nil, v)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errgo.Notef(err, "can not marshaling body")
 	}
-	req, err := http.NewRequest(method, url, r)
+	log.Debugf(">>> %s", b)
+	return db.doReader(method, url, "application/json", bytes.NewReader(b), v)
+}
+
+// doReader performs a request on the elasticsearch server. If body is
+// not nil it will be sent with the request with the given content type.
+// If v is non nil the response body will be unmarshalled into the
+// value it points to.
+func (db *Database) doReader(method, url, contentType string, body io.Reader, v interface{}) error {
+	log.Debugf(">>> %s %s", method, url)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		log.Debugf("*** %s", err)
 		return errgo.Notef(err, "cannot create request")
 	}
 	if body != nil {
-		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Content-Type", contentType)
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -432,10 +560,77 @@ type SearchResult struct {
 		MaxScore float64 `json:"max_score"`
 		Hits     []Hit   `json:"hits"`
 	} `json:"hits"`
-	Took     int  `json:"took"`
-	TimedOut bool `json:"timed_out"`
+	Took         int                          `json:"took"`
+	TimedOut     bool                         `json:"timed_out"`
+	Aggregations map[string]AggregationResult `json:"aggregations"`
+	Suggest      map[string][]SuggestEntry    `json:"suggest"`
+}
+
+// SuggestEntry holds the suggestions made by a suggester for one
+// term of the suggested text.
+type SuggestEntry struct {
+	Text    string          `json:"text"`
+	Offset  int             `json:"offset"`
+	Length  int             `json:"length"`
+	Options []SuggestOption `json:"options"`
+}
+
+// SuggestOption holds a single suggestion, most likely first.
+type SuggestOption struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+	Freq  int     `json:"freq"`
 }
 
+// AggregationResult holds the result of a bucket aggregation
+// requested in a search.
+type AggregationResult struct {
+	Buckets []Bucket
+}
+
+// Bucket holds a bucket returned from a bucket aggregation. For a
+// FiltersAggregation, the key holds the name of the filter.
+type Bucket struct {
+	Key      string `json:"key"`
+	DocCount int    `json:"doc_count"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Buckets may be returned
+// either as an array, as for a TermsAggregation, or as an object keyed
+// by bucket name, as for a FiltersAggregation; in the latter case, the
+// buckets are sorted by key.
+func (r *AggregationResult) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Buckets json.RawMessage `json:"buckets"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.Buckets = nil
+	if len(raw.Buckets) == 0 {
+		return nil
+	}
+	if raw.Buckets[0] != '{' {
+		return json.Unmarshal(raw.Buckets, &r.Buckets)
+	}
+	var keyed map[string]Bucket
+	if err := json.Unmarshal(raw.Buckets, &keyed); err != nil {
+		return err
+	}
+	for k, b := range keyed {
+		b.Key = k
+		r.Buckets = append(r.Buckets, b)
+	}
+	sort.Sort(bucketsByKey(r.Buckets))
+	return nil
+}
+
+type bucketsByKey []Bucket
+
+func (b bucketsByKey) Len() int           { return len(b) }
+func (b bucketsByKey) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b bucketsByKey) Less(i, j int) bool { return b[i].Key < b[j].Key }
+
 // Hit represents an individual search hit returned from elasticsearch
 type Hit struct {
 	Index  string          `json:"_index"`
@@ -189,6 +189,71 @@ func (s *Suite) TestPutVersionWithTypeUpdateEarlierDocumentVersion(c *gc.C) {
 	c.Assert(exists, gc.Equals, true)
 }
 
+func (s *Suite) TestBulk(c *gc.C) {
+	err := s.ES.PutDocumentVersionWithType(s.TestIndex, "testtype", "c", 3, es.ExternalGTE, map[string]string{"a": "c"})
+	c.Assert(err, gc.IsNil)
+	items, err := s.ES.Bulk([]es.BulkIndex{{
+		Index: s.TestIndex,
+		Type:  "testtype",
+		Id:    "a",
+		Doc:   map[string]string{"a": "a1"},
+	}, {
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		Id:          "b",
+		Version:     0,
+		VersionType: es.ExternalGTE,
+		Doc:         map[string]string{"a": "b1"},
+	}, {
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		Id:          "c",
+		Version:     1,
+		VersionType: es.ExternalGTE,
+		Doc:         map[string]string{"a": "c1"},
+	}})
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.HasLen, 3)
+	c.Assert(items[0].Id, gc.Equals, "a")
+	c.Assert(items[0].Err(), gc.IsNil)
+	c.Assert(items[1].Id, gc.Equals, "b")
+	c.Assert(items[1].Err(), gc.IsNil)
+	c.Assert(items[2].Id, gc.Equals, "c")
+	c.Assert(items[2].Err(), gc.Equals, es.ErrConflict)
+	for id, expect := range map[string]string{
+		"a": "a1",
+		"b": "b1",
+		"c": "c",
+	} {
+		var result map[string]string
+		err = s.ES.GetDocument(s.TestIndex, "testtype", id, &result)
+		c.Assert(err, gc.IsNil)
+		c.Assert(result["a"], gc.Equals, expect)
+	}
+}
+
+func (s *Suite) TestBulkNoOperations(c *gc.C) {
+	items, err := s.ES.Bulk(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(items, gc.HasLen, 0)
+}
+
+func (s *Suite) TestCount(c *gc.C) {
+	for _, id := range []string{"a", "b"} {
+		err := s.ES.PutDocument(s.TestIndex, "counttype", id, map[string]string{"a": id})
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	n, err := s.ES.Count(s.TestIndex, "counttype")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(2))
+	// The index also holds the document added by SetUpTest.
+	n, err = s.ES.Count(s.TestIndex, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(3))
+}
+
 func (s *Suite) TestDelete(c *gc.C) {
 	doc := map[string]string{
 		"a": "b",
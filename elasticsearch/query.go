@@ -58,13 +58,22 @@ func (m MatchQuery) MarshalJSON() ([]byte, error) {
 type MultiMatchQuery struct {
 	Query  string
 	Fields []string
+
+	// Fuzziness holds the maximum edit distance allowed when
+	// matching terms, for example "AUTO". If it is empty, terms
+	// must match exactly.
+	Fuzziness string
 }
 
 func (m MultiMatchQuery) MarshalJSON() ([]byte, error) {
-	return marshalNamedObject("multi_match", map[string]interface{}{
+	params := map[string]interface{}{
 		"query":  m.Query,
 		"fields": m.Fields,
-	})
+	}
+	if m.Fuzziness != "" {
+		params["fuzziness"] = m.Fuzziness
+	}
+	return marshalNamedObject("multi_match", params)
 }
 
 // FilteredQuery provides a query that includes a filter.
@@ -193,6 +202,17 @@ func (r RegexpFilter) MarshalJSON() ([]byte, error) {
 	return marshalNamedObject("regexp", map[string]string{r.Field: r.Regexp})
 }
 
+// RangeFilter provides a filter that requires a field to sort after
+// the given value.
+type RangeFilter struct {
+	Field string
+	GT    string
+}
+
+func (r RangeFilter) MarshalJSON() ([]byte, error) {
+	return marshalNamedObject("range", map[string]map[string]string{r.Field: {"gt": r.GT}})
+}
+
 // TermFilter provides a filter that requires a field to match.
 type TermFilter struct {
 	Field string
@@ -210,14 +230,87 @@ func (f ExistsFilter) MarshalJSON() ([]byte, error) {
 	return marshalNamedObject("exists", map[string]string{"field": string(f)})
 }
 
+// Aggregation represents an aggregation in the elasticsearch DSL.
+type Aggregation interface {
+	json.Marshaler
+}
+
+// TermsAggregation provides an aggregation that creates a bucket for
+// each distinct value of a field in the matching documents.
+type TermsAggregation struct {
+	Field string
+
+	// Size holds the maximum number of buckets to return,
+	// most frequent first. If it is zero, all the buckets
+	// are returned.
+	Size int
+}
+
+func (t TermsAggregation) MarshalJSON() ([]byte, error) {
+	return marshalNamedObject("terms", map[string]interface{}{
+		"field": t.Field,
+		"size":  t.Size,
+	})
+}
+
+// FiltersAggregation provides an aggregation that creates a bucket
+// for each of the given filters, holding the matching documents.
+// The buckets are named with the keys of the map.
+type FiltersAggregation map[string]Filter
+
+func (f FiltersAggregation) MarshalJSON() ([]byte, error) {
+	return marshalNamedObject("filters", map[string]interface{}{
+		"filters": map[string]Filter(f),
+	})
+}
+
+// Suggester represents a suggester in the elasticsearch DSL.
+type Suggester interface {
+	json.Marshaler
+}
+
+// TermSuggester provides a suggester that suggests terms from a field
+// that are similar to each of the terms in the text.
+type TermSuggester struct {
+	Text  string
+	Field string
+
+	// Analyzer holds the analyzer used to split the text into
+	// terms. If it is empty, the analyzer of the field is used.
+	Analyzer string
+
+	// Size holds the maximum number of suggestions returned
+	// for each term. If it is zero, the elasticsearch default
+	// is used.
+	Size int
+}
+
+func (t TermSuggester) MarshalJSON() ([]byte, error) {
+	params := map[string]interface{}{
+		"field": t.Field,
+	}
+	if t.Analyzer != "" {
+		params["analyzer"] = t.Analyzer
+	}
+	if t.Size != 0 {
+		params["size"] = t.Size
+	}
+	return json.Marshal(map[string]interface{}{
+		"text": t.Text,
+		"term": params,
+	})
+}
+
 // QueryDSL provides a structure to put together a query using the
 // elasticsearch DSL.
 type QueryDSL struct {
-	Fields []string `json:"fields"`
-	From   int      `json:"from,omitempty"`
-	Size   int      `json:"size,omitempty"`
-	Query  Query    `json:"query,omitempty"`
-	Sort   []Sort   `json:"sort,omitempty"`
+	Fields       []string               `json:"fields"`
+	From         int                    `json:"from,omitempty"`
+	Size         int                    `json:"size,omitempty"`
+	Query        Query                  `json:"query,omitempty"`
+	Sort         []Sort                 `json:"sort,omitempty"`
+	Aggregations map[string]Aggregation `json:"aggs,omitempty"`
+	Suggest      map[string]Suggester   `json:"suggest,omitempty"`
 }
 
 type Sort struct {
@@ -4,6 +4,8 @@
 package elasticsearch_test // import "gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
 
 import (
+	"encoding/json"
+
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -39,6 +41,10 @@ func (s *QuerySuite) TestJSONEncodings(c *gc.C) {
 		about: "multi match query",
 		query: MultiMatchQuery{Query: "foo", Fields: []string{BoostField("bar", 2), "baz"}},
 		json:  `{"multi_match": {"query": "foo", "fields": ["bar^2.000000", "baz"]}}`,
+	}, {
+		about: "fuzzy multi match query",
+		query: MultiMatchQuery{Query: "foo", Fields: []string{"bar"}, Fuzziness: "AUTO"},
+		json:  `{"multi_match": {"query": "foo", "fields": ["bar"], "fuzziness": "AUTO"}}`,
 	}, {
 		about: "filtered query",
 		query: FilteredQuery{
@@ -88,6 +94,10 @@ func (s *QuerySuite) TestJSONEncodings(c *gc.C) {
 		about: "regexp filter",
 		query: RegexpFilter{Field: "foo", Regexp: ".*"},
 		json:  `{"regexp": {"foo": ".*"}}`,
+	}, {
+		about: "range filter",
+		query: RangeFilter{Field: "foo", GT: "bar"},
+		json:  `{"range": {"foo": {"gt": "bar"}}}`,
 	}, {
 		about: "query dsl",
 		query: QueryDSL{
@@ -139,6 +149,45 @@ func (s *QuerySuite) TestJSONEncodings(c *gc.C) {
 			Modifier: "bar",
 		},
 		json: `{"field_value_factor": {"field": "foo", "factor": 1.2, "modifier": "bar"}}`,
+	}, {
+		about: "terms aggregation",
+		query: TermsAggregation{Field: "foo"},
+		json:  `{"terms": {"field": "foo", "size": 0}}`,
+	}, {
+		about: "filters aggregation",
+		query: FiltersAggregation{
+			"bar": TermFilter{Field: "foo", Value: "bar"},
+			"baz": ExistsFilter("baz"),
+		},
+		json: `{"filters": {"filters": {"bar": {"term": {"foo": "bar"}}, "baz": {"exists": {"field": "baz"}}}}}`,
+	}, {
+		about: "query with aggregations",
+		query: QueryDSL{
+			Fields: []string{"foo"},
+			Query:  MatchAllQuery{},
+			Aggregations: map[string]Aggregation{
+				"foo": TermsAggregation{Field: "foo", Size: 5},
+			},
+		},
+		json: `{"fields": ["foo"], "query": {"match_all": {}}, "aggs": {"foo": {"terms": {"field": "foo", "size": 5}}}}`,
+	}, {
+		about: "term suggester",
+		query: TermSuggester{Text: "foo bar", Field: "baz"},
+		json:  `{"text": "foo bar", "term": {"field": "baz"}}`,
+	}, {
+		about: "term suggester with analyzer and size",
+		query: TermSuggester{Text: "foo bar", Field: "baz", Analyzer: "standard", Size: 3},
+		json:  `{"text": "foo bar", "term": {"field": "baz", "analyzer": "standard", "size": 3}}`,
+	}, {
+		about: "query with suggest",
+		query: QueryDSL{
+			Fields: []string{"foo"},
+			Query:  MatchAllQuery{},
+			Suggest: map[string]Suggester{
+				"foo": TermSuggester{Text: "bar", Field: "foo"},
+			},
+		},
+		json: `{"fields": ["foo"], "query": {"match_all": {}}, "suggest": {"foo": {"text": "bar", "term": {"field": "foo"}}}}`,
 	}}
 	for i, test := range tests {
 		c.Logf("%d: %s", i, test.about)
@@ -147,3 +196,52 @@ func (s *QuerySuite) TestJSONEncodings(c *gc.C) {
 		c.Assert(test.json, jc.JSONEquals, test.query)
 	}
 }
+
+func (s *QuerySuite) TestUnmarshalAggregationResult(c *gc.C) {
+	var sr SearchResult
+	err := json.Unmarshal([]byte(`{
+		"aggregations": {
+			"terms": {
+				"buckets": [{"key": "foo", "doc_count": 3}, {"key": "bar", "doc_count": 1}]
+			},
+			"filters": {
+				"buckets": {"foo": {"doc_count": 2}, "bar": {"doc_count": 5}}
+			}
+		}
+	}`), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Aggregations, jc.DeepEquals, map[string]AggregationResult{
+		"terms": {
+			Buckets: []Bucket{{Key: "foo", DocCount: 3}, {Key: "bar", DocCount: 1}},
+		},
+		"filters": {
+			Buckets: []Bucket{{Key: "bar", DocCount: 5}, {Key: "foo", DocCount: 2}},
+		},
+	})
+}
+
+func (s *QuerySuite) TestUnmarshalSuggest(c *gc.C) {
+	var sr SearchResult
+	err := json.Unmarshal([]byte(`{
+		"suggest": {
+			"names": [{
+				"text": "wordprss",
+				"offset": 0,
+				"length": 8,
+				"options": [{"text": "wordpress", "score": 0.875, "freq": 2}]
+			}]
+		}
+	}`), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Suggest, jc.DeepEquals, map[string][]SuggestEntry{
+		"names": {{
+			Text:   "wordprss",
+			Length: 8,
+			Options: []SuggestOption{{
+				Text:  "wordpress",
+				Score: 0.875,
+				Freq:  2,
+			}},
+		}},
+	})
+}
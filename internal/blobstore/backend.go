@@ -0,0 +1,124 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/juju/blobstore"
+	"github.com/juju/errors"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrNotFound is returned as the cause of backend errors
+// when a blob does not exist.
+var ErrNotFound = errgo.New("blob not found")
+
+// Backend is implemented by the storage systems that hold blob
+// content. A Backend is only concerned with storing content under a
+// name; the Store keeps the catalog that maps content hashes to
+// names and issues content challenges.
+type Backend interface {
+	// Put stores size bytes read from r under the given name,
+	// replacing any existing blob with that name.
+	Put(name string, r io.Reader, size int64) error
+
+	// Open opens the blob with the given name and returns
+	// its size.
+	Open(name string) (ReadSeekCloser, int64, error)
+
+	// Remove removes the blob with the given name.
+	Remove(name string) error
+
+	// Stat returns the size of the blob with the given name.
+	Stat(name string) (int64, error)
+}
+
+// NewBackend returns the backend described by the given URL.
+// The following URL forms are recognised:
+//
+//	file:///path/to/dir
+//	s3://[access-key:secret-key@]host[:port]/bucket[?region=region]
+//	s3+http://[access-key:secret-key@]host[:port]/bucket[?region=region]
+//
+// The s3 form talks to the S3-compatible service over HTTPS,
+// s3+http over plain HTTP.
+//
+// GridFS backends are not described by URL because they need a mongo
+// database; use NewGridFSBackend to create one.
+func NewBackend(location string) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse blob storage location")
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errgo.Newf("no directory specified in blob storage location %q", location)
+		}
+		return NewFileBackend(u.Path)
+	case "s3", "s3+http":
+		p := S3Params{
+			Endpoint: "https://" + u.Host,
+			Bucket:   strings.Trim(u.Path, "/"),
+			Region:   u.Query().Get("region"),
+		}
+		if u.Scheme == "s3+http" {
+			p.Endpoint = "http://" + u.Host
+		}
+		if u.User != nil {
+			p.AccessKey = u.User.Username()
+			p.SecretKey, _ = u.User.Password()
+		}
+		if u.Host == "" || p.Bucket == "" {
+			return nil, errgo.Newf("blob storage location %q does not specify a host and bucket", location)
+		}
+		return NewS3Backend(p), nil
+	}
+	return nil, errgo.Newf("unknown blob storage location %q", location)
+}
+
+// resourceStorage adapts a Backend to the blobstore.ResourceStorage
+// interface used by the managed storage, which implements the
+// content-addressed catalog and the proof of access challenges.
+type resourceStorage struct {
+	backend Backend
+}
+
+var _ blobstore.ResourceStorage = resourceStorage{}
+
+// Get implements blobstore.ResourceStorage.Get.
+// The returned reader always implements ReadSeekCloser.
+func (s resourceStorage) Get(path string) (io.ReadCloser, error) {
+	r, _, err := s.backend.Open(path)
+	if errgo.Cause(err) == ErrNotFound {
+		return nil, errors.NotFoundf("resource at path %q", path)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return r, nil
+}
+
+// Put implements blobstore.ResourceStorage.Put.
+func (s resourceStorage) Put(path string, r io.Reader, length int64) (string, error) {
+	hash := md5.New()
+	if err := s.backend.Put(path, io.TeeReader(r, hash), length); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Remove implements blobstore.ResourceStorage.Remove.
+func (s resourceStorage) Remove(path string) error {
+	err := s.backend.Remove(path)
+	if errgo.Cause(err) == ErrNotFound {
+		return errors.NotFoundf("resource at path %q", path)
+	}
+	return errgo.Mask(err)
+}
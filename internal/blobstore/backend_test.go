@@ -0,0 +1,338 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	jujutesting "github.com/juju/testing"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+)
+
+type BackendSuite struct {
+	jujutesting.IsolatedMgoSuite
+	s3     *s3Stub
+	s3Srv  *httptest.Server
+	s3Addr string
+}
+
+var _ = gc.Suite(&BackendSuite{})
+
+func (s *BackendSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	s.s3 = newS3Stub("bucket", "access-key")
+	s.s3Srv = httptest.NewServer(s.s3)
+	s.s3Addr = strings.TrimPrefix(s.s3Srv.URL, "http://")
+}
+
+func (s *BackendSuite) TearDownTest(c *gc.C) {
+	s.s3Srv.Close()
+	s.IsolatedMgoSuite.TearDownTest(c)
+}
+
+// backends returns an instance of each kind of backend.
+func (s *BackendSuite) backends(c *gc.C) map[string]blobstore.Backend {
+	fileBackend, err := blobstore.NewFileBackend(c.MkDir())
+	c.Assert(err, gc.IsNil)
+	return map[string]blobstore.Backend{
+		"gridfs": blobstore.NewGridFSBackend(s.Session.DB("db"), "blobstore"),
+		"file":   fileBackend,
+		"s3": blobstore.NewS3Backend(blobstore.S3Params{
+			Endpoint:  s.s3Srv.URL,
+			Bucket:    "bucket",
+			AccessKey: "access-key",
+			SecretKey: "secret-key",
+		}),
+	}
+}
+
+func (s *BackendSuite) TestBackend(c *gc.C) {
+	for name, backend := range s.backends(c) {
+		c.Logf("backend %s", name)
+		content := "some data"
+		err := backend.Put("x/y:z", strings.NewReader(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+
+		size, err := backend.Stat("x/y:z")
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(len(content)))
+
+		r, size, err := backend.Open("x/y:z")
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(len(content)))
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, content)
+
+		// Check that the content can be read again after seeking.
+		_, err = r.Seek(5, 0)
+		c.Assert(err, gc.IsNil)
+		data, err = ioutil.ReadAll(r)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, "data")
+		err = r.Close()
+		c.Assert(err, gc.IsNil)
+
+		// Only size bytes are read from the reader.
+		err = backend.Put("other", strings.NewReader("more data"), 4)
+		c.Assert(err, gc.IsNil)
+		size, err = backend.Stat("other")
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(4))
+
+		err = backend.Put("empty", strings.NewReader(""), 0)
+		c.Assert(err, gc.IsNil)
+		size, err = backend.Stat("empty")
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(0))
+
+		err = backend.Remove("x/y:z")
+		c.Assert(err, gc.IsNil)
+		_, err = backend.Stat("x/y:z")
+		c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+		_, _, err = backend.Open("x/y:z")
+		c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+		err = backend.Remove("x/y:z")
+		c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+
+		// The other blobs are unaffected.
+		size, err = backend.Stat("other")
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(4))
+	}
+}
+
+func (s *BackendSuite) TestPutShortContent(c *gc.C) {
+	for name, backend := range s.backends(c) {
+		c.Logf("backend %s", name)
+		err := backend.Put("x", strings.NewReader("some data"), 100)
+		c.Assert(err, gc.NotNil)
+		_, err = backend.Stat("x")
+		c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+	}
+}
+
+func (s *BackendSuite) TestFallbackBackend(c *gc.C) {
+	primary, err := blobstore.NewFileBackend(c.MkDir())
+	c.Assert(err, gc.IsNil)
+	fallback := blobstore.NewGridFSBackend(s.Session.DB("db"), "blobstore")
+	backend := blobstore.NewFallbackBackend(primary, fallback)
+
+	err = fallback.Put("old", strings.NewReader("old data"), 8)
+	c.Assert(err, gc.IsNil)
+	err = backend.Put("new", strings.NewReader("new data!"), 9)
+	c.Assert(err, gc.IsNil)
+
+	// New blobs are only written to the primary backend.
+	_, err = fallback.Stat("new")
+	c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+
+	for name, content := range map[string]string{
+		"old": "old data",
+		"new": "new data!",
+	} {
+		size, err := backend.Stat(name)
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(len(content)))
+		r, size, err := backend.Open(name)
+		c.Assert(err, gc.IsNil)
+		c.Assert(size, gc.Equals, int64(len(content)))
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, content)
+
+		err = backend.Remove(name)
+		c.Assert(err, gc.IsNil)
+		_, err = backend.Stat(name)
+		c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+	}
+	_, _, err = backend.Open("old")
+	c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+	err = backend.Remove("old")
+	c.Assert(errgo.Cause(err), gc.Equals, blobstore.ErrNotFound)
+}
+
+func (s *BackendSuite) TestStoreWithBackend(c *gc.C) {
+	for name, backend := range s.backends(c) {
+		c.Logf("backend %s", name)
+		db := s.Session.DB("db-" + name)
+		store := blobstore.NewWithBackend(db, backend)
+		content := "some data"
+		chal, err := store.Put(strings.NewReader(content), "x", int64(len(content)), hashOf(content), nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(chal, gc.IsNil)
+
+		rc, length, err := store.Open("x")
+		c.Assert(err, gc.IsNil)
+		c.Assert(length, gc.Equals, int64(len(content)))
+		data, err := ioutil.ReadAll(rc)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, content)
+		rc.Close()
+
+		// Putting the same content under another name requires
+		// proof of access to the content.
+		chal, err = store.Put(strings.NewReader(content), "y", int64(len(content)), hashOf(content), nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(chal, gc.NotNil)
+		resp, err := blobstore.NewContentChallengeResponse(chal, strings.NewReader(content))
+		c.Assert(err, gc.IsNil)
+		chal, err = store.Put(strings.NewReader(content), "y", int64(len(content)), hashOf(content), resp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(chal, gc.IsNil)
+
+		rc, length, err = store.Open("y")
+		c.Assert(err, gc.IsNil)
+		c.Assert(length, gc.Equals, int64(len(content)))
+		data, err = ioutil.ReadAll(rc)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, content)
+		rc.Close()
+
+		err = store.Remove("x")
+		c.Assert(err, gc.IsNil)
+		err = store.Remove("y")
+		c.Assert(err, gc.IsNil)
+		_, _, err = store.Open("y")
+		c.Assert(err, gc.ErrorMatches, `resource at path "[^"]+" not found`)
+	}
+}
+
+func (s *BackendSuite) TestNewBackend(c *gc.C) {
+	dir := c.MkDir()
+	backend, err := blobstore.NewBackend("file://" + dir)
+	c.Assert(err, gc.IsNil)
+	err = backend.Put("x", strings.NewReader("data"), 4)
+	c.Assert(err, gc.IsNil)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(files, gc.HasLen, 1)
+	c.Assert(files[0].Name(), gc.Equals, "x")
+
+	backend, err = blobstore.NewBackend("s3+http://access-key:secret-key@" + s.s3Addr + "/bucket?region=eu-west-1")
+	c.Assert(err, gc.IsNil)
+	err = backend.Put("x", strings.NewReader("data"), 4)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.s3.object("x"), gc.Equals, "data")
+	c.Assert(s.s3.lastAuthorization(), gc.Matches, `AWS4-HMAC-SHA256 Credential=access-key/[0-9]{8}/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}`)
+}
+
+var newBackendErrorTests = []struct {
+	location    string
+	expectError string
+}{{
+	location:    "ftp://example.com/blobs",
+	expectError: `unknown blob storage location "ftp://example.com/blobs"`,
+}, {
+	location:    "file://",
+	expectError: `no directory specified in blob storage location "file://"`,
+}, {
+	location:    "s3://s3.amazonaws.com",
+	expectError: `blob storage location "s3://s3.amazonaws.com" does not specify a host and bucket`,
+}, {
+	location:    "s3:///bucket",
+	expectError: `blob storage location "s3:///bucket" does not specify a host and bucket`,
+}}
+
+func (s *BackendSuite) TestNewBackendError(c *gc.C) {
+	for i, test := range newBackendErrorTests {
+		c.Logf("test %d: %s", i, test.location)
+		backend, err := blobstore.NewBackend(test.location)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+		c.Assert(backend, gc.IsNil)
+	}
+}
+
+func (s *BackendSuite) TestS3Unauthorized(c *gc.C) {
+	backend := blobstore.NewS3Backend(blobstore.S3Params{
+		Endpoint:  s.s3Srv.URL,
+		Bucket:    "bucket",
+		AccessKey: "other-key",
+		SecretKey: "secret-key",
+	})
+	err := backend.Put("x", strings.NewReader("data"), 4)
+	c.Assert(err, gc.ErrorMatches, `cannot put blob "x": S3 request failed with status "403 Forbidden": <Error><Code>AccessDenied</Code></Error>`)
+}
+
+// s3Stub is a minimal in-memory stand-in for an S3-compatible
+// service holding a single bucket.
+type s3Stub struct {
+	bucket    string
+	accessKey string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	auth    string
+}
+
+func newS3Stub(bucket, accessKey string) *s3Stub {
+	return &s3Stub{
+		bucket:    bucket,
+		accessKey: accessKey,
+		objects:   make(map[string][]byte),
+	}
+}
+
+func (s *s3Stub) object(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.objects[name])
+}
+
+func (s *s3Stub) lastAuthorization() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = req.Header.Get("Authorization")
+	if !strings.HasPrefix(s.auth, "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/") {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") == "" || req.Header.Get("X-Amz-Date") == "" {
+		http.Error(w, "<Error><Code>InvalidRequest</Code></Error>", http.StatusBadRequest)
+		return
+	}
+	prefix := "/" + s.bucket + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, prefix)
+	switch req.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[name] = data
+	case "GET", "HEAD":
+		data, ok := s.objects[name]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(data))
+	case "DELETE":
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "<Error><Code>MethodNotAllowed</Code></Error>", http.StatusMethodNotAllowed)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"path"
 	"strconv"
 
 	"github.com/juju/blobstore"
@@ -76,18 +77,25 @@ func NewContentChallengeResponse(chal *ContentChallenge, r io.ReadSeeker) (*Cont
 	}, nil
 }
 
-// Store stores data blobs in mongodb, de-duplicating by
-// blob hash.
+// Store stores data blobs, de-duplicating by blob hash. The catalog
+// of blobs is held in mongodb; the content is held by a Backend.
 type Store struct {
+	db     *mgo.Database
 	mstore blobstore.ManagedStorage
 }
 
 // New returns a new blob store that writes to the given database,
-// prefixing its collections with the given prefix.
+// prefixing its GridFS collections with the given prefix.
 func New(db *mgo.Database, prefix string) *Store {
-	rs := blobstore.NewGridFS(db.Name, prefix, db.Session)
+	return NewWithBackend(db, NewGridFSBackend(db, prefix))
+}
+
+// NewWithBackend returns a new blob store that keeps its catalog in
+// the given database and stores blob content in the given backend.
+func NewWithBackend(db *mgo.Database, backend Backend) *Store {
 	return &Store{
-		mstore: blobstore.NewManagedStorage(db, rs),
+		db:     db,
+		mstore: blobstore.NewManagedStorage(db, resourceStorage{backend}),
 	}
 }
 
@@ -157,3 +165,25 @@ func (s *Store) Open(name string) (ReadSeekCloser, int64, error) {
 func (s *Store) Remove(name string) error {
 	return s.mstore.RemoveForEnvironment("", name)
 }
+
+// managedResourcesCollection holds the name of the collection in
+// which the managed storage records each named blob.
+const managedResourcesCollection = "managedStoredResources"
+
+// Names returns the names of all the blobs in the store.
+func (s *Store) Names() ([]string, error) {
+	var names []string
+	iter := s.db.C(managedResourcesCollection).Find(nil).Select(map[string]int{"path": 1}).Iter()
+	var doc struct {
+		Path string `bson:"path"`
+	}
+	for iter.Next(&doc) {
+		// The managed storage prefixes the name with
+		// the path of its environment.
+		names = append(names, path.Base(doc.Path))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot list blobs")
+	}
+	return names, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"io"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fallbackBackend is a Backend that writes to a primary backend and
+// reads from a fallback backend any blobs that the primary backend
+// does not hold.
+type fallbackBackend struct {
+	primary  Backend
+	fallback Backend
+}
+
+// NewFallbackBackend returns a backend that stores new blobs in
+// primary, but reads and removes blobs from fallback when primary does
+// not hold them. It allows blobs stored before the blob storage was
+// changed to remain accessible until they have been migrated with
+// Migrate.
+func NewFallbackBackend(primary, fallback Backend) Backend {
+	return &fallbackBackend{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+// Put implements Backend.Put.
+func (b *fallbackBackend) Put(name string, r io.Reader, size int64) error {
+	return b.primary.Put(name, r, size)
+}
+
+// Open implements Backend.Open.
+func (b *fallbackBackend) Open(name string) (ReadSeekCloser, int64, error) {
+	r, size, err := b.primary.Open(name)
+	if errgo.Cause(err) != ErrNotFound {
+		return r, size, errgo.Mask(err)
+	}
+	r, size, err = b.fallback.Open(name)
+	if err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	return r, size, nil
+}
+
+// Remove implements Backend.Remove.
+func (b *fallbackBackend) Remove(name string) error {
+	perr := b.primary.Remove(name)
+	if perr != nil && errgo.Cause(perr) != ErrNotFound {
+		return errgo.Mask(perr)
+	}
+	// A migrated blob may still be held by the fallback backend.
+	ferr := b.fallback.Remove(name)
+	if ferr != nil && errgo.Cause(ferr) != ErrNotFound {
+		return errgo.Mask(ferr)
+	}
+	if perr != nil && ferr != nil {
+		return errgo.WithCausef(nil, ErrNotFound, "blob %q not found", name)
+	}
+	return nil
+}
+
+// Stat implements Backend.Stat.
+func (b *fallbackBackend) Stat(name string) (int64, error) {
+	size, err := b.primary.Stat(name)
+	if errgo.Cause(err) != ErrNotFound {
+		return size, errgo.Mask(err)
+	}
+	size, err = b.fallback.Stat(name)
+	if err != nil {
+		return 0, errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	return size, nil
+}
+
+// MigrateParams holds the parameters for Migrate.
+type MigrateParams struct {
+	// From holds the backend to copy blobs from.
+	From Backend
+
+	// To holds the backend to copy blobs to.
+	To Backend
+
+	// RemoveSource specifies that each blob should be removed
+	// from From once it has been copied.
+	RemoveSource bool
+}
+
+// Migrate copies the content of every blob in the catalog held in
+// the given database from p.From to p.To. Blobs that p.To already
+// holds with the expected size are not copied again, so an
+// interrupted migration can be run again. It returns the number of
+// blobs that were copied.
+func Migrate(db *mgo.Database, p MigrateParams) (int, error) {
+	var doc struct {
+		Path   string `bson:"path"`
+		Length int64  `bson:"length"`
+	}
+	n := 0
+	iter := db.C("storedResources").Find(bson.D{{"path", bson.D{{"$ne", ""}}}}).Select(bson.D{{"path", 1}, {"length", 1}}).Iter()
+	for iter.Next(&doc) {
+		copied, err := migrateBlob(doc.Path, doc.Length, p)
+		if err != nil {
+			iter.Close()
+			return n, errgo.Mask(err)
+		}
+		if copied {
+			n++
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return n, errgo.Notef(err, "cannot iterate over blob catalog")
+	}
+	return n, nil
+}
+
+// migrateBlob copies the blob with the given name and size from
+// p.From to p.To, and reports whether it was copied.
+func migrateBlob(name string, size int64, p MigrateParams) (bool, error) {
+	dsize, err := p.To.Stat(name)
+	if err == nil && dsize == size {
+		if p.RemoveSource {
+			if err := p.From.Remove(name); err != nil && errgo.Cause(err) != ErrNotFound {
+				return false, errgo.Notef(err, "cannot remove migrated blob %q", name)
+			}
+		}
+		return false, nil
+	}
+	if err != nil && errgo.Cause(err) != ErrNotFound {
+		return false, errgo.Mask(err)
+	}
+	r, ssize, err := p.From.Open(name)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot open blob %q", name)
+	}
+	defer r.Close()
+	if ssize != size {
+		return false, errgo.Newf("blob %q has size %d, expected %d", name, ssize, size)
+	}
+	if err := p.To.Put(name, r, size); err != nil {
+		return false, errgo.Notef(err, "cannot copy blob %q", name)
+	}
+	dsize, err = p.To.Stat(name)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot check copied blob %q", name)
+	}
+	if dsize != size {
+		return false, errgo.Newf("copied blob %q has size %d, expected %d", name, dsize, size)
+	}
+	if p.RemoveSource {
+		if err := p.From.Remove(name); err != nil {
+			return false, errgo.Notef(err, "cannot remove migrated blob %q", name)
+		}
+	}
+	return true, nil
+}
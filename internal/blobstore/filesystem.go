@@ -0,0 +1,107 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+)
+
+// fileBackend is a Backend that holds each blob
+// in a file in a local directory.
+type fileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a backend that stores blobs as files in the
+// given directory, which is created if it does not exist.
+func NewFileBackend(dir string) (Backend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errgo.Notef(err, "cannot create blob directory")
+	}
+	return &fileBackend{
+		dir: dir,
+	}, nil
+}
+
+// path returns the path of the file holding the blob with the given
+// name. Names are escaped so that they cannot refer to files
+// outside the blob directory.
+func (b *fileBackend) path(name string) string {
+	return filepath.Join(b.dir, url.QueryEscape(name))
+}
+
+// Put implements Backend.Put. The content is written to a temporary
+// file that is renamed into place once complete, so a partially
+// written blob can never be opened.
+func (b *fileBackend) Put(name string, r io.Reader, size int64) error {
+	f, err := ioutil.TempFile(b.dir, ".tmp")
+	if err != nil {
+		return errgo.Notef(err, "cannot create blob file")
+	}
+	defer func() {
+		// Remove the temporary file if it has not been renamed.
+		os.Remove(f.Name())
+	}()
+	_, err = io.CopyN(f, r, size)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot write blob %q", name)
+	}
+	if err := os.Rename(f.Name(), b.path(name)); err != nil {
+		return errgo.Notef(err, "cannot write blob %q", name)
+	}
+	return nil
+}
+
+// Open implements Backend.Open.
+func (b *fileBackend) Open(name string) (ReadSeekCloser, int64, error) {
+	f, err := os.Open(b.path(name))
+	if os.IsNotExist(err) {
+		return nil, 0, errgo.WithCausef(nil, ErrNotFound, "blob %q not found", name)
+	}
+	if err != nil {
+		return nil, 0, errgo.Notef(err, "cannot open blob %q", name)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, errgo.Notef(err, "cannot stat blob %q", name)
+	}
+	return f, info.Size(), nil
+}
+
+// Remove implements Backend.Remove.
+func (b *fileBackend) Remove(name string) error {
+	err := os.Remove(b.path(name))
+	if os.IsNotExist(err) {
+		return errgo.WithCausef(nil, ErrNotFound, "blob %q not found", name)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot remove blob %q", name)
+	}
+	return nil
+}
+
+// Stat implements Backend.Stat.
+func (b *fileBackend) Stat(name string) (int64, error) {
+	info, err := os.Stat(b.path(name))
+	if os.IsNotExist(err) {
+		return 0, errgo.WithCausef(nil, ErrNotFound, "blob %q not found", name)
+	}
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot stat blob %q", name)
+	}
+	return info.Size(), nil
+}
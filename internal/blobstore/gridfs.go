@@ -0,0 +1,86 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"io"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+)
+
+// gridFSBackend is a Backend that holds blobs in MongoDB GridFS.
+type gridFSBackend struct {
+	fs *mgo.GridFS
+}
+
+// NewGridFSBackend returns a backend that stores blobs in GridFS
+// in the given database, prefixing its collections with the given
+// prefix. The layout is the same as that used by earlier versions of
+// the blob store, so existing blobs remain accessible.
+func NewGridFSBackend(db *mgo.Database, prefix string) Backend {
+	return &gridFSBackend{
+		fs: db.GridFS(prefix),
+	}
+}
+
+// Put implements Backend.Put.
+func (b *gridFSBackend) Put(name string, r io.Reader, size int64) (err error) {
+	f, err := b.fs.Create(name)
+	if err != nil {
+		return errgo.Notef(err, "cannot create GridFS file %q", name)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		f.Abort()
+		f.Close()
+	}()
+	if _, err := io.CopyN(f, r, size); err != nil {
+		return errgo.Notef(err, "cannot write GridFS file %q", name)
+	}
+	if err := f.Close(); err != nil {
+		return errgo.Notef(err, "cannot write GridFS file %q", name)
+	}
+	return nil
+}
+
+// Open implements Backend.Open.
+func (b *gridFSBackend) Open(name string) (ReadSeekCloser, int64, error) {
+	f, err := b.fs.Open(name)
+	if err == mgo.ErrNotFound {
+		return nil, 0, errgo.WithCausef(nil, ErrNotFound, "GridFS file %q not found", name)
+	}
+	if err != nil {
+		return nil, 0, errgo.Notef(err, "cannot open GridFS file %q", name)
+	}
+	return f, f.Size(), nil
+}
+
+// Remove implements Backend.Remove.
+func (b *gridFSBackend) Remove(name string) error {
+	if _, err := b.Stat(name); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	if err := b.fs.Remove(name); err != nil {
+		return errgo.Notef(err, "cannot remove GridFS file %q", name)
+	}
+	return nil
+}
+
+// Stat implements Backend.Stat.
+func (b *gridFSBackend) Stat(name string) (int64, error) {
+	var doc struct {
+		Length int64 `bson:"length"`
+	}
+	err := b.fs.Files.Find(map[string]string{"filename": name}).Sort("-uploadDate").Select(map[string]int{"length": 1}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, errgo.WithCausef(nil, ErrNotFound, "GridFS file %q not found", name)
+	}
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot stat GridFS file %q", name)
+	}
+	return doc.Length, nil
+}
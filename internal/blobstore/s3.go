@@ -0,0 +1,324 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package blobstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// S3Params holds the parameters used to create
+// an S3-compatible backend.
+type S3Params struct {
+	// Endpoint holds the base URL of the service,
+	// for example https://s3.amazonaws.com.
+	Endpoint string
+
+	// Bucket holds the name of the bucket that holds the blobs.
+	// It must already exist.
+	Bucket string
+
+	// Region holds the region used when signing requests.
+	// If it is empty, us-east-1 is used.
+	Region string
+
+	// AccessKey and SecretKey hold the credentials used to
+	// sign requests. If AccessKey is empty, requests are
+	// not signed.
+	AccessKey string
+	SecretKey string
+
+	// Client holds the HTTP client used to make requests.
+	// If it is nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// s3Backend is a Backend that holds each blob as an object
+// in a bucket of an S3-compatible service. Requests are
+// made with path-style addressing and signed with AWS
+// signature version 4.
+type s3Backend struct {
+	p S3Params
+}
+
+// NewS3Backend returns a backend that stores blobs
+// in an S3-compatible service.
+func NewS3Backend(p S3Params) Backend {
+	p.Endpoint = strings.TrimSuffix(p.Endpoint, "/")
+	if p.Region == "" {
+		p.Region = "us-east-1"
+	}
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	return &s3Backend{
+		p: p,
+	}
+}
+
+// Put implements Backend.Put.
+func (b *s3Backend) Put(name string, r io.Reader, size int64) error {
+	var body io.Reader
+	if size > 0 {
+		body = io.LimitReader(r, size)
+	}
+	req, err := b.newRequest("PUT", name, body)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req.ContentLength = size
+	resp, err := b.do(req)
+	if err != nil {
+		return errgo.Notef(err, "cannot put blob %q", name)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open implements Backend.Open. The content is fetched lazily
+// with ranged requests, so seeking does not download the
+// skipped content.
+func (b *s3Backend) Open(name string) (ReadSeekCloser, int64, error) {
+	size, err := b.Stat(name)
+	if err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	return &s3Reader{
+		backend: b,
+		name:    name,
+		size:    size,
+	}, size, nil
+}
+
+// Remove implements Backend.Remove.
+func (b *s3Backend) Remove(name string) error {
+	// S3 does not report an error when deleting
+	// an object that does not exist.
+	if _, err := b.Stat(name); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	req, err := b.newRequest("DELETE", name, nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return errgo.Notef(err, "cannot remove blob %q", name)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stat implements Backend.Stat.
+func (b *s3Backend) Stat(name string) (int64, error) {
+	req, err := b.newRequest("HEAD", name, nil)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return 0, errgo.NoteMask(err, fmt.Sprintf("cannot stat blob %q", name), errgo.Is(ErrNotFound))
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// newRequest returns a new request for the object holding
+// the blob with the given name.
+func (b *s3Backend) newRequest(method, name string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, b.p.Endpoint+"/"+s3Escape(b.p.Bucket)+"/"+s3Escape(name), body)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make S3 request")
+	}
+	return req, nil
+}
+
+// do signs and sends the given request. A response with
+// an unsuccessful status is returned as an error, with
+// an ErrNotFound cause when the object does not exist.
+func (b *s3Backend) do(req *http.Request) (*http.Response, error) {
+	b.sign(req, time.Now())
+	resp, err := b.p.Client.Do(req)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errgo.WithCausef(nil, ErrNotFound, "object not found")
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if len(data) > 0 {
+		return nil, errgo.Newf("S3 request failed with status %q: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return nil, errgo.Newf("S3 request failed with status %q", resp.Status)
+}
+
+// s3UnsignedPayload is used as the payload hash of all
+// requests, so that content can be streamed without
+// being read twice.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// sign adds an AWS signature version 4 authorization
+// header to the given request.
+func (b *s3Backend) sign(req *http.Request, now time.Time) {
+	if b.p.AccessKey == "" {
+		return
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Range") != "" {
+		headers = append(headers, "range")
+	}
+	sort.Strings(headers)
+	var canonicalHeaders bytes.Buffer
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + b.p.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(canonicalRequest))),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+b.p.SecretKey), date)
+	key = hmacSHA256(key, b.p.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		b.p.AccessKey,
+		scope,
+		signedHeaders,
+		hmacSHA256(key, stringToSign),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes the given object key or bucket name for use in a
+// request path, as required by AWS signature version 4: all bytes
+// except unreserved characters and slashes are percent-encoded.
+func s3Escape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~', c == '/':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// s3Reader reads the content of an S3 object.
+type s3Reader struct {
+	backend *s3Backend
+	name    string
+	size    int64
+
+	// offset holds the current read offset.
+	offset int64
+
+	// body holds the body of the response to a ranged request
+	// for the content starting at offset. It is nil if no
+	// request has been made since the last seek.
+	body io.ReadCloser
+}
+
+// Read implements io.Reader.
+func (r *s3Reader) Read(buf []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		req, err := r.backend.newRequest("GET", r.name, nil)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		resp, err := r.backend.do(req)
+		if err != nil {
+			return 0, errgo.Notef(err, "cannot read blob %q", r.name)
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(buf)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 0:
+	case 1:
+		offset += r.offset
+	case 2:
+		offset += r.size
+	default:
+		return 0, errgo.Newf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errgo.Newf("negative seek offset")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close implements io.Closer.
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
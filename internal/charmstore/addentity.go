@@ -11,7 +11,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"sort"
 	"time"
 
@@ -127,7 +126,24 @@ func (s *Store) UploadEntity(url *router.ResolvedURL, blob io.Reader, blobHash s
 	if url.URL.Revision == -1 {
 		return errgo.WithCausef(nil, params.ErrEntityIdNotAllowed, "entity id does not specify revision")
 	}
-	blobName, blobHash256, err := s.putArchive(blob, size, blobHash)
+	// Check the archive against the configured limits before
+	// it is stored, so that oversized archives and zip bombs
+	// never reach the blob store.
+	if err := s.checkArchiveSize(size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrInvalidEntity))
+	}
+	archive, cleanup, err := seekableArchive(blob, size, blobHash)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrInvalidEntity))
+	}
+	defer cleanup()
+	if err := s.checkArchiveLimits(ReaderAtSeeker(archive), size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrInvalidEntity))
+	}
+	if _, err := archive.Seek(0, 0); err != nil {
+		return errgo.Notef(err, "cannot seek to start of archive")
+	}
+	blobName, blobHash256, err := s.putArchive(archive, size, blobHash)
 	if err != nil {
 		return errgo.Mask(err)
 	}
@@ -146,6 +162,7 @@ func (s *Store) UploadEntity(url *router.ResolvedURL, blob io.Reader, blobHash s
 			errgo.Is(params.ErrInvalidEntity),
 		)
 	}
+	s.notifyWebhooks(mongodoc.UploadEvent, &url.URL, chans)
 	return nil
 }
 
@@ -205,7 +222,7 @@ func (s *Store) addEntityFromReader(id *router.ResolvedURL, r io.ReadSeeker, blo
 		logger.Infof("adding pre-v5 compat blob for %#v", id)
 		info, err := addPreV5CompatibilityHackBlob(s.BlobStore, r, p.blobName, p.blobSize)
 		if err != nil {
-			return errgo.Notef(err, "cannot add pre-v5 compatibility blob")
+			return errgo.NoteMask(err, "cannot add pre-v5 compatibility blob", errgo.Is(params.ErrInvalidEntity))
 		}
 		p.preV5BlobHash = info.hash
 		p.preV5BlobHash256 = info.hash256
@@ -262,7 +279,13 @@ func addPreV5CompatibilityHackBlob(blobStore *blobstore.Store, r io.ReadSeeker,
 		return nil, errgo.Notef(err, "cannot open metadata.yaml from archive")
 	}
 	defer fr.Close()
-	data, err := removeSeriesField(fr)
+	// The juju/zip reader only checks the size of the content once
+	// it has all been read, so limit it to the recorded size.
+	var metadata bytes.Buffer
+	if err := copyArchiveFile(&metadata, fr, metadataf.Name, metadataf.UncompressedSize64); err != nil {
+		return nil, errgo.NoteMask(err, "cannot read metadata.yaml from archive", errgo.Is(params.ErrInvalidEntity))
+	}
+	data, err := removeSeriesField(metadata.Bytes())
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot remove series field from metadata")
 	}
@@ -310,17 +333,13 @@ func preV5CompatibilityBlobName(blobName string) string {
 	return blobName + ".pre-v5-suffix"
 }
 
-func removeSeriesField(r io.Reader) ([]byte, error) {
-	data, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, errgo.Mask(err)
-	}
+func removeSeriesField(data []byte) ([]byte, error) {
 	var meta map[string]interface{}
 	if err := yaml.Unmarshal(data, &meta); err != nil {
 		return nil, errgo.Notef(err, "cannot unmarshal metadata.yaml")
 	}
 	delete(meta, "series")
-	data, err = yaml.Marshal(meta)
+	data, err := yaml.Marshal(meta)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot re-marshal metadata.yaml")
 	}
@@ -749,7 +768,8 @@ func interfacesForRelations(rels map[string]charm.Relation) []string {
 // added as context.
 func zipReadError(err error, msg string) error {
 	switch errgo.Cause(err) {
-	case zip.ErrFormat, zip.ErrAlgorithm, zip.ErrChecksum:
+	case zip.ErrFormat, zip.ErrAlgorithm, zip.ErrChecksum,
+		jujuzip.ErrFormat, jujuzip.ErrAlgorithm, jujuzip.ErrChecksum:
 		return errgo.WithCausef(err, params.ErrInvalidEntity, msg)
 	}
 	return errgo.Notef(err, msg)
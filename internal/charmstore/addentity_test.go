@@ -187,18 +187,15 @@ var uploadEntityErrorsTests = []struct {
 	url:         "~charmers/precise/wordpress-0",
 	upload:      storetesting.NewCharm(nil),
 	blobHash:    "blahblah",
-	expectError: "cannot put archive blob: hash mismatch",
-	// It would be nice if this was:
-	// expectCause: params.ErrInvalidEntity,
+	expectError: "archive hash mismatch",
+	expectCause: params.ErrInvalidEntity,
 }, {
 	about:       "size mismatch",
 	url:         "~charmers/precise/wordpress-0",
 	upload:      storetesting.NewCharm(nil),
 	blobSize:    99999,
-	expectError: "cannot read charm archive: seek past end of file",
-	// It would be nice if the above error was better and
-	// the cause was:
-	// expectCause: params.ErrInvalidEntity,
+	expectError: "archive is shorter than its declared size 99999",
+	expectCause: params.ErrInvalidEntity,
 }, {
 	about:       "charm uploaded to bundle URL",
 	url:         "~charmers/bundle/foo-0",
@@ -277,19 +274,19 @@ var uploadEntityErrorsTests = []struct {
 	about:       "invalid zip format",
 	url:         "~charmers/foo-0",
 	upload:      zipWithInvalidFormat(),
-	expectError: `cannot read charm archive: zip: not a valid zip file`,
+	expectError: `cannot read archive: zip: not a valid zip file`,
 	expectCause: params.ErrInvalidEntity,
 }, {
 	about:       "invalid zip algorithm",
 	url:         "~charmers/foo-0",
 	upload:      zipWithInvalidAlgorithm(),
-	expectError: `cannot read charm archive: zip: unsupported compression algorithm`,
+	expectError: `cannot open archive file: zip: unsupported compression algorithm`,
 	expectCause: params.ErrInvalidEntity,
 }, {
 	about:       "invalid zip checksum",
 	url:         "~charmers/foo-0",
 	upload:      zipWithInvalidChecksum(),
-	expectError: `cannot read charm archive: zip: checksum error`,
+	expectError: `cannot read archive file: zip: checksum error`,
 	expectCause: params.ErrInvalidEntity,
 }}
 
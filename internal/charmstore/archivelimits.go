@@ -0,0 +1,200 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+)
+
+// Default limits on uploaded archives, used when the
+// corresponding ServerParams fields are zero.
+const (
+	DefaultMaxArchiveSize             = 1 << 30
+	DefaultMaxArchiveUncompressedSize = 4 << 30
+	DefaultMaxArchiveFiles            = 50000
+	DefaultMaxArchivePathDepth        = 64
+	DefaultMaxArchiveCompressionRatio = 100
+	DefaultMaxArchiveMetadataSize     = 1 << 20
+)
+
+// archiveMetadataFiles holds the names of the archive files that are
+// read into memory when a charm or bundle is read. The size of each
+// is limited by ServerParams.MaxArchiveMetadataSize.
+var archiveMetadataFiles = map[string]bool{
+	"metadata.yaml": true,
+	"config.yaml":   true,
+	"actions.yaml":  true,
+	"metrics.yaml":  true,
+	"revision":      true,
+	"bundle.yaml":   true,
+	"README.md":     true,
+}
+
+// setArchiveLimitDefaults sets any zero archive limits
+// in p to their default values.
+func (p *ServerParams) setArchiveLimitDefaults() {
+	if p.MaxArchiveSize == 0 {
+		p.MaxArchiveSize = DefaultMaxArchiveSize
+	}
+	if p.MaxArchiveUncompressedSize == 0 {
+		p.MaxArchiveUncompressedSize = DefaultMaxArchiveUncompressedSize
+	}
+	if p.MaxArchiveFiles == 0 {
+		p.MaxArchiveFiles = DefaultMaxArchiveFiles
+	}
+	if p.MaxArchivePathDepth == 0 {
+		p.MaxArchivePathDepth = DefaultMaxArchivePathDepth
+	}
+	if p.MaxArchiveCompressionRatio == 0 {
+		p.MaxArchiveCompressionRatio = DefaultMaxArchiveCompressionRatio
+	}
+	if p.MaxArchiveMetadataSize == 0 {
+		p.MaxArchiveMetadataSize = DefaultMaxArchiveMetadataSize
+	}
+}
+
+// checkArchiveSize checks that an archive of the given
+// size is within the configured archive size limit.
+func (s *Store) checkArchiveSize(size int64) error {
+	if max := s.pool.config.MaxArchiveSize; max > 0 && size > max {
+		return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive size %d exceeds maximum %d", size, max)
+	}
+	return nil
+}
+
+// checkArchiveLimits checks the zip archive of the given size read
+// from r against the configured archive limits.
+//
+// The limits are first checked against the sizes recorded in the
+// archive directory. As those sizes can be forged and the zip readers
+// do not necessarily check them before all the content has been read,
+// the content of every file is then uncompressed, without being kept,
+// to check that it is no larger than its recorded size. This bounds
+// the amount of data uncompressed when the charm or bundle is read.
+func (s *Store) checkArchiveLimits(r io.ReaderAt, size int64) error {
+	config := &s.pool.config
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return errgo.WithCausef(err, params.ErrInvalidEntity, "cannot read archive")
+	}
+	if max := config.MaxArchiveFiles; max > 0 && len(zr.File) > max {
+		return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive contains %d files, exceeding maximum %d", len(zr.File), max)
+	}
+	var total int64
+	for _, f := range zr.File {
+		if max := config.MaxArchivePathDepth; max > 0 {
+			if depth := len(strings.Split(strings.Trim(f.Name, "/"), "/")); depth > max {
+				return errgo.WithCausef(nil, params.ErrInvalidEntity, "path %q in archive has depth %d, exceeding maximum %d", f.Name, depth, max)
+			}
+		}
+		if max := config.MaxArchiveMetadataSize; max > 0 && archiveMetadataFiles[path.Clean(f.Name)] && f.UncompressedSize64 > uint64(max) {
+			return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive file %q has size %d, exceeding maximum %d", f.Name, f.UncompressedSize64, max)
+		}
+		if f.UncompressedSize64 > math.MaxInt64-uint64(total) {
+			total = math.MaxInt64
+		} else {
+			total += int64(f.UncompressedSize64)
+		}
+	}
+	if max := config.MaxArchiveUncompressedSize; max > 0 && total > max {
+		return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive uncompressed size %d exceeds maximum %d", total, max)
+	}
+	if max := int64(config.MaxArchiveCompressionRatio); max > 0 && size > 0 && total/size > max {
+		return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive compression ratio %d exceeds maximum %d", total/size, max)
+	}
+	for _, f := range zr.File {
+		if err := checkArchiveFileSize(f); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrInvalidEntity))
+		}
+	}
+	return nil
+}
+
+// seekableArchive returns a reader holding the archive of the given
+// size and SHA384 hash read from blob, so that the archive content
+// can be checked before it is stored. If blob cannot seek, its content
+// is copied to a temporary file. The size and hash are checked while
+// the archive is read. The returned cleanup function should be called
+// after the returned reader has been used.
+func seekableArchive(blob io.Reader, size int64, hash string) (_ io.ReadSeeker, cleanup func(), err error) {
+	archive, ok := blob.(io.ReadSeeker)
+	w := ioutil.Discard
+	cleanup = func() {}
+	if !ok {
+		f, err := ioutil.TempFile("", "charmstore-archive")
+		if err != nil {
+			return nil, nil, errgo.Notef(err, "cannot create temporary file")
+		}
+		cleanup = func() {
+			f.Close()
+			os.Remove(f.Name())
+		}
+		archive, w = f, f
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+	h := blobstore.NewHash()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(blob, size+1))
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot read archive")
+	}
+	if n < size {
+		return nil, nil, errgo.WithCausef(nil, params.ErrInvalidEntity, "archive is shorter than its declared size %d", size)
+	}
+	if n > size {
+		return nil, nil, errgo.WithCausef(nil, params.ErrInvalidEntity, "archive is longer than its declared size %d", size)
+	}
+	if fmt.Sprintf("%x", h.Sum(nil)) != hash {
+		return nil, nil, errgo.WithCausef(nil, params.ErrInvalidEntity, "archive hash mismatch")
+	}
+	if _, err := archive.Seek(0, 0); err != nil {
+		return nil, nil, errgo.Notef(err, "cannot seek to start of archive")
+	}
+	return archive, cleanup, nil
+}
+
+// checkArchiveFileSize checks that the content of the given
+// archive file is no larger than its recorded size.
+func checkArchiveFileSize(f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return zipReadError(err, "cannot open archive file")
+	}
+	defer r.Close()
+	return errgo.Mask(copyArchiveFile(ioutil.Discard, r, f.Name, f.UncompressedSize64), errgo.Is(params.ErrInvalidEntity))
+}
+
+// copyArchiveFile copies the content of the archive file with the given
+// name and recorded uncompressed size from r to w. No more than size+1
+// bytes are read from r, and an error with a params.ErrInvalidEntity
+// cause is returned if the content is larger than size.
+func copyArchiveFile(w io.Writer, r io.Reader, name string, size uint64) error {
+	limit := int64(math.MaxInt64)
+	if size < math.MaxInt64 {
+		limit = int64(size) + 1
+	}
+	n, err := io.Copy(w, io.LimitReader(r, limit))
+	if err != nil {
+		return zipReadError(err, "cannot read archive file")
+	}
+	if uint64(n) > size {
+		return errgo.WithCausef(nil, params.ErrInvalidEntity, "archive file %q is larger than its recorded size %d", name, size)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type ArchiveLimitsSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&ArchiveLimitsSuite{})
+
+// zipFile holds a file to be added to an archive made by makeZip.
+type zipFile struct {
+	name    string
+	content string
+}
+
+// makeZip returns a zip archive holding the given files.
+func makeZip(c *gc.C, files ...zipFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   f.name,
+			Method: zip.Deflate,
+		})
+		c.Assert(err, gc.IsNil)
+		_, err = io.WriteString(w, f.content)
+		c.Assert(err, gc.IsNil)
+	}
+	err := zw.Close()
+	c.Assert(err, gc.IsNil)
+	return buf.Bytes()
+}
+
+// forgeUncompressedSizes returns a copy of the given zip archive
+// with the uncompressed sizes recorded in its central directory
+// replaced by the given size.
+func forgeUncompressedSizes(c *gc.C, data []byte, size uint32) []byte {
+	data = append([]byte(nil), data...)
+	found := false
+	for i := 0; i+28 <= len(data); i++ {
+		if string(data[i:i+4]) == "PK\x01\x02" {
+			binary.LittleEndian.PutUint32(data[i+24:], size)
+			found = true
+		}
+	}
+	c.Assert(found, gc.Equals, true)
+	return data
+}
+
+var archiveLimitsTests = []struct {
+	about       string
+	config      ServerParams
+	files       []zipFile
+	expectError string
+}{{
+	about: "archive too large",
+	config: ServerParams{
+		MaxArchiveSize: 10,
+	},
+	files:       []zipFile{{"metadata.yaml", "name: foo"}},
+	expectError: `archive size [0-9]+ exceeds maximum 10`,
+}, {
+	about: "too many files",
+	config: ServerParams{
+		MaxArchiveFiles: 2,
+	},
+	files:       []zipFile{{"a", "a"}, {"b", "b"}, {"c", "c"}},
+	expectError: `archive contains 3 files, exceeding maximum 2`,
+}, {
+	about: "path too deep",
+	config: ServerParams{
+		MaxArchivePathDepth: 2,
+	},
+	files:       []zipFile{{"a/b", "x"}, {"a/b/c", "x"}},
+	expectError: `path "a/b/c" in archive has depth 3, exceeding maximum 2`,
+}, {
+	about: "uncompressed size too large",
+	config: ServerParams{
+		MaxArchiveUncompressedSize: 1000,
+	},
+	files:       []zipFile{{"a", strings.Repeat("x", 600)}, {"b", strings.Repeat("y", 600)}},
+	expectError: `archive uncompressed size 1200 exceeds maximum 1000`,
+}, {
+	about: "metadata file too large",
+	config: ServerParams{
+		MaxArchiveMetadataSize: 100,
+	},
+	files:       []zipFile{{"README.md", strings.Repeat("x", 100)}, {"metadata.yaml", "name: foo\n" + strings.Repeat("#", 100)}},
+	expectError: `archive file "metadata.yaml" has size 110, exceeding maximum 100`,
+}, {
+	about:       "compression ratio too large",
+	files:       []zipFile{{"bomb", strings.Repeat("\x00", 1<<20)}},
+	expectError: `archive compression ratio [0-9]+ exceeds maximum 100`,
+}}
+
+func (s *ArchiveLimitsSuite) TestUploadEntityLimits(c *gc.C) {
+	for i, test := range archiveLimitsTests {
+		c.Logf("test %d: %s", i, test.about)
+		store := s.newStore(c, false)
+		test.config.setArchiveLimitDefaults()
+		store.pool.config = test.config
+		data := makeZip(c, test.files...)
+		url := router.MustNewResolvedURL("cs:~charmers/precise/foo-0", -1)
+		// The archive is checked in place when it can be
+		// read at any offset, and spooled otherwise.
+		for _, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+			err := store.UploadEntity(url, r, hashOfString(string(data)), int64(len(data)), nil)
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+
+			// Nothing has been stored.
+			names, err := store.BlobStore.Names()
+			c.Assert(err, gc.IsNil)
+			c.Assert(names, gc.HasLen, 0)
+		}
+		store.Close()
+	}
+}
+
+func (s *ArchiveLimitsSuite) TestUploadEntityForgedSize(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// The archive directory records a small size for a file that
+	// uncompresses to 1MiB, so it passes the directory checks.
+	data := makeZip(c, zipFile{"metadata.yaml", "name: foo\n" + strings.Repeat("#", 1<<20)})
+	data = forgeUncompressedSizes(c, data, 10)
+	url := router.MustNewResolvedURL("cs:~charmers/precise/foo-0", -1)
+	err := store.UploadEntity(url, bytes.NewReader(data), hashOfString(string(data)), int64(len(data)), nil)
+	// Depending on the Go version, the zip reader may notice
+	// the forged size before the read limit is reached.
+	c.Assert(err, gc.ErrorMatches, `archive file "metadata.yaml" is larger than its recorded size 10|cannot read archive file: zip: not a valid zip file`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+
+	// Nothing has been stored.
+	names, err := store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 0)
+}
+
+func (s *ArchiveLimitsSuite) TestCheckArchiveLimitsInvalidZip(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	data := "not a zip archive"
+	err := store.checkArchiveLimits(strings.NewReader(data), int64(len(data)))
+	c.Assert(err, gc.ErrorMatches, `cannot read archive: zip: not a valid zip file`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+}
+
+func (s *ArchiveLimitsSuite) TestAddPreV5CompatibilityHackBlobForgedSize(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// The juju/zip reader only checks the size at the end
+	// of the content, so the read limit is always reached.
+	data := makeZip(c, zipFile{"metadata.yaml", "name: foo\n" + strings.Repeat("#", 1<<20)})
+	data = forgeUncompressedSizes(c, data, 10)
+	_, err := addPreV5CompatibilityHackBlob(store.BlobStore, bytes.NewReader(data), "foo", int64(len(data)))
+	c.Assert(err, gc.ErrorMatches, `cannot read metadata.yaml from archive: archive file "metadata.yaml" is larger than its recorded size 10`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+}
+
+func (s *ArchiveLimitsSuite) TestCopyArchiveFile(c *gc.C) {
+	var buf bytes.Buffer
+	err := copyArchiveFile(&buf, strings.NewReader("hello"), "foo", 5)
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.String(), gc.Equals, "hello")
+
+	// No more than one byte over the recorded size is read.
+	buf.Reset()
+	r := strings.NewReader("hello world")
+	err = copyArchiveFile(&buf, r, "foo", 3)
+	c.Assert(err, gc.ErrorMatches, `archive file "foo" is larger than its recorded size 3`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+	c.Assert(buf.String(), gc.Equals, "hell")
+	c.Assert(r.Len(), gc.Equals, len(" world"))
+}
+
+func (s *ArchiveLimitsSuite) TestUploadEntityNoLimits(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	store.pool.config.MaxArchiveSize = -1
+	store.pool.config.MaxArchiveCompressionRatio = -1
+
+	// The archive is read as an invalid charm rather than
+	// being rejected because of its compression ratio.
+	data := makeZip(c, zipFile{"bomb", strings.Repeat("\x00", 1<<20)})
+	url := router.MustNewResolvedURL("cs:~charmers/precise/foo-0", -1)
+	err := store.UploadEntity(url, bytes.NewReader(data), hashOfString(string(data)), int64(len(data)), nil)
+	c.Assert(err, gc.ErrorMatches, `cannot read charm archive: archive file "metadata.yaml" not found`)
+}
+
+func (s *ArchiveLimitsSuite) TestUploadEntityFromStream(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// An archive read from a reader that cannot seek is
+	// checked and stored as usual.
+	var buf bytes.Buffer
+	err := storetesting.NewCharm(nil).ArchiveTo(&buf)
+	c.Assert(err, gc.IsNil)
+	hash := hashOfString(buf.String())
+	size := int64(buf.Len())
+	url := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-0", -1)
+	err = store.UploadEntity(url, struct{ io.Reader }{&buf}, hash, size, nil)
+	c.Assert(err, gc.IsNil)
+	entity, err := store.FindEntity(url, FieldSelector("blobhash"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.BlobHash, gc.Equals, hash)
+}
+
+var seekableArchiveTests = []struct {
+	about       string
+	data        string
+	size        int64
+	hash        string
+	expectError string
+}{{
+	about:       "archive too short",
+	data:        "hello",
+	size:        6,
+	expectError: "archive is shorter than its declared size 6",
+}, {
+	about:       "archive too long",
+	data:        "hello",
+	size:        4,
+	expectError: "archive is longer than its declared size 4",
+}, {
+	about:       "hash mismatch",
+	data:        "hello",
+	size:        5,
+	hash:        hashOfString("world"),
+	expectError: "archive hash mismatch",
+}}
+
+func (s *ArchiveLimitsSuite) TestSeekableArchiveErrors(c *gc.C) {
+	for i, test := range seekableArchiveTests {
+		c.Logf("test %d: %s", i, test.about)
+		hash := test.hash
+		if hash == "" {
+			hash = hashOfString(test.data)
+		}
+		_, _, err := seekableArchive(struct{ io.Reader }{strings.NewReader(test.data)}, test.size, hash)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+		c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+	}
+}
+
+func (s *ArchiveLimitsSuite) TestSetArchiveLimitDefaults(c *gc.C) {
+	p := ServerParams{
+		MaxArchiveFiles:     -1,
+		MaxArchivePathDepth: 10,
+	}
+	p.setArchiveLimitDefaults()
+	c.Assert(p, gc.DeepEquals, ServerParams{
+		MaxArchiveSize:             DefaultMaxArchiveSize,
+		MaxArchiveUncompressedSize: DefaultMaxArchiveUncompressedSize,
+		MaxArchiveFiles:            -1,
+		MaxArchivePathDepth:        10,
+		MaxArchiveCompressionRatio: DefaultMaxArchiveCompressionRatio,
+		MaxArchiveMetadataSize:     DefaultMaxArchiveMetadataSize,
+	})
+}
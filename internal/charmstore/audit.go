@@ -0,0 +1,77 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+)
+
+// auditLogRetention holds the length of time that audit log entries
+// are kept in the audits collection before MongoDB removes them.
+const auditLogRetention = 365 * 24 * time.Hour
+
+// AuditFilter holds the criteria used to select entries
+// from the audit log. Zero-valued fields are ignored.
+type AuditFilter struct {
+	// User holds the user that performed the operation.
+	User string
+
+	// Op holds the operation that was performed.
+	Op audit.Operation
+
+	// Entity holds the entity that the operation applied to.
+	// It must match the entity recorded in the entry exactly.
+	Entity *charm.URL
+
+	// After and Before hold the bounds (inclusive) of
+	// the time when the operation was performed.
+	After  time.Time
+	Before time.Time
+
+	// Skip holds the number of matching entries to skip.
+	Skip int
+
+	// Limit holds the maximum number of entries to return.
+	Limit int
+}
+
+// AuditEntries returns the audit log entries that match the given
+// filter, in the order they were recorded.
+func (s *Store) AuditEntries(filter AuditFilter) ([]audit.Entry, error) {
+	query := make(bson.D, 0, 4)
+	if filter.User != "" {
+		query = append(query, bson.DocElem{"user", filter.User})
+	}
+	if filter.Op != "" {
+		query = append(query, bson.DocElem{"op", filter.Op})
+	}
+	if filter.Entity != nil {
+		query = append(query, bson.DocElem{"entity", filter.Entity})
+	}
+	var tquery bson.D
+	if !filter.After.IsZero() {
+		tquery = append(tquery, bson.DocElem{"$gte", filter.After})
+	}
+	if !filter.Before.IsZero() {
+		tquery = append(tquery, bson.DocElem{"$lte", filter.Before})
+	}
+	if len(tquery) > 0 {
+		query = append(query, bson.DocElem{"time", tquery})
+	}
+	q := s.DB.Audits().Find(query).Sort("time", "_id").Skip(filter.Skip)
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	entries := []audit.Entry{}
+	if err := q.All(&entries); err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve audit log entries")
+	}
+	return entries, nil
+}
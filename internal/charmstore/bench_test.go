@@ -0,0 +1,245 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type CountersBenchmarkSuite struct {
+	commonSuite
+	store *Store
+}
+
+var _ = gc.Suite(&CountersBenchmarkSuite{})
+
+func (s *CountersBenchmarkSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.store = s.newStore(c, false)
+}
+
+func (s *CountersBenchmarkSuite) TearDownTest(c *gc.C) {
+	s.store.Close()
+	s.commonSuite.TearDownTest(c)
+}
+
+// counterRequests holds the requests used to compare the
+// aggregation pipeline with map-reduce.
+var counterRequests = []CounterRequest{{
+	Key:    []string{params.StatsArchiveDownload},
+	Prefix: true,
+}, {
+	Key:    []string{params.StatsArchiveDownload},
+	Prefix: true,
+	By:     ByDay,
+}, {
+	Key:    []string{params.StatsArchiveDownload},
+	Prefix: true,
+	By:     ByWeek,
+}, {
+	Key:    []string{params.StatsArchiveDownload},
+	Prefix: true,
+	List:   true,
+}, {
+	Key:    []string{params.StatsArchiveDownload, "trusty"},
+	Prefix: true,
+	List:   true,
+	By:     ByDay,
+}, {
+	Key:    []string{params.StatsArchiveDownload, "trusty", "charm3"},
+	Prefix: true,
+	List:   true,
+	By:     ByWeek,
+}, {
+	Key:   []string{params.StatsArchiveDownload, "trusty", "charm3", "user3", "1"},
+	By:    ByDay,
+	Start: time.Now().Add(-30 * 24 * time.Hour),
+	Stop:  time.Now().Add(-7 * 24 * time.Hour),
+}, {
+	Key:    []string{params.StatsArchiveDownload, "trusty", "charm3"},
+	Prefix: true,
+	By:     ByDay,
+	Start:  time.Now().Add(-10 * 24 * time.Hour),
+}}
+
+func (s *CountersBenchmarkSuite) TestAggregationMatchesMapReduce(c *gc.C) {
+	addSyntheticCounters(c, s.store, 10, 3, 50)
+	for i, req := range counterRequests {
+		c.Logf("test %d: %#v", i, req)
+		searchKey, err := s.store.stats.key(s.store.DB, req.Key, false)
+		c.Assert(err, gc.IsNil)
+		totals, err := s.store.sumCounters(&req, searchKey)
+		c.Assert(err, gc.IsNil)
+		counters, err := s.store.countersFromTotals(&req, totals)
+		c.Assert(err, gc.IsNil)
+		expectTotals, err := mapReduceCounterTotals(s.store, &req, searchKey)
+		c.Assert(err, gc.IsNil)
+		expectCounters, err := s.store.countersFromTotals(&req, expectTotals)
+		c.Assert(err, gc.IsNil)
+		c.Assert(counters, jc.DeepEquals, expectCounters)
+		c.Assert(len(counters) > 0, gc.Equals, true)
+	}
+}
+
+func (s *CountersBenchmarkSuite) BenchmarkCountersAggregation(c *gc.C) {
+	benchmarkCounterTotals(c, s.store, (*Store).sumCounters)
+}
+
+func (s *CountersBenchmarkSuite) BenchmarkCountersMapReduce(c *gc.C) {
+	benchmarkCounterTotals(c, s.store, mapReduceCounterTotals)
+}
+
+// benchmarkCounterTotals benchmarks the given function for summing
+// counters over all the counterRequests on a synthetic stats fixture.
+func benchmarkCounterTotals(c *gc.C, store *Store, sum func(*Store, *CounterRequest, string) ([]counterTotal, error)) {
+	addSyntheticCounters(c, store, 50, 4, 100)
+	searchKeys := make([]string, len(counterRequests))
+	for i, req := range counterRequests {
+		var err error
+		searchKeys[i], err = store.stats.key(store.DB, req.Key, false)
+		c.Assert(err, gc.IsNil)
+	}
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		for j := range counterRequests {
+			if _, err := sum(store, &counterRequests[j], searchKeys[j]); err != nil {
+				c.Fatalf("cannot sum counters: %v", err)
+			}
+		}
+	}
+}
+
+// addSyntheticCounters adds archive download counters for the given
+// number of charms, each with the given number of revisions. Each
+// revision has the given number of counters, at random minutes in the
+// last 90 days. The counters are inserted directly so that large
+// fixtures can be created quickly.
+func addSyntheticCounters(c *gc.C, store *Store, charms, revisions, points int) {
+	r := rand.New(rand.NewSource(0))
+	now := time.Now()
+	for i := 0; i < charms; i++ {
+		bulk := store.DB.StatCounters().Bulk()
+		bulk.Unordered()
+		for rev := 0; rev < revisions; rev++ {
+			url := charm.MustParseURL(fmt.Sprintf("~user%d/trusty/charm%d-%d", i%5, i, rev))
+			skey, err := store.stats.key(store.DB, EntityStatsKey(url, params.StatsArchiveDownload), true)
+			c.Assert(err, gc.IsNil)
+			stamps := make(map[int32]bool)
+			for len(stamps) < points {
+				t := now.Add(-time.Duration(r.Int63n(int64(90 * 24 * time.Hour))))
+				stamps[timeToStamp(t.Truncate(time.Minute))] = true
+			}
+			for stamp := range stamps {
+				bulk.Insert(bson.D{{"k", skey}, {"t", stamp}, {"c", 1 + r.Intn(10)}})
+			}
+		}
+		_, err := bulk.Run()
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+// mapReduceCounterTotals implements Store.sumCounters with the
+// map-reduce jobs that were used before the aggregation pipeline. It is
+// kept to check that both return the same results and to compare their
+// performance.
+func mapReduceCounterTotals(s *Store, req *CounterRequest, searchKey string) ([]counterTotal, error) {
+	var regex string
+	if req.Prefix {
+		regex = "^" + searchKey + ".+"
+	} else {
+		regex = "^" + searchKey + "$"
+	}
+
+	// This reduce function simply sums, for each emitted key, all the values found under it.
+	job := mgo.MapReduce{Reduce: "function(key, values) { return Array.sum(values); }"}
+	var emit string
+	switch req.By {
+	case ByDay:
+		emit = "emit(k+'@'+NumberInt(this.t/86400), this.c);"
+	case ByWeek:
+		emit = "emit(k+'@'+NumberInt(this.t/604800), this.c);"
+	default:
+		emit = "emit(k, this.c);"
+	}
+	if req.List && req.Prefix {
+		job.Scope = bson.D{{"searchKeyLen", len(searchKey)}}
+		job.Map = fmt.Sprintf(`
+			function() {
+				var k = this.k;
+				var i = k.indexOf(':', searchKeyLen)+1;
+				if (k.length > i)  { k = k.substr(0, i)+'*'; }
+				%s
+			}`, emit)
+	} else {
+		emitKey := searchKey
+		if req.Prefix {
+			emitKey += "*"
+		}
+		job.Scope = bson.D{{"emitKey", emitKey}}
+		job.Map = fmt.Sprintf(`
+			function() {
+				var k = emitKey;
+				%s
+			}`, emit)
+	}
+
+	var result []struct {
+		Key   string `bson:"_id"`
+		Value int64
+	}
+	var query, tquery bson.D
+	if !req.Start.IsZero() {
+		tquery = append(tquery, bson.DocElem{
+			Name:  "$gte",
+			Value: timeToStamp(req.Start),
+		})
+	}
+	if !req.Stop.IsZero() {
+		tquery = append(tquery, bson.DocElem{
+			Name:  "$lte",
+			Value: timeToStamp(req.Stop),
+		})
+	}
+	if len(tquery) == 0 {
+		query = bson.D{{"k", bson.D{{"$regex", regex}}}}
+	} else {
+		query = bson.D{{"k", bson.D{{"$regex", regex}}}, {"t", tquery}}
+	}
+	if _, err := s.DB.StatCounters().Find(query).MapReduce(&job, &result); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	totals := make([]counterTotal, len(result))
+	for i, r := range result {
+		totals[i] = counterTotal{
+			Key:   r.Key,
+			Count: r.Value,
+		}
+		if req.By == ByAll {
+			continue
+		}
+		at := strings.Index(r.Key, "@")
+		if at == -1 {
+			return nil, errgo.Newf("bad aggregated key %q", r.Key)
+		}
+		period, err := strconv.ParseInt(r.Key[at+1:], 10, 32)
+		if err != nil {
+			return nil, errgo.Notef(err, "bad aggregated key %q", r.Key)
+		}
+		totals[i].Key = r.Key[:at]
+		totals[i].Period = period
+	}
+	return totals, nil
+}
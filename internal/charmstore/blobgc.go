@@ -0,0 +1,186 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// DefaultBlobGCGracePeriod holds the default minimum age of
+// an unreferenced blob before it is treated as orphaned.
+const DefaultBlobGCGracePeriod = 24 * time.Hour
+
+// BlobGCParams holds the parameters for Store.CollectBlobGarbage.
+type BlobGCParams struct {
+	// GracePeriod holds the minimum age of the unreferenced blobs
+	// that are treated as orphaned. Younger blobs may belong to
+	// uploads that are still in progress. If it is zero,
+	// DefaultBlobGCGracePeriod is used.
+	GracePeriod time.Duration
+
+	// DryRun specifies that orphaned blobs are only
+	// reported, not removed.
+	DryRun bool
+
+	// Stop, if not nil, may be closed to stop the garbage
+	// collection early. An error with an ErrStopped cause
+	// is then returned along with the partial result.
+	Stop <-chan struct{}
+}
+
+// BlobGCResult holds the result of a blob garbage collection.
+type BlobGCResult struct {
+	// Orphans holds the names, in sorted order, of the
+	// unreferenced blobs older than the grace period.
+	Orphans []string
+
+	// Removed holds the number of orphaned blobs removed.
+	// It is always zero for a dry run.
+	Removed int
+
+	// Recent holds the number of unreferenced blobs that were
+	// left alone because they are younger than the grace period.
+	Recent int
+
+	// Unrecognised holds the names, in sorted order, of the
+	// unreferenced blobs whose age cannot be determined from
+	// their name. They are never removed.
+	Unrecognised []string
+}
+
+// CollectBlobGarbage finds the blobs that are not referenced by any
+// entity, resource or upload, and removes those older than the grace period
+// unless p.DryRun is true. Such blobs may be left behind by
+// entity deletions, failed uploads or expired chunked uploads.
+func (s *Store) CollectBlobGarbage(p BlobGCParams) (*BlobGCResult, error) {
+	if p.GracePeriod == 0 {
+		p.GracePeriod = DefaultBlobGCGracePeriod
+	}
+	// List the blobs before finding the references so that any blob
+	// added in between, and referenced by the time it is looked up,
+	// is not mistaken for an orphan.
+	names, err := s.BlobStore.Names()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if isStopped(p.Stop) {
+		return nil, errgo.WithCausef(nil, ErrStopped, "blob garbage collection stopped")
+	}
+	referenced, err := s.referencedBlobs()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	cutoff := time.Now().Add(-p.GracePeriod)
+	var result BlobGCResult
+	for _, name := range names {
+		if referenced[name] {
+			continue
+		}
+		created, ok := blobCreationTime(name)
+		if !ok {
+			result.Unrecognised = append(result.Unrecognised, name)
+			continue
+		}
+		if created.After(cutoff) {
+			result.Recent++
+			continue
+		}
+		result.Orphans = append(result.Orphans, name)
+	}
+	sort.Strings(result.Orphans)
+	sort.Strings(result.Unrecognised)
+	if p.DryRun {
+		return &result, nil
+	}
+	for _, name := range result.Orphans {
+		if isStopped(p.Stop) {
+			return &result, errgo.WithCausef(nil, ErrStopped, "blob garbage collection stopped")
+		}
+		if err := s.BlobStore.Remove(name); err != nil {
+			return &result, errgo.Notef(err, "cannot remove blob %s", name)
+		}
+		result.Removed++
+	}
+	return &result, nil
+}
+
+// referencedBlobs returns the names of all the blobs
+// referenced by entities, resources and uploads.
+func (s *Store) referencedBlobs() (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	var entity mongodoc.Entity
+	iter := s.DB.Entities().Find(nil).Select(FieldSelector("blobname", "blobhash", "prev5blobhash")).Iter()
+	for iter.Next(&entity) {
+		referenced[entity.BlobName] = true
+		if entity.BlobHash != entity.PreV5BlobHash {
+			referenced[preV5CompatibilityBlobName(entity.BlobName)] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate over entities")
+	}
+	var resource mongodoc.Resource
+	iter = s.DB.Resources().Find(nil).Select(FieldSelector("blobname")).Iter()
+	for iter.Next(&resource) {
+		referenced[resource.BlobName] = true
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate over resources")
+	}
+	var upload mongodoc.Upload
+	iter = s.DB.Uploads().Find(nil).Select(FieldSelector("parts")).Iter()
+	for iter.Next(&upload) {
+		for _, part := range upload.Parts {
+			referenced[part.BlobName] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate over uploads")
+	}
+	return referenced, nil
+}
+
+// blobCreationTime returns the time that the blob with the given name
+// was created, as recorded in the object id used to name it. It
+// returns false if the name was not generated by the charm store.
+func blobCreationTime(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(name, preV5CompatibilityBlobName(""))
+	if !bson.IsObjectIdHex(name) {
+		return time.Time{}, false
+	}
+	return bson.ObjectIdHex(name).Time(), true
+}
+
+// collectBlobGarbage runs a blob garbage collection with the
+// parameters configured for the server. It is run periodically
+// by the server, and stops early when stop is closed.
+func collectBlobGarbage(store *Store, stop <-chan struct{}) {
+	config := store.pool.config
+	result, err := store.CollectBlobGarbage(BlobGCParams{
+		GracePeriod: config.BlobGCGracePeriod,
+		DryRun:      config.BlobGCDryRun,
+		Stop:        stop,
+	})
+	if errgo.Cause(err) == ErrStopped {
+		logger.Infof("blob garbage collection stopped")
+		return
+	}
+	if err != nil {
+		logger.Errorf("cannot collect blob garbage: %v", err)
+		return
+	}
+	if config.BlobGCDryRun {
+		for _, name := range result.Orphans {
+			logger.Infof("found orphaned blob %s", name)
+		}
+	}
+	logger.Infof("blob garbage collection found %d orphaned blobs, removed %d; %d recent and %d unrecognised unreferenced blobs left", len(result.Orphans), result.Removed, result.Recent, len(result.Unrecognised))
+}
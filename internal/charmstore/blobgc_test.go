@@ -0,0 +1,193 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type BlobGCSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&BlobGCSuite{})
+
+// blobGCFixture holds the names of the blobs
+// added by addBlobGCFixture.
+type blobGCFixture struct {
+	entityBlob   string
+	compatBlob   string
+	resourceBlob string
+	oldOrphan    string
+	oldCompat    string
+	recentOrphan string
+	unrecognised string
+}
+
+// addBlobGCFixture adds a multi-series charm, a resource and a set of
+// unreferenced blobs of various ages to the given store.
+func addBlobGCFixture(c *gc.C, store *Store) blobGCFixture {
+	var f blobGCFixture
+	url := router.MustNewResolvedURL("cs:~charmers/multi-series-23", 23)
+	err := store.AddCharmWithArchive(url, storetesting.NewCharm(storetesting.MetaWithSupportedSeries(nil, "trusty", "precise")))
+	c.Assert(err, gc.IsNil)
+	entity, err := store.FindEntity(url, FieldSelector("blobname"))
+	c.Assert(err, gc.IsNil)
+	f.entityBlob = entity.BlobName
+	f.compatBlob = preV5CompatibilityBlobName(entity.BlobName)
+
+	old := time.Now().Add(-48 * time.Hour)
+	f.resourceBlob = bson.NewObjectIdWithTime(old).Hex()
+	putBlob(c, store, f.resourceBlob, "resource")
+	err = store.DB.Resources().Insert(&mongodoc.Resource{
+		BaseURL:  charm.MustParseURL("cs:~charmers/multi-series"),
+		Name:     "data",
+		BlobName: f.resourceBlob,
+	})
+	c.Assert(err, gc.IsNil)
+
+	f.oldOrphan = bson.NewObjectIdWithTime(old.Add(-time.Minute)).Hex()
+	putBlob(c, store, f.oldOrphan, "old orphan")
+	f.oldCompat = preV5CompatibilityBlobName(bson.NewObjectIdWithTime(old).Hex())
+	putBlob(c, store, f.oldCompat, "old compatibility blob")
+	f.recentOrphan = bson.NewObjectId().Hex()
+	putBlob(c, store, f.recentOrphan, "recent orphan")
+	f.unrecognised = "unrecognised"
+	putBlob(c, store, f.unrecognised, "unrecognised")
+	return f
+}
+
+func putBlob(c *gc.C, store *Store, name, content string) {
+	err := store.BlobStore.PutUnchallenged(strings.NewReader(content), name, int64(len(content)), hashOfString(content))
+	c.Assert(err, gc.IsNil)
+}
+
+func blobExists(c *gc.C, store *Store, name string) bool {
+	r, _, err := store.BlobStore.Open(name)
+	if err != nil {
+		c.Assert(err, gc.ErrorMatches, "resource.*not found")
+		return false
+	}
+	r.Close()
+	return true
+}
+
+func (s *BlobGCSuite) TestCollectBlobGarbageDryRun(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	f := addBlobGCFixture(c, store)
+
+	result, err := store.CollectBlobGarbage(BlobGCParams{
+		DryRun: true,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &BlobGCResult{
+		Orphans:      sortedStrings(f.oldOrphan, f.oldCompat),
+		Recent:       1,
+		Unrecognised: []string{f.unrecognised},
+	})
+	for _, name := range []string{f.entityBlob, f.compatBlob, f.resourceBlob, f.oldOrphan, f.oldCompat, f.recentOrphan, f.unrecognised} {
+		c.Assert(blobExists(c, store, name), gc.Equals, true, gc.Commentf("blob %s", name))
+	}
+}
+
+func (s *BlobGCSuite) TestCollectBlobGarbageStopped(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	f := addBlobGCFixture(c, store)
+
+	stop := make(chan struct{})
+	close(stop)
+	_, err := store.CollectBlobGarbage(BlobGCParams{
+		Stop: stop,
+	})
+	c.Assert(err, gc.ErrorMatches, `blob garbage collection stopped`)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrStopped)
+	for _, name := range []string{f.oldOrphan, f.oldCompat} {
+		c.Assert(blobExists(c, store, name), gc.Equals, true, gc.Commentf("blob %s", name))
+	}
+}
+
+func (s *BlobGCSuite) TestCollectBlobGarbage(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	f := addBlobGCFixture(c, store)
+
+	result, err := store.CollectBlobGarbage(BlobGCParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &BlobGCResult{
+		Orphans:      sortedStrings(f.oldOrphan, f.oldCompat),
+		Removed:      2,
+		Recent:       1,
+		Unrecognised: []string{f.unrecognised},
+	})
+	for _, name := range []string{f.entityBlob, f.compatBlob, f.resourceBlob, f.recentOrphan, f.unrecognised} {
+		c.Assert(blobExists(c, store, name), gc.Equals, true, gc.Commentf("blob %s", name))
+	}
+	for _, name := range []string{f.oldOrphan, f.oldCompat} {
+		c.Assert(blobExists(c, store, name), gc.Equals, false, gc.Commentf("blob %s", name))
+	}
+
+	// A shorter grace period makes the recent blob an orphan.
+	result, err = store.CollectBlobGarbage(BlobGCParams{
+		GracePeriod: -time.Hour,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &BlobGCResult{
+		Orphans:      []string{f.recentOrphan},
+		Removed:      1,
+		Unrecognised: []string{f.unrecognised},
+	})
+	c.Assert(blobExists(c, store, f.recentOrphan), gc.Equals, false)
+	c.Assert(blobExists(c, store, f.entityBlob), gc.Equals, true)
+}
+
+func (s *BlobGCSuite) TestNewServerBlobGC(c *gc.C) {
+	versions := map[string]NewAPIHandlerFunc{
+		"version1": func(*Pool, ServerParams, string) HTTPCloseHandler {
+			return nopCloseHandler{http.NotFoundHandler()}
+		},
+	}
+	srv, err := NewServer(s.Session.DB("juju_test"), nil, ServerParams{}, versions)
+	c.Assert(err, gc.IsNil)
+	c.Check(srv.pool.blobGC, gc.IsNil)
+	srv.Close()
+
+	srv, err = NewServer(s.Session.DB("juju_test"), nil, ServerParams{
+		BlobGCInterval: 10 * time.Millisecond,
+	}, versions)
+	c.Assert(err, gc.IsNil)
+	defer srv.Close()
+	c.Assert(srv.pool.blobGC, gc.NotNil)
+	store := srv.pool.Store()
+	defer store.Close()
+	f := addBlobGCFixture(c, store)
+	deadline := time.Now().Add(5 * time.Second)
+	for blobExists(c, store, f.oldOrphan) {
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for blob garbage collection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(blobExists(c, store, f.recentOrphan), gc.Equals, true)
+	c.Assert(blobExists(c, store, f.entityBlob), gc.Equals, true)
+}
+
+func sortedStrings(ss ...string) []string {
+	sort.Strings(ss)
+	return ss
+}
@@ -45,7 +45,7 @@ func (s *commonSuite) addRequiredCharms(c *gc.C, bundle charm.Bundle) {
 		}
 		err := store.AddCharmWithArchive(&rurl, ch)
 		c.Assert(err, gc.IsNil)
-		err = store.Publish(&rurl, params.StableChannel)
+		err = store.Publish(&rurl, nil, params.StableChannel)
 		c.Assert(err, gc.IsNil)
 	}
 }
@@ -53,7 +53,7 @@ func (s *commonSuite) addRequiredCharms(c *gc.C, bundle charm.Bundle) {
 func (s *commonSuite) newStore(c *gc.C, withES bool) *Store {
 	var si *SearchIndex
 	if withES {
-		si = &SearchIndex{s.ES, s.TestIndex}
+		si = &SearchIndex{Database: s.ES, Index: s.TestIndex}
 	}
 	p, err := NewPool(s.Session.DB("juju_test"), si, &bakery.NewServiceParams{}, ServerParams{})
 	c.Assert(err, gc.IsNil)
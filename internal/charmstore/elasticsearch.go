@@ -10,7 +10,7 @@ var (
 	esMapping = mustParseJSON(esMappingJSON)
 )
 
-const esSettingsVersion = 8
+const esSettingsVersion = 11
 
 func mustParseJSON(s string) interface{} {
 	var j json.RawMessage
@@ -275,6 +275,18 @@ const esMappingJSON = `
         "omit_norms" : true,
         "index_options" : "docs"
       },
+      "ConfigOptions" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
+      "Actions" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
 
 
       "BundleData" : {
@@ -333,6 +345,9 @@ const esMappingJSON = `
       "TotalDownloads": {
         "type": "long"
       },
+      "RecentDownloads": {
+        "type": "double"
+      },
       "Public": {
         "type": "boolean",
         "index" : "not_analyzed",
@@ -345,6 +360,12 @@ const esMappingJSON = `
         "omit_norms" : true,
         "index_options" : "docs"
       },
+      "Tags" : {
+        "type" : "string",
+        "index": "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
       "SingleSeries": {
         "type": "boolean",
         "index" : "not_analyzed",
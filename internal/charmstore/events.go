@@ -0,0 +1,122 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// eventRetention holds the length of time that change events
+// are kept in the events collection before MongoDB removes them.
+const eventRetention = 90 * 24 * time.Hour
+
+// EventVisibilityDelay holds the length of time after an event is
+// recorded before it is returned by EventsQuery. Event numbers are
+// allocated before the events are inserted, so without the delay a
+// reader could see an event before another with a smaller number has
+// been inserted by a concurrent writer, and then skip over it.
+var EventVisibilityDelay = 10 * time.Second
+
+// AddEvent records a change event of the given kind for the entity
+// with the given URL in the events collection. The channels hold
+// the channels affected by the change, if any.
+func (s *Store) AddEvent(kind mongodoc.EventKind, url *charm.URL, channels ...params.Channel) error {
+	return s.addEventAtTime(kind, url, channels, time.Now())
+}
+
+func (s *Store) addEventAtTime(kind mongodoc.EventKind, url *charm.URL, channels []params.Channel, t time.Time) error {
+	id, err := s.nextSequence("events")
+	if err != nil {
+		return errgo.Notef(err, "cannot add %s event for %q", kind, url)
+	}
+	err = s.DB.Events().Insert(&mongodoc.Event{
+		Id:       id,
+		Kind:     kind,
+		URL:      url,
+		Channels: channels,
+		Time:     t,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot add %s event for %q", kind, url)
+	}
+	return nil
+}
+
+// nextSequence returns the next number in the sequence with the
+// given name. The numbers returned for a sequence start at one and
+// increase by one each time, even when called from several
+// processes.
+func (s *Store) nextSequence(name string) (int64, error) {
+	var doc struct {
+		Seq int64
+	}
+	_, err := s.DB.Sequences().FindId(name).Apply(mgo.Change{
+		Update:    bson.D{{"$inc", bson.D{{"seq", int64(1)}}}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &doc)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot increment %s sequence", name)
+	}
+	return doc.Seq, nil
+}
+
+// EventsExpired reports whether any of the change events recorded after
+// the event with the given number have been removed from the events
+// collection because they were older than the retention period.
+func (s *Store) EventsExpired(since int64) (bool, error) {
+	var oldest mongodoc.Event
+	err := s.DB.Events().Find(nil).Sort("_id").Select(bson.D{{"_id", 1}}).One(&oldest)
+	if err == nil {
+		return oldest.Id > since+1, nil
+	}
+	if err != mgo.ErrNotFound {
+		return false, errgo.Notef(err, "cannot retrieve oldest event")
+	}
+	// All the events have expired, so check whether any were
+	// recorded after the given one.
+	var doc struct {
+		Seq int64
+	}
+	err = s.DB.Sequences().FindId("events").One(&doc)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errgo.Notef(err, "cannot retrieve events sequence")
+	}
+	return doc.Seq > since, nil
+}
+
+// EventsQuery returns a mongo query that iterates, in the order they
+// were recorded, over the change events recorded after the event with
+// the given number. If since is zero, all recorded events are included.
+// Events recorded within the last EventVisibilityDelay are not
+// included, so that the number of the last event returned can be used
+// as the since value of a later query without missing any events.
+//
+// If channel is not params.NoChannel, only events that affect the
+// given channel are included, along with events, such as promulgation
+// and deletion, that are not tied to any channel.
+func (s *Store) EventsQuery(since int64, channel params.Channel) *mgo.Query {
+	query := bson.D{
+		{"_id", bson.D{{"$gt", since}}},
+		{"time", bson.D{{"$lte", time.Now().Add(-EventVisibilityDelay)}}},
+	}
+	if channel != params.NoChannel {
+		query = append(query, bson.DocElem{"$or", []bson.D{
+			{{"channels", channel}},
+			{{"channels", bson.D{{"$exists", false}}}},
+		}})
+	}
+	return s.DB.Events().Find(query).Sort("_id")
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type EventsSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&EventsSuite{})
+
+func (s *EventsSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&EventVisibilityDelay, time.Duration(0))
+}
+
+// eventSummary holds the fields of an event that
+// can be compared deterministically.
+type eventSummary struct {
+	Kind     mongodoc.EventKind
+	URL      *charm.URL
+	Channels []params.Channel
+}
+
+func (s *EventsSuite) TestStoreOperationsRecordEvents(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	id0 := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id0, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	id1 := router.MustNewResolvedURL("~charmers/precise/wordpress-1", -1)
+	err = store.AddCharmWithArchive(id1, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	err = store.Publish(id0, nil, params.DevelopmentChannel, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	err = store.SetPerms(&id0.URL, "stable.read", params.Everyone)
+	c.Assert(err, gc.IsNil)
+	err = store.SetPromulgated(id0, true)
+	c.Assert(err, gc.IsNil)
+	err = store.SetPromulgated(id0, false)
+	c.Assert(err, gc.IsNil)
+	err = store.DeleteEntity(id1)
+	c.Assert(err, gc.IsNil)
+
+	var events []mongodoc.Event
+	err = store.EventsQuery(0, params.NoChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(summarizeEvents(events), jc.DeepEquals, []eventSummary{{
+		Kind:     mongodoc.PublishEvent,
+		URL:      &id0.URL,
+		Channels: []params.Channel{params.DevelopmentChannel, params.StableChannel},
+	}, {
+		Kind:     mongodoc.PermEvent,
+		URL:      charm.MustParseURL("~charmers/wordpress"),
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Kind: mongodoc.PromulgateEvent,
+		URL:  charm.MustParseURL("~charmers/wordpress"),
+	}, {
+		Kind: mongodoc.UnpromulgateEvent,
+		URL:  charm.MustParseURL("~charmers/wordpress"),
+	}, {
+		Kind: mongodoc.DeleteEvent,
+		URL:  &id1.URL,
+	}})
+}
+
+func (s *EventsSuite) TestEventsQuery(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := charm.MustParseURL("~charmers/precise/wordpress-0")
+	err := store.AddEvent(mongodoc.PublishEvent, url, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+	err = store.AddEvent(mongodoc.PublishEvent, url, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	err = store.AddEvent(mongodoc.DeleteEvent, url)
+	c.Assert(err, gc.IsNil)
+
+	var all []mongodoc.Event
+	err = store.EventsQuery(0, params.NoChannel).All(&all)
+	c.Assert(err, gc.IsNil)
+	c.Assert(all, gc.HasLen, 3)
+
+	// Events are numbered in sequence.
+	for i, e := range all {
+		c.Assert(e.Id, gc.Equals, int64(i+1))
+	}
+
+	// Events not tied to a channel are included when filtering by channel.
+	var events []mongodoc.Event
+	err = store.EventsQuery(0, params.StableChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(summarizeEvents(events), jc.DeepEquals, []eventSummary{{
+		Kind:     mongodoc.PublishEvent,
+		URL:      url,
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Kind: mongodoc.DeleteEvent,
+		URL:  url,
+	}})
+
+	// Only events recorded after the given one are returned.
+	err = store.EventsQuery(all[0].Id, params.NoChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(events, jc.DeepEquals, all[1:])
+
+	err = store.EventsQuery(all[2].Id, params.NoChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(events, gc.HasLen, 0)
+
+	// Recent events are not returned until the visibility
+	// delay has passed.
+	s.PatchValue(&EventVisibilityDelay, time.Hour)
+	err = store.EventsQuery(0, params.NoChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(events, gc.HasLen, 0)
+	err = store.addEventAtTime(mongodoc.DeleteEvent, url, nil, time.Now().Add(-2*time.Hour))
+	c.Assert(err, gc.IsNil)
+	err = store.EventsQuery(all[2].Id, params.NoChannel).All(&events)
+	c.Assert(err, gc.IsNil)
+	c.Assert(events, gc.HasLen, 1)
+	c.Assert(events[0].Id, gc.Equals, int64(4))
+}
+
+func (s *EventsSuite) TestEventsExpired(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// No events have been recorded.
+	expired, err := store.EventsExpired(0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(expired, gc.Equals, false)
+
+	url := charm.MustParseURL("~charmers/precise/wordpress-0")
+	for i := 0; i < 3; i++ {
+		err := store.AddEvent(mongodoc.PublishEvent, url, params.StableChannel)
+		c.Assert(err, gc.IsNil)
+	}
+	expired, err = store.EventsExpired(0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(expired, gc.Equals, false)
+
+	// Simulate the expiry of the oldest events.
+	_, err = store.DB.Events().RemoveAll(bson.D{{"_id", bson.D{{"$lte", 2}}}})
+	c.Assert(err, gc.IsNil)
+	for since, expect := range map[int64]bool{0: true, 1: true, 2: false, 3: false} {
+		expired, err := store.EventsExpired(since)
+		c.Assert(err, gc.IsNil)
+		c.Assert(expired, gc.Equals, expect, gc.Commentf("since %d", since))
+	}
+
+	// All the events have expired.
+	_, err = store.DB.Events().RemoveAll(nil)
+	c.Assert(err, gc.IsNil)
+	for since, expect := range map[int64]bool{2: true, 3: false} {
+		expired, err := store.EventsExpired(since)
+		c.Assert(err, gc.IsNil)
+		c.Assert(expired, gc.Equals, expect, gc.Commentf("since %d", since))
+	}
+}
+
+func (s *EventsSuite) TestNextSequence(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	const n = 20
+	seqs := make(chan int64, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			store := store.Copy()
+			defer store.Close()
+			seq, err := store.nextSequence("test")
+			c.Check(err, gc.IsNil)
+			seqs <- seq
+		}()
+	}
+	found := make(map[int64]bool)
+	for i := 0; i < n; i++ {
+		found[<-seqs] = true
+	}
+	for i := int64(1); i <= n; i++ {
+		c.Assert(found[i], gc.Equals, true, gc.Commentf("sequence number %d", i))
+	}
+	seq, err := store.nextSequence("other")
+	c.Assert(err, gc.IsNil)
+	c.Assert(seq, gc.Equals, int64(1))
+}
+
+func summarizeEvents(events []mongodoc.Event) []eventSummary {
+	summary := make([]eventSummary, len(events))
+	for i, e := range events {
+		summary[i] = eventSummary{
+			Kind:     e.Kind,
+			URL:      e.URL,
+			Channels: e.Channels,
+		}
+	}
+	return summary
+}
@@ -0,0 +1,376 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// interestingRefreshInterval holds the interval between computations
+// of the ranking of interesting entities.
+var interestingRefreshInterval = 15 * time.Minute
+
+// interestingPromulgatedFactor holds the factor by which the score
+// of promulgated entities is multiplied.
+const interestingPromulgatedFactor = 2
+
+// featuredId holds the id of the document in the featured collection
+// that holds the list of featured entities.
+const featuredId = "featured"
+
+// SetFeatured replaces the list of featured charms and bundles with
+// the base entities of the given URLs, in order. If one of the base
+// entities does not exist, an error with a params.ErrNotFound cause
+// is returned and the list is left unchanged.
+func (s *Store) SetFeatured(urls []*charm.URL) error {
+	baseURLs := make([]*charm.URL, len(urls))
+	seen := make(map[charm.URL]bool)
+	for i, url := range urls {
+		baseURL := mongodoc.BaseURL(url)
+		if seen[*baseURL] {
+			return errgo.Newf("duplicate featured entity %q", baseURL)
+		}
+		seen[*baseURL] = true
+		if _, err := s.FindBaseEntity(baseURL, FieldSelector("_id")); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		baseURLs[i] = baseURL
+	}
+	// The list is held in a single document so that
+	// it is replaced atomically.
+	_, err := s.DB.Featured().UpsertId(featuredId, &mongodoc.FeaturedList{
+		Id:   featuredId,
+		URLs: baseURLs,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot update featured entities")
+	}
+	return nil
+}
+
+// Featured returns the base URLs of the featured charms
+// and bundles, in order.
+func (s *Store) Featured() ([]*charm.URL, error) {
+	var doc mongodoc.FeaturedList
+	err := s.DB.Featured().FindId(featuredId).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve featured entities")
+	}
+	return doc.URLs, nil
+}
+
+// interestingEntity holds a charm or bundle ranked by Interesting.
+type interestingEntity struct {
+	baseURL *charm.URL
+	entity  *mongodoc.Entity
+	readACL []string
+
+	// score holds the ranking score of the entity.
+	score float64
+}
+
+// interestingRanking holds the most recently computed ranking of
+// interesting entities.
+type interestingRanking struct {
+	mu sync.Mutex
+
+	// ranked holds all the charms and bundles with a stable
+	// revision, in score order.
+	ranked []interestingEntity
+
+	// time holds the time that the computation of ranked
+	// started, or the zero time if no ranking has been computed.
+	time time.Time
+}
+
+// get returns the most recently computed ranking.
+func (r *interestingRanking) get() []interestingEntity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ranked
+}
+
+// set records the given ranking, whose computation started at the
+// given time, unless a ranking that started later is already held.
+func (r *interestingRanking) set(ranked []interestingEntity, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t.After(r.time) {
+		r.ranked, r.time = ranked, t
+	}
+}
+
+// Interesting returns the charms and bundles that are interesting to
+// show when no other search is performed, honoring the Limit, Skip,
+// Admin and Groups fields of sp. Featured entities come first, in
+// their curated order, followed by the other entities with a stable
+// revision, ordered by download velocity over the last week, with
+// promulgated entities favoured.
+//
+// The ranking is expensive to compute, so it is computed periodically
+// in the background (see RefreshInteresting) and Interesting only
+// uses the most recent result. No entities are returned before the
+// first ranking has been computed. As permissions may have changed
+// since then, the current read ACLs of the entities in the returned
+// page are checked again, and entities that may no longer be read
+// are left out of it.
+func (s *Store) Interesting(sp SearchParams) (SearchResult, error) {
+	start := time.Now()
+	featured, err := s.Featured()
+	if err != nil {
+		return SearchResult{}, errgo.Mask(err)
+	}
+	var r SearchResult
+	var page []interestingEntity
+	for _, e := range orderInteresting(s.pool.interesting.get(), featured) {
+		if !sp.Admin && !aclAllows(e.readACL, sp.Groups) {
+			continue
+		}
+		r.Total++
+		if r.Total <= sp.Skip || sp.Limit > 0 && len(page) >= sp.Limit {
+			continue
+		}
+		page = append(page, e)
+	}
+	if !sp.Admin {
+		readable, err := s.readableInteresting(page, sp.Groups)
+		if err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
+		r.Total -= len(page) - len(readable)
+		page = readable
+	}
+	for _, e := range page {
+		// Copy the entity so that callers cannot
+		// change the ranking.
+		entity := *e.entity
+		r.Results = append(r.Results, &entity)
+	}
+	r.SearchTime = time.Since(start)
+	return r, nil
+}
+
+// readableInteresting returns the given entities whose base entity
+// still exists and has a stable read ACL that allows access to
+// everyone or to any of the given groups. The ACLs are read with
+// a single query, so the cost is bounded by the page size.
+func (s *Store) readableInteresting(entities []interestingEntity, groups []string) ([]interestingEntity, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+	baseURLs := make([]*charm.URL, len(entities))
+	for i, e := range entities {
+		baseURLs[i] = e.baseURL
+	}
+	var baseEntities []mongodoc.BaseEntity
+	if err := s.DB.BaseEntities().Find(bson.D{{"_id", bson.D{{"$in", baseURLs}}}}).Select(FieldSelector("channelacls")).All(&baseEntities); err != nil {
+		return nil, errgo.Notef(err, "cannot check permissions of interesting entities")
+	}
+	readACLs := make(map[charm.URL][]string, len(baseEntities))
+	for _, b := range baseEntities {
+		readACLs[*b.URL] = b.ChannelACLs[params.StableChannel].Read
+	}
+	readable := make([]interestingEntity, 0, len(entities))
+	for _, e := range entities {
+		if acl, ok := readACLs[*e.baseURL]; ok && aclAllows(acl, groups) {
+			readable = append(readable, e)
+		}
+	}
+	return readable, nil
+}
+
+// orderInteresting returns the given ranked entities with the
+// entities with the given featured base URLs moved to the front,
+// in the featured order.
+func orderInteresting(ranked []interestingEntity, featured []*charm.URL) []interestingEntity {
+	if len(featured) == 0 {
+		return ranked
+	}
+	index := make(map[charm.URL]int, len(ranked))
+	for i, e := range ranked {
+		index[*e.baseURL] = i
+	}
+	ordered := make([]interestingEntity, 0, len(ranked))
+	isFeatured := make(map[int]bool, len(featured))
+	for _, url := range featured {
+		if i, ok := index[*url]; ok {
+			ordered = append(ordered, ranked[i])
+			isFeatured[i] = true
+		}
+	}
+	for i, e := range ranked {
+		if !isFeatured[i] {
+			ordered = append(ordered, e)
+		}
+	}
+	return ordered
+}
+
+// refreshInteresting computes the ranking used by Interesting. It is
+// run periodically by the server, and stops early when stop is closed.
+func refreshInteresting(store *Store, stop <-chan struct{}) {
+	err := store.refreshInteresting(stop)
+	if errgo.Cause(err) == ErrStopped {
+		logger.Infof("interesting entity ranking stopped")
+		return
+	}
+	if err != nil {
+		logger.Errorf("cannot rank interesting entities: %v", err)
+	}
+}
+
+// RefreshInteresting computes the ranking of interesting entities
+// used by Interesting from the current contents of the store. It is
+// called periodically by the server, so it only needs to be called
+// directly when an up to date ranking is needed immediately.
+func (s *Store) RefreshInteresting() error {
+	return errgo.Mask(s.refreshInteresting(nil))
+}
+
+// refreshInteresting implements RefreshInteresting. It returns an
+// error with an ErrStopped cause if stop is closed before the ranking
+// has been computed.
+func (s *Store) refreshInteresting(stop <-chan struct{}) error {
+	start := time.Now()
+	ranked, err := s.rankInteresting(stop)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrStopped))
+	}
+	s.pool.interesting.set(ranked, start)
+	return nil
+}
+
+// rankInteresting returns all the charms and bundles with a stable
+// revision in score order. It returns an error with an ErrStopped
+// cause if stop is closed before it has finished.
+func (s *Store) rankInteresting(stop <-chan struct{}) ([]interestingEntity, error) {
+	weekAgo := time.Now().AddDate(0, 0, -7)
+	var ranked []interestingEntity
+	iter := s.DB.BaseEntities().Find(bson.D{{
+		"channelentities." + string(params.StableChannel), bson.D{{"$exists", true}},
+	}}).Select(FieldSelector("promulgated", "channelacls", "channelentities")).Iter()
+	defer iter.Close()
+	for {
+		var baseEntity mongodoc.BaseEntity
+		if !iter.Next(&baseEntity) {
+			break
+		}
+		if isStopped(stop) {
+			return nil, errgo.WithCausef(nil, ErrStopped, "ranking stopped after %d entities", len(ranked))
+		}
+		url := latestEntityURL(baseEntity.ChannelEntities[params.StableChannel])
+		if url == nil {
+			continue
+		}
+		entity, err := s.FindEntity(&router.ResolvedURL{URL: *url, PromulgatedRevision: -1}, FieldSelector(
+			"promulgated-url",
+			"promulgated-revision",
+			"series",
+			"supportedseries",
+			"uploadtime",
+		))
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot find entity %v", url)
+		}
+		// Only the latest revision of a promulgated base entity is
+		// returned with a promulgated URL, as for search results.
+		if !baseEntity.Promulgated {
+			entity.PromulgatedURL = nil
+			entity.PromulgatedRevision = -1
+		}
+		_, counts, err := s.ArchiveDownloadCounts(EntityResolvedURL(entity).PreferredURL(), false)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		e := interestingEntity{
+			baseURL: baseEntity.URL,
+			entity:  entity,
+			readACL: baseEntity.ChannelACLs[params.StableChannel].Read,
+			score:   interestingScore(counts.LastWeek, entity.UploadTime, weekAgo),
+		}
+		if baseEntity.Promulgated {
+			e.score *= interestingPromulgatedFactor
+		}
+		ranked = append(ranked, e)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate base entities")
+	}
+	sort.Sort(interestingEntities(ranked))
+	return ranked, nil
+}
+
+// interestingScore returns the score of an entity uploaded at the
+// given time that was downloaded the given number of times since
+// weekAgo. The score is the number of downloads per day, counted
+// from the upload time for entities uploaded during the last week,
+// plus one so that promulgation still counts for entities that have
+// not been downloaded.
+func interestingScore(downloads int64, uploadTime, weekAgo time.Time) float64 {
+	since := weekAgo
+	if uploadTime.After(since) {
+		since = uploadTime
+	}
+	days := time.Since(since).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	return float64(downloads)/days + 1
+}
+
+// latestEntityURL returns the URL with the highest revision from the
+// given map of channel entities, or nil if the map is empty.
+func latestEntityURL(entities map[string]*charm.URL) *charm.URL {
+	var latest *charm.URL
+	for _, url := range entities {
+		if latest == nil || url.Revision > latest.Revision || url.Revision == latest.Revision && url.String() < latest.String() {
+			latest = url
+		}
+	}
+	return latest
+}
+
+// aclAllows reports whether the given read ACL allows
+// access to everyone or to any of the given groups.
+func aclAllows(acl []string, groups []string) bool {
+	for _, name := range acl {
+		if name == params.Everyone {
+			return true
+		}
+		for _, g := range groups {
+			if name == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interestingEntities implements sort.Interface to order entities
+// for Interesting: the highest scores first, then by URL to make the
+// order stable.
+type interestingEntities []interestingEntity
+
+func (s interestingEntities) Len() int      { return len(s) }
+func (s interestingEntities) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s interestingEntities) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	return s[i].entity.URL.String() < s[j].entity.URL.String()
+}
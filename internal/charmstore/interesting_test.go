@@ -0,0 +1,297 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type InterestingSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&InterestingSuite{})
+
+func (s *InterestingSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&LegacyDownloadCountsEnabled, false)
+}
+
+// addInterestingCharms adds charms to the store for the Interesting
+// tests. All the charms but ~bob/trusty/wordpress-0 are published to
+// the stable channel; ~alice/trusty/riak-0 may only be read by alice.
+// The charms are then ranked, as the server does periodically.
+func (s *InterestingSuite) addInterestingCharms(c *gc.C, store *Store) {
+	for _, id := range []string{
+		"~charmers/precise/wordpress-0",
+		"~bob/trusty/mysql-0",
+		"~bob/trusty/varnish-0",
+		"~alice/trusty/riak-0",
+	} {
+		rurl := router.MustNewResolvedURL(id, -1)
+		err := store.AddCharmWithArchive(rurl, storetesting.Charms.CharmDir(rurl.URL.Name))
+		c.Assert(err, gc.IsNil)
+		err = store.Publish(rurl, nil, params.StableChannel)
+		c.Assert(err, gc.IsNil)
+		acl := params.Everyone
+		if rurl.URL.User == "alice" {
+			acl = "alice"
+		}
+		err = store.SetPerms(&rurl.URL, "stable.read", acl)
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.AddCharmWithArchive(router.MustNewResolvedURL("~bob/trusty/wordpress-0", -1), storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = store.SetPromulgated(router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1), true)
+	c.Assert(err, gc.IsNil)
+
+	for id, downloads := range map[string]int{
+		"~bob/trusty/mysql-0":   10,
+		"~bob/trusty/varnish-0": 3,
+	} {
+		for i := 0; i < downloads; i++ {
+			err := store.IncrementDownloadCountsAtTime(router.MustNewResolvedURL(id, -1), time.Now())
+			c.Assert(err, gc.IsNil)
+		}
+	}
+	err = store.RefreshInteresting()
+	c.Assert(err, gc.IsNil)
+}
+
+var interestingTests = []struct {
+	about        string
+	featured     []string
+	sp           SearchParams
+	expectTotal  int
+	expectResult []string
+}{{
+	about:       "ranked by downloads with promulgated entities favoured",
+	expectTotal: 3,
+	expectResult: []string{
+		"~bob/trusty/mysql-0",
+		"~bob/trusty/varnish-0",
+		"~charmers/precise/wordpress-0",
+	},
+}, {
+	about:       "featured entities first",
+	featured:    []string{"~charmers/wordpress", "~alice/riak", "~bob/varnish"},
+	expectTotal: 3,
+	expectResult: []string{
+		"~charmers/precise/wordpress-0",
+		"~bob/trusty/varnish-0",
+		"~bob/trusty/mysql-0",
+	},
+}, {
+	about: "private entities readable by group",
+	sp: SearchParams{
+		Groups: []string{"alice"},
+	},
+	expectTotal: 4,
+	expectResult: []string{
+		"~bob/trusty/mysql-0",
+		"~bob/trusty/varnish-0",
+		"~charmers/precise/wordpress-0",
+		"~alice/trusty/riak-0",
+	},
+}, {
+	about: "admin",
+	sp: SearchParams{
+		Admin: true,
+	},
+	expectTotal: 4,
+	expectResult: []string{
+		"~bob/trusty/mysql-0",
+		"~bob/trusty/varnish-0",
+		"~charmers/precise/wordpress-0",
+		"~alice/trusty/riak-0",
+	},
+}, {
+	about: "limit and skip",
+	sp: SearchParams{
+		Limit: 1,
+		Skip:  1,
+	},
+	expectTotal: 3,
+	expectResult: []string{
+		"~bob/trusty/varnish-0",
+	},
+}}
+
+func (s *InterestingSuite) TestInteresting(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	s.addInterestingCharms(c, store)
+	for i, test := range interestingTests {
+		c.Logf("test %d: %s", i, test.about)
+		featured := make([]*charm.URL, len(test.featured))
+		for i, id := range test.featured {
+			featured[i] = charm.MustParseURL(id)
+		}
+		err := store.SetFeatured(featured)
+		c.Assert(err, gc.IsNil)
+		r, err := store.Interesting(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(r.Total, gc.Equals, test.expectTotal)
+		ids := make([]string, len(r.Results))
+		for i, e := range r.Results {
+			ids[i] = e.URL.Path()
+		}
+		c.Assert(ids, jc.DeepEquals, test.expectResult)
+	}
+}
+
+func (s *InterestingSuite) TestInterestingPromulgatedURL(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	s.addInterestingCharms(c, store)
+	r, err := store.Interesting(SearchParams{Limit: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Results, gc.HasLen, 1)
+	c.Assert(r.Results[0].PromulgatedURL, gc.IsNil)
+
+	err = store.SetFeatured([]*charm.URL{charm.MustParseURL("~charmers/wordpress")})
+	c.Assert(err, gc.IsNil)
+	r, err = store.Interesting(SearchParams{Limit: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Results, gc.HasLen, 1)
+	c.Assert(r.Results[0].PromulgatedURL, jc.DeepEquals, charm.MustParseURL("precise/wordpress-0"))
+}
+
+func (s *InterestingSuite) TestInterestingUsesLastRanking(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// Nothing is returned before the first ranking.
+	r, err := store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 0)
+
+	s.addInterestingCharms(c, store)
+	r, err = store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 4)
+
+	// A newly published charm is not returned until
+	// the next ranking.
+	rurl := router.MustNewResolvedURL("~bob/trusty/wordpress-0", -1)
+	err = store.Publish(rurl, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	r, err = store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 4)
+
+	// Featured entities are ordered without a new ranking.
+	err = store.SetFeatured([]*charm.URL{charm.MustParseURL("~alice/riak")})
+	c.Assert(err, gc.IsNil)
+	r, err = store.Interesting(SearchParams{Admin: true, Limit: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Results[0].URL.Path(), gc.Equals, "~alice/trusty/riak-0")
+
+	err = store.RefreshInteresting()
+	c.Assert(err, gc.IsNil)
+	r, err = store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 5)
+
+	// A ranking that started earlier does not replace
+	// the current one.
+	store.pool.interesting.set(nil, time.Now().Add(-time.Hour))
+	r, err = store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 5)
+}
+
+func (s *InterestingSuite) TestInterestingChecksCurrentACLs(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	s.addInterestingCharms(c, store)
+
+	// Making a ranked charm private removes it from the
+	// results before the next ranking.
+	err := store.SetPerms(charm.MustParseURL("~bob/mysql"), "stable.read", "bob")
+	c.Assert(err, gc.IsNil)
+	r, err := store.Interesting(SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 2)
+	ids := make([]string, len(r.Results))
+	for i, e := range r.Results {
+		ids[i] = e.URL.Path()
+	}
+	c.Assert(ids, jc.DeepEquals, []string{
+		"~bob/trusty/varnish-0",
+		"~charmers/precise/wordpress-0",
+	})
+
+	// Only the returned page is checked again.
+	r, err = store.Interesting(SearchParams{Limit: 1, Skip: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 3)
+	c.Assert(r.Results, gc.HasLen, 1)
+	c.Assert(r.Results[0].URL.Path(), gc.Equals, "~bob/trusty/varnish-0")
+
+	// The current ACLs are not checked for admins.
+	r, err = store.Interesting(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(r.Total, gc.Equals, 4)
+}
+
+func (s *InterestingSuite) TestRefreshInterestingStopped(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	s.addInterestingCharms(c, store)
+	stop := make(chan struct{})
+	close(stop)
+	err := store.refreshInteresting(stop)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrStopped)
+}
+
+func (s *InterestingSuite) TestSetFeatured(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	s.addInterestingCharms(c, store)
+
+	urls, err := store.Featured()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, gc.HasLen, 0)
+
+	err = store.SetFeatured([]*charm.URL{
+		charm.MustParseURL("~bob/trusty/varnish-0"),
+		charm.MustParseURL("~charmers/wordpress"),
+	})
+	c.Assert(err, gc.IsNil)
+	urls, err = store.Featured()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, jc.DeepEquals, []*charm.URL{
+		charm.MustParseURL("~bob/varnish"),
+		charm.MustParseURL("~charmers/wordpress"),
+	})
+
+	// Setting an unknown entity leaves the list unchanged.
+	err = store.SetFeatured([]*charm.URL{charm.MustParseURL("~bob/no-such")})
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	urls, err = store.Featured()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, gc.HasLen, 2)
+
+	err = store.SetFeatured([]*charm.URL{
+		charm.MustParseURL("~bob/varnish"),
+		charm.MustParseURL("~bob/trusty/varnish-0"),
+	})
+	c.Assert(err, gc.ErrorMatches, `duplicate featured entity "cs:~bob/varnish"`)
+
+	err = store.SetFeatured(nil)
+	c.Assert(err, gc.IsNil)
+	urls, err = store.Featured()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, gc.HasLen, 0)
+}
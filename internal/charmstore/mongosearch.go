@@ -0,0 +1,472 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// mongoSearchBackend implements SearchBackend by storing search
+// documents in the search collection of the charm store database,
+// using a MongoDB text index for full text search.
+type mongoSearchBackend struct {
+	db StoreDatabase
+}
+
+// NewMongoSearchBackend returns a search backend that stores its
+// index in the given database. It is intended for deployments
+// without Elasticsearch.
+func NewMongoSearchBackend(db *mgo.Database) SearchBackend {
+	return &mongoSearchBackend{
+		db: StoreDatabase{db},
+	}
+}
+
+// mongoSearchDoc holds a document in the search collection.
+type mongoSearchDoc struct {
+	// ID holds the URL of the entity without its revision,
+	// so that later revisions replace earlier ones.
+	ID string `bson:"_id"`
+
+	URL            *charm.URL
+	PromulgatedURL *charm.URL `bson:"promulgated-url,omitempty"`
+	Promulgated    bool
+	Revision       int
+	Name           string
+	User           string
+	Series         []string
+
+	// The fields below are indexed for full text search.
+	Summary     string
+	Description string
+	Tags        []string
+
+	Provides        []string `bson:",omitempty"`
+	Requires        []string `bson:",omitempty"`
+	ConfigOptions   []string `bson:",omitempty"`
+	Actions         []string `bson:",omitempty"`
+	ReadACLs        []string
+	TotalDownloads  int64
+	RecentDownloads float64
+	SingleSeries    bool
+	AllSeries       bool
+}
+
+// EnsureIndexes implements SearchBackend.EnsureIndexes.
+func (b *mongoSearchBackend) EnsureIndexes(force bool) error {
+	db := b.db.copy()
+	defer db.Close()
+	coll := db.Search()
+	if force {
+		if _, err := coll.RemoveAll(nil); err != nil {
+			return errgo.Notef(err, "cannot remove search documents")
+		}
+	}
+	indexes := []mgo.Index{{
+		Key: []string{"$text:name", "$text:summary", "$text:description", "$text:tags"},
+		Weights: map[string]int{
+			"name":        10,
+			"tags":        5,
+			"summary":     3,
+			"description": 1,
+		},
+	}, {
+		Key: []string{"readacls"},
+	}, {
+		Key: []string{"name"},
+	}, {
+		Key: []string{"user"},
+	}}
+	for _, index := range indexes {
+		if err := coll.EnsureIndex(index); err != nil {
+			return errgo.Notef(err, "cannot ensure index with keys %v on search collection", index.Key)
+		}
+	}
+	return nil
+}
+
+// Update implements SearchBackend.Update.
+func (b *mongoSearchBackend) Update(doc *SearchDoc) error {
+	db := b.db.copy()
+	defer db.Close()
+	mdoc := newMongoSearchDoc(doc)
+	_, err := db.Search().Upsert(bson.D{
+		{"_id", mdoc.ID},
+		{"revision", bson.D{{"$lte", mdoc.Revision}}},
+	}, mdoc)
+	if mgo.IsDup(err) {
+		// A later revision is already indexed: the upsert
+		// tried to insert a new document with the same id.
+		return nil
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot update search document for %v", doc.URL)
+	}
+	return nil
+}
+
+// newMongoSearchDoc returns the search collection document
+// corresponding to the given search document.
+func newMongoSearchDoc(doc *SearchDoc) *mongoSearchDoc {
+	url := *doc.URL
+	url.Revision = -1
+	mdoc := &mongoSearchDoc{
+		ID:              url.String(),
+		URL:             doc.URL,
+		PromulgatedURL:  doc.PromulgatedURL,
+		Promulgated:     doc.PromulgatedURL != nil,
+		Revision:        doc.URL.Revision,
+		Name:            doc.URL.Name,
+		User:            doc.URL.User,
+		Series:          doc.Series,
+		Tags:            doc.Tags,
+		Provides:        doc.CharmProvidedInterfaces,
+		Requires:        doc.CharmRequiredInterfaces,
+		ConfigOptions:   doc.ConfigOptions,
+		Actions:         doc.Actions,
+		ReadACLs:        doc.ReadACLs,
+		TotalDownloads:  doc.TotalDownloads,
+		RecentDownloads: doc.RecentDownloads,
+		SingleSeries:    doc.SingleSeries,
+		AllSeries:       doc.AllSeries,
+	}
+	if meta := doc.CharmMeta; meta != nil {
+		mdoc.Summary = meta.Summary
+		mdoc.Description = meta.Description
+	}
+	if data := doc.BundleData; data != nil {
+		mdoc.Description = data.Description
+	}
+	return mdoc
+}
+
+// GetSearchDocument implements SearchBackend.GetSearchDocument.
+// Only the search specific fields and the URL fields of the
+// entity are completed in the returned document.
+func (b *mongoSearchBackend) GetSearchDocument(id *charm.URL) (*SearchDoc, error) {
+	db := b.db.copy()
+	defer db.Close()
+	url := *id
+	url.Revision = -1
+	var mdoc mongoSearchDoc
+	if err := db.Search().FindId(url.String()).One(&mdoc); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "search document for %v not found", id)
+		}
+		return nil, errgo.Notef(err, "cannot retrieve search document for %v", id)
+	}
+	return &SearchDoc{
+		Entity:          mdoc.entity(),
+		TotalDownloads:  mdoc.TotalDownloads,
+		RecentDownloads: mdoc.RecentDownloads,
+		ReadACLs:        mdoc.ReadACLs,
+		Series:          mdoc.Series,
+		Tags:            mdoc.Tags,
+		ConfigOptions:   mdoc.ConfigOptions,
+		Actions:         mdoc.Actions,
+		SingleSeries:    mdoc.SingleSeries,
+		AllSeries:       mdoc.AllSeries,
+	}, nil
+}
+
+// RecentlyDownloaded implements SearchBackend.RecentlyDownloaded.
+func (b *mongoSearchBackend) RecentlyDownloaded() ([]*charm.URL, error) {
+	db := b.db.copy()
+	defer db.Close()
+	var urls []*charm.URL
+	var mdoc mongoSearchDoc
+	iter := db.Search().Find(bson.D{{"recentdownloads", bson.D{{"$ne", 0}}}}).Select(bson.D{{"url", 1}}).Iter()
+	for iter.Next(&mdoc) {
+		urls = append(urls, mdoc.URL)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate over search documents")
+	}
+	return urls, nil
+}
+
+// UpdateRecentDownloads implements SearchBackend.UpdateRecentDownloads.
+func (b *mongoSearchBackend) UpdateRecentDownloads(id *charm.URL, recent float64) error {
+	db := b.db.copy()
+	defer db.Close()
+	url := *id
+	url.Revision = -1
+	err := db.Search().UpdateId(url.String(), bson.D{{"$set", bson.D{{"recentdownloads", recent}}}})
+	if err != nil && err != mgo.ErrNotFound {
+		return errgo.Notef(err, "cannot update search document for %v", id)
+	}
+	return nil
+}
+
+// entity returns the entity for the search document with
+// the fields documented in SearchResult completed.
+func (mdoc *mongoSearchDoc) entity() *mongodoc.Entity {
+	e := &mongodoc.Entity{
+		URL:                 mdoc.URL,
+		PromulgatedURL:      mdoc.PromulgatedURL,
+		PromulgatedRevision: -1,
+	}
+	if mdoc.PromulgatedURL != nil {
+		e.PromulgatedRevision = mdoc.PromulgatedURL.Revision
+	}
+	if mdoc.URL.Series == "" {
+		e.SupportedSeries = mdoc.Series
+	} else if mdoc.URL.Series != "bundle" {
+		e.SupportedSeries = []string{mdoc.URL.Series}
+	}
+	return e
+}
+
+// Search implements SearchBackend.Search.
+func (b *mongoSearchBackend) Search(sp SearchParams) (SearchResult, error) {
+	start := time.Now()
+	db := b.db.copy()
+	defer db.Close()
+	query := createMongoSearchQuery(sp)
+	q := db.Search().Find(query)
+	total, err := q.Count()
+	if err != nil {
+		return SearchResult{}, errgo.Notef(err, "cannot count search results")
+	}
+	fields := bson.D{
+		{"url", 1},
+		{"promulgated-url", 1},
+		{"series", 1},
+	}
+	var sort []string
+	for _, f := range sp.SortFields() {
+		order := ""
+		if strings.HasPrefix(f, "-") {
+			order, f = "-", f[1:]
+		}
+		sort = append(sort, order+sortMongoSearchFields[f])
+	}
+	if len(sort) == 0 {
+		// Sort by relevance, favouring promulgated, recently
+		// downloaded and popular entities as the Elasticsearch
+		// query does.
+		if sp.Text != "" && !sp.AutoComplete {
+			fields = append(fields, bson.DocElem{"score", bson.D{{"$meta", "textScore"}}})
+			sort = append(sort, "$textScore:score")
+		}
+		sort = append(sort, "-promulgated", "-recentdownloads", "-totaldownloads")
+	}
+	// Always sort by id last so that the order,
+	// and hence pagination, is stable.
+	sort = append(sort, "_id")
+	q = q.Select(fields).Sort(sort...).Skip(sp.Skip)
+	if sp.Limit > 0 {
+		q = q.Limit(sp.Limit)
+	}
+	var docs []mongoSearchDoc
+	if err := q.All(&docs); err != nil {
+		return SearchResult{}, errgo.Notef(err, "cannot search")
+	}
+	r := SearchResult{
+		Total:   total,
+		Results: make([]*mongodoc.Entity, len(docs)),
+	}
+	for i := range docs {
+		r.Results[i] = docs[i].entity()
+	}
+	if len(sp.Facets) > 0 {
+		r.Facets = make(map[string][]FacetBucket, len(sp.Facets))
+		for _, facet := range sp.Facets {
+			buckets, err := mongoFacetBuckets(db, query, facet)
+			if err != nil {
+				return SearchResult{}, errgo.Mask(err)
+			}
+			r.Facets[facet] = buckets
+		}
+	}
+	r.SearchTime = time.Since(start)
+	return r, nil
+}
+
+// mongoFacetGroups contains a mapping from a facet in the API to the
+// aggregation pipeline stages that group the matching search documents
+// by facet value.
+var mongoFacetGroups = map[string][]bson.D{
+	"owner": {
+		{{"$group", bson.D{{"_id", "$user"}, {"count", bson.D{{"$sum", 1}}}}}},
+	},
+	"promulgated": {
+		{{"$group", bson.D{{"_id", "$promulgated"}, {"count", bson.D{{"$sum", 1}}}}}},
+	},
+	"series": {
+		{{"$unwind", "$series"}},
+		{{"$group", bson.D{{"_id", "$series"}, {"count", bson.D{{"$sum", 1}}}}}},
+	},
+	"tags": {
+		{{"$unwind", "$tags"}},
+		{{"$group", bson.D{{"_id", "$tags"}, {"count", bson.D{{"$sum", 1}}}}}},
+	},
+	"type": {
+		{{"$group", bson.D{{"_id", bson.D{{"$cond", []interface{}{
+			bson.D{{"$eq", []interface{}{"$series", []string{"bundle"}}}},
+			"bundle",
+			"charm",
+		}}}}, {"count", bson.D{{"$sum", 1}}}}}},
+	},
+}
+
+// mongoFacetBuckets returns the buckets of the given facet for
+// the search documents matching the given query.
+func mongoFacetBuckets(db StoreDatabase, query bson.D, facet string) ([]FacetBucket, error) {
+	pipeline := append([]bson.D{{{"$match", query}}}, mongoFacetGroups[facet]...)
+	var groups []struct {
+		Value interface{} `bson:"_id"`
+		Count int
+	}
+	if err := db.Search().Pipe(pipeline).All(&groups); err != nil {
+		return nil, errgo.Notef(err, "cannot count %s facet", facet)
+	}
+	buckets := make([]FacetBucket, len(groups))
+	for i, g := range groups {
+		buckets[i].Count = g.Count
+		switch v := g.Value.(type) {
+		case bool:
+			buckets[i].Value = "0"
+			if v {
+				buckets[i].Value = "1"
+			}
+		case string:
+			buckets[i].Value = v
+		}
+	}
+	sortFacetBuckets(buckets)
+	return buckets, nil
+}
+
+// sortMongoSearchFields contains a mapping from api fieldnames to the search
+// collection fields to sort by.
+var sortMongoSearchFields = map[string]string{
+	"name":      "name",
+	"owner":     "user",
+	"series":    "series",
+	"downloads": "totaldownloads",
+	"trending":  "recentdownloads",
+}
+
+// createMongoSearchQuery builds a query on the search collection from
+// the search parameters. Filters are handled as for the Elasticsearch
+// query: all the requested filters must match, each matching any one
+// of its values, and unknown filters are ignored.
+func createMongoSearchQuery(sp SearchParams) bson.D {
+	var query bson.D
+	var and []bson.D
+	if sp.ExpandedMultiSeries {
+		and = append(and, bson.D{{"singleseries", true}})
+	} else {
+		and = append(and, bson.D{{"allseries", true}})
+	}
+	if sp.Text != "" {
+		if sp.AutoComplete {
+			and = append(and, bson.D{{"name", bson.RegEx{
+				Pattern: "^" + regexp.QuoteMeta(strings.ToLower(sp.Text)),
+			}}})
+		} else {
+			query = append(query, bson.DocElem{"$text", bson.D{{"$search", sp.Text}}})
+		}
+	}
+	for k, vals := range sp.Filters {
+		filter, ok := mongoFilters[k]
+		if !ok {
+			continue
+		}
+		or := make([]bson.D, len(vals))
+		for i, v := range vals {
+			or[i] = filter(v)
+		}
+		and = append(and, bson.D{{"$or", or}})
+	}
+	if !sp.Admin {
+		and = append(and, bson.D{{"readacls", bson.D{{"$in", append([]string{params.Everyone}, sp.Groups...)}}}})
+	}
+	return append(query, bson.DocElem{"$and", and})
+}
+
+// mongoFilters contains a mapping from a filter parameter in the API to
+// a function that will generate a query on the search collection for
+// the given value.
+var mongoFilters = map[string]func(string) bson.D{
+	"action":        termsMongoFilter("actions"),
+	"config-option": termsMongoFilter("configoptions"),
+	"description":   phraseMongoFilter("description"),
+	"name":          equalMongoFilter("name"),
+	"owner":         ownerMongoFilter,
+	"promulgated":   promulgatedMongoFilter,
+	"provides":      termsMongoFilter("provides"),
+	"requires":      termsMongoFilter("requires"),
+	"series":        equalMongoFilter("series"),
+	"summary":       phraseMongoFilter("summary"),
+	"tags":          termsMongoFilter("tags"),
+	"type":          typeMongoFilter,
+}
+
+// equalMongoFilter returns a function that generates a query matching
+// the given field against the value.
+func equalMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		return bson.D{{field, value}}
+	}
+}
+
+// phraseMongoFilter returns a function that generates a query matching
+// documents with the value in the given field, ignoring case.
+func phraseMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		return bson.D{{field, bson.RegEx{
+			Pattern: regexp.QuoteMeta(value),
+			Options: "i",
+		}}}
+	}
+}
+
+// termsMongoFilter returns a function that generates a query matching
+// documents with all of the space separated terms in the value in the
+// given array field.
+func termsMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		terms := strings.Fields(value)
+		if len(terms) == 0 {
+			return bson.D{}
+		}
+		return bson.D{{field, bson.D{{"$all", terms}}}}
+	}
+}
+
+// ownerMongoFilter generates a query matching the owner of the entity.
+// An empty owner matches promulgated entities.
+func ownerMongoFilter(value string) bson.D {
+	if value == "" {
+		return promulgatedMongoFilter("1")
+	}
+	return bson.D{{"user", value}}
+}
+
+// promulgatedMongoFilter generates a query matching promulgated entities
+// if the value is "1" and other entities otherwise.
+func promulgatedMongoFilter(value string) bson.D {
+	return bson.D{{"promulgated", value == "1"}}
+}
+
+// typeMongoFilter generates a query matching either only charms,
+// or only bundles.
+func typeMongoFilter(value string) bson.D {
+	if value == "bundle" {
+		return bson.D{{"series", "bundle"}}
+	}
+	return bson.D{{"series", bson.D{{"$ne", "bundle"}}}}
+}
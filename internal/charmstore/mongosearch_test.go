@@ -0,0 +1,282 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type MongoSearchSuite struct {
+	commonSuite
+	store *Store
+}
+
+var _ = gc.Suite(&MongoSearchSuite{})
+
+func (s *MongoSearchSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&LegacyDownloadCountsEnabled, false)
+	db := s.Session.DB("juju_test")
+	si := &SearchIndex{
+		Backend: NewMongoSearchBackend(db),
+	}
+	pool, err := NewPool(db, si, nil, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	s.store = pool.Store()
+	pool.Close()
+	addSearchTestEntities(c, s.store)
+}
+
+func (s *MongoSearchSuite) TearDownTest(c *gc.C) {
+	s.store.Close()
+	s.commonSuite.TearDownTest(c)
+}
+
+func (s *MongoSearchSuite) TestSearches(c *gc.C) {
+	for i, test := range searchTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Logf("results: %v", res.Results)
+		sort.Sort(resolvedURLsByString(res.Results))
+		sort.Sort(resolvedURLsByString(test.results))
+		c.Check(res.Results, jc.DeepEquals, test.results)
+		c.Check(res.Total, gc.Equals, len(test.results)+test.totalDiff)
+	}
+}
+
+func (s *MongoSearchSuite) TestFacets(c *gc.C) {
+	for i, test := range facetTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Facets, jc.DeepEquals, test.expectFacets)
+	}
+}
+
+func (s *MongoSearchSuite) TestPaginatedSearch(c *gc.C) {
+	res, err := s.store.Search(SearchParams{
+		Text:  "wordpress",
+		Skip:  1,
+		Limit: 1,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 1)
+	c.Assert(res.Total, gc.Equals, 2)
+}
+
+func (s *MongoSearchSuite) TestSorting(c *gc.C) {
+	tests := []struct {
+		about     string
+		sortQuery string
+		results   []*mongodoc.Entity
+	}{{
+		about:     "name descending",
+		sortQuery: "-name",
+		results: []*mongodoc.Entity{
+			exportTestBundles["wordpress-simple"],
+			exportTestCharms["wordpress"],
+			exportTestCharms["varnish"],
+			exportTestCharms["mysql"],
+		},
+	}, {
+		about:     "owner ascending",
+		sortQuery: "owner,name",
+		results: []*mongodoc.Entity{
+			exportTestCharms["wordpress"],
+			exportTestBundles["wordpress-simple"],
+			exportTestCharms["varnish"],
+			exportTestCharms["mysql"],
+		},
+	}, {
+		about:     "downloads descending",
+		sortQuery: "-downloads",
+		results: []*mongodoc.Entity{
+			exportTestCharms["varnish"],
+			exportTestCharms["mysql"],
+			exportTestBundles["wordpress-simple"],
+			exportTestCharms["wordpress"],
+		},
+	}, {
+		about:     "trending descending",
+		sortQuery: "-trending",
+		results: []*mongodoc.Entity{
+			exportTestCharms["varnish"],
+			exportTestCharms["mysql"],
+			exportTestBundles["wordpress-simple"],
+			exportTestCharms["wordpress"],
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d. %s", i, test.about)
+		var sp SearchParams
+		err := sp.ParseSortFields(test.sortQuery)
+		c.Assert(err, gc.IsNil)
+		res, err := s.store.Search(sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Results, jc.DeepEquals, test.results)
+		c.Assert(res.Total, gc.Equals, len(test.results))
+	}
+}
+
+func (s *MongoSearchSuite) TestDefaultOrder(c *gc.C) {
+	res, err := s.store.Search(SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*mongodoc.Entity{
+		exportTestCharms["mysql"],
+		exportTestBundles["wordpress-simple"],
+		exportTestCharms["wordpress"],
+		exportTestCharms["varnish"],
+	})
+}
+
+func (s *MongoSearchSuite) TestPromulgatedRank(c *gc.C) {
+	ent := newEntity("cs:~charmers/trusty/varnish-1", 1)
+	addCharmForSearch(
+		c,
+		s.store,
+		EntityResolvedURL(ent),
+		storetesting.Charms.CharmDir("varnish"),
+		[]string{ent.URL.User, params.Everyone},
+		0,
+	)
+	res, err := s.store.Search(SearchParams{
+		Filters: map[string][]string{
+			"name": {"varnish"},
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*mongodoc.Entity{
+		ent,
+		exportTestCharms["varnish"],
+	})
+}
+
+func (s *MongoSearchSuite) TestMultiSeriesCharm(c *gc.C) {
+	ent := newEntity("cs:~charmers/juju-gui-25", -1, "trusty", "utopic", "vivid", "wily")
+	addCharmForSearch(
+		c,
+		s.store,
+		EntityResolvedURL(ent),
+		storetesting.Charms.CharmDir("multi-series"),
+		[]string{params.Everyone},
+		0,
+	)
+	filters := map[string][]string{
+		"name": {"juju-gui"},
+	}
+	res, err := s.store.Search(SearchParams{
+		Filters: filters,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*mongodoc.Entity{ent})
+
+	res, err = s.store.Search(SearchParams{
+		Filters:             filters,
+		ExpandedMultiSeries: true,
+		sort:                []sortParam{{Field: "series"}},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*mongodoc.Entity{
+		newEntity("cs:~charmers/trusty/juju-gui-25", -1),
+		newEntity("cs:~charmers/utopic/juju-gui-25", -1),
+		newEntity("cs:~charmers/vivid/juju-gui-25", -1),
+		newEntity("cs:~charmers/wily/juju-gui-25", -1),
+	})
+}
+
+func (s *MongoSearchSuite) TestActionFilter(c *gc.C) {
+	ent := newEntity("cs:~charmers/trusty/dummy-1", -1)
+	addCharmForSearch(
+		c,
+		s.store,
+		EntityResolvedURL(ent),
+		storetesting.Charms.CharmDir("dummy"),
+		[]string{ent.URL.User, params.Everyone},
+		0,
+	)
+	assertActionFilter(c, s.store, ent)
+}
+
+func (s *MongoSearchSuite) TestUpdateKeepsLatestRevision(c *gc.C) {
+	entity, err := s.store.FindEntity(EntityResolvedURL(exportTestCharms["wordpress"]), nil)
+	c.Assert(err, gc.IsNil)
+	err = s.store.ES.update(&SearchDoc{
+		Entity:         entity,
+		TotalDownloads: 4000,
+		ReadACLs:       []string{params.Everyone},
+		AllSeries:      true,
+		SingleSeries:   true,
+	})
+	c.Assert(err, gc.IsNil)
+	doc, err := s.store.ES.GetSearchDocument(entity.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.TotalDownloads, gc.Equals, int64(4000))
+
+	old := *entity
+	oldURL := *entity.URL
+	oldURL.Revision--
+	old.URL = &oldURL
+	err = s.store.ES.update(&SearchDoc{
+		Entity:         &old,
+		TotalDownloads: 1,
+		ReadACLs:       []string{params.Everyone},
+		AllSeries:      true,
+		SingleSeries:   true,
+	})
+	c.Assert(err, gc.IsNil)
+	doc, err = s.store.ES.GetSearchDocument(entity.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.TotalDownloads, gc.Equals, int64(4000))
+	c.Assert(doc.URL, jc.DeepEquals, entity.URL)
+}
+
+func (s *MongoSearchSuite) TestGetSearchDocumentNotFound(c *gc.C) {
+	_, err := s.store.ES.GetSearchDocument(charm.MustParseURL("~charmers/precise/no-such-1"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	c.Assert(err, gc.ErrorMatches, `search document for cs:~charmers/precise/no-such-1 not found`)
+}
+
+func (s *MongoSearchSuite) TestSynchroniseElasticsearch(c *gc.C) {
+	err := s.store.ES.ensureIndexes(true)
+	c.Assert(err, gc.IsNil)
+	res, err := s.store.Search(SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
+
+	err = s.store.SynchroniseElasticsearch(SyncSearchParams{})
+	c.Assert(err, gc.IsNil)
+	res, err = s.store.Search(SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 4)
+}
+
+func (s *MongoSearchSuite) TestRefreshRecentDownloads(c *gc.C) {
+	wordpress := exportTestCharms["wordpress"].URL
+	err := s.store.ES.updateRecentDownloads(wordpress, 4)
+	c.Assert(err, gc.IsNil)
+	urls, err := s.store.ES.recentlyDownloaded()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, gc.HasLen, 4)
+
+	n, err := s.store.refreshRecentDownloads(time.Now().Add(RecentDownloadsPeriod+48*time.Hour), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 4)
+	urls, err = s.store.ES.recentlyDownloaded()
+	c.Assert(err, gc.IsNil)
+	c.Assert(urls, gc.HasLen, 0)
+	doc, err := s.store.ES.GetSearchDocument(exportTestCharms["varnish"].URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.TotalDownloads, gc.Equals, int64(5))
+}
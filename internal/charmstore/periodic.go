@@ -0,0 +1,70 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+)
+
+// periodicTask runs a function at a regular interval,
+// passing it a store from a pool and a channel that is
+// closed when the task is closed. The function should
+// return promptly when the channel is closed.
+type periodicTask struct {
+	pool     *Pool
+	interval time.Duration
+	f        func(store *Store, stop <-chan struct{})
+
+	// closing is closed when the task is closed.
+	closing chan struct{}
+
+	// done is closed when the task goroutine has finished.
+	done chan struct{}
+}
+
+// newPeriodicTask starts calling f with a store from the
+// given pool at the given interval.
+func newPeriodicTask(p *Pool, interval time.Duration, f func(store *Store, stop <-chan struct{})) *periodicTask {
+	t := &periodicTask{
+		pool:     p,
+		interval: interval,
+		f:        f,
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *periodicTask) run() {
+	defer close(t.done)
+	for {
+		select {
+		case <-time.After(t.interval):
+		case <-t.closing:
+			return
+		}
+		store := t.pool.Store()
+		t.f(store, t.closing)
+		store.Close()
+	}
+}
+
+// close stops the task, waiting for any call
+// in progress to return.
+func (t *periodicTask) close() {
+	close(t.closing)
+	<-t.done
+}
+
+// isStopped reports whether the given stop channel
+// has been closed. A nil channel is never closed.
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+type PeriodicSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&PeriodicSuite{})
+
+func (s *PeriodicSuite) TestCloseStopsRunningTask(c *gc.C) {
+	p, err := NewPool(s.Session.DB("juju_test"), nil, nil, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer p.Close()
+
+	started := make(chan struct{})
+	t := newPeriodicTask(p, time.Millisecond, func(store *Store, stop <-chan struct{}) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		// Simulate a long-running task that only
+		// returns when it is asked to stop.
+		<-stop
+	})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for task to start")
+	}
+	closed := make(chan struct{})
+	go func() {
+		t.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for task to close")
+	}
+}
+
+func (s *PeriodicSuite) TestIsStopped(c *gc.C) {
+	c.Assert(isStopped(nil), gc.Equals, false)
+	stop := make(chan struct{})
+	c.Assert(isStopped(stop), gc.Equals, false)
+	close(stop)
+	c.Assert(isStopped(stop), gc.Equals, true)
+}
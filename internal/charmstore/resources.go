@@ -0,0 +1,258 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// maxResourceRevisionAttempts holds the number of times UploadResource
+// will try to allocate a new revision for a resource before giving up.
+const maxResourceRevisionAttempts = 10
+
+// UploadResource reads the given blob, which should have the given
+// hash and size, and stores it as a new revision of the resource with
+// the given name, associated with the charm with the given id.
+//
+// The following error causes may be returned:
+//	params.ErrNotFound if the charm does not exist or does not
+//	    declare a resource with the given name.
+//	params.ErrForbidden if the id refers to a bundle.
+func (s *Store) UploadResource(id *router.ResolvedURL, name string, blob io.Reader, blobHash string, size int64) (*mongodoc.Resource, error) {
+	entity, err := s.FindEntity(id, FieldSelector("baseurl", "charmmeta"))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if id.URL.Series == "bundle" {
+		return nil, errgo.WithCausef(nil, params.ErrForbidden, "cannot upload a resource to a bundle")
+	}
+	if entity.CharmMeta == nil {
+		return nil, errgo.Newf("entity %q has no charm metadata", id)
+	}
+	if _, ok := entity.CharmMeta.Resources[name]; !ok {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "charm %s has no resource named %q", id, name)
+	}
+	blobName := bson.NewObjectId().Hex()
+	if err := s.BlobStore.PutUnchallenged(blob, blobName, size, blobHash); err != nil {
+		return nil, errgo.Notef(err, "cannot put resource blob")
+	}
+	res := &mongodoc.Resource{
+		BaseURL:    entity.BaseURL,
+		Name:       name,
+		BlobHash:   blobHash,
+		Size:       size,
+		BlobName:   blobName,
+		UploadTime: time.Now(),
+	}
+	if err := s.insertResource(res); err != nil {
+		if err1 := s.BlobStore.Remove(blobName); err1 != nil {
+			logger.Errorf("cannot remove blob %s after error: %v", blobName, err1)
+		}
+		return nil, errgo.Mask(err)
+	}
+	return res, nil
+}
+
+// AddResourceRevision stores the given blob, which should have the
+// hash and size recorded in res, as the revision of the resource
+// described by res. Unlike UploadResource, it does not allocate a new
+// revision, so that resources can be copied from another charm store
+// with their revisions intact.
+//
+// If the revision already exists, an error with a
+// params.ErrDuplicateUpload cause is returned.
+func (s *Store) AddResourceRevision(res *mongodoc.Resource, blob io.Reader) error {
+	blobName := bson.NewObjectId().Hex()
+	if err := s.BlobStore.PutUnchallenged(blob, blobName, res.Size, res.BlobHash); err != nil {
+		return errgo.Notef(err, "cannot put resource blob")
+	}
+	doc := *res
+	doc.BlobName = blobName
+	err := s.DB.Resources().Insert(&doc)
+	if err == nil {
+		return nil
+	}
+	if err1 := s.BlobStore.Remove(blobName); err1 != nil {
+		logger.Errorf("cannot remove blob %s after error: %v", blobName, err1)
+	}
+	if mgo.IsDup(err) {
+		return errgo.WithCausef(nil, params.ErrDuplicateUpload, "resource %q revision %d already exists", res.Name, res.Revision)
+	}
+	return errgo.Notef(err, "cannot insert resource")
+}
+
+// insertResource inserts the given resource into the resources
+// collection, giving it the next available revision number.
+func (s *Store) insertResource(res *mongodoc.Resource) error {
+	for i := 0; i < maxResourceRevisionAttempts; i++ {
+		latest, err := s.findResource(res.BaseURL, res.Name, -1)
+		switch {
+		case errgo.Cause(err) == params.ErrNotFound:
+			res.Revision = 0
+		case err != nil:
+			return errgo.Mask(err)
+		default:
+			res.Revision = latest.Revision + 1
+		}
+		err = s.DB.Resources().Insert(res)
+		if err == nil {
+			return nil
+		}
+		if !mgo.IsDup(err) {
+			return errgo.Notef(err, "cannot insert resource")
+		}
+		// Another upload of the same resource got the
+		// revision first, so try again with the next one.
+	}
+	return errgo.Newf("cannot allocate a new revision for resource %q", res.Name)
+}
+
+// ListResources returns the current revision of each resource declared
+// in the metadata of the given charm entity, ordered by resource name.
+// The entity must include at least the "baseurl", "charmmeta" and
+// "channelresources" fields.
+//
+// The current revision of a resource is the one published with the
+// entity in the given channel or, if there is none, the latest
+// uploaded revision. Resources that have not yet been uploaded are
+// included as documents holding only the base URL and the name, with
+// a revision of -1. Bundles have no resources, so an empty slice is
+// returned for them.
+func (s *Store) ListResources(entity *mongodoc.Entity, channel params.Channel) ([]*mongodoc.Resource, error) {
+	if entity.URL.Series == "bundle" {
+		return []*mongodoc.Resource{}, nil
+	}
+	if entity.CharmMeta == nil {
+		return nil, errgo.Newf("entity %q has no charm metadata", entity.URL)
+	}
+	names := make([]string, 0, len(entity.CharmMeta.Resources))
+	for name := range entity.CharmMeta.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	docs := make([]*mongodoc.Resource, len(names))
+	for i, name := range names {
+		doc, err := s.findResource(entity.BaseURL, name, publishedRevision(entity, channel, name))
+		if errgo.Cause(err) == params.ErrNotFound {
+			doc = &mongodoc.Resource{
+				BaseURL:  entity.BaseURL,
+				Name:     name,
+				Revision: -1,
+			}
+		} else if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		docs[i] = doc
+	}
+	return docs, nil
+}
+
+// ResolveResource returns the resource with the given name and revision
+// associated with the charm with the given id. If revision is -1, the
+// revision published with the charm in the given channel is returned
+// or, if there is none, the latest uploaded revision.
+//
+// If the resource does not exist, an error with a params.ErrNotFound
+// cause is returned.
+func (s *Store) ResolveResource(id *router.ResolvedURL, name string, revision int, channel params.Channel) (*mongodoc.Resource, error) {
+	entity, err := s.FindEntity(id, FieldSelector("baseurl", "channelresources"))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if revision == -1 {
+		revision = publishedRevision(entity, channel, name)
+	}
+	res, err := s.findResource(entity.BaseURL, name, revision)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return res, nil
+}
+
+// publishedRevision returns the revision of the named resource that
+// was published with the given entity in the given channel,
+// or -1 if there is none.
+func publishedRevision(entity *mongodoc.Entity, channel params.Channel, name string) int {
+	for _, r := range entity.ChannelResources[channel] {
+		if r.Name == name {
+			return r.Revision
+		}
+	}
+	return -1
+}
+
+// publishedResourceRevisions checks that all the given resource
+// revisions exist for the given entity, which must include at least
+// the "baseurl" and "charmmeta" fields, and returns them as a slice
+// ordered by resource name.
+func (s *Store) publishedResourceRevisions(entity *mongodoc.Entity, resources map[string]int) ([]mongodoc.ResourceRevision, error) {
+	revisions := make([]mongodoc.ResourceRevision, 0, len(resources))
+	for name, revision := range resources {
+		if entity.CharmMeta == nil {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "%s has no resources", entity.URL)
+		}
+		if _, ok := entity.CharmMeta.Resources[name]; !ok {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "charm %s has no resource named %q", entity.URL, name)
+		}
+		if _, err := s.findResource(entity.BaseURL, name, revision); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		revisions = append(revisions, mongodoc.ResourceRevision{
+			Name:     name,
+			Revision: revision,
+		})
+	}
+	sort.Sort(resourceRevisionsByName(revisions))
+	return revisions, nil
+}
+
+type resourceRevisionsByName []mongodoc.ResourceRevision
+
+func (r resourceRevisionsByName) Len() int           { return len(r) }
+func (r resourceRevisionsByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r resourceRevisionsByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+
+func (s *Store) findResource(baseURL *charm.URL, name string, revision int) (*mongodoc.Resource, error) {
+	query := bson.D{{"baseurl", baseURL}, {"name", name}}
+	if revision != -1 {
+		query = append(query, bson.DocElem{"revision", revision})
+	}
+	var res mongodoc.Resource
+	err := s.DB.Resources().Find(query).Sort("-revision").One(&res)
+	if err == mgo.ErrNotFound {
+		if revision == -1 {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "resource %q not found", name)
+		}
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "resource %q revision %d not found", name, revision)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find resource %q", name)
+	}
+	return &res, nil
+}
+
+// OpenResourceBlob returns the blob holding the content
+// of the given resource revision.
+func (s *Store) OpenResourceBlob(res *mongodoc.Resource) (*Blob, error) {
+	r, size, err := s.BlobStore.Open(res.BlobName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open resource blob for %q", res.Name)
+	}
+	return &Blob{
+		ReadSeekCloser: r,
+		Size:           size,
+		Hash:           res.BlobHash,
+	}, nil
+}
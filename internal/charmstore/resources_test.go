@@ -0,0 +1,298 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type ResourcesSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&ResourcesSuite{})
+
+func (s *ResourcesSuite) TestUploadResource(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+
+	for i, content := range []string{"first content", "second content"} {
+		res, err := store.UploadResource(id, "for-store", strings.NewReader(content), hashOfString(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.BaseURL, gc.DeepEquals, charm.MustParseURL("~charmers/starsay"))
+		c.Assert(res.Name, gc.Equals, "for-store")
+		c.Assert(res.Revision, gc.Equals, i)
+		c.Assert(res.BlobHash, gc.Equals, hashOfString(content))
+		c.Assert(res.Size, gc.Equals, int64(len(content)))
+	}
+
+	// Revisions are numbered independently for each resource.
+	res, err := store.UploadResource(id, "for-install", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 0)
+
+	// Revisions are shared between all revisions of the charm.
+	id1 := router.MustNewResolvedURL("~charmers/trusty/starsay-1", -1)
+	err = store.AddCharmWithArchive(id1, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+	res, err = store.UploadResource(id1, "for-store", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 2)
+}
+
+func (s *ResourcesSuite) TestUploadResourceErrors(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+
+	_, err = store.UploadResource(id, "no-such", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(err, gc.ErrorMatches, `charm cs:~charmers/trusty/starsay-0 has no resource named "no-such"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	_, err = store.UploadResource(router.MustNewResolvedURL("~charmers/trusty/no-such-0", -1), "for-store", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	_, err = store.UploadResource(id, "for-store", strings.NewReader("x"), hashOfString("y"), 1)
+	c.Assert(err, gc.ErrorMatches, `cannot put resource blob: .*`)
+
+	// No resource has been recorded after the failed uploads.
+	count, err := store.DB.Resources().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+}
+
+func (s *ResourcesSuite) TestAddResourceRevision(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+
+	res := &mongodoc.Resource{
+		BaseURL:  charm.MustParseURL("~charmers/starsay"),
+		Name:     "for-store",
+		Revision: 3,
+		BlobHash: hashOfString("content 3"),
+		Size:     9,
+	}
+	err = store.AddResourceRevision(res, strings.NewReader("content 3"))
+	c.Assert(err, gc.IsNil)
+	got, err := store.ResolveResource(id, "for-store", 3, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	s.assertResourceContent(c, store, got, "content 3")
+
+	err = store.AddResourceRevision(res, strings.NewReader("content 3"))
+	c.Assert(err, gc.ErrorMatches, `resource "for-store" revision 3 already exists`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrDuplicateUpload)
+
+	// New uploads follow the added revision.
+	uploaded, err := store.UploadResource(id, "for-store", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(uploaded.Revision, gc.Equals, 4)
+}
+
+func (s *ResourcesSuite) TestResolveResource(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+
+	_, err = store.ResolveResource(id, "for-store", -1, params.NoChannel)
+	c.Assert(err, gc.ErrorMatches, `resource "for-store" not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	for _, content := range []string{"content 0", "content 1"} {
+		_, err := store.UploadResource(id, "for-store", strings.NewReader(content), hashOfString(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+	}
+
+	res, err := store.ResolveResource(id, "for-store", -1, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 1)
+	s.assertResourceContent(c, store, res, "content 1")
+
+	res, err = store.ResolveResource(id, "for-store", 0, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 0)
+	s.assertResourceContent(c, store, res, "content 0")
+
+	_, err = store.ResolveResource(id, "for-store", 2, params.NoChannel)
+	c.Assert(err, gc.ErrorMatches, `resource "for-store" revision 2 not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *ResourcesSuite) TestListResources(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+	for _, content := range []string{"content 0", "content 1"} {
+		_, err := store.UploadResource(id, "for-store", strings.NewReader(content), hashOfString(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+	}
+
+	entity, err := store.FindEntity(id, FieldSelector("baseurl", "charmmeta", "channelresources"))
+	c.Assert(err, gc.IsNil)
+	docs, err := store.ListResources(entity, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(docs, gc.HasLen, 3)
+
+	baseURL := charm.MustParseURL("~charmers/starsay")
+	c.Assert(docs[0], jc.DeepEquals, &mongodoc.Resource{
+		BaseURL:  baseURL,
+		Name:     "for-install",
+		Revision: -1,
+	})
+	c.Assert(docs[1].BaseURL, jc.DeepEquals, baseURL)
+	c.Assert(docs[1].Name, gc.Equals, "for-store")
+	c.Assert(docs[1].Revision, gc.Equals, 1)
+	c.Assert(docs[1].BlobHash, gc.Equals, hashOfString("content 1"))
+	c.Assert(docs[1].Size, gc.Equals, int64(len("content 1")))
+	c.Assert(docs[2], jc.DeepEquals, &mongodoc.Resource{
+		BaseURL:  baseURL,
+		Name:     "for-upload",
+		Revision: -1,
+	})
+}
+
+func (s *ResourcesSuite) TestListResourcesBundle(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	docs, err := store.ListResources(&mongodoc.Entity{
+		URL:     charm.MustParseURL("~charmers/bundle/wordpress-simple-0"),
+		BaseURL: charm.MustParseURL("~charmers/wordpress-simple"),
+	}, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(docs, gc.HasLen, 0)
+}
+
+func (s *ResourcesSuite) TestPublishWithResources(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+	for _, content := range []string{"content 0", "content 1", "content 2"} {
+		_, err := store.UploadResource(id, "for-store", strings.NewReader(content), hashOfString(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+	}
+	_, err = store.UploadResource(id, "for-install", strings.NewReader("install"), hashOfString("install"), 7)
+	c.Assert(err, gc.IsNil)
+
+	err = store.Publish(id, map[string]int{"for-store": 0, "for-install": 0}, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(id, map[string]int{"for-store": 1}, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+
+	entity, err := store.FindEntity(id, FieldSelector("baseurl", "charmmeta", "channelresources"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.ChannelResources, jc.DeepEquals, map[params.Channel][]mongodoc.ResourceRevision{
+		params.StableChannel: {{
+			Name:     "for-install",
+			Revision: 0,
+		}, {
+			Name:     "for-store",
+			Revision: 0,
+		}},
+		params.DevelopmentChannel: {{
+			Name:     "for-store",
+			Revision: 1,
+		}},
+	})
+
+	// Published revisions are used when no revision is specified.
+	for _, test := range []struct {
+		channel        params.Channel
+		expectRevision int
+	}{
+		{params.StableChannel, 0},
+		{params.DevelopmentChannel, 1},
+		{params.UnpublishedChannel, 2},
+		{params.NoChannel, 2},
+	} {
+		c.Logf("channel %q", test.channel)
+		res, err := store.ResolveResource(id, "for-store", -1, test.channel)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Revision, gc.Equals, test.expectRevision)
+
+		docs, err := store.ListResources(entity, test.channel)
+		c.Assert(err, gc.IsNil)
+		c.Assert(docs, gc.HasLen, 3)
+		c.Assert(docs[1].Name, gc.Equals, "for-store")
+		c.Assert(docs[1].Revision, gc.Equals, test.expectRevision)
+	}
+
+	// An explicit revision is always honored.
+	res, err := store.ResolveResource(id, "for-store", 2, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 2)
+
+	// Publishing again without resources removes the published revisions.
+	err = store.Publish(id, nil, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+	res, err = store.ResolveResource(id, "for-store", -1, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 2)
+}
+
+func (s *ResourcesSuite) TestPublishWithResourcesErrors(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	id := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("starsay"))
+	c.Assert(err, gc.IsNil)
+	_, err = store.UploadResource(id, "for-store", strings.NewReader("x"), hashOfString("x"), 1)
+	c.Assert(err, gc.IsNil)
+
+	err = store.Publish(id, map[string]int{"no-such": 0}, params.StableChannel)
+	c.Assert(err, gc.ErrorMatches, `charm cs:~charmers/trusty/starsay-0 has no resource named "no-such"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	err = store.Publish(id, map[string]int{"for-store": 1}, params.StableChannel)
+	c.Assert(err, gc.ErrorMatches, `resource "for-store" revision 1 not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// The entity has not been published.
+	entity, err := store.FindEntity(id, FieldSelector("stable", "channelresources"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Stable, gc.Equals, false)
+	c.Assert(entity.ChannelResources, gc.IsNil)
+}
+
+func (s *ResourcesSuite) assertResourceContent(c *gc.C, store *Store, res *mongodoc.Resource, expect string) {
+	blob, err := store.OpenResourceBlob(res)
+	c.Assert(err, gc.IsNil)
+	defer blob.Close()
+	c.Assert(blob.Size, gc.Equals, int64(len(expect)))
+	c.Assert(blob.Hash, gc.Equals, hashOfString(expect))
+	data, err := ioutil.ReadAll(blob)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, expect)
+}
+
+func hashOfString(s string) string {
+	h := blobstore.NewHash()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
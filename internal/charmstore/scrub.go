@@ -0,0 +1,208 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// The following messages are logged with the mongodoc.IntegrityType
+// log type at info level when a blob integrity scrub starts and
+// completes. Each archive that fails the check is logged with the
+// same type at error level in between.
+const (
+	BlobScrubStart    = "blob integrity scrub started"
+	BlobScrubComplete = "blob integrity scrub completed"
+)
+
+// BlobScrubResult holds the result of a blob integrity scrub.
+type BlobScrubResult struct {
+	// Checked holds the number of entities whose
+	// archives were checked.
+	Checked int
+
+	// Mismatches holds the ids of the entities, in the order
+	// they were checked, whose archives could not be read or
+	// do not match the recorded sizes and hashes.
+	Mismatches []*charm.URL
+}
+
+// ScrubBlobs reads the archive of every entity in the store, checking
+// that its content still matches the recorded size, BlobHash and
+// BlobHash256 and, for entities with a pre-v5 compatibility blob, the
+// recorded PreV5BlobSize, PreV5BlobHash and PreV5BlobHash256. Each
+// failure is recorded as an error log with the mongodoc.IntegrityType
+// log type.
+//
+// If stop is not nil, it may be closed to stop the scrub early. An
+// error with an ErrStopped cause is then returned along with the
+// partial result.
+func (s *Store) ScrubBlobs(stop <-chan struct{}) (*BlobScrubResult, error) {
+	if err := s.addIntegrityLog(mongodoc.InfoLevel, BlobScrubStart, nil); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var result BlobScrubResult
+	var entity mongodoc.Entity
+	iter := s.DB.Entities().Find(nil).Select(FieldSelector(
+		"promulgated-url",
+		"size",
+		"blobname",
+		"blobhash",
+		"blobhash256",
+		"prev5blobhash",
+		"prev5blobhash256",
+		"prev5blobsize",
+	)).Iter()
+	for iter.Next(&entity) {
+		if isStopped(stop) {
+			iter.Close()
+			return &result, errgo.WithCausef(nil, ErrStopped, "blob integrity scrub stopped after %d archives", result.Checked)
+		}
+		result.Checked++
+		problems := s.checkBlob(&entity)
+		if len(problems) == 0 {
+			continue
+		}
+		result.Mismatches = append(result.Mismatches, entity.URL)
+		urls := []*charm.URL{entity.URL}
+		if entity.PromulgatedURL != nil {
+			urls = append(urls, entity.PromulgatedURL)
+		}
+		msg := fmt.Sprintf("archive of %s failed integrity check: %s", entity.URL, strings.Join(problems, "; "))
+		if err := s.addIntegrityLog(mongodoc.ErrorLevel, msg, urls); err != nil {
+			iter.Close()
+			return &result, errgo.Mask(err)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return &result, errgo.Notef(err, "cannot iterate over entities")
+	}
+	msg := fmt.Sprintf("%s: %d archives checked, %d failed", BlobScrubComplete, result.Checked, len(result.Mismatches))
+	if err := s.addIntegrityLog(mongodoc.InfoLevel, msg, nil); err != nil {
+		return &result, errgo.Mask(err)
+	}
+	return &result, nil
+}
+
+// checkBlob reads the archive of the given entity, and returns
+// a description of each way in which it does not match the
+// recorded sizes and hashes.
+func (s *Store) checkBlob(entity *mongodoc.Entity) []string {
+	r, _, err := s.BlobStore.Open(entity.BlobName)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot open blob: %v", err)}
+	}
+	defer r.Close()
+	// The pre-v5 archive is the main blob followed by the
+	// compatibility blob, so the pre-v5 hashes are computed
+	// in the same pass as the main ones.
+	hasPreV5 := entity.PreV5BlobHash != entity.BlobHash
+	main := newBlobChecksum()
+	preV5 := newBlobChecksum()
+	w := io.Writer(main)
+	if hasPreV5 {
+		w = io.MultiWriter(main, preV5)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return []string{fmt.Sprintf("cannot read blob: %v", err)}
+	}
+	problems := main.check("", entity.Size, entity.BlobHash, entity.BlobHash256)
+	if !hasPreV5 {
+		return problems
+	}
+	r2, _, err := s.BlobStore.Open(preV5CompatibilityBlobName(entity.BlobName))
+	if err != nil {
+		return append(problems, fmt.Sprintf("cannot open pre-v5 compatibility blob: %v", err))
+	}
+	defer r2.Close()
+	if _, err := io.Copy(preV5, r2); err != nil {
+		return append(problems, fmt.Sprintf("cannot read pre-v5 compatibility blob: %v", err))
+	}
+	return append(problems, preV5.check("pre-v5 ", entity.PreV5BlobSize, entity.PreV5BlobHash, entity.PreV5BlobHash256)...)
+}
+
+// blobChecksum is an io.Writer that computes the size
+// and hashes of the content written to it.
+type blobChecksum struct {
+	size    int64
+	hash    hash.Hash
+	hash256 hash.Hash
+}
+
+func newBlobChecksum() *blobChecksum {
+	return &blobChecksum{
+		hash:    blobstore.NewHash(),
+		hash256: sha256.New(),
+	}
+}
+
+// Write implements io.Writer.
+func (c *blobChecksum) Write(buf []byte) (int, error) {
+	c.size += int64(len(buf))
+	c.hash.Write(buf)
+	c.hash256.Write(buf)
+	return len(buf), nil
+}
+
+// check returns a description of each difference between the content
+// written so far and the given expected values, each prefixed with
+// the given qualifier. An empty hash256 is not checked, as it is not
+// recorded for some older entities.
+func (c *blobChecksum) check(qualifier string, size int64, hash, hash256 string) []string {
+	var problems []string
+	if c.size != size {
+		problems = append(problems, fmt.Sprintf("%ssize mismatch (got %d, want %d)", qualifier, c.size, size))
+	}
+	if got := fmt.Sprintf("%x", c.hash.Sum(nil)); got != hash {
+		problems = append(problems, fmt.Sprintf("%shash mismatch (got %s, want %s)", qualifier, got, hash))
+	}
+	if got := fmt.Sprintf("%x", c.hash256.Sum(nil)); hash256 != "" && got != hash256 {
+		problems = append(problems, fmt.Sprintf("%sSHA256 hash mismatch (got %s, want %s)", qualifier, got, hash256))
+	}
+	return problems
+}
+
+// addIntegrityLog adds a log with the given level and message
+// and the mongodoc.IntegrityType log type.
+func (s *Store) addIntegrityLog(level mongodoc.LogLevel, msg string, urls []*charm.URL) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal log message")
+	}
+	raw := json.RawMessage(data)
+	if err := s.AddLog(&raw, level, mongodoc.IntegrityType, urls); err != nil {
+		return errgo.Notef(err, "cannot add integrity log")
+	}
+	return nil
+}
+
+// scrubBlobs runs a blob integrity scrub. It is run
+// periodically by the server, and stops early when
+// stop is closed.
+func scrubBlobs(store *Store, stop <-chan struct{}) {
+	result, err := store.ScrubBlobs(stop)
+	if errgo.Cause(err) == ErrStopped {
+		logger.Infof("%v", err)
+		return
+	}
+	if err != nil {
+		logger.Errorf("cannot scrub blobs: %v", err)
+		return
+	}
+	for _, id := range result.Mismatches {
+		logger.Errorf("archive of %s failed integrity check", id)
+	}
+	logger.Infof("blob integrity scrub checked %d archives, %d failed", result.Checked, len(result.Mismatches))
+}
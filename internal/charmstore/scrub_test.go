@@ -0,0 +1,186 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type ScrubSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&ScrubSuite{})
+
+// replaceBlob replaces the content of the blob with the given name.
+func replaceBlob(c *gc.C, store *Store, name, content string) {
+	err := store.BlobStore.Remove(name)
+	c.Assert(err, gc.IsNil)
+	putBlob(c, store, name, content)
+}
+
+// integrityLogs returns the messages of the integrity logs with
+// the given level, in the order they were added.
+func integrityLogs(c *gc.C, store *Store, level mongodoc.LogLevel) []string {
+	var logs []mongodoc.Log
+	err := store.DB.Logs().Find(bson.D{
+		{"type", mongodoc.IntegrityType},
+		{"level", level},
+	}).Sort("time", "_id").All(&logs)
+	c.Assert(err, gc.IsNil)
+	msgs := make([]string, len(logs))
+	for i, log := range logs {
+		err := json.Unmarshal(log.Data, &msgs[i])
+		c.Assert(err, gc.IsNil)
+	}
+	return msgs
+}
+
+func (s *ScrubSuite) TestScrubBlobs(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	good := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-1", 1)
+	err := store.AddCharmWithArchive(good, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+	multi := router.MustNewResolvedURL("cs:~charmers/multi-series-2", -1)
+	err = store.AddCharmWithArchive(multi, storetesting.NewCharm(storetesting.MetaWithSupportedSeries(nil, "trusty", "precise")))
+	c.Assert(err, gc.IsNil)
+
+	result, err := store.ScrubBlobs(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &BlobScrubResult{
+		Checked: 2,
+	})
+	c.Assert(integrityLogs(c, store, mongodoc.ErrorLevel), gc.HasLen, 0)
+	c.Assert(integrityLogs(c, store, mongodoc.InfoLevel), jc.DeepEquals, []string{
+		"blob integrity scrub started",
+		"blob integrity scrub completed: 2 archives checked, 0 failed",
+	})
+
+	// Corrupt the archive of the single-series charm
+	// and the compatibility blob of the multi-series one.
+	entity, err := store.FindEntity(good, FieldSelector("blobname"))
+	c.Assert(err, gc.IsNil)
+	replaceBlob(c, store, entity.BlobName, "corrupted")
+	entity, err = store.FindEntity(multi, FieldSelector("blobname"))
+	c.Assert(err, gc.IsNil)
+	replaceBlob(c, store, preV5CompatibilityBlobName(entity.BlobName), "corrupted")
+
+	result, err = store.ScrubBlobs(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Checked, gc.Equals, 2)
+	c.Assert(result.Mismatches, gc.HasLen, 2)
+	failed := map[string]bool{
+		result.Mismatches[0].String(): true,
+		result.Mismatches[1].String(): true,
+	}
+	c.Assert(failed, jc.DeepEquals, map[string]bool{
+		good.URL.String():  true,
+		multi.URL.String(): true,
+	})
+
+	errors := integrityLogs(c, store, mongodoc.ErrorLevel)
+	c.Assert(errors, gc.HasLen, 2)
+	for _, msg := range errors {
+		if strings.Contains(msg, good.URL.String()) {
+			c.Assert(msg, gc.Matches, `archive of cs:~charmers/precise/wordpress-1 failed integrity check: size mismatch \(got 9, want [0-9]+\); hash mismatch \(got [0-9a-f]{96}, want [0-9a-f]{96}\); SHA256 hash mismatch \(got [0-9a-f]{64}, want [0-9a-f]{64}\)`)
+		} else {
+			// Only the pre-v5 archive is affected.
+			c.Assert(msg, gc.Matches, `archive of cs:~charmers/multi-series-2 failed integrity check: pre-v5 size mismatch \(got [0-9]+, want [0-9]+\); pre-v5 hash mismatch \(got [0-9a-f]{96}, want [0-9a-f]{96}\); pre-v5 SHA256 hash mismatch \(got [0-9a-f]{64}, want [0-9a-f]{64}\)`)
+		}
+	}
+	c.Assert(integrityLogs(c, store, mongodoc.InfoLevel)[3], gc.Equals, "blob integrity scrub completed: 2 archives checked, 2 failed")
+
+	// The failure logs refer to the entities.
+	n, err := store.DB.Logs().Find(bson.D{
+		{"type", mongodoc.IntegrityType},
+		{"urls", charm.MustParseURL("cs:precise/wordpress-1")},
+	}).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+}
+
+func (s *ScrubSuite) TestScrubBlobsMissingBlob(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-1", -1)
+	err := store.AddCharmWithArchive(url, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+	entity, err := store.FindEntity(url, FieldSelector("blobname"))
+	c.Assert(err, gc.IsNil)
+	err = store.BlobStore.Remove(entity.BlobName)
+	c.Assert(err, gc.IsNil)
+
+	result, err := store.ScrubBlobs(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &BlobScrubResult{
+		Checked:    1,
+		Mismatches: []*charm.URL{url.URL},
+	})
+	errors := integrityLogs(c, store, mongodoc.ErrorLevel)
+	c.Assert(errors, gc.HasLen, 1)
+	c.Assert(errors[0], gc.Matches, `archive of cs:~charmers/precise/wordpress-1 failed integrity check: cannot open blob: .*not found`)
+}
+
+func (s *ScrubSuite) TestScrubBlobsStopped(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-1", -1)
+	err := store.AddCharmWithArchive(url, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+
+	stop := make(chan struct{})
+	close(stop)
+	result, err := store.ScrubBlobs(stop)
+	c.Assert(err, gc.ErrorMatches, `blob integrity scrub stopped after 0 archives`)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrStopped)
+	c.Assert(result, jc.DeepEquals, &BlobScrubResult{})
+	c.Assert(integrityLogs(c, store, mongodoc.InfoLevel), jc.DeepEquals, []string{
+		"blob integrity scrub started",
+	})
+}
+
+func (s *ScrubSuite) TestNewServerBlobScrub(c *gc.C) {
+	versions := map[string]NewAPIHandlerFunc{
+		"version1": func(*Pool, ServerParams, string) HTTPCloseHandler {
+			return nopCloseHandler{http.NotFoundHandler()}
+		},
+	}
+	srv, err := NewServer(s.Session.DB("juju_test"), nil, ServerParams{}, versions)
+	c.Assert(err, gc.IsNil)
+	c.Check(srv.pool.blobScrub, gc.IsNil)
+	srv.Close()
+
+	srv, err = NewServer(s.Session.DB("juju_test"), nil, ServerParams{
+		BlobScrubInterval: 10 * time.Millisecond,
+	}, versions)
+	c.Assert(err, gc.IsNil)
+	defer srv.Close()
+	c.Assert(srv.pool.blobScrub, gc.NotNil)
+	store := srv.pool.Store()
+	defer store.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(integrityLogs(c, store, mongodoc.InfoLevel)) < 2 {
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for blob integrity scrub")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,7 +16,6 @@ import (
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
-	"gopkg.in/mgo.v2/bson"
 
 	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
@@ -23,9 +23,51 @@ import (
 	"gopkg.in/juju/charmstore.v5-unstable/internal/series"
 )
 
+// SearchIndex holds the index used to search for charms and bundles.
+// When Database is set, the index is held in the given Elasticsearch
+// index; otherwise Backend, if set, is used. When neither is set,
+// nothing is indexed and searches return no results.
 type SearchIndex struct {
 	*elasticsearch.Database
 	Index string
+
+	// Backend holds the search backend to use
+	// when Database is nil.
+	Backend SearchBackend
+}
+
+// SearchBackend is the interface implemented by search backends
+// that can be used instead of Elasticsearch.
+type SearchBackend interface {
+	// EnsureIndexes makes sure that the indexes used by the backend
+	// exist. If force is true, any existing search documents are
+	// removed so that the index can be populated from scratch.
+	EnsureIndexes(force bool) error
+
+	// Update adds the given document to the index, replacing
+	// any document for the same entity with a lower or equal
+	// revision. A document for a multi-series charm only refers
+	// to the canonical entity; the expanded documents for each of
+	// its supported series are passed in separate calls.
+	Update(doc *SearchDoc) error
+
+	// Search returns the entities matching the given parameters.
+	// The returned entities must have the fields documented in
+	// SearchResult completed.
+	Search(sp SearchParams) (SearchResult, error)
+
+	// GetSearchDocument returns the current search document
+	// for the entity with the given id.
+	GetSearchDocument(id *charm.URL) (*SearchDoc, error)
+
+	// RecentlyDownloaded returns the URLs of the indexed documents
+	// whose RecentDownloads value is not zero.
+	RecentlyDownloaded() ([]*charm.URL, error)
+
+	// UpdateRecentDownloads sets the RecentDownloads value of the
+	// document for the entity with the given id, if there is one,
+	// leaving the rest of the document unchanged.
+	UpdateRecentDownloads(id *charm.URL, recent float64) error
 }
 
 const typeName = "entity"
@@ -53,6 +95,22 @@ type SearchDoc struct {
 	ReadACLs       []string
 	Series         []string
 
+	// RecentDownloads holds the decay-weighted number of recent
+	// downloads of all revisions of the entity, as returned by
+	// Store.RecentDownloads when the document was indexed.
+	RecentDownloads float64
+
+	// Tags holds the categories and tags of a charm, or the
+	// tags of a bundle, without duplicates.
+	Tags []string
+
+	// ConfigOptions holds the names of the charm's configuration
+	// options.
+	ConfigOptions []string
+
+	// Actions holds the names of the charm's actions.
+	Actions []string
+
 	// SingleSeries is true if the document referes to an entity that
 	// describes a single series. This will either be a bundle, a
 	// single-series charm or an expanded record for a multi-series
@@ -81,7 +139,7 @@ func (s *Store) UpdateSearchAsync(r *router.ResolvedURL) {
 // so the latest stable revision of the charm specified by r will be
 // indexed.
 func (s *Store) UpdateSearch(r *router.ResolvedURL) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if !s.ES.enabled() {
 		return nil
 	}
 	// For multi-series charms update the whole base URL.
@@ -92,6 +150,9 @@ func (s *Store) UpdateSearch(r *router.ResolvedURL) error {
 	if !series.Series[r.URL.Series].SearchIndex {
 		return nil
 	}
+	if err := s.recordSearchChange(mongodoc.BaseURL(&r.URL)); err != nil {
+		return errgo.Mask(err)
+	}
 	baseEntity, err := s.FindBaseEntity(&r.URL, nil)
 	if err != nil {
 		return errgo.NoteMask(err, fmt.Sprintf("cannot update search record for %q", &r.URL), errgo.Is(params.ErrNotFound))
@@ -116,15 +177,35 @@ func (s *Store) UpdateSearch(r *router.ResolvedURL) error {
 // the specified base URL. It must be called whenever the entry for the
 // given URL in the BaseEntitites collection has changed.
 func (s *Store) UpdateSearchBaseURL(baseURL *charm.URL) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if !s.ES.enabled() {
 		return nil
 	}
+	if err := s.recordSearchChange(mongodoc.BaseURL(baseURL)); err != nil {
+		return errgo.Mask(err)
+	}
+	docs, err := s.stableSearchDocs(baseURL)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	for _, doc := range docs {
+		if err := s.ES.update(doc); err != nil {
+			return errgo.Notef(err, "cannot update search record for %q", doc.URL)
+		}
+	}
+	return nil
+}
+
+// stableSearchDocs returns the search documents for the latest stable
+// revision of the entity with the given base URL in each indexed
+// series.
+func (s *Store) stableSearchDocs(baseURL *charm.URL) ([]*SearchDoc, error) {
 	baseEntity, err := s.FindBaseEntity(baseURL, nil)
 	if err != nil {
-		return errgo.NoteMask(err, fmt.Sprintf("cannot index %s", baseURL), errgo.Is(params.ErrNotFound))
+		return nil, errgo.NoteMask(err, fmt.Sprintf("cannot index %s", baseURL), errgo.Is(params.ErrNotFound))
 	}
 	stableEntities := baseEntity.ChannelEntities[params.StableChannel]
 	updated := make(map[string]bool, len(stableEntities))
+	var docs []*SearchDoc
 	for urlSeries, url := range stableEntities {
 		if !series.Series[urlSeries].SearchIndex {
 			continue
@@ -135,13 +216,15 @@ func (s *Store) UpdateSearchBaseURL(baseURL *charm.URL) error {
 		updated[url.String()] = true
 		entity, err := s.FindEntity(&router.ResolvedURL{URL: *url}, nil)
 		if err != nil {
-			return errgo.Notef(err, "cannot update search record for %q", url)
+			return nil, errgo.Notef(err, "cannot update search record for %q", url)
 		}
-		if err := s.updateSearchEntity(entity, baseEntity); err != nil {
-			return errgo.Notef(err, "cannot update search record for %q", url)
+		doc, err := s.searchDocFromEntity(entity, baseEntity)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot update search record for %q", url)
 		}
+		docs = append(docs, doc)
 	}
-	return nil
+	return docs, nil
 }
 
 func (s *Store) updateSearchEntity(entity *mongodoc.Entity, baseEntity *mongodoc.BaseEntity) error {
@@ -158,7 +241,7 @@ func (s *Store) updateSearchEntity(entity *mongodoc.Entity, baseEntity *mongodoc
 // UpdateSearchFields updates the search record for the entity reference r
 // with the updated values in fields.
 func (s *Store) UpdateSearchFields(r *router.ResolvedURL, fields map[string]interface{}) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if !s.ES.enabled() {
 		return nil
 	}
 	var needUpdate bool
@@ -196,23 +279,128 @@ func (s *Store) searchDocFromEntity(e *mongodoc.Entity, be *mongodoc.BaseEntity)
 		return nil, errgo.Mask(err)
 	}
 	doc.TotalDownloads = allRevisions.Total
+	doc.RecentDownloads, err = s.RecentDownloads(EntityResolvedURL(e).PreferredURL(), time.Now())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
 	if doc.Entity.Series == "bundle" {
 		doc.Series = []string{"bundle"}
 	} else {
 		doc.Series = doc.Entity.SupportedSeries
 	}
+	doc.Tags = entityTags(e)
+	doc.ConfigOptions = entityConfigOptions(e)
+	doc.Actions = entityActions(e)
 	doc.AllSeries = true
 	doc.SingleSeries = doc.Entity.Series != ""
 	return &doc, nil
 }
 
-// update inserts an entity into elasticsearch if elasticsearch
-// is configured. The entity with id r is extracted from mongodb
-// and written into elasticsearch.
+// entityTags returns the categories and tags of the given charm,
+// or the tags of the given bundle, without duplicates.
+func entityTags(e *mongodoc.Entity) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(ts []string) {
+		for _, t := range ts {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	if e.CharmMeta != nil {
+		add(e.CharmMeta.Categories)
+		add(e.CharmMeta.Tags)
+	}
+	if e.BundleData != nil {
+		add(e.BundleData.Tags)
+	}
+	return tags
+}
+
+// entityConfigOptions returns the sorted names of the configuration
+// options of the given charm.
+func entityConfigOptions(e *mongodoc.Entity) []string {
+	if e.CharmConfig == nil || len(e.CharmConfig.Options) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.CharmConfig.Options))
+	for name := range e.CharmConfig.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entityActions returns the sorted names of the actions of the
+// given charm.
+func entityActions(e *mongodoc.Entity) []string {
+	if e.CharmActions == nil || len(e.CharmActions.ActionSpecs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.CharmActions.ActionSpecs))
+	for name := range e.CharmActions.ActionSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enabled reports whether si is configured with either
+// Elasticsearch or another search backend.
+func (si *SearchIndex) enabled() bool {
+	return si != nil && (si.Database != nil || si.Backend != nil)
+}
+
+// update inserts an entity into the search index if one is
+// configured.
 func (si *SearchIndex) update(doc *SearchDoc) error {
-	if si == nil || si.Database == nil {
+	if !si.enabled() {
 		return nil
 	}
+	for _, doc := range expandSearchDoc(doc) {
+		if err := si.put(doc); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// expandSearchDoc returns the documents to index for the given
+// document. If the document represents a multi-series charm, a copy
+// of the document is added for each of the supported series.
+func expandSearchDoc(doc *SearchDoc) []*SearchDoc {
+	docs := []*SearchDoc{doc}
+	if doc.Entity.URL.Series != "" {
+		return docs
+	}
+	for _, series := range doc.Entity.SupportedSeries {
+		e := *doc.Entity
+		u := *e.URL
+		u.Series = series
+		e.URL = &u
+		if e.PromulgatedURL != nil {
+			u := *e.PromulgatedURL
+			u.Series = series
+			e.PromulgatedURL = &u
+		}
+		sdoc := *doc
+		sdoc.Entity = &e
+		sdoc.Series = []string{series}
+		sdoc.AllSeries = false
+		sdoc.SingleSeries = true
+		docs = append(docs, &sdoc)
+	}
+	return docs
+}
+
+// put writes the given document to the search index, unless
+// a later revision of the same entity is already indexed.
+func (si *SearchIndex) put(doc *SearchDoc) error {
+	if si.Database == nil {
+		return si.Backend.Update(doc)
+	}
 	err := si.PutDocumentVersionWithType(
 		si.Index,
 		typeName,
@@ -223,25 +411,40 @@ func (si *SearchIndex) update(doc *SearchDoc) error {
 	if err != nil && err != elasticsearch.ErrConflict {
 		return errgo.Mask(err)
 	}
-	if doc.Entity.URL.Series != "" {
+	return nil
+}
+
+// putBatch writes all the given documents to the search index. When
+// the index is held in Elasticsearch, the documents are sent in a
+// single bulk request. As for put, a document is ignored if a later
+// revision of the same entity is already indexed.
+func (si *SearchIndex) putBatch(docs []*SearchDoc) error {
+	if si.Database == nil {
+		for _, doc := range docs {
+			if err := si.Backend.Update(doc); err != nil {
+				return errgo.Mask(err)
+			}
+		}
 		return nil
 	}
-	// This document represents a multi-series charm. Expand the
-	// document for each of the supported series.
-	for _, series := range doc.Entity.SupportedSeries {
-		u := *doc.Entity.URL
-		u.Series = series
-		doc.Entity.URL = &u
-		if doc.PromulgatedURL != nil {
-			u := *doc.Entity.PromulgatedURL
-			u.Series = series
-			doc.Entity.PromulgatedURL = &u
+	ops := make([]elasticsearch.BulkIndex, len(docs))
+	for i, doc := range docs {
+		ops[i] = elasticsearch.BulkIndex{
+			Index:       si.Index,
+			Type:        typeName,
+			Id:          si.getID(doc.URL),
+			Version:     int64(doc.URL.Revision),
+			VersionType: elasticsearch.ExternalGTE,
+			Doc:         doc,
 		}
-		doc.Series = []string{series}
-		doc.AllSeries = false
-		doc.SingleSeries = true
-		if err := si.update(doc); err != nil {
-			return errgo.Mask(err)
+	}
+	items, err := si.Bulk(ops)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i, item := range items {
+		if err := item.Err(); err != nil && err != elasticsearch.ErrConflict {
+			return errgo.Notef(err, "cannot index %v", docs[i].URL)
 		}
 	}
 	return nil
@@ -259,13 +462,20 @@ func (si *SearchIndex) getID(r *charm.URL) string {
 	return strings.TrimRight(s, "=")
 }
 
-// Search searches for matching entities in the configured elasticsearch index.
-// If there is no elasticsearch index configured then it will return an empty
+// Search searches for matching entities in the configured search index.
+// If there is no search index configured then it will return an empty
 // SearchResult, as if no results were found.
 func (si *SearchIndex) search(sp SearchParams) (SearchResult, error) {
-	if si == nil || si.Database == nil {
+	if !si.enabled() {
 		return SearchResult{}, nil
 	}
+	if si.Database == nil {
+		r, err := si.Backend.Search(sp)
+		if err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
+		return r, nil
+	}
 	q := createSearchDSL(sp)
 	q.Fields = append(q.Fields, "URL", "PromulgatedURL", "Series")
 	esr, err := si.Search(si.Index, typeName, q)
@@ -277,6 +487,23 @@ func (si *SearchIndex) search(sp SearchParams) (SearchResult, error) {
 		Total:      esr.Hits.Total,
 		Results:    make([]*mongodoc.Entity, 0, len(esr.Hits.Hits)),
 	}
+	if len(sp.Facets) > 0 {
+		r.Facets = make(map[string][]FacetBucket)
+		for _, facet := range sp.Facets {
+			esBuckets := esr.Aggregations[facet].Buckets
+			buckets := make([]FacetBucket, 0, len(esBuckets))
+			for _, b := range esBuckets {
+				if b.DocCount > 0 {
+					buckets = append(buckets, FacetBucket{
+						Value: b.Key,
+						Count: b.DocCount,
+					})
+				}
+			}
+			sortFacetBuckets(buckets)
+			r.Facets[facet] = buckets
+		}
+	}
 	for _, h := range esr.Hits.Hits {
 		urlStr := h.Fields.GetString("URL")
 		url, err := charm.ParseURL(urlStr)
@@ -308,15 +535,80 @@ func (si *SearchIndex) search(sp SearchParams) (SearchResult, error) {
 		}
 		r.Results = append(r.Results, e)
 	}
+	if r.Suggestions, err = si.suggestions(sp, esr.Suggest[nameSuggester]); err != nil {
+		return SearchResult{}, errgo.Mask(err)
+	}
 	return r, nil
 }
 
+// suggestions returns the names suggested in the given entries that
+// belong to entities matching the filters and permissions of the
+// given search parameters, most likely first. Suggestions are made
+// from all the names in the index, so they are checked with a further
+// query so that the names of entities the user cannot see, or that
+// would not match the search filters, are never suggested.
+func (si *SearchIndex) suggestions(sp SearchParams, entries []elasticsearch.SuggestEntry) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, o := range entry.Options {
+			if !seen[o.Text] {
+				seen[o.Text] = true
+				names = append(names, o.Text)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	of := make(elasticsearch.OrFilter, len(names))
+	for i, name := range names {
+		of[i] = elasticsearch.TermFilter{
+			Field: "Name",
+			Value: name,
+		}
+	}
+	q := elasticsearch.QueryDSL{
+		// Only the aggregation is needed, so no fields are
+		// returned with the hits.
+		Fields: []string{},
+		Query: elasticsearch.FilteredQuery{
+			Query:  elasticsearch.MatchAllQuery{},
+			Filter: elasticsearch.AndFilter{createFilters(sp), of},
+		},
+		Aggregations: map[string]elasticsearch.Aggregation{
+			"names": elasticsearch.TermsAggregation{
+				Field: "Name",
+				Size:  len(names),
+			},
+		},
+	}
+	esr, err := si.Search(si.Index, typeName, q)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot check suggestions")
+	}
+	visible := make(map[string]bool)
+	for _, b := range esr.Aggregations["names"].Buckets {
+		visible[b.Key] = true
+	}
+	var suggestions []string
+	for _, name := range names {
+		if visible[name] {
+			suggestions = append(suggestions, name)
+		}
+	}
+	return suggestions, nil
+}
+
 // GetSearchDocument retrieves the current search record for the charm
 // reference id.
 func (si *SearchIndex) GetSearchDocument(id *charm.URL) (*SearchDoc, error) {
-	if si == nil || si.Database == nil {
+	if !si.enabled() {
 		return &SearchDoc{}, nil
 	}
+	if si.Database == nil {
+		return si.Backend.GetSearchDocument(id)
+	}
 	var s SearchDoc
 	err := si.GetDocument(si.Index, "entity", si.getID(id), &s)
 	if err != nil {
@@ -325,6 +617,94 @@ func (si *SearchIndex) GetSearchDocument(id *charm.URL) (*SearchDoc, error) {
 	return &s, nil
 }
 
+// recentlyDownloadedPageSize holds the number of documents retrieved
+// by each search made by recentlyDownloaded.
+var recentlyDownloadedPageSize = 1000
+
+// recentlyDownloaded returns the URLs of the indexed documents whose
+// RecentDownloads value is not zero.
+func (si *SearchIndex) recentlyDownloaded() ([]*charm.URL, error) {
+	if !si.enabled() {
+		return nil, nil
+	}
+	if si.Database == nil {
+		return si.Backend.RecentlyDownloaded()
+	}
+	// Elasticsearch refuses to page beyond its result window
+	// with from and size, so each page starts after the last
+	// URL of the previous one instead.
+	var urls []*charm.URL
+	last := ""
+	for {
+		filter := elasticsearch.AndFilter{
+			elasticsearch.ExistsFilter("RecentDownloads"),
+			elasticsearch.NotFilter{elasticsearch.TermFilter{Field: "RecentDownloads", Value: "0"}},
+		}
+		if last != "" {
+			filter = append(filter, elasticsearch.RangeFilter{Field: "URL", GT: last})
+		}
+		q := elasticsearch.QueryDSL{
+			Fields: []string{"URL"},
+			Size:   recentlyDownloadedPageSize,
+			Query: elasticsearch.FilteredQuery{
+				Query:  elasticsearch.MatchAllQuery{},
+				Filter: filter,
+			},
+			Sort: []elasticsearch.Sort{{Field: "URL", Order: elasticsearch.Ascending}},
+		}
+		r, err := si.Search(si.Index, typeName, q)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot search for recently downloaded entities")
+		}
+		for _, h := range r.Hits.Hits {
+			url, err := charm.ParseURL(h.Fields.GetString("URL"))
+			if err != nil {
+				return nil, errgo.Notef(err, "invalid URL in search result %q", h.Fields.GetString("URL"))
+			}
+			urls = append(urls, url)
+			last = h.Fields.GetString("URL")
+		}
+		if len(r.Hits.Hits) < recentlyDownloadedPageSize {
+			return urls, nil
+		}
+	}
+}
+
+// updateRecentDownloads sets the RecentDownloads value of the
+// indexed document for the entity with the given id, if there is
+// one. The rest of the document is left unchanged.
+func (si *SearchIndex) updateRecentDownloads(id *charm.URL, recent float64) error {
+	if !si.enabled() {
+		return nil
+	}
+	if si.Database == nil {
+		return si.Backend.UpdateRecentDownloads(id, recent)
+	}
+	esID := si.getID(id)
+	d, err := si.GetESDocument(si.Index, typeName, esID)
+	if err != nil {
+		return errgo.Notef(err, "cannot retrieve search document for %v", id)
+	}
+	if !d.Found {
+		return nil
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(d.Source, &source); err != nil {
+		return errgo.Notef(err, "cannot unmarshal search document for %v", id)
+	}
+	if old, ok := source["RecentDownloads"].(float64); ok && old == recent {
+		return nil
+	}
+	source["RecentDownloads"] = recent
+	// Writing with the current version means that the update is
+	// discarded if a later revision has been indexed in the meantime.
+	err = si.PutDocumentVersionWithType(si.Index, typeName, esID, d.Version, elasticsearch.ExternalGTE, source)
+	if err != nil && err != elasticsearch.ErrConflict {
+		return errgo.Notef(err, "cannot update search document for %v", id)
+	}
+	return nil
+}
+
 // version is a document that stores the structure information
 // in the elasticsearch database.
 type version struct {
@@ -339,9 +719,12 @@ const versionType = "version"
 // settings. If force is true then ensureIndexes will create new indexes irrespective
 // of the status of the current index.
 func (si *SearchIndex) ensureIndexes(force bool) error {
-	if si == nil || si.Database == nil {
+	if !si.enabled() {
 		return nil
 	}
+	if si.Database == nil {
+		return si.Backend.EnsureIndexes(force)
+	}
 	old, dv, err := si.getCurrentVersion()
 	if err != nil {
 		return errgo.Notef(err, "cannot get current version")
@@ -434,30 +817,6 @@ func (si *SearchIndex) updateVersion(v version, dv int64) (bool, error) {
 	return true, nil
 }
 
-// syncSearch populates the SearchIndex with all the data currently stored in
-// mongodb. If the SearchIndex is not configured then this method returns a nil error.
-func (s *Store) syncSearch() error {
-	if s.ES == nil || s.ES.Database == nil {
-		return nil
-	}
-	var result mongodoc.Entity
-	// Only get the IDs here, UpdateSearch will get the full document
-	// if it is in a series that is indexed.
-	iter := s.DB.Entities().Find(nil).Select(bson.M{"_id": 1, "promulgated-url": 1}).Iter()
-	defer iter.Close() // Make sure we always close on error.
-	for iter.Next(&result) {
-		rurl := EntityResolvedURL(&result)
-		if err := s.UpdateSearch(rurl); err != nil {
-			return errgo.Notef(err, "cannot index %s", rurl)
-		}
-	}
-	logger.Infof("finished sync search")
-	if err := iter.Close(); err != nil {
-		return err
-	}
-	return nil
-}
-
 // SearchParams represents the search parameters used to search the store.
 type SearchParams struct {
 	// The text to use in the full text search query.
@@ -484,6 +843,14 @@ type SearchParams struct {
 	// ExpandedMultiSeries returns a number of entries for
 	// multi-series charms, one for each entity.
 	ExpandedMultiSeries bool
+	// Count the matching items for each value of
+	// the following facets.
+	Facets []string
+	// Fuzzy allows terms in the text to match terms that differ
+	// by a small number of edits, so that misspelt queries still
+	// return results. It is not supported by the built-in MongoDB
+	// search backend.
+	Fuzzy bool
 }
 
 var allowedSortFields = map[string]bool{
@@ -491,6 +858,7 @@ var allowedSortFields = map[string]bool{
 	"owner":     true,
 	"series":    true,
 	"downloads": true,
+	"trending":  true,
 }
 
 func (sp *SearchParams) ParseSortFields(f ...string) error {
@@ -512,6 +880,43 @@ func (sp *SearchParams) ParseSortFields(f ...string) error {
 	return nil
 }
 
+// allowedFacets holds the facets that may be requested in a search.
+var allowedFacets = map[string]bool{
+	"owner":       true,
+	"promulgated": true,
+	"series":      true,
+	"tags":        true,
+	"type":        true,
+}
+
+// ParseFacets adds the facets in the given comma separated lists to
+// sp.Facets.
+func (sp *SearchParams) ParseFacets(f ...string) error {
+	for _, s := range f {
+		for _, s := range strings.Split(s, ",") {
+			if !allowedFacets[s] {
+				return errgo.Newf("unrecognized facet %q", s)
+			}
+			sp.Facets = append(sp.Facets, s)
+		}
+	}
+	return nil
+}
+
+// SortFields returns the fields parsed by ParseSortFields, in order.
+// The names of fields sorted in descending order are prefixed
+// with "-".
+func (sp SearchParams) SortFields() []string {
+	fields := make([]string, len(sp.sort))
+	for i, s := range sp.sort {
+		fields[i] = s.Field
+		if s.Order == sortDescending {
+			fields[i] = "-" + s.Field
+		}
+	}
+	return fields
+}
+
 // sortOrder defines the order in which a field should be sorted.
 type sortOrder int
 
@@ -532,10 +937,46 @@ type sortParam struct {
 // 	- SupportedSeries
 // 	- PromulgatedURL
 // 	- PromulgatedRevision
+//
+// When facets are requested, Facets holds the buckets for each
+// requested facet, with the most frequent values first.
+//
+// When the search specifies text, Suggestions holds the names of
+// charms and bundles matching the other search parameters that are
+// spelt similarly to words in the text, most likely first. Suggestions
+// are only made when searching with Elasticsearch.
 type SearchResult struct {
-	SearchTime time.Duration
-	Total      int
-	Results    []*mongodoc.Entity
+	SearchTime  time.Duration
+	Total       int
+	Results     []*mongodoc.Entity
+	Facets      map[string][]FacetBucket
+	Suggestions []string
+}
+
+// FacetBucket holds the number of items matching a search
+// with a given value of a facet. The values of the promulgated
+// facet are "1" and "0", and those of the type facet are
+// "charm" and "bundle", as for the corresponding filters.
+type FacetBucket struct {
+	Value string
+	Count int
+}
+
+// sortFacetBuckets sorts the given buckets by decreasing
+// count, then by value.
+func sortFacetBuckets(buckets []FacetBucket) {
+	sort.Sort(facetBucketsByCount(buckets))
+}
+
+type facetBucketsByCount []FacetBucket
+
+func (b facetBucketsByCount) Len() int      { return len(b) }
+func (b facetBucketsByCount) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b facetBucketsByCount) Less(i, j int) bool {
+	if b[i].Count != b[j].Count {
+		return b[i].Count > b[j].Count
+	}
+	return b[i].Value < b[j].Value
 }
 
 // ListResult represents the result of performing a list.
@@ -588,10 +1029,14 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 	if sp.Text == "" {
 		q = elasticsearch.MatchAllQuery{}
 	} else {
-		q = elasticsearch.MultiMatchQuery{
+		mq := elasticsearch.MultiMatchQuery{
 			Query:  sp.Text,
 			Fields: encodeFields(queryFields(sp)),
 		}
+		if sp.Fuzzy && !sp.AutoComplete {
+			mq.Fuzziness = "AUTO"
+		}
+		q = mq
 	}
 
 	// Boosting
@@ -603,6 +1048,14 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 			Factor:   0.000001,
 			Modifier: "ln2p",
 		},
+		// Favour entities that have been downloaded recently, so
+		// that old entities with large historical download counts
+		// do not dominate the results.
+		elasticsearch.FieldValueFactorFunction{
+			Field:    "RecentDownloads",
+			Factor:   0.0001,
+			Modifier: "ln2p",
+		},
 		elasticsearch.BoostFactorFunction{
 			Filter:      promulgatedFilter("1"),
 			BoostFactor: 1.25,
@@ -630,9 +1083,53 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 		qdsl.Sort = append(qdsl.Sort, createElasticSort(s))
 	}
 
+	// Facets
+	if len(sp.Facets) > 0 {
+		qdsl.Aggregations = make(map[string]elasticsearch.Aggregation, len(sp.Facets))
+		for _, facet := range sp.Facets {
+			qdsl.Aggregations[facet] = facetAggregations[facet]
+		}
+	}
+
+	// Suggestions
+	if sp.Text != "" && !sp.AutoComplete {
+		qdsl.Suggest = map[string]elasticsearch.Suggester{
+			nameSuggester: elasticsearch.TermSuggester{
+				Text:     sp.Text,
+				Field:    "Name",
+				Analyzer: "standard",
+				Size:     maxSuggestions,
+			},
+		}
+	}
+
 	return qdsl
 }
 
+// nameSuggester holds the name of the suggester used to
+// suggest entity names in search queries.
+const nameSuggester = "names"
+
+// maxSuggestions holds the maximum number of names
+// suggested for each word in the search text.
+const maxSuggestions = 3
+
+// facetAggregations contains a mapping from a facet in the API to the
+// elasticsearch aggregation that computes its buckets.
+var facetAggregations = map[string]elasticsearch.Aggregation{
+	"owner": elasticsearch.TermsAggregation{Field: "User"},
+	"promulgated": elasticsearch.FiltersAggregation{
+		"1": promulgatedFilter("1"),
+		"0": promulgatedFilter("0"),
+	},
+	"series": elasticsearch.TermsAggregation{Field: "Series"},
+	"tags":   elasticsearch.TermsAggregation{Field: "Tags"},
+	"type": elasticsearch.FiltersAggregation{
+		"charm":  typeFilter("charm"),
+		"bundle": typeFilter("bundle"),
+	},
+}
+
 // createFilters converts the filters requested with the search API into
 // filters in the elasticsearch query DSL.
 // See https://github.com/juju/charmstore/blob/v4/docs/API.md#get-search
@@ -687,16 +1184,18 @@ func createFilters(sp SearchParams) elasticsearch.Filter {
 // function that will generate an elasticsearch query DSL filter for the
 // given value.
 var filters = map[string]func(string) elasticsearch.Filter{
-	"description": descriptionFilter,
-	"name":        nameFilter,
-	"owner":       ownerFilter,
-	"promulgated": promulgatedFilter,
-	"provides":    termFilter("CharmProvidedInterfaces"),
-	"requires":    termFilter("CharmRequiredInterfaces"),
-	"series":      seriesFilter,
-	"summary":     summaryFilter,
-	"tags":        tagsFilter,
-	"type":        typeFilter,
+	"action":        termFilter("Actions"),
+	"config-option": termFilter("ConfigOptions"),
+	"description":   descriptionFilter,
+	"name":          nameFilter,
+	"owner":         ownerFilter,
+	"promulgated":   promulgatedFilter,
+	"provides":      termFilter("CharmProvidedInterfaces"),
+	"requires":      termFilter("CharmRequiredInterfaces"),
+	"series":        seriesFilter,
+	"summary":       summaryFilter,
+	"tags":          tagsFilter,
+	"type":          typeFilter,
 }
 
 // descriptionFilter generates a filter that will match against the
@@ -838,6 +1337,7 @@ var sortESFields = map[string]string{
 	"owner":     "User",
 	"series":    "Series",
 	"downloads": "TotalDownloads",
+	"trending":  "RecentDownloads",
 }
 
 // createSort creates an elasticsearch.Sort query parameter out of a Sort parameter.
@@ -8,11 +8,14 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
 
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
@@ -41,7 +44,7 @@ func (s *StoreSearchSuite) SetUpTest(c *gc.C) {
 		LegacyDownloadCountsEnabled = original
 	})
 
-	s.index = SearchIndex{s.ES, s.TestIndex}
+	s.index = SearchIndex{Database: s.ES, Index: s.TestIndex}
 	s.ES.RefreshIndex(".versions")
 	pool, err := NewPool(s.Session.DB("foo"), &s.index, nil, ServerParams{})
 	c.Assert(err, gc.IsNil)
@@ -109,13 +112,22 @@ func (s *StoreSearchSuite) TestSuccessfulExport(c *gc.C) {
 		if ent.URL.Name == "riak" {
 			readACLs = []string{ent.URL.User}
 		}
+		tags := strings.Split(name, "-")
+		for _, t := range strings.Split(name, "-") {
+			tags = append(tags, t+"TAG")
+		}
 		doc := SearchDoc{
-			Entity:         entity,
-			TotalDownloads: int64(charmDownloadCounts[name]),
-			ReadACLs:       readACLs,
-			Series:         entity.SupportedSeries,
-			AllSeries:      true,
-			SingleSeries:   true,
+			Entity:          entity,
+			TotalDownloads:  int64(charmDownloadCounts[name]),
+			RecentDownloads: float64(charmDownloadCounts[name]),
+			ReadACLs:        readACLs,
+			Series:          entity.SupportedSeries,
+			Tags:            tags,
+			AllSeries:       true,
+			SingleSeries:    true,
+		}
+		if name == "wordpress" {
+			doc.ConfigOptions = []string{"blog-title"}
 		}
 		c.Assert(string(actual), jc.JSONEquals, doc)
 	}
@@ -239,6 +251,12 @@ func (s *StoreSearchSuite) TestExportSearchDocument(c *gc.C) {
 }
 
 func (s *StoreSearchSuite) addCharmsToStore(c *gc.C) {
+	addSearchTestEntities(c, s.store)
+}
+
+// addSearchTestEntities adds the charms in exportTestCharms and the
+// bundles in exportTestBundles to the given store and indexes them.
+func addSearchTestEntities(c *gc.C, store *Store) {
 	for name, ent := range exportTestCharms {
 		charmArchive := storetesting.Charms.CharmDir(name)
 		cats := strings.Split(name, "-")
@@ -255,7 +273,7 @@ func (s *StoreSearchSuite) addCharmsToStore(c *gc.C) {
 		}
 		addCharmForSearch(
 			c,
-			s.store,
+			store,
 			EntityResolvedURL(ent),
 			storetesting.NewCharm(meta),
 			acl,
@@ -268,15 +286,15 @@ func (s *StoreSearchSuite) addCharmsToStore(c *gc.C) {
 		data.Tags = strings.Split(name, "-")
 		addBundleForSearch(
 			c,
-			s.store,
+			store,
 			EntityResolvedURL(ent),
 			storetesting.NewBundle(data),
 			[]string{ent.URL.User, params.Everyone},
 			charmDownloadCounts[name],
 		)
 	}
-	s.store.pool.statsCache.EvictAll()
-	err := s.store.syncSearch()
+	store.pool.statsCache.EvictAll()
+	err := store.syncSearch(SyncSearchParams{})
 	c.Assert(err, gc.IsNil)
 }
 
@@ -371,6 +389,37 @@ var searchTests = []struct {
 		results: []*mongodoc.Entity{
 			exportTestCharms["wordpress"],
 		},
+	}, {
+		about: "config-option filter search",
+		sp: SearchParams{
+			Text: "",
+			Filters: map[string][]string{
+				"config-option": {"blog-title"},
+			},
+		},
+		results: []*mongodoc.Entity{
+			exportTestCharms["wordpress"],
+		},
+	}, {
+		about: "config-option filter search - no match",
+		sp: SearchParams{
+			Text: "",
+			Filters: map[string][]string{
+				"config-option": {"no-such-option"},
+			},
+		},
+	}, {
+		about: "requires and config-option filter search",
+		sp: SearchParams{
+			Text: "",
+			Filters: map[string][]string{
+				"requires":      {"mysql"},
+				"config-option": {"blog-title"},
+			},
+		},
+		results: []*mongodoc.Entity{
+			exportTestCharms["wordpress"],
+		},
 	}, {
 		about: "series filter search",
 		sp: SearchParams{
@@ -583,6 +632,182 @@ func (s *StoreSearchSuite) TestSearches(c *gc.C) {
 	}
 }
 
+var facetTests = []struct {
+	about        string
+	sp           SearchParams
+	expectFacets map[string][]FacetBucket
+}{{
+	about: "all facets",
+	sp: SearchParams{
+		Facets: []string{"series", "owner", "tags", "type", "promulgated"},
+	},
+	expectFacets: map[string][]FacetBucket{
+		"series": {
+			{Value: "trusty", Count: 2},
+			{Value: "bundle", Count: 1},
+			{Value: "precise", Count: 1},
+		},
+		"owner": {
+			{Value: "charmers", Count: 2},
+			{Value: "foo", Count: 1},
+			{Value: "openstack-charmers", Count: 1},
+		},
+		"tags": {
+			{Value: "wordpress", Count: 2},
+			{Value: "mysql", Count: 1},
+			{Value: "mysqlTAG", Count: 1},
+			{Value: "simple", Count: 1},
+			{Value: "varnish", Count: 1},
+			{Value: "varnishTAG", Count: 1},
+			{Value: "wordpressTAG", Count: 1},
+		},
+		"type": {
+			{Value: "charm", Count: 3},
+			{Value: "bundle", Count: 1},
+		},
+		"promulgated": {
+			{Value: "1", Count: 3},
+			{Value: "0", Count: 1},
+		},
+	},
+}, {
+	about: "facets count all the matching entities",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"type": {"charm"},
+		},
+		Limit:  1,
+		Facets: []string{"series"},
+	},
+	expectFacets: map[string][]FacetBucket{
+		"series": {
+			{Value: "trusty", Count: 2},
+			{Value: "precise", Count: 1},
+		},
+	},
+}, {
+	about: "facets honor groups",
+	sp: SearchParams{
+		Groups: []string{"charmers"},
+		Facets: []string{"owner"},
+	},
+	expectFacets: map[string][]FacetBucket{
+		"owner": {
+			{Value: "charmers", Count: 3},
+			{Value: "foo", Count: 1},
+			{Value: "openstack-charmers", Count: 1},
+		},
+	},
+}, {
+	about: "no matches",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"name": {"no-such"},
+		},
+		Facets: []string{"type"},
+	},
+	expectFacets: map[string][]FacetBucket{
+		"type": {},
+	},
+}, {
+	about: "no facets",
+}}
+
+func (s *StoreSearchSuite) TestFacets(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range facetTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Facets, jc.DeepEquals, test.expectFacets)
+	}
+}
+
+func (s *StoreSearchSuite) TestParseFacets(c *gc.C) {
+	var sp SearchParams
+	err := sp.ParseFacets("series,owner", "tags")
+	c.Assert(err, gc.IsNil)
+	c.Assert(sp.Facets, jc.DeepEquals, []string{"series", "owner", "tags"})
+	err = sp.ParseFacets("series,name")
+	c.Assert(err, gc.ErrorMatches, `unrecognized facet "name"`)
+}
+
+func (s *StoreSearchSuite) TestFuzzySearch(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	res, err := s.store.Search(SearchParams{
+		Text: "wordprss",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
+	res, err = s.store.Search(SearchParams{
+		Text:  "wordprss",
+		Fuzzy: true,
+	})
+	c.Assert(err, gc.IsNil)
+	sort.Sort(resolvedURLsByString(res.Results))
+	expect := []*mongodoc.Entity{
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	}
+	sort.Sort(resolvedURLsByString(expect))
+	c.Assert(res.Results, jc.DeepEquals, expect)
+}
+
+var suggestionTests = []struct {
+	about             string
+	sp                SearchParams
+	expectSuggestions []string
+}{{
+	about: "misspelt name",
+	sp: SearchParams{
+		Text: "wordprss",
+	},
+	expectSuggestions: []string{"wordpress"},
+}, {
+	about: "correctly spelt name",
+	sp: SearchParams{
+		Text: "mysql",
+	},
+}, {
+	about: "no text",
+}, {
+	about: "autocomplete",
+	sp: SearchParams{
+		Text:         "wordprss",
+		AutoComplete: true,
+	},
+}, {
+	about: "private name not suggested",
+	sp: SearchParams{
+		Text: "riac",
+	},
+}, {
+	about: "private name suggested to group member",
+	sp: SearchParams{
+		Text:   "riac",
+		Groups: []string{"charmers"},
+	},
+	expectSuggestions: []string{"riak"},
+}, {
+	about: "suggestions restricted by filters",
+	sp: SearchParams{
+		Text: "wordprss",
+		Filters: map[string][]string{
+			"type": {"bundle"},
+		},
+	},
+}}
+
+func (s *StoreSearchSuite) TestSuggestions(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range suggestionTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Suggestions, jc.DeepEquals, test.expectSuggestions)
+	}
+}
+
 type resolvedURLsByString []*mongodoc.Entity
 
 func (r resolvedURLsByString) Less(i, j int) bool {
@@ -726,6 +951,15 @@ func (s *StoreSearchSuite) TestSorting(c *gc.C) {
 			exportTestBundles["wordpress-simple"],
 			exportTestCharms["wordpress"],
 		},
+	}, {
+		about:     "trending descending",
+		sortQuery: "-trending",
+		results: []*mongodoc.Entity{
+			exportTestCharms["varnish"],
+			exportTestCharms["mysql"],
+			exportTestBundles["wordpress-simple"],
+			exportTestCharms["wordpress"],
+		},
 	}}
 	for i, test := range tests {
 		c.Logf("test %d. %s", i, test.about)
@@ -754,6 +988,208 @@ func (s *StoreSearchSuite) TestBoosting(c *gc.C) {
 	})
 }
 
+func (s *StoreSearchSuite) TestSortTrending(c *gc.C) {
+	now := time.Now()
+	// The first charm was downloaded many times long ago, the
+	// second a few times recently.
+	downloads := []struct {
+		id    *router.ResolvedURL
+		name  string
+		times []time.Time
+	}{{
+		id:    router.MustNewResolvedURL("cs:~trending-test/trusty/mysql-1", -1),
+		name:  "mysql",
+		times: repeatTime(now.Add(-100*24*time.Hour), 10),
+	}, {
+		id:    router.MustNewResolvedURL("cs:~trending-test/trusty/varnish-1", -1),
+		name:  "varnish",
+		times: repeatTime(now, 2),
+	}}
+	for _, d := range downloads {
+		addCharmForSearch(c, s.store, d.id, storetesting.Charms.CharmDir(d.name), []string{"trending-test", params.Everyone}, 0)
+		for _, t := range d.times {
+			err := s.store.IncrementDownloadCountsAtTime(d.id, t)
+			c.Assert(err, gc.IsNil)
+		}
+	}
+	s.store.pool.statsCache.EvictAll()
+	for _, d := range downloads {
+		err := s.store.UpdateSearch(d.id)
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.store.ES.Database.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+
+	search := func(sort string) []string {
+		sp := SearchParams{
+			Filters: map[string][]string{
+				"owner": {"trending-test"},
+			},
+		}
+		err := sp.ParseSortFields(sort)
+		c.Assert(err, gc.IsNil)
+		res, err := s.store.Search(sp)
+		c.Assert(err, gc.IsNil)
+		names := make([]string, len(res.Results))
+		for i, e := range res.Results {
+			names[i] = e.URL.Name
+		}
+		return names
+	}
+	c.Assert(search("-downloads"), jc.DeepEquals, []string{"mysql", "varnish"})
+	c.Assert(search("-trending"), jc.DeepEquals, []string{"varnish", "mysql"})
+}
+
+func repeatTime(t time.Time, n int) []time.Time {
+	ts := make([]time.Time, n)
+	for i := range ts {
+		ts[i] = t
+	}
+	return ts
+}
+
+func (s *StoreSearchSuite) TestTrendingRefresh(c *gc.C) {
+	id := EntityResolvedURL(exportTestCharms["varnish"])
+	doc, err := s.store.ES.GetSearchDocument(&id.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.RecentDownloads, gc.Equals, 5.0)
+
+	// Record some downloads without updating the search index.
+	key := EntityStatsKey(&id.URL, params.StatsArchiveDownload)
+	for i := 0; i < 2; i++ {
+		err := s.store.IncCounter(key)
+		c.Assert(err, gc.IsNil)
+	}
+	doc, err = s.store.ES.GetSearchDocument(&id.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.RecentDownloads, gc.Equals, 5.0)
+
+	r := newPeriodicTask(s.store.pool, 10*time.Millisecond, refreshTrending)
+	defer r.close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		doc, err = s.store.ES.GetSearchDocument(&id.URL)
+		c.Assert(err, gc.IsNil)
+		if doc.RecentDownloads == 7 {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for refresh; recent downloads %v", doc.RecentDownloads)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *StoreSearchSuite) TestTrendingRefreshKeepsCheckpoint(c *gc.C) {
+	checkpoint := searchSyncCheckpoint{
+		Index:  s.store.ES.Index,
+		LastID: charm.MustParseURL("cs:~charmers/mysql"),
+		Time:   time.Now().UTC().Truncate(time.Millisecond),
+	}
+	err := s.store.DB.SearchSync().Insert(&checkpoint)
+	c.Assert(err, gc.IsNil)
+	refreshTrending(s.store, nil)
+	var got searchSyncCheckpoint
+	err = s.store.DB.SearchSync().FindId(s.store.ES.Index).One(&got)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, jc.DeepEquals, checkpoint)
+}
+
+func (s *StoreSearchSuite) TestRefreshRecentDownloads(c *gc.C) {
+	// Give an entity without any downloads a stale figure in the
+	// search index.
+	wordpress := exportTestCharms["wordpress"].URL
+	err := s.store.ES.updateRecentDownloads(wordpress, 4)
+	c.Assert(err, gc.IsNil)
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+
+	// Only the downloaded entities and the one with a non-zero
+	// figure are refreshed.
+	n, err := s.store.refreshRecentDownloads(time.Now(), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 4)
+	recent := func(name string) float64 {
+		doc, err := s.store.ES.GetSearchDocument(exportTestCharms[name].URL)
+		c.Assert(err, gc.IsNil)
+		return doc.RecentDownloads
+	}
+	c.Assert(recent("wordpress"), gc.Equals, 0.0)
+	c.Assert(recent("mysql"), gc.Equals, 3.0)
+	c.Assert(recent("varnish"), gc.Equals, 5.0)
+
+	// The rest of the document is unchanged.
+	doc, err := s.store.ES.GetSearchDocument(exportTestCharms["varnish"].URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.TotalDownloads, gc.Equals, int64(5))
+	c.Assert(doc.Tags, jc.DeepEquals, []string{"varnish", "varnishTAG"})
+
+	// Once the downloads fall outside the recent period, the
+	// figures decay to zero.
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	n, err = s.store.refreshRecentDownloads(time.Now().Add(RecentDownloadsPeriod+48*time.Hour), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 3)
+	c.Assert(recent("mysql"), gc.Equals, 0.0)
+	c.Assert(recent("varnish"), gc.Equals, 0.0)
+	doc, err = s.store.ES.GetSearchDocument(exportTestBundles["wordpress-simple"].URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.RecentDownloads, gc.Equals, 0.0)
+}
+
+func (s *StoreSearchSuite) TestRecentlyDownloadedPaging(c *gc.C) {
+	all, err := s.store.ES.recentlyDownloaded()
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(all), jc.GreaterThan, 1)
+
+	// Retrieving one document at a time returns the same URLs.
+	s.PatchValue(&recentlyDownloadedPageSize, 1)
+	paged, err := s.store.ES.recentlyDownloaded()
+	c.Assert(err, gc.IsNil)
+	c.Assert(paged, jc.DeepEquals, all)
+}
+
+func (s *StoreSearchSuite) TestRefreshRecentDownloadsStopped(c *gc.C) {
+	stop := make(chan struct{})
+	close(stop)
+	n, err := s.store.refreshRecentDownloads(time.Now(), stop)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrStopped)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *StoreSearchSuite) TestSyncSearchBulk(c *gc.C) {
+	url := router.MustNewResolvedURL("cs:~charmers/juju-gui-25", -1)
+	addCharmForSearch(
+		c,
+		s.store,
+		url,
+		storetesting.Charms.CharmDir("multi-series"),
+		[]string{url.URL.User, params.Everyone},
+		0,
+	)
+	ids := []*charm.URL{
+		exportTestCharms["mysql"].URL,
+		exportTestBundles["wordpress-simple"].URL,
+		&url.URL,
+		charm.MustParseURL("cs:~charmers/trusty/juju-gui-25"),
+	}
+	for _, id := range ids {
+		err := s.ES.DeleteDocument(s.TestIndex, typeName, s.store.ES.getID(id))
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.store.syncSearch(SyncSearchParams{
+		BatchSize:   2,
+		Concurrency: 2,
+	})
+	c.Assert(err, gc.IsNil)
+	for _, id := range ids {
+		present, err := s.store.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(id))
+		c.Assert(err, gc.IsNil)
+		c.Assert(present, gc.Equals, true, gc.Commentf("%v", id))
+	}
+}
+
 func (s *StoreSearchSuite) TestEnsureIndex(c *gc.C) {
 	s.store.ES.Index = s.TestIndex + "-ensure-index"
 	defer s.ES.DeleteDocument(".versions", "version", s.store.ES.Index)
@@ -815,6 +1251,98 @@ func (s *StoreSearchSuite) TestEnsureIndexForce(c *gc.C) {
 	c.Assert(indexes[0], gc.Not(gc.Equals), index)
 }
 
+func (s *StoreSearchSuite) TestReindex(c *gc.C) {
+	s.store.ES.Index = s.TestIndex + "-reindex"
+	defer s.ES.DeleteDocument(".versions", "version", s.store.ES.Index)
+	err := s.store.ES.ensureIndexes(false)
+	c.Assert(err, gc.Equals, nil)
+	indexes, err := s.ES.ListIndexesForAlias(s.store.ES.Index)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(indexes, gc.HasLen, 1)
+	oldIndex := indexes[0]
+	defer s.ES.DeleteIndex(oldIndex)
+
+	previous, err := s.store.ReindexElasticsearch(SyncSearchParams{
+		BatchSize: 2,
+	})
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(previous, gc.Equals, oldIndex)
+	indexes, err = s.ES.ListIndexesForAlias(s.store.ES.Index)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(indexes, gc.HasLen, 1)
+	newIndex := indexes[0]
+	defer s.ES.DeleteIndex(newIndex)
+	c.Assert(newIndex, gc.Not(gc.Equals), oldIndex)
+
+	// The previous index is kept for rollback.
+	all, err := s.ES.ListAllIndexes()
+	c.Assert(err, gc.Equals, nil)
+	found := false
+	for _, index := range all {
+		if index == oldIndex {
+			found = true
+		}
+	}
+	c.Assert(found, gc.Equals, true)
+
+	v, _, err := s.store.ES.getCurrentVersion()
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(v, gc.Equals, version{
+		Version: esSettingsVersion,
+		Index:   newIndex,
+	})
+	res, err := s.store.Search(SearchParams{Admin: true})
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(res.Total, gc.Equals, 5)
+}
+
+func (s *StoreSearchSuite) TestReindexCatchesUpWithChanges(c *gc.C) {
+	// Treat the changes made when the charms were added as made
+	// before the reindex started.
+	start := time.Now()
+	_, err := s.store.DB.SearchChanges().UpdateAll(nil, bson.D{{"$set", bson.D{{"time", start.Add(-time.Hour)}}}})
+	c.Assert(err, gc.IsNil)
+
+	index, err := s.store.ES.newIndex()
+	c.Assert(err, gc.IsNil)
+	defer s.ES.DeleteIndex(index)
+	err = s.store.buildIndex(index, SyncSearchParams{})
+	c.Assert(err, gc.IsNil)
+
+	// Change the permissions while the new index is being built,
+	// so that the change is written to the current index only.
+	rurl := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-23", -1)
+	err = s.store.UpdateBaseEntity(rurl, bson.D{{"$set", bson.D{
+		{"channelacls.stable.read", []string{"bob"}},
+	}}})
+	c.Assert(err, gc.IsNil)
+	err = s.store.UpdateSearchBaseURL(mongodoc.BaseURL(&rurl.URL))
+	c.Assert(err, gc.IsNil)
+	var change searchChange
+	err = s.store.DB.SearchChanges().FindId(mongodoc.BaseURL(&rurl.URL)).One(&change)
+	c.Assert(err, gc.IsNil)
+	c.Assert(change.Time.Before(start), gc.Equals, false)
+
+	var doc SearchDoc
+	err = s.store.ES.GetDocument(index, typeName, s.store.ES.getID(&rurl.URL), &doc)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.ReadACLs, gc.Not(jc.DeepEquals), []string{"bob"})
+
+	err = s.store.catchUpIndex(index, start, 0)
+	c.Assert(err, gc.IsNil)
+	doc = SearchDoc{}
+	err = s.store.ES.GetDocument(index, typeName, s.store.ES.getID(&rurl.URL), &doc)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.ReadACLs, jc.DeepEquals, []string{"bob"})
+}
+
+func (s *StoreSearchSuite) TestReindexResumeNotAllowed(c *gc.C) {
+	_, err := s.store.ReindexElasticsearch(SyncSearchParams{
+		Resume: true,
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot resume a reindex`)
+}
+
 func (s *StoreSearchSuite) TestGetCurrentVersionNoVersion(c *gc.C) {
 	s.store.ES.Index = s.TestIndex + "-current-version"
 	defer s.ES.DeleteDocument(".versions", "version", s.store.ES.Index)
@@ -893,6 +1421,59 @@ func (s *StoreSearchSuite) TestUpdateConflict(c *gc.C) {
 	c.Assert(updated, gc.Equals, false)
 }
 
+func (s *StoreSearchSuite) TestActionFilter(c *gc.C) {
+	ent := newEntity("cs:~charmers/trusty/dummy-1", -1)
+	addCharmForSearch(
+		c,
+		s.store,
+		EntityResolvedURL(ent),
+		storetesting.Charms.CharmDir("dummy"),
+		[]string{ent.URL.User, params.Everyone},
+		0,
+	)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	assertActionFilter(c, s.store, ent)
+}
+
+// assertActionFilter checks that searching the given store by
+// action and config option finds only the dummy charm with the
+// given entity.
+func assertActionFilter(c *gc.C, store *Store, ent *mongodoc.Entity) {
+	tests := []struct {
+		filters map[string][]string
+		results []*mongodoc.Entity
+	}{{
+		filters: map[string][]string{
+			"action": {"snapshot"},
+		},
+		results: []*mongodoc.Entity{ent},
+	}, {
+		filters: map[string][]string{
+			"action":        {"snapshot"},
+			"config-option": {"username skill-level"},
+		},
+		results: []*mongodoc.Entity{ent},
+	}, {
+		filters: map[string][]string{
+			"action":        {"snapshot"},
+			"config-option": {"blog-title"},
+		},
+	}, {
+		filters: map[string][]string{
+			"action": {"no-such-action"},
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %v", i, test.filters)
+		res, err := store.Search(SearchParams{
+			Filters: test.filters,
+		})
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Results, jc.DeepEquals, test.results)
+		c.Assert(res.Total, gc.Equals, len(test.results))
+	}
+}
+
 func (s *StoreSearchSuite) TestMultiSeriesCharmFiltersSeriesCorrectly(c *gc.C) {
 	charmArchive := storetesting.Charms.CharmDir("multi-series")
 	url := router.MustNewResolvedURL("cs:~charmers/juju-gui-25", -1)
@@ -980,14 +1561,14 @@ func (s *StoreSearchSuite) TestOnlyIndexStableCharms(c *gc.C) {
 	err = s.store.ES.GetDocument(s.TestIndex, typeName, s.store.ES.getID(&id.URL), &actual)
 	c.Assert(err, gc.ErrorMatches, "elasticsearch document not found")
 
-	err = s.store.Publish(id, params.DevelopmentChannel)
+	err = s.store.Publish(id, nil, params.DevelopmentChannel)
 	c.Assert(err, gc.IsNil)
 	err = s.store.UpdateSearch(id)
 	c.Assert(err, gc.IsNil)
 	err = s.store.ES.GetDocument(s.TestIndex, typeName, s.store.ES.getID(&id.URL), &actual)
 	c.Assert(err, gc.ErrorMatches, "elasticsearch document not found")
 
-	err = s.store.Publish(id, params.StableChannel)
+	err = s.store.Publish(id, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 	err = s.store.UpdateSearch(id)
 	c.Assert(err, gc.IsNil)
@@ -1018,7 +1599,7 @@ func addCharmForSearch(c *gc.C, s *Store, id *router.ResolvedURL, ch charm.Charm
 	}
 	err = s.SetPerms(&id.URL, "stable.read", acl...)
 	c.Assert(err, gc.IsNil)
-	err = s.Publish(id, params.StableChannel)
+	err = s.Publish(id, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 }
 
@@ -1034,6 +1615,6 @@ func addBundleForSearch(c *gc.C, s *Store, id *router.ResolvedURL, b charm.Bundl
 	}
 	err = s.SetPerms(&id.URL, "stable.read", acl...)
 	c.Assert(err, gc.IsNil)
-	err = s.Publish(id, params.StableChannel)
+	err = s.Publish(id, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 }
@@ -0,0 +1,531 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/utils/parallel"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// DefaultSyncSearchBatchSize holds the default number of base
+	// entities indexed in each batch when synchronising the search
+	// index.
+	DefaultSyncSearchBatchSize = 100
+
+	// DefaultSyncSearchConcurrency holds the default number of
+	// batches indexed concurrently when synchronising the search
+	// index.
+	DefaultSyncSearchConcurrency = 4
+
+	// DefaultSyncSearchProgressInterval holds the default minimum
+	// interval between progress reports when synchronising the
+	// search index.
+	DefaultSyncSearchProgressInterval = 30 * time.Second
+
+	// searchChangeMargin holds the length of time before the start
+	// of a reindex from which recorded search changes are indexed
+	// again, allowing for differences between the clocks of the
+	// servers that record the changes.
+	searchChangeMargin = time.Minute
+)
+
+// SyncSearchParams holds parameters for synchronising the search index
+// with the contents of the charm store.
+type SyncSearchParams struct {
+	// BatchSize holds the maximum number of base entities indexed
+	// in each batch. When the index is held in Elasticsearch, the
+	// documents for each batch are sent in a single bulk request.
+	// If this is zero, DefaultSyncSearchBatchSize is used.
+	BatchSize int
+
+	// Concurrency holds the maximum number of batches that are
+	// indexed concurrently. If this is zero,
+	// DefaultSyncSearchConcurrency is used.
+	Concurrency int
+
+	// ProgressInterval holds the minimum interval between progress
+	// reports. If this is zero, DefaultSyncSearchProgressInterval is
+	// used.
+	ProgressInterval time.Duration
+
+	// Resume specifies that the synchronisation should continue
+	// after the last base entity indexed by an earlier,
+	// interrupted, synchronisation rather than starting from the
+	// beginning.
+	Resume bool
+
+	// Stop, if not nil, may be closed to stop the synchronisation
+	// early. No more batches are started, and an error with an
+	// ErrStopped cause is returned once the batches in progress have
+	// completed. The checkpoint is kept so that the synchronisation
+	// can be resumed.
+	Stop <-chan struct{}
+}
+
+// searchSyncCheckpoint holds the document recording the progress of a
+// search index synchronisation in the searchsync collection.
+type searchSyncCheckpoint struct {
+	// Index holds the name of the search index being synchronised.
+	Index string `bson:"_id"`
+
+	// LastID holds the id of the last base entity for which
+	// it and all earlier base entities have been indexed.
+	LastID *charm.URL `bson:"lastid"`
+
+	// Time holds the time the checkpoint was recorded.
+	Time time.Time
+}
+
+// searchChange holds the document recording, in the searchchanges
+// collection, the last time the search documents for a base entity
+// were updated.
+type searchChange struct {
+	// URL holds the base URL of the entity.
+	URL *charm.URL `bson:"_id"`
+
+	// Time holds the time of the last update.
+	Time time.Time
+}
+
+// recordSearchChange records that the search documents for the base
+// entity with the given URL are about to be updated, so that
+// ReindexElasticsearch can index the change again in an index that is
+// being built. It must be called before the documents are written.
+func (s *Store) recordSearchChange(baseURL *charm.URL) error {
+	if s.ES.Database == nil {
+		// Only Elasticsearch indexes are rebuilt.
+		return nil
+	}
+	_, err := s.DB.SearchChanges().UpsertId(baseURL, bson.D{{"$set", bson.D{{"time", time.Now()}}}})
+	if err != nil {
+		return errgo.Notef(err, "cannot record search change for %q", baseURL)
+	}
+	return nil
+}
+
+// syncSearch populates the SearchIndex with all the data currently
+// stored in mongodb. Base entities are indexed in order of id, in
+// batches, and the progress is recorded so that an interrupted
+// synchronisation can be resumed. If the SearchIndex is not configured
+// then this method returns a nil error.
+func (s *Store) syncSearch(p SyncSearchParams) error {
+	if !s.ES.enabled() {
+		return nil
+	}
+	if _, err := s.syncSearchDocs(p); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// syncSearchDocs implements syncSearch. It returns the number of
+// documents that were indexed.
+func (s *Store) syncSearchDocs(p SyncSearchParams) (int, error) {
+	if p.BatchSize <= 0 {
+		p.BatchSize = DefaultSyncSearchBatchSize
+	}
+	if p.Concurrency <= 0 {
+		p.Concurrency = DefaultSyncSearchConcurrency
+	}
+	if p.ProgressInterval <= 0 {
+		p.ProgressInterval = DefaultSyncSearchProgressInterval
+	}
+	var query bson.D
+	if p.Resume {
+		var checkpoint searchSyncCheckpoint
+		err := s.DB.SearchSync().FindId(s.ES.Index).One(&checkpoint)
+		switch {
+		case err == mgo.ErrNotFound:
+			logger.Infof("no search sync checkpoint found; starting from the beginning")
+		case err != nil:
+			return 0, errgo.Notef(err, "cannot read search sync checkpoint")
+		default:
+			logger.Infof("resuming search sync after %v (checkpoint recorded at %v)", checkpoint.LastID, checkpoint.Time)
+			query = bson.D{{"_id", bson.D{{"$gt", checkpoint.LastID}}}}
+		}
+	} else {
+		if _, err := s.DB.SearchSync().RemoveAll(bson.D{{"_id", s.ES.Index}}); err != nil {
+			return 0, errgo.Notef(err, "cannot remove search sync checkpoint")
+		}
+	}
+	total, err := s.DB.BaseEntities().Find(query).Count()
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot count base entities")
+	}
+	progress := newSearchSyncProgress(s, total, p.ProgressInterval)
+	run := parallel.NewRun(p.Concurrency)
+	iter := s.DB.BaseEntities().Find(query).Select(bson.D{{"_id", 1}}).Sort("_id").Iter()
+	defer iter.Close() // Make sure we always close on error.
+	var batch []*charm.URL
+	seq := 0
+	flush := func() {
+		ids, n := batch, seq
+		batch, seq = nil, seq+1
+		run.Do(func() error {
+			store := s.Copy()
+			defer store.Close()
+			ndocs, err := store.indexBaseEntities(ids)
+			if err != nil {
+				progress.fail()
+				return errgo.Mask(err)
+			}
+			if err := progress.done(n, ids[len(ids)-1], len(ids), ndocs); err != nil {
+				progress.fail()
+				return errgo.Mask(err)
+			}
+			return nil
+		})
+	}
+	var result struct {
+		URL *charm.URL `bson:"_id"`
+	}
+	stopped := false
+	for !progress.failed() && iter.Next(&result) {
+		if isStopped(p.Stop) {
+			stopped = true
+			break
+		}
+		batch = append(batch, result.URL)
+		if len(batch) >= p.BatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 && !progress.failed() && !stopped {
+		flush()
+	}
+	iterErr := iter.Close()
+	if err := run.Wait(); err != nil {
+		// We could have got multiple errors, but we'll only return one of them.
+		return 0, errgo.Notef(err.(parallel.Errors)[0], "cannot synchronise search index")
+	}
+	if iterErr != nil {
+		return 0, errgo.Notef(iterErr, "cannot iterate base entities")
+	}
+	if stopped {
+		progress.report(true)
+		return 0, errgo.WithCausef(nil, ErrStopped, "search index synchronisation stopped")
+	}
+	if _, err := s.DB.SearchSync().RemoveAll(bson.D{{"_id", s.ES.Index}}); err != nil {
+		return 0, errgo.Notef(err, "cannot remove search sync checkpoint")
+	}
+	progress.report(true)
+	logger.Infof("finished sync search")
+	return progress.docs, nil
+}
+
+// ReindexElasticsearch builds a new elasticsearch index with the
+// current settings and populates it from the mongodb database while
+// searches continue to use the existing index. When the number of
+// documents in the new index matches the number indexed from the
+// database, the index alias is switched to the new index in a single
+// operation. The previous index is kept, so that the alias can be
+// switched back if necessary, and its name is returned.
+//
+// Changes made to the charm store while the new index is being built
+// are written to the previous index, but they are recorded so that the
+// base entities they affect are indexed again in the new index, both
+// before the alias is switched and afterwards, to include changes made
+// while it was being switched. If that second pass fails, the name of
+// the previous index is returned along with the error.
+func (s *Store) ReindexElasticsearch(p SyncSearchParams) (previous string, err error) {
+	si := s.ES
+	if si == nil || si.Database == nil {
+		return "", errgo.New("elasticsearch is not configured")
+	}
+	if p.Resume {
+		return "", errgo.New("cannot resume a reindex")
+	}
+	old, dv, err := si.getCurrentVersion()
+	if err != nil {
+		return "", errgo.Notef(err, "cannot get current version")
+	}
+	start := time.Now()
+	index, err := si.newIndex()
+	if err != nil {
+		return "", errgo.Notef(err, "cannot create index")
+	}
+	logger.Infof("building new index %q for %q", index, si.Index)
+	deleteIndex := func() {
+		if err := si.DeleteIndex(index); err != nil {
+			logger.Errorf("cannot delete index %q: %v", index, err)
+		}
+	}
+	if err := s.buildIndex(index, p); err != nil {
+		deleteIndex()
+		return "", errgo.Mask(err)
+	}
+	catchUp := time.Now()
+	if err := s.catchUpIndex(index, start.Add(-searchChangeMargin), p.BatchSize); err != nil {
+		deleteIndex()
+		return "", errgo.Mask(err)
+	}
+	// Check for a concurrent change before switching the alias, so
+	// that the alias is rarely switched only to be switched back.
+	if _, cdv, err := si.getCurrentVersion(); err != nil || cdv != dv {
+		deleteIndex()
+		if err == nil {
+			err = errgo.Newf("index %q was changed concurrently", si.Index)
+		}
+		return "", errgo.Notef(err, "cannot update version")
+	}
+	if err := si.Alias(index, si.Index); err != nil {
+		deleteIndex()
+		return "", errgo.Notef(err, "cannot switch alias %q to %q", si.Index, index)
+	}
+	updated, err := si.updateVersion(version{
+		Version: esSettingsVersion,
+		Index:   index,
+	}, dv)
+	if err == nil && !updated {
+		err = errgo.Newf("index %q was changed concurrently", si.Index)
+	}
+	if err != nil {
+		// Switch the alias back so that it matches the recorded
+		// version again. The new index can only be deleted once
+		// nothing refers to it.
+		if err := si.Alias(old.Index, si.Index); err != nil {
+			logger.Errorf("cannot switch alias %q back to %q: %v", si.Index, old.Index, err)
+		} else {
+			deleteIndex()
+		}
+		return "", errgo.Notef(err, "cannot update version")
+	}
+	if old.Index != "" {
+		logger.Infof("alias %q switched from %q to %q; previous index kept for rollback", si.Index, old.Index, index)
+	} else {
+		logger.Infof("alias %q switched to %q", si.Index, index)
+	}
+	if err := s.catchUpIndex(index, catchUp.Add(-searchChangeMargin), p.BatchSize); err != nil {
+		return old.Index, errgo.Mask(err)
+	}
+	return old.Index, nil
+}
+
+// buildIndex populates the given elasticsearch index from the mongodb
+// database and verifies that it holds the expected number of
+// documents.
+func (s *Store) buildIndex(index string, p SyncSearchParams) error {
+	store := s.indexStore(index)
+	defer store.Close()
+	expect, err := store.syncSearchDocs(p)
+	if err != nil {
+		return errgo.Notef(err, "cannot populate index %q", index)
+	}
+	if err := store.ES.RefreshIndex(index); err != nil {
+		return errgo.Notef(err, "cannot refresh index %q", index)
+	}
+	n, err := store.ES.Count(index, typeName)
+	if err != nil {
+		return errgo.Notef(err, "cannot count documents in index %q", index)
+	}
+	if n != int64(expect) {
+		return errgo.Newf("index %q holds %d documents, expected %d", index, n, expect)
+	}
+	logger.Infof("index %q verified with %d documents", index, n)
+	return nil
+}
+
+// catchUpIndex indexes again in the given elasticsearch index the base
+// entities whose search documents have been updated since the given
+// time, in batches of the given size.
+func (s *Store) catchUpIndex(index string, since time.Time, batchSize int) error {
+	if batchSize <= 0 {
+		batchSize = DefaultSyncSearchBatchSize
+	}
+	var ids []*charm.URL
+	iter := s.DB.SearchChanges().Find(bson.D{{"time", bson.D{{"$gte", since}}}}).Sort("_id").Iter()
+	for {
+		var change searchChange
+		if !iter.Next(&change) {
+			break
+		}
+		ids = append(ids, change.URL)
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate search changes")
+	}
+	store := s.indexStore(index)
+	defer store.Close()
+	for i := 0; i < len(ids); i += batchSize {
+		end := i + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if _, err := store.indexBaseEntities(ids[i:end]); err != nil {
+			return errgo.Notef(err, "cannot index changes in index %q", index)
+		}
+	}
+	logger.Infof("indexed %d base entities changed since %v in index %q", len(ids), since, index)
+	return nil
+}
+
+// indexStore returns a copy of s that writes search documents to the
+// given elasticsearch index. It must be closed after use.
+func (s *Store) indexStore(index string) *Store {
+	store := s.Copy()
+	store.ES = &SearchIndex{
+		Database: s.ES.Database,
+		Index:    index,
+	}
+	return store
+}
+
+// indexBaseEntities indexes the latest stable revisions of all
+// the given base entities and returns the number of distinct
+// documents that were indexed.
+func (s *Store) indexBaseEntities(ids []*charm.URL) (int, error) {
+	var docs []*SearchDoc
+	for _, id := range ids {
+		bdocs, err := s.stableSearchDocs(id)
+		if errgo.Cause(err) == params.ErrNotFound {
+			// The base entity has been removed since
+			// the synchronisation started.
+			continue
+		}
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		for _, doc := range bdocs {
+			docs = append(docs, expandSearchDoc(doc)...)
+		}
+	}
+	if err := s.ES.putBatch(docs); err != nil {
+		return 0, errgo.Notef(err, "cannot index batch ending with %v", ids[len(ids)-1])
+	}
+	// A document may be generated more than once when the expanded
+	// records of a multi-series charm coincide with a single-series
+	// entity, but only one of them is kept in the index.
+	indexed := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		u := *doc.URL
+		u.Revision = -1
+		indexed[u.String()] = true
+	}
+	return len(indexed), nil
+}
+
+// searchSyncProgress tracks the progress of a search index
+// synchronisation, recording checkpoints and logging progress
+// reports.
+type searchSyncProgress struct {
+	store    *Store
+	total    int
+	start    time.Time
+	interval time.Duration
+
+	mu sync.Mutex
+	// next holds the sequence number of the earliest batch
+	// that has not been completed.
+	next int
+	// completed holds the last id of each completed batch that
+	// cannot yet be recorded as a checkpoint because an earlier
+	// batch is still in progress, keyed by sequence number.
+	completed  map[int]*charm.URL
+	entities   int
+	docs       int
+	lastReport time.Time
+	hasFailed  bool
+}
+
+func newSearchSyncProgress(s *Store, total int, interval time.Duration) *searchSyncProgress {
+	now := time.Now()
+	return &searchSyncProgress{
+		store:      s,
+		total:      total,
+		start:      now,
+		interval:   interval,
+		completed:  make(map[int]*charm.URL),
+		lastReport: now,
+	}
+}
+
+// done records that the batch with the given sequence number, ending
+// with the base entity lastID and holding the given number of base
+// entities and documents, has been indexed. The checkpoint is advanced
+// when all earlier batches have also been indexed.
+func (p *searchSyncProgress) done(seq int, lastID *charm.URL, entities, docs int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entities += entities
+	p.docs += docs
+	p.completed[seq] = lastID
+	var checkpoint *charm.URL
+	for {
+		id, ok := p.completed[p.next]
+		if !ok {
+			break
+		}
+		checkpoint = id
+		delete(p.completed, p.next)
+		p.next++
+	}
+	if checkpoint != nil {
+		_, err := p.store.DB.SearchSync().UpsertId(p.store.ES.Index, &searchSyncCheckpoint{
+			Index:  p.store.ES.Index,
+			LastID: checkpoint,
+			Time:   time.Now(),
+		})
+		if err != nil {
+			return errgo.Notef(err, "cannot record search sync checkpoint")
+		}
+	}
+	p.reportLocked(false)
+	return nil
+}
+
+// fail records that the synchronisation has failed, so that no more
+// batches are started.
+func (p *searchSyncProgress) fail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hasFailed = true
+}
+
+// failed reports whether the synchronisation has failed.
+func (p *searchSyncProgress) failed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hasFailed
+}
+
+// report logs the progress of the synchronisation. Unless force is
+// true, nothing is logged if progress was reported within the
+// reporting interval.
+func (p *searchSyncProgress) report(force bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reportLocked(force)
+}
+
+func (p *searchSyncProgress) reportLocked(force bool) {
+	now := time.Now()
+	if !force && now.Sub(p.lastReport) < p.interval {
+		return
+	}
+	p.lastReport = now
+	elapsed := now.Sub(p.start)
+	percent := 100.0
+	var eta time.Duration
+	if p.total > 0 {
+		percent = float64(p.entities) * 100 / float64(p.total)
+	}
+	if p.entities > 0 && p.entities < p.total {
+		eta = time.Duration(float64(elapsed) * float64(p.total-p.entities) / float64(p.entities))
+	}
+	logger.Infof("search sync: indexed %d/%d base entities (%.1f%%), %d documents, elapsed %v, estimated time remaining %v",
+		p.entities, p.total, percent, p.docs, roundDuration(elapsed), roundDuration(eta))
+}
+
+// roundDuration rounds d to the nearest second.
+func roundDuration(d time.Duration) time.Duration {
+	return (d + time.Second/2) / time.Second * time.Second
+}
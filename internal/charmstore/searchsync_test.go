@@ -0,0 +1,179 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+type SearchSyncSuite struct {
+	commonSuite
+	store *Store
+}
+
+var _ = gc.Suite(&SearchSyncSuite{})
+
+func (s *SearchSyncSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&LegacyDownloadCountsEnabled, false)
+	db := s.Session.DB("juju_test")
+	si := &SearchIndex{
+		Backend: NewMongoSearchBackend(db),
+	}
+	pool, err := NewPool(db, si, nil, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	s.store = pool.Store()
+	pool.Close()
+	addSearchTestEntities(c, s.store)
+	err = s.store.ES.ensureIndexes(true)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *SearchSyncSuite) TearDownTest(c *gc.C) {
+	s.store.Close()
+	s.commonSuite.TearDownTest(c)
+}
+
+func (s *SearchSyncSuite) TestSyncInBatches(c *gc.C) {
+	err := s.store.syncSearch(SyncSearchParams{
+		BatchSize:   1,
+		Concurrency: 2,
+	})
+	c.Assert(err, gc.IsNil)
+	s.assertSearchResults(c, []*mongodoc.Entity{
+		exportTestCharms["mysql"],
+		exportTestCharms["riak"],
+		exportTestCharms["varnish"],
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	})
+	// The checkpoint is removed when the synchronisation completes.
+	n, err := s.store.DB.SearchSync().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *SearchSyncSuite) TestResume(c *gc.C) {
+	err := s.store.DB.SearchSync().Insert(&searchSyncCheckpoint{
+		Index:  s.store.ES.Index,
+		LastID: charm.MustParseURL("cs:~charmers/wordpress-simple"),
+		Time:   time.Now(),
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.store.syncSearch(SyncSearchParams{
+		BatchSize: 1,
+		Resume:    true,
+	})
+	c.Assert(err, gc.IsNil)
+	// Only the base entities after the checkpoint are indexed.
+	s.assertSearchResults(c, []*mongodoc.Entity{
+		exportTestCharms["mysql"],
+		exportTestCharms["varnish"],
+	})
+	n, err := s.store.DB.SearchSync().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *SearchSyncSuite) TestResumeWithoutCheckpoint(c *gc.C) {
+	err := s.store.syncSearch(SyncSearchParams{
+		Resume: true,
+	})
+	c.Assert(err, gc.IsNil)
+	s.assertSearchResults(c, []*mongodoc.Entity{
+		exportTestCharms["mysql"],
+		exportTestCharms["riak"],
+		exportTestCharms["varnish"],
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	})
+}
+
+func (s *SearchSyncSuite) TestNoResumeIgnoresCheckpoint(c *gc.C) {
+	err := s.store.DB.SearchSync().Insert(&searchSyncCheckpoint{
+		Index:  s.store.ES.Index,
+		LastID: charm.MustParseURL("cs:~openstack-charmers/mysql"),
+		Time:   time.Now(),
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.store.syncSearch(SyncSearchParams{})
+	c.Assert(err, gc.IsNil)
+	res, err := s.store.Search(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Total, gc.Equals, 5)
+}
+
+func (s *SearchSyncSuite) TestSyncStopped(c *gc.C) {
+	checkpoint := charm.MustParseURL("cs:~charmers/riak")
+	err := s.store.DB.SearchSync().Insert(&searchSyncCheckpoint{
+		Index:  s.store.ES.Index,
+		LastID: checkpoint,
+		Time:   time.Now(),
+	})
+	c.Assert(err, gc.IsNil)
+	stop := make(chan struct{})
+	close(stop)
+	err = s.store.syncSearch(SyncSearchParams{
+		Resume: true,
+		Stop:   stop,
+	})
+	c.Assert(err, gc.ErrorMatches, `search index synchronisation stopped`)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrStopped)
+	// The checkpoint is kept so that the synchronisation can be resumed.
+	s.assertCheckpoint(c, checkpoint)
+}
+
+func (s *SearchSyncSuite) TestSearchSyncProgressCheckpoint(c *gc.C) {
+	p := newSearchSyncProgress(s.store, 3, time.Hour)
+	ids := []*charm.URL{
+		charm.MustParseURL("cs:~bob/a"),
+		charm.MustParseURL("cs:~bob/b"),
+		charm.MustParseURL("cs:~bob/c"),
+	}
+	// Batches completing out of order do not advance the
+	// checkpoint past a batch still in progress.
+	err := p.done(1, ids[1], 1, 1)
+	c.Assert(err, gc.IsNil)
+	err = s.store.DB.SearchSync().FindId(s.store.ES.Index).One(new(searchSyncCheckpoint))
+	c.Assert(err, gc.Equals, mgo.ErrNotFound)
+
+	err = p.done(0, ids[0], 1, 1)
+	c.Assert(err, gc.IsNil)
+	s.assertCheckpoint(c, ids[1])
+
+	err = p.done(2, ids[2], 1, 2)
+	c.Assert(err, gc.IsNil)
+	s.assertCheckpoint(c, ids[2])
+	c.Assert(p.entities, gc.Equals, 3)
+	c.Assert(p.docs, gc.Equals, 4)
+}
+
+func (s *SearchSyncSuite) TestReindexRequiresElasticsearch(c *gc.C) {
+	_, err := s.store.ReindexElasticsearch(SyncSearchParams{})
+	c.Assert(err, gc.ErrorMatches, `elasticsearch is not configured`)
+}
+
+func (s *SearchSyncSuite) assertCheckpoint(c *gc.C, expect *charm.URL) {
+	var checkpoint searchSyncCheckpoint
+	err := s.store.DB.SearchSync().FindId(s.store.ES.Index).One(&checkpoint)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checkpoint.LastID, jc.DeepEquals, expect)
+}
+
+func (s *SearchSyncSuite) assertSearchResults(c *gc.C, expect []*mongodoc.Entity) {
+	res, err := s.store.Search(SearchParams{Admin: true})
+	c.Assert(err, gc.IsNil)
+	sort.Sort(resolvedURLsByString(res.Results))
+	sort.Sort(resolvedURLsByString(expect))
+	c.Assert(res.Results, jc.DeepEquals, expect)
+}
@@ -82,6 +82,89 @@ type ServerParams struct {
 	// AuditLogger optionally holds the logger which will be used to
 	// write audit log entries.
 	AuditLogger *lumberjack.Logger
+
+	// TrendingRefreshInterval holds the interval between refreshes
+	// of the recent download figures used to rank search results.
+	// If it is zero, DefaultTrendingRefreshInterval is used. If it
+	// is negative, the figures are only updated when entities are
+	// downloaded or indexed.
+	TrendingRefreshInterval time.Duration
+
+	// BlobStorage holds the location of the storage that holds blob
+	// content, in one of the URL forms accepted by
+	// blobstore.NewBackend. If it is empty, blobs are held in GridFS
+	// in the charm store database. Blobs stored in GridFS before it
+	// was set are still read from there until they are moved with
+	// blobstore.Migrate.
+	BlobStorage string
+
+	// BlobGCInterval holds the interval between runs of the
+	// garbage collector that removes blobs not referenced by
+	// any entity or resource. If it is zero, the garbage
+	// collector is not run by the server.
+	BlobGCInterval time.Duration
+
+	// BlobGCGracePeriod holds the minimum age of the unreferenced
+	// blobs removed by the garbage collector. If it is zero,
+	// a default of one day is used.
+	BlobGCGracePeriod time.Duration
+
+	// BlobGCDryRun specifies that the garbage collector
+	// should only log the orphaned blobs it finds.
+	BlobGCDryRun bool
+
+	// BlobScrubInterval holds the interval between runs of the
+	// scrubber that checks that all entity archives still match
+	// their recorded hashes. If it is zero, the scrubber is not
+	// run by the server.
+	BlobScrubInterval time.Duration
+
+	// MaxArchiveSize holds the maximum size in bytes of an
+	// uploaded charm or bundle archive. If it is zero, a default
+	// of 1GiB is used.
+	MaxArchiveSize int64
+
+	// MaxArchiveUncompressedSize holds the maximum total size in
+	// bytes of the files in an uploaded archive once uncompressed.
+	// If it is zero, a default of 4GiB is used.
+	MaxArchiveUncompressedSize int64
+
+	// MaxArchiveFiles holds the maximum number of files
+	// in an uploaded archive. If it is zero, a default
+	// of 50000 is used.
+	MaxArchiveFiles int
+
+	// MaxArchivePathDepth holds the maximum number of
+	// elements in the path of a file in an uploaded archive.
+	// If it is zero, a default of 64 is used.
+	MaxArchivePathDepth int
+
+	// MaxArchiveCompressionRatio holds the maximum ratio between
+	// the uncompressed size of an uploaded archive and its size.
+	// If it is zero, a default of 100 is used.
+	MaxArchiveCompressionRatio int
+
+	// MaxArchiveMetadataSize holds the maximum uncompressed size
+	// in bytes of each file in an uploaded archive that is read
+	// into memory, such as metadata.yaml, config.yaml or
+	// bundle.yaml. If it is zero, a default of 1MiB is used.
+	//
+	// If any of the above archive limits is negative,
+	// that limit is not enforced.
+	MaxArchiveMetadataSize int64
+
+	// MaxOpenUploads holds the maximum number of chunked uploads
+	// that a user may have open at once. If it is zero, a default
+	// of 10 is used.
+	MaxOpenUploads int
+
+	// MinUploadPartSize holds the minimum size in bytes of each
+	// part of a chunked upload other than the last. If it is zero,
+	// a default of 5MiB is used.
+	//
+	// If either of the above upload limits is negative,
+	// that limit is not enforced.
+	MinUploadPartSize int64
 }
 
 // NewServer returns a handler that serves the given charm store API
@@ -125,10 +208,32 @@ func NewServer(db *mgo.Database, si *SearchIndex, config ServerParams, versions
 		return nil, errgo.Notef(err, "database migration failed")
 	}
 	store.Go(func(store *Store) {
-		if err := store.syncSearch(); err != nil {
+		err := store.syncSearch(SyncSearchParams{
+			Stop: pool.closing,
+		})
+		if err != nil && errgo.Cause(err) != ErrStopped {
 			logger.Errorf("Cannot populate elasticsearch: %v", err)
 		}
 	})
+	// Rank the interesting entities straight away so that
+	// they can be served before the first periodic refresh.
+	store.Go(func(store *Store) {
+		refreshInteresting(store, pool.closing)
+	})
+	pool.interestingRefresh = newPeriodicTask(pool, interestingRefreshInterval, refreshInteresting)
+	if store.ES.enabled() && config.TrendingRefreshInterval >= 0 {
+		interval := config.TrendingRefreshInterval
+		if interval == 0 {
+			interval = DefaultTrendingRefreshInterval
+		}
+		pool.trending = newPeriodicTask(pool, interval, refreshTrending)
+	}
+	if config.BlobGCInterval > 0 {
+		pool.blobGC = newPeriodicTask(pool, config.BlobGCInterval, collectBlobGarbage)
+	}
+	if config.BlobScrubInterval > 0 {
+		pool.blobScrub = newPeriodicTask(pool, config.BlobScrubInterval, scrubBlobs)
+	}
 	srv := &Server{
 		pool: pool,
 		mux:  router.NewServeMux(),
@@ -5,6 +5,7 @@ package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/char
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/juju/testing/httptesting"
 	gc "gopkg.in/check.v1"
@@ -118,7 +119,7 @@ func (s *ServerSuite) TestNewServerWithElasticSearch(c *gc.C) {
 			}),
 		}
 	}
-	h, err := NewServer(s.Session.DB("foo"), &SearchIndex{s.ES, s.TestIndex}, serverParams,
+	h, err := NewServer(s.Session.DB("foo"), &SearchIndex{Database: s.ES, Index: s.TestIndex}, serverParams,
 		map[string]NewAPIHandlerFunc{
 			"version1": serveConfig,
 		})
@@ -131,6 +132,48 @@ func (s *ServerSuite) TestNewServerWithElasticSearch(c *gc.C) {
 	})
 }
 
+func (s *ServerSuite) TestNewServerTrendingRefresh(c *gc.C) {
+	versions := map[string]NewAPIHandlerFunc{
+		"version1": func(*Pool, ServerParams, string) HTTPCloseHandler {
+			return nopCloseHandler{http.NotFoundHandler()}
+		},
+	}
+	tests := []struct {
+		about          string
+		si             *SearchIndex
+		interval       time.Duration
+		expectInterval time.Duration
+	}{{
+		about:          "default interval",
+		si:             &SearchIndex{Database: s.ES, Index: s.TestIndex},
+		expectInterval: DefaultTrendingRefreshInterval,
+	}, {
+		about:          "specified interval",
+		si:             &SearchIndex{Database: s.ES, Index: s.TestIndex},
+		interval:       time.Hour,
+		expectInterval: time.Hour,
+	}, {
+		about:    "refresh disabled",
+		si:       &SearchIndex{Database: s.ES, Index: s.TestIndex},
+		interval: -1,
+	}, {
+		about: "no search index",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		params := serverParams
+		params.TrendingRefreshInterval = test.interval
+		srv, err := NewServer(s.Session.DB("foo"), test.si, params, versions)
+		c.Assert(err, gc.IsNil)
+		if test.expectInterval == 0 {
+			c.Check(srv.pool.trending, gc.IsNil)
+		} else {
+			c.Check(srv.pool.trending.interval, gc.Equals, test.expectInterval)
+		}
+		srv.Close()
+	}
+}
+
 func assertServesVersion(c *gc.C, h http.Handler, vers string) {
 	path := vers
 	if path != "" {
@@ -5,7 +5,7 @@ package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/char
 
 import (
 	"encoding/json"
-	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -252,6 +252,7 @@ const (
 	ByAll CounterRequestBy = iota
 	ByDay
 	ByWeek
+	ByMonth
 )
 
 type Counter struct {
@@ -263,9 +264,6 @@ type Counter struct {
 
 // Counters aggregates and returns counter values according to the provided request.
 func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
-	tokensColl := s.DB.StatTokens()
-	countersColl := s.DB.StatCounters()
-
 	searchKey, err := s.stats.key(s.DB, req.Key, false)
 	if errgo.Cause(err) == params.ErrNotFound {
 		if !req.List {
@@ -280,57 +278,44 @@ func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	totals, err := s.sumCounters(req, searchKey)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return s.countersFromTotals(req, totals)
+}
+
+// counterTotal holds the sum of the counters matching a counter
+// request that share the same key and period.
+type counterTotal struct {
+	// Key holds the compound statistics identifier of the total.
+	// A key ending in "*" holds the total of all the keys that
+	// start with the preceding key.
+	Key string
+
+	// Period holds the number of the day, week or month, since
+	// counterEpoch, of the counters in the total. It is zero
+	// when the request does not aggregate by period.
+	Period int64
+
+	// Count holds the sum of the counters.
+	Count int64
+}
+
+// sumCounters sums the counters matching the given request, whose key
+// has been translated to the given compound statistics identifier.
+// The counters are summed for each period and, when req.List and
+// req.Prefix are both true, for each key one token longer than the
+// search key.
+func (s *Store) sumCounters(req *CounterRequest, searchKey string) ([]counterTotal, error) {
 	var regex string
 	if req.Prefix {
 		regex = "^" + searchKey + ".+"
 	} else {
 		regex = "^" + searchKey + "$"
 	}
-
-	// This reduce function simply sums, for each emitted key, all the values found under it.
-	job := mgo.MapReduce{Reduce: "function(key, values) { return Array.sum(values); }"}
-	var emit string
-	switch req.By {
-	case ByDay:
-		emit = "emit(k+'@'+NumberInt(this.t/86400), this.c);"
-	case ByWeek:
-		emit = "emit(k+'@'+NumberInt(this.t/604800), this.c);"
-	default:
-		emit = "emit(k, this.c);"
-	}
-	if req.List && req.Prefix {
-		// For a search key "a:b:" matching a key "a:b:c:d:e:", this map function emits "a:b:c:*".
-		// For a search key "a:b:" matching a key "a:b:c:", it emits "a:b:c:".
-		// For a search key "a:b:" matching a key "a:b:", it emits "a:b:".
-		job.Scope = bson.D{{"searchKeyLen", len(searchKey)}}
-		job.Map = fmt.Sprintf(`
-			function() {
-				var k = this.k;
-				var i = k.indexOf(':', searchKeyLen)+1;
-				if (k.length > i)  { k = k.substr(0, i)+'*'; }
-				%s
-			}`, emit)
-	} else {
-		// For a search key "a:b:" matching a key "a:b:c:d:e:", this map function emits "a:b:*".
-		// For a search key "a:b:" matching a key "a:b:c:", it also emits "a:b:*".
-		// For a search key "a:b:" matching a key "a:b:", it emits "a:b:".
-		emitKey := searchKey
-		if req.Prefix {
-			emitKey += "*"
-		}
-		job.Scope = bson.D{{"emitKey", emitKey}}
-		job.Map = fmt.Sprintf(`
-			function() {
-				var k = emitKey;
-				%s
-			}`, emit)
-	}
-
-	var result []struct {
-		Key   string `bson:"_id"`
-		Value int64
-	}
-	var query, tquery bson.D
+	match := bson.D{{"k", bson.D{{"$regex", regex}}}}
+	var tquery bson.D
 	if !req.Start.IsZero() {
 		tquery = append(tquery, bson.DocElem{
 			Name:  "$gte",
@@ -343,63 +328,130 @@ func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
 			Value: timeToStamp(req.Stop),
 		})
 	}
-	if len(tquery) == 0 {
-		query = bson.D{{"k", bson.D{{"$regex", regex}}}}
-	} else {
-		query = bson.D{{"k", bson.D{{"$regex", regex}}}, {"t", tquery}}
+	if len(tquery) > 0 {
+		match = append(match, bson.DocElem{"t", tquery})
 	}
-	_, err = countersColl.Find(query).MapReduce(&job, &result)
-	if err != nil {
-		return nil, err
+	listKeys := req.List && req.Prefix
+	var group bson.D
+	if listKeys {
+		// The keys are shortened after grouping, as the
+		// aggregation framework cannot search within strings.
+		group = append(group, bson.DocElem{"k", "$k"})
 	}
+	var periodLen int
+	switch req.By {
+	case ByDay, ByMonth:
+		// Months have different lengths, so the counters are
+		// summed by day and the days are then merged into months.
+		periodLen = 86400
+	case ByWeek:
+		periodLen = 604800
+	}
+	if periodLen > 0 {
+		// Divide the time stamp by the period length, rounding
+		// towards zero: (t - t%n) / n.
+		group = append(group, bson.DocElem{"p", bson.D{{
+			"$divide", []interface{}{
+				bson.D{{"$subtract", []interface{}{
+					"$t",
+					bson.D{{"$mod", []interface{}{"$t", periodLen}}},
+				}}},
+				periodLen,
+			},
+		}}})
+	}
+	var groupId interface{}
+	if len(group) > 0 {
+		groupId = group
+	}
+	pipeline := []bson.D{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", groupId},
+			{"c", bson.D{{"$sum", "$c"}}},
+		}}},
+	}
+	var result []struct {
+		Id struct {
+			Key    string  `bson:"k"`
+			Period float64 `bson:"p"`
+		} `bson:"_id"`
+		Count int64 `bson:"c"`
+	}
+	if err := s.DB.StatCounters().Pipe(pipeline).AllowDiskUse().All(&result); err != nil {
+		return nil, errgo.Notef(err, "cannot aggregate counters")
+	}
+	// For a search key "a:b:" matching a key "a:b:c:d:e:", the total
+	// is held under "a:b:c:*" when listing keys and "a:b:*" otherwise.
+	// For a search key "a:b:" matching a key "a:b:c:", it is held
+	// under "a:b:c:" when listing keys and "a:b:*" otherwise.
+	// For a search key "a:b:" matching a key "a:b:", it is held
+	// under "a:b:".
+	emitKey := searchKey
+	if req.Prefix {
+		emitKey += "*"
+	}
+	totals := make([]counterTotal, 0, len(result))
+	index := make(map[counterTotal]int)
+	for _, r := range result {
+		t := counterTotal{
+			Key:    emitKey,
+			Period: int64(r.Id.Period),
+		}
+		if req.By == ByMonth {
+			t.Period = dayToMonth(t.Period)
+		}
+		if listKeys {
+			t.Key = r.Id.Key
+			if i := strings.Index(t.Key[len(searchKey):], ":"); i != -1 && len(t.Key) > len(searchKey)+i+1 {
+				t.Key = t.Key[:len(searchKey)+i+1] + "*"
+			}
+		}
+		if i, ok := index[t]; ok {
+			totals[i].Count += r.Count
+			continue
+		}
+		index[t] = len(totals)
+		t.Count = r.Count
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// dayToMonth returns the number of the month, since counterEpoch,
+// holding the given day, also numbered since counterEpoch.
+func dayToMonth(day int64) int64 {
+	epoch := time.Unix(counterEpoch, 0).In(time.UTC)
+	t := time.Unix(counterEpoch+day*86400, 0).In(time.UTC)
+	return int64(t.Year()-epoch.Year())*12 + int64(t.Month()-epoch.Month())
+}
+
+// countersFromTotals returns the counters corresponding to the given
+// totals, which were computed for the given request.
+func (s *Store) countersFromTotals(req *CounterRequest, totals []counterTotal) ([]Counter, error) {
 	var counters []Counter
-	for i := range result {
-		key := result[i].Key
+	for _, total := range totals {
 		when := time.Time{}
-		if req.By != ByAll {
-			var stamp int64
-			if at := strings.Index(key, "@"); at != -1 && len(key) > at+1 {
-				stamp, _ = strconv.ParseInt(key[at+1:], 10, 32)
-				key = key[:at]
-			}
-			if stamp == 0 {
-				return nil, errgo.Newf("internal error: bad aggregated key: %q", result[i].Key)
-			}
-			switch req.By {
-			case ByDay:
-				stamp = stamp * 86400
-			case ByWeek:
-				// The +1 puts it at the end of the period.
-				stamp = (stamp + 1) * 604800
-			}
-			when = time.Unix(counterEpoch+stamp, 0).In(time.UTC)
+		switch req.By {
+		case ByDay:
+			when = time.Unix(counterEpoch+total.Period*86400, 0).In(time.UTC)
+		case ByWeek:
+			// The +1 puts it at the end of the period.
+			when = time.Unix(counterEpoch+(total.Period+1)*604800, 0).In(time.UTC)
+		case ByMonth:
+			// The month is reported by its first day.
+			epoch := time.Unix(counterEpoch, 0).In(time.UTC)
+			when = time.Date(epoch.Year(), epoch.Month()+time.Month(total.Period), 1, 0, 0, 0, 0, time.UTC)
 		}
-		ids := strings.Split(key, ":")
-		tokens := make([]string, 0, len(ids))
-		for i := 0; i < len(ids)-1; i++ {
-			if ids[i] == "*" {
-				continue
-			}
-			id, err := strconv.ParseInt(ids[i], 32, 32)
-			if err != nil {
-				return nil, errgo.Newf("store: invalid id: %q", ids[i])
-			}
-			token, found := s.stats.idToken(int(id))
-			if !found {
-				var t tokenId
-				err = tokensColl.FindId(id).One(&t)
-				if err == mgo.ErrNotFound {
-					return nil, errgo.Newf("store: internal error; token id not found: %d", id)
-				}
-				s.stats.cacheTokenId(t.Token, t.Id)
-				token = t.Token
-			}
-			tokens = append(tokens, token)
+		ids := strings.Split(total.Key, ":")
+		tokens, err := s.statsKeyTokens(total.Key)
+		if err != nil {
+			return nil, errgo.Mask(err)
 		}
 		counter := Counter{
 			Key:    tokens,
 			Prefix: len(ids) > 0 && ids[len(ids)-1] == "*",
-			Count:  result[i].Value,
+			Count:  total.Count,
 			Time:   when,
 		}
 		counters = append(counters, counter)
@@ -412,6 +464,35 @@ func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
 	return counters, nil
 }
 
+// statsKeyTokens returns the words of the key represented by the given
+// compound statistics identifier, as returned by stats.key. A "*" in
+// the identifier, as used by counterTotal, is ignored.
+func (s *Store) statsKeyTokens(skey string) ([]string, error) {
+	ids := strings.Split(skey, ":")
+	tokens := make([]string, 0, len(ids))
+	for i := 0; i < len(ids)-1; i++ {
+		if ids[i] == "*" {
+			continue
+		}
+		id, err := strconv.ParseInt(ids[i], 32, 32)
+		if err != nil {
+			return nil, errgo.Newf("store: invalid id: %q", ids[i])
+		}
+		token, found := s.stats.idToken(int(id))
+		if !found {
+			var t tokenId
+			err = s.DB.StatTokens().FindId(id).One(&t)
+			if err == mgo.ErrNotFound {
+				return nil, errgo.Newf("store: internal error; token id not found: %d", id)
+			}
+			s.stats.cacheTokenId(t.Token, t.Id)
+			token = t.Token
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
 type sortableCounters []Counter
 
 func (s sortableCounters) Len() int      { return len(s) }
@@ -602,6 +683,57 @@ func (s *Store) aggregateStats(key []string, prefix bool) (AggregatedCounts, err
 	return counts, nil
 }
 
+const (
+	// RecentDownloadsPeriod holds the period over which downloads
+	// contribute to the recent downloads figure of an entity.
+	RecentDownloadsPeriod = 30 * 24 * time.Hour
+
+	// recentDownloadsHalfLife holds the age, in days, at which a
+	// download contributes half as much to the recent downloads
+	// figure as a download made today.
+	recentDownloadsHalfLife = 7
+)
+
+// RecentDownloads returns the number of downloads of all revisions of
+// the given charm or bundle in the RecentDownloadsPeriod before now,
+// with each day's downloads weighted by an exponential decay so that
+// the figure favours entities that are currently popular. A download
+// made a week ago counts half as much as one made today.
+//
+// Unlike ArchiveDownloadCounts, the figure does not include
+// legacy download counts, which have no associated time.
+func (s *Store) RecentDownloads(id *charm.URL, now time.Time) (float64, error) {
+	fetchId := *id
+	fetchId.Revision = -1
+	kind := params.StatsArchiveDownload
+	if fetchId.User == "" {
+		kind = params.StatsArchiveDownloadPromulgated
+	}
+	results, err := s.Counters(&CounterRequest{
+		Key:    EntityStatsKey(&fetchId, kind),
+		Prefix: true,
+		By:     ByDay,
+		Start:  now.Add(-RecentDownloadsPeriod),
+	})
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot retrieve stats")
+	}
+	var recent float64
+	for _, result := range results {
+		if result.Count == 0 {
+			continue
+		}
+		// Counts are aggregated by day, so the age is
+		// the number of whole days since the count's day.
+		days := int64(now.Sub(result.Time) / (24 * time.Hour))
+		if days < 0 {
+			days = 0
+		}
+		recent += float64(result.Count) * math.Pow(0.5, float64(days)/recentDownloadsHalfLife)
+	}
+	return recent, nil
+}
+
 // IncrementDownloadCountsAsync updates the download statistics for entity id in both
 // the statistics database and the search database. The action is done in the
 // background using a separate goroutine.
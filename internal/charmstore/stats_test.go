@@ -431,6 +431,73 @@ func (s *StatsSuite) TestListCountersBy(c *gc.C) {
 	}
 }
 
+func (s *StatsSuite) TestListCountersByMonth(c *gc.C) {
+	incs := []struct {
+		key []string
+		t   time.Time
+	}{
+		{[]string{"a", "b"}, time.Date(2012, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{[]string{"a", "b"}, time.Date(2012, time.January, 31, 23, 59, 0, 0, time.UTC)},
+		{[]string{"a", "c"}, time.Date(2012, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{[]string{"a", "b"}, time.Date(2012, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{[]string{"a", "b"}, time.Date(2013, time.February, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, inc := range incs {
+		err := s.store.IncCounterAtTime(inc.key, inc.t)
+		c.Assert(err, gc.IsNil)
+	}
+	month := func(year int, m time.Month) time.Time {
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		request charmstore.CounterRequest
+		result  []charmstore.Counter
+	}{{
+		request: charmstore.CounterRequest{
+			Key:    []string{"a"},
+			Prefix: true,
+			By:     charmstore.ByMonth,
+		},
+		result: []charmstore.Counter{
+			{Key: []string{"a"}, Prefix: true, Count: 2, Time: month(2012, time.January)},
+			{Key: []string{"a"}, Prefix: true, Count: 2, Time: month(2012, time.February)},
+			{Key: []string{"a"}, Prefix: true, Count: 1, Time: month(2013, time.February)},
+		},
+	}, {
+		request: charmstore.CounterRequest{
+			Key:    []string{"a"},
+			Prefix: true,
+			List:   true,
+			By:     charmstore.ByMonth,
+		},
+		result: []charmstore.Counter{
+			{Key: []string{"a", "b"}, Count: 2, Time: month(2012, time.January)},
+			{Key: []string{"a", "b"}, Count: 1, Time: month(2012, time.February)},
+			{Key: []string{"a", "c"}, Count: 1, Time: month(2012, time.February)},
+			{Key: []string{"a", "b"}, Count: 1, Time: month(2013, time.February)},
+		},
+	}, {
+		request: charmstore.CounterRequest{
+			Key:    []string{"a"},
+			Prefix: true,
+			By:     charmstore.ByMonth,
+			Start:  time.Date(2012, time.January, 15, 0, 0, 0, 0, time.UTC),
+			Stop:   time.Date(2012, time.December, 31, 0, 0, 0, 0, time.UTC),
+		},
+		result: []charmstore.Counter{
+			{Key: []string{"a"}, Prefix: true, Count: 1, Time: month(2012, time.January)},
+			{Key: []string{"a"}, Prefix: true, Count: 2, Time: month(2012, time.February)},
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %#v", i, test.request)
+		result, err := s.store.Counters(&test.request)
+		c.Assert(err, gc.IsNil)
+		c.Assert(result, jc.DeepEquals, test.result)
+	}
+}
+
 type testStatsEntity struct {
 	id          *router.ResolvedURL
 	lastDay     int
@@ -697,6 +764,31 @@ func setDownloadCounts(c *gc.C, s *charmstore.Store, id *charm.URL, t time.Time,
 	}
 }
 
+func (s *StatsSuite) TestRecentDownloads(c *gc.C) {
+	now := time.Now()
+	id := charm.MustParseURL("~charmers/trusty/wordpress-1")
+	setDownloadCounts(c, s.store, id, now, 4)
+	// Downloads of other revisions are included, weighted by age.
+	setDownloadCounts(c, s.store, charm.MustParseURL("~charmers/trusty/wordpress-2"), now.Add(-7*24*time.Hour), 2)
+	setDownloadCounts(c, s.store, id, now.Add(-14*24*time.Hour), 4)
+	// Downloads before the recent period are ignored.
+	setDownloadCounts(c, s.store, id, now.Add(-40*24*time.Hour), 100)
+	// Downloads of the promulgated entity are counted separately.
+	setDownloadCounts(c, s.store, charm.MustParseURL("trusty/wordpress-1"), now, 10)
+
+	recent, err := s.store.RecentDownloads(charm.MustParseURL("~charmers/trusty/wordpress-5"), now)
+	c.Assert(err, gc.IsNil)
+	c.Assert(recent, gc.Equals, 4+2*0.5+4*0.25)
+
+	recent, err = s.store.RecentDownloads(charm.MustParseURL("trusty/wordpress"), now)
+	c.Assert(err, gc.IsNil)
+	c.Assert(recent, gc.Equals, 10.0)
+
+	recent, err = s.store.RecentDownloads(charm.MustParseURL("~charmers/trusty/mysql-1"), now)
+	c.Assert(err, gc.IsNil)
+	c.Assert(recent, gc.Equals, 0.0)
+}
+
 func (s *StatsSuite) TestIncrementDownloadCounts(c *gc.C) {
 	ch := storetesting.Charms.CharmDir("wordpress")
 	id := charmstore.MustParseResolvedURL("0 ~charmers/trusty/wordpress-1")
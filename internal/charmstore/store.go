@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,6 +35,10 @@ var logger = loggo.GetLogger("charmstore.internal.charmstore")
 var (
 	errClosed          = errgo.New("charm store has been closed")
 	ErrTooManySessions = errgo.New("too many mongo sessions in use")
+
+	// ErrStopped is used as the cause of the error returned by
+	// long-running operations that are stopped before completion.
+	ErrStopped = errgo.New("operation stopped")
 )
 
 // Pool holds a connection to the underlying charm and blob
@@ -54,6 +60,40 @@ type Pool struct {
 
 	config ServerParams
 
+	// webhooks delivers webhook notifications.
+	webhooks *webhookNotifier
+
+	// trending periodically refreshes the recent download
+	// figures in the search index. It is nil if no refresh
+	// has been started.
+	trending *periodicTask
+
+	// blobGC periodically removes orphaned blobs. It is nil
+	// if blob garbage collection is not enabled.
+	blobGC *periodicTask
+
+	// blobScrub periodically checks the integrity of entity
+	// archives. It is nil if blob scrubbing is not enabled.
+	blobScrub *periodicTask
+
+	// interestingRefresh periodically computes the ranking of
+	// interesting entities. It is nil if no refresh has been
+	// started.
+	interestingRefresh *periodicTask
+
+	// interesting holds the most recent ranking of
+	// interesting entities, used by Store.Interesting.
+	interesting interestingRanking
+
+	// closing is closed when the pool is closed, so that
+	// long-running background operations can stop early.
+	closing chan struct{}
+
+	// blobBackend holds the backend that stores blob content.
+	// If it is nil, blob content is held in GridFS in the
+	// store database.
+	blobBackend blobstore.Backend
+
 	// auditEncoder encodes messages to auditLogger.
 	auditEncoder *json.Encoder
 	auditLogger  *lumberjack.Logger
@@ -92,6 +132,16 @@ func NewPool(db *mgo.Database, si *SearchIndex, bakeryParams *bakery.NewServiceP
 	if config.StatsCacheMaxAge == 0 {
 		config.StatsCacheMaxAge = time.Hour
 	}
+	config.setArchiveLimitDefaults()
+	config.setUploadLimitDefaults()
+	var blobBackend blobstore.Backend
+	if config.BlobStorage != "" {
+		var err error
+		blobBackend, err = blobstore.NewBackend(config.BlobStorage)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot make blob storage backend")
+		}
+	}
 
 	p := &Pool{
 		db:          StoreDatabase{db}.copy(),
@@ -99,7 +149,10 @@ func NewPool(db *mgo.Database, si *SearchIndex, bakeryParams *bakery.NewServiceP
 		statsCache:  cache.New(config.StatsCacheMaxAge),
 		config:      config,
 		run:         parallel.NewRun(maxAsyncGoroutines),
+		webhooks:    newWebhookNotifier(),
 		auditLogger: config.AuditLogger,
+		blobBackend: blobBackend,
+		closing:     make(chan struct{}),
 	}
 	if config.MaxMgoSessions > 0 {
 		p.reqStoreC = make(chan *Store, config.MaxMgoSessions)
@@ -152,7 +205,21 @@ func (p *Pool) Close() {
 	}
 	p.closed = true
 	p.mu.Unlock()
+	close(p.closing)
+	if p.trending != nil {
+		p.trending.close()
+	}
+	if p.blobGC != nil {
+		p.blobGC.close()
+	}
+	if p.blobScrub != nil {
+		p.blobScrub.close()
+	}
+	if p.interestingRefresh != nil {
+		p.interestingRefresh.close()
+	}
 	p.run.Wait()
+	p.webhooks.close()
 	p.db.Close()
 	// Close all cached stores. Any used by
 	// outstanding requests will be closed when the
@@ -224,7 +291,7 @@ func (p *Pool) requestStoreNB(always bool) (*Store, error) {
 	db := p.db.copy()
 	store := &Store{
 		DB:        db,
-		BlobStore: blobstore.New(db.Database, "entitystore"),
+		BlobStore: p.newBlobStore(db),
 		ES:        p.es,
 		stats:     &p.stats,
 		pool:      p,
@@ -235,6 +302,20 @@ func (p *Pool) requestStoreNB(always bool) (*Store, error) {
 	return store, nil
 }
 
+// newBlobStore returns a blob store that uses the given database
+// and the pool's blob backend. Blobs that the backend does not hold
+// are read from GridFS, where they were stored before the backend was
+// configured.
+func (p *Pool) newBlobStore(db StoreDatabase) *blobstore.Store {
+	if p.blobBackend == nil {
+		return blobstore.New(db.Database, "entitystore")
+	}
+	return blobstore.NewWithBackend(db.Database, blobstore.NewFallbackBackend(
+		p.blobBackend,
+		blobstore.NewGridFSBackend(db.Database, "entitystore"),
+	))
+}
+
 func newBakery(db StoreDatabase, bp bakery.NewServiceParams) *bakery.Service {
 	macStore, err := mgostorage.New(db.Macaroons())
 	if err != nil {
@@ -271,7 +352,7 @@ type Store struct {
 func (s *Store) Copy() *Store {
 	s1 := *s
 	s1.DB = s.DB.clone()
-	s1.BlobStore = blobstore.New(s1.DB.Database, "entitystore")
+	s1.BlobStore = s.pool.newBlobStore(s1.DB)
 	if s.Bakery != nil {
 		s1.Bakery = newBakery(s1.DB, *s.pool.bakeryParams)
 	}
@@ -396,6 +477,32 @@ func (s *Store) ensureIndexes() error {
 	}, {
 		s.DB.BaseEntities(),
 		mgo.Index{Key: []string{"name"}},
+	}, {
+		s.DB.Resources(),
+		mgo.Index{Key: []string{"baseurl", "name", "revision"}, Unique: true},
+	}, {
+		s.DB.Audits(),
+		mgo.Index{Key: []string{"time"}, ExpireAfter: auditLogRetention},
+	}, {
+		s.DB.Audits(),
+		mgo.Index{Key: []string{"entity", "time"}},
+	}, {
+		s.DB.Audits(),
+		mgo.Index{Key: []string{"user", "time"}},
+	}, {
+		s.DB.Events(),
+		mgo.Index{Key: []string{"time"}, ExpireAfter: eventRetention},
+	}, {
+		// Uploads are removed as soon as the TTL monitor
+		// runs after they expire.
+		s.DB.Uploads(),
+		mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second},
+	}, {
+		s.DB.Uploads(),
+		mgo.Index{Key: []string{"owner", "expires"}},
+	}, {
+		s.DB.SearchChanges(),
+		mgo.Index{Key: []string{"time"}},
 	}, {
 		// TODO this index should be created by the mgo gridfs code.
 		s.DB.C("entitystore.files"),
@@ -410,16 +517,22 @@ func (s *Store) ensureIndexes() error {
 	return nil
 }
 
-// AddAudit adds the given entry to the audit log.
+// AddAudit adds the given entry to the audit log. The entry is
+// recorded in the audits collection, where it can be retrieved
+// with AuditEntries, and is also written to the audit logger
+// if one has been configured.
 func (s *Store) AddAudit(entry audit.Entry) {
 	s.addAuditAtTime(entry, time.Now())
 }
 
 func (s *Store) addAuditAtTime(entry audit.Entry, t time.Time) {
+	entry.Time = t
+	if err := s.DB.Audits().Insert(entry); err != nil {
+		logger.Errorf("Cannot insert audit log entry: %v", err)
+	}
 	if s.pool.auditEncoder == nil {
 		return
 	}
-	entry.Time = t
 	err := s.pool.auditEncoder.Encode(entry)
 	if err != nil {
 		logger.Errorf("Cannot write audit log entry: %v", err)
@@ -739,7 +852,14 @@ func (s *Store) UpdateBaseEntity(url *router.ResolvedURL, update bson.D) error {
 // Publish assigns channels to the entity corresponding to the given URL.
 // An error is returned if no channels are provided. For the time being,
 // the only supported channels are "development" and "stable".
-func (s *Store) Publish(url *router.ResolvedURL, channels ...params.Channel) error {
+//
+// The resources map holds the revisions of the charm's resources
+// that are published along with the entity, keyed by resource name.
+// These replace any resource revisions previously published with the
+// entity in the given channels. If a named resource is not declared
+// by the charm, or the revision does not exist, an error with a
+// params.ErrNotFound cause is returned.
+func (s *Store) Publish(url *router.ResolvedURL, resources map[string]int, channels ...params.Channel) error {
 	var updateSearch bool
 	// Validate channels.
 	actual := make([]params.Channel, 0, len(channels))
@@ -757,12 +877,36 @@ func (s *Store) Publish(url *router.ResolvedURL, channels ...params.Channel) err
 		return errgo.Newf("cannot update %q: no channels provided", url)
 	}
 
+	// Validate resources.
+	var resourceRevisions []mongodoc.ResourceRevision
+	if len(resources) > 0 {
+		entity, err := s.FindEntity(url, FieldSelector("baseurl", "charmmeta"))
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		resourceRevisions, err = s.publishedResourceRevisions(entity, resources)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+	}
+
 	// Update the entity.
-	update := make(bson.D, numChannels)
-	for i, c := range actual {
-		update[i] = bson.DocElem{string(c), true}
+	set := make(bson.D, 0, 2*numChannels)
+	var unset bson.D
+	for _, c := range actual {
+		set = append(set, bson.DocElem{string(c), true})
+		field := "channelresources." + string(c)
+		if len(resourceRevisions) > 0 {
+			set = append(set, bson.DocElem{field, resourceRevisions})
+		} else {
+			unset = append(unset, bson.DocElem{field, 1})
+		}
+	}
+	update := bson.D{{"$set", set}}
+	if len(unset) > 0 {
+		update = append(update, bson.DocElem{"$unset", unset})
 	}
-	if err := s.UpdateEntity(url, bson.D{{"$set", update}}); err != nil {
+	if err := s.UpdateEntity(url, update); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
 
@@ -786,6 +930,10 @@ func (s *Store) Publish(url *router.ResolvedURL, channels ...params.Channel) err
 	if err := s.UpdateBaseEntity(url, bson.D{{"$set", update}}); err != nil {
 		return errgo.Mask(err)
 	}
+	if err := s.AddEvent(mongodoc.PublishEvent, &url.URL, actual...); err != nil {
+		return errgo.Mask(err)
+	}
+	s.notifyWebhooks(mongodoc.PublishEvent, &url.URL, actual)
 
 	if !updateSearch {
 		return nil
@@ -830,6 +978,10 @@ func (s *Store) SetPromulgated(url *router.ResolvedURL, promulgate bool) error {
 			}
 			return errgo.Notef(err, "cannot unpromulgate base entity %q", base)
 		}
+		if err := s.AddEvent(mongodoc.UnpromulgateEvent, base); err != nil {
+			return errgo.Mask(err)
+		}
+		s.notifyWebhooks(mongodoc.UnpromulgateEvent, base, nil)
 		if err := s.UpdateSearchBaseURL(base); err != nil {
 			return errgo.Notef(err, "cannot update search entities for %q", base)
 		}
@@ -937,6 +1089,10 @@ func (s *Store) SetPromulgated(url *router.ResolvedURL, promulgate bool) error {
 			return errgo.Notef(err, "cannot update promulgated URLs")
 		}
 	}
+	if err := s.AddEvent(mongodoc.PromulgateEvent, base); err != nil {
+		return errgo.Mask(err)
+	}
+	s.notifyWebhooks(mongodoc.PromulgateEvent, base, nil)
 
 	// Update the search record for the newest entity.
 	if err := s.UpdateSearchBaseURL(base); err != nil {
@@ -952,9 +1108,18 @@ func (s *Store) SetPromulgated(url *router.ResolvedURL, promulgate bool) error {
 // channel then the unpublished ACL is updated. This is only provided for
 // testing.
 func (s *Store) SetPerms(id *charm.URL, which string, acl ...string) error {
-	return s.DB.BaseEntities().UpdateId(mongodoc.BaseURL(id), bson.D{{"$set",
+	base := mongodoc.BaseURL(id)
+	err := s.DB.BaseEntities().UpdateId(base, bson.D{{"$set",
 		bson.D{{"channelacls." + which, acl}},
 	}})
+	if err != nil {
+		return err
+	}
+	ch := params.UnpublishedChannel
+	if i := strings.Index(which, "."); i >= 0 {
+		ch = params.Channel(which[:i])
+	}
+	return s.AddEvent(mongodoc.PermEvent, base, ch)
 }
 
 // MatchingInterfacesQuery returns a mongo query
@@ -1019,11 +1184,25 @@ func (s *Store) AddLog(data *json.RawMessage, logLevel mongodoc.LogLevel, logTyp
 	return nil
 }
 
+// DeleteEntity removes the entity with the given id, along with its
+// archive blobs, and updates the search index accordingly.
+//
+// An entity that is currently published in a channel for any series
+// cannot be deleted: in that case an error with a params.ErrForbidden
+// cause is returned. If the entity does not exist, an error with a
+// params.ErrNotFound cause is returned.
 func (s *Store) DeleteEntity(id *router.ResolvedURL) error {
 	entity, err := s.FindEntity(id, FieldSelector("blobname", "blobhash", "prev5blobhash"))
 	if err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
+	baseEntity, err := s.FindBaseEntity(&id.URL, FieldSelector("channelentities"))
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if channels := currentChannels(baseEntity, &id.URL); len(channels) > 0 {
+		return errgo.WithCausef(nil, params.ErrForbidden, "entity is currently published in channels %s", strings.Join(channels, ", "))
+	}
 	// Remove the entity.
 	if err := s.DB.Entities().RemoveId(&id.URL); err != nil {
 		if err == mgo.ErrNotFound {
@@ -1032,6 +1211,9 @@ func (s *Store) DeleteEntity(id *router.ResolvedURL) error {
 		}
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
+	if err := s.AddEvent(mongodoc.DeleteEvent, &id.URL); err != nil {
+		return errgo.Mask(err)
+	}
 	// Remove the reference to the archive from the blob store.
 	if err := s.BlobStore.Remove(entity.BlobName); err != nil {
 		return errgo.Notef(err, "cannot remove blob %s", entity.BlobName)
@@ -1042,10 +1224,29 @@ func (s *Store) DeleteEntity(id *router.ResolvedURL) error {
 			return errgo.Notef(err, "cannot remove compatibility blob %s", name)
 		}
 	}
-
+	if err := s.UpdateSearch(id); err != nil {
+		return errgo.Notef(err, "cannot update search index for %s", id)
+	}
 	return nil
 }
 
+// currentChannels returns the names of the channels, in sorted order,
+// in which the entity with the given URL is the currently published
+// revision for any series.
+func currentChannels(baseEntity *mongodoc.BaseEntity, url *charm.URL) []string {
+	var channels []string
+	for ch, entities := range baseEntity.ChannelEntities {
+		for _, u := range entities {
+			if *u == *url {
+				channels = append(channels, string(ch))
+				break
+			}
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
 // StoreDatabase wraps an mgo.DB ands adds a few convenience methods.
 type StoreDatabase struct {
 	*mgo.Database
@@ -1096,10 +1297,62 @@ func (s StoreDatabase) Migrations() *mgo.Collection {
 	return s.C("migrations")
 }
 
+// Resources returns the mongo collection where resource revisions are stored.
+func (s StoreDatabase) Resources() *mgo.Collection {
+	return s.C("resources")
+}
+
+// Audits returns the mongo collection where audit log entries are stored.
+func (s StoreDatabase) Audits() *mgo.Collection {
+	return s.C("audits")
+}
+
+// Events returns the mongo collection where change events are stored.
+func (s StoreDatabase) Events() *mgo.Collection {
+	return s.C("events")
+}
+
+// Sequences returns the mongo collection that holds the
+// sequence counters used to number documents, such as events.
+func (s StoreDatabase) Sequences() *mgo.Collection {
+	return s.C("sequences")
+}
+
+// Featured returns the mongo collection where the featured
+// entities are stored.
+func (s StoreDatabase) Featured() *mgo.Collection {
+	return s.C("featured")
+}
+
+// Search returns the mongo collection where the built-in
+// search backend stores its search documents.
+func (s StoreDatabase) Search() *mgo.Collection {
+	return s.C("search")
+}
+
+// SearchSync returns the mongo collection where the progress
+// of search index synchronisations is recorded.
+func (s StoreDatabase) SearchSync() *mgo.Collection {
+	return s.C("searchsync")
+}
+
+// SearchChanges returns the mongo collection where the
+// last update of the search documents for each base entity
+// is recorded.
+func (s StoreDatabase) SearchChanges() *mgo.Collection {
+	return s.C("searchchanges")
+}
+
 func (s StoreDatabase) Macaroons() *mgo.Collection {
 	return s.C("macaroons")
 }
 
+// Uploads returns the collection that holds
+// the chunked archive uploads in progress.
+func (s StoreDatabase) Uploads() *mgo.Collection {
+	return s.C("uploads")
+}
+
 // allCollections holds for each collection used by the charm store a
 // function returns that collection.
 // The macaroons collection is omitted because it does
@@ -1111,6 +1364,15 @@ var allCollections = []func(StoreDatabase) *mgo.Collection{
 	StoreDatabase.BaseEntities,
 	StoreDatabase.Logs,
 	StoreDatabase.Migrations,
+	StoreDatabase.Resources,
+	StoreDatabase.Audits,
+	StoreDatabase.Events,
+	StoreDatabase.Sequences,
+	StoreDatabase.Featured,
+	StoreDatabase.Search,
+	StoreDatabase.SearchSync,
+	StoreDatabase.SearchChanges,
+	StoreDatabase.Uploads,
 }
 
 // Collections returns a slice of all the collections used
@@ -1315,11 +1577,14 @@ func (lq *ListQuery) Iter(fields map[string]int) *mgo.Iter {
 
 // SynchroniseElasticsearch creates new indexes in elasticsearch
 // and populates them with the current data from the mongodb database.
-func (s *Store) SynchroniseElasticsearch() error {
-	if err := s.ES.ensureIndexes(true); err != nil {
+// If p.Resume is true, the existing indexes are kept and the
+// synchronisation continues from where an earlier one was
+// interrupted.
+func (s *Store) SynchroniseElasticsearch(p SyncSearchParams) error {
+	if err := s.ES.ensureIndexes(!p.Resume); err != nil {
 		return errgo.Notef(err, "cannot create indexes")
 	}
-	if err := s.syncSearch(); err != nil {
+	if err := s.syncSearch(p); err != nil {
 		return errgo.Notef(err, "cannot synchronise indexes")
 	}
 	return nil
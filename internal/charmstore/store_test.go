@@ -947,6 +947,105 @@ func (s *StoreSuite) TestOpenBlob(c *gc.C) {
 	c.Assert(blob.Size, gc.Equals, info.Size())
 }
 
+func (s *StoreSuite) TestOpenBlobWithBlobStorage(c *gc.C) {
+	dir := c.MkDir()
+	p, err := NewPool(s.Session.DB("juju_test"), nil, nil, ServerParams{
+		BlobStorage: "file://" + dir,
+	})
+	c.Assert(err, gc.IsNil)
+	defer p.Close()
+	store := p.Store()
+	defer store.Close()
+
+	charmArchive := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	url := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-23", 23)
+	err = store.AddCharmWithArchive(url, charmArchive)
+	c.Assert(err, gc.IsNil)
+
+	// The content is held in the blob storage directory
+	// rather than in GridFS.
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, gc.IsNil)
+	c.Assert(len(files) > 0, gc.Equals, true)
+	n, err := store.DB.C("entitystore.files").Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+
+	f, err := os.Open(charmArchive.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	expectHash := hashOfReader(c, f)
+
+	store1 := store.Copy()
+	defer store1.Close()
+	blob, err := store1.OpenBlob(url)
+	c.Assert(err, gc.IsNil)
+	defer blob.Close()
+	c.Assert(hashOfReader(c, blob), gc.Equals, expectHash)
+}
+
+func (s *StoreSuite) TestOpenBlobStoredBeforeBlobStorage(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	charmArchive := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	url := router.MustNewResolvedURL("cs:~charmers/precise/wordpress-23", 23)
+	err := store.AddCharmWithArchive(url, charmArchive)
+	c.Assert(err, gc.IsNil)
+	f, err := os.Open(charmArchive.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	expectHash := hashOfReader(c, f)
+
+	dir := c.MkDir()
+	p, err := NewPool(s.Session.DB("juju_test"), nil, nil, ServerParams{
+		BlobStorage: "file://" + dir,
+	})
+	c.Assert(err, gc.IsNil)
+	defer p.Close()
+	store1 := p.Store()
+	defer store1.Close()
+
+	// The blob is read from GridFS until it has been migrated.
+	blob, err := store1.OpenBlob(url)
+	c.Assert(err, gc.IsNil)
+	c.Assert(hashOfReader(c, blob), gc.Equals, expectHash)
+	blob.Close()
+
+	backend, err := blobstore.NewBackend("file://" + dir)
+	c.Assert(err, gc.IsNil)
+	n, err := blobstore.Migrate(store1.DB.Database, blobstore.MigrateParams{
+		From:         blobstore.NewGridFSBackend(store1.DB.Database, "entitystore"),
+		To:           backend,
+		RemoveSource: true,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(n > 0, gc.Equals, true)
+	count, err := store1.DB.C("entitystore.files").Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+
+	blob, err = store1.OpenBlob(url)
+	c.Assert(err, gc.IsNil)
+	defer blob.Close()
+	c.Assert(hashOfReader(c, blob), gc.Equals, expectHash)
+
+	// Running the migration again copies nothing.
+	n, err = blobstore.Migrate(store1.DB.Database, blobstore.MigrateParams{
+		From: blobstore.NewGridFSBackend(store1.DB.Database, "entitystore"),
+		To:   backend,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *StoreSuite) TestNewPoolWithInvalidBlobStorage(c *gc.C) {
+	p, err := NewPool(s.Session.DB("juju_test"), nil, nil, ServerParams{
+		BlobStorage: "ftp://example.com",
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot make blob storage backend: unknown blob storage location "ftp://example.com"`)
+	c.Assert(p, gc.IsNil)
+}
+
 func (s *StoreSuite) TestOpenBlobPreV5(c *gc.C) {
 	store := s.newStore(c, false)
 	defer store.Close()
@@ -1130,6 +1229,10 @@ func (s *StoreSuite) TestCollections(c *gc.C) {
 	createdOnUse := map[string]bool{
 		"migrations": true,
 		"macaroons":  true,
+		"sequences":  true,
+		"featured":   true,
+		"search":     true,
+		"searchsync": true,
 	}
 	// Check that all collections mentioned by Collections are actually created.
 	for _, coll := range colls {
@@ -2512,11 +2615,11 @@ func (s *StoreSuite) TestFindBestEntity(c *gc.C) {
 		err = store.SetPromulgated(ch.id, ch.id.PromulgatedRevision != -1)
 		c.Assert(err, gc.IsNil)
 		if ch.development {
-			err := store.Publish(ch.id, params.DevelopmentChannel)
+			err := store.Publish(ch.id, nil, params.DevelopmentChannel)
 			c.Assert(err, gc.IsNil)
 		}
 		if ch.stable {
-			err := store.Publish(ch.id, params.StableChannel)
+			err := store.Publish(ch.id, nil, params.StableChannel)
 			c.Assert(err, gc.IsNil)
 		}
 	}
@@ -2527,11 +2630,11 @@ func (s *StoreSuite) TestFindBestEntity(c *gc.C) {
 		err = store.SetPromulgated(b.id, b.id.PromulgatedRevision != -1)
 		c.Assert(err, gc.IsNil)
 		if b.development {
-			err := store.Publish(b.id, params.DevelopmentChannel)
+			err := store.Publish(b.id, nil, params.DevelopmentChannel)
 			c.Assert(err, gc.IsNil)
 		}
 		if b.stable {
-			err := store.Publish(b.id, params.StableChannel)
+			err := store.Publish(b.id, nil, params.StableChannel)
 			c.Assert(err, gc.IsNil)
 		}
 	}
@@ -3161,6 +3264,108 @@ func (s *StoreSuite) TestAddAuditWithNoLumberjack(c *gc.C) {
 	})
 }
 
+var auditEntriesTests = []struct {
+	about         string
+	filter        AuditFilter
+	expectEntries []int
+}{{
+	about:         "no filter",
+	expectEntries: []int{0, 1, 2, 3},
+}, {
+	about: "filter by user",
+	filter: AuditFilter{
+		User: "bob",
+	},
+	expectEntries: []int{0, 2},
+}, {
+	about: "filter by op",
+	filter: AuditFilter{
+		Op: audit.OpPublish,
+	},
+	expectEntries: []int{1, 2},
+}, {
+	about: "filter by entity",
+	filter: AuditFilter{
+		Entity: charm.MustParseURL("cs:~bob/trusty/wordpress-1"),
+	},
+	expectEntries: []int{1, 2},
+}, {
+	about: "filter by time range",
+	filter: AuditFilter{
+		After:  time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2016, 1, 3, 0, 0, 0, 0, time.UTC),
+	},
+	expectEntries: []int{1, 2},
+}, {
+	about: "combined filters",
+	filter: AuditFilter{
+		User: "bob",
+		Op:   audit.OpPublish,
+	},
+	expectEntries: []int{2},
+}, {
+	about: "skip and limit",
+	filter: AuditFilter{
+		Skip:  1,
+		Limit: 2,
+	},
+	expectEntries: []int{1, 2},
+}, {
+	about: "no matches",
+	filter: AuditFilter{
+		User: "nobody",
+	},
+	expectEntries: []int{},
+}}
+
+func (s *StoreSuite) TestAuditEntries(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	entries := []audit.Entry{{
+		Time:   time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		User:   "bob",
+		Op:     audit.OpSetPerm,
+		Entity: charm.MustParseURL("cs:~bob/trusty/wordpress-0"),
+		ACL: &audit.ACL{
+			Read: []string{"everyone"},
+		},
+	}, {
+		Time:     time.Date(2016, 1, 2, 0, 0, 0, 0, time.UTC),
+		User:     "alice",
+		Op:       audit.OpPublish,
+		Entity:   charm.MustParseURL("cs:~bob/trusty/wordpress-1"),
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Time:     time.Date(2016, 1, 2, 12, 0, 0, 0, time.UTC),
+		User:     "bob",
+		Op:       audit.OpPublish,
+		Entity:   charm.MustParseURL("cs:~bob/trusty/wordpress-1"),
+		Channels: []params.Channel{params.DevelopmentChannel},
+	}, {
+		Time: time.Date(2016, 1, 4, 0, 0, 0, 0, time.UTC),
+		User: "alice",
+		Op:   audit.OpSetExtraInfo,
+		Key:  "foo",
+	}}
+	for _, e := range entries {
+		store.addAuditAtTime(e, e.Time)
+	}
+	for i, test := range auditEntriesTests {
+		c.Logf("test %d: %s", i, test.about)
+		got, err := store.AuditEntries(test.filter)
+		c.Assert(err, gc.IsNil)
+		for i := range got {
+			got[i].Time = got[i].Time.UTC()
+		}
+		expect := make([]audit.Entry, len(test.expectEntries))
+		for i, n := range test.expectEntries {
+			expect[i] = entries[n]
+		}
+		c.Assert(got, jc.DeepEquals, expect)
+	}
+}
+
 func (s *StoreSuite) TestDenormalizeEntity(c *gc.C) {
 	e := &mongodoc.Entity{
 		URL: charm.MustParseURL("~someone/utopic/acharm-45"),
@@ -3221,19 +3426,19 @@ func (s *StoreSuite) TestBundleCharms(c *gc.C) {
 	rurl := router.MustNewResolvedURL("cs:~charmers/saucy/mysql-0", 0)
 	err := store.AddCharmWithArchive(rurl, mysql)
 	c.Assert(err, gc.IsNil)
-	err = store.Publish(rurl, params.StableChannel)
+	err = store.Publish(rurl, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 	riak := storetesting.Charms.CharmArchive(c.MkDir(), "riak")
 	rurl = router.MustNewResolvedURL("cs:~charmers/trusty/riak-42", 42)
 	err = store.AddCharmWithArchive(rurl, riak)
 	c.Assert(err, gc.IsNil)
-	err = store.Publish(rurl, params.StableChannel)
+	err = store.Publish(rurl, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 	wordpress := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
 	rurl = router.MustNewResolvedURL("cs:~charmers/utopic/wordpress-47", 47)
 	err = store.AddCharmWithArchive(rurl, wordpress)
 	c.Assert(err, gc.IsNil)
-	err = store.Publish(rurl, params.StableChannel)
+	err = store.Publish(rurl, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 
 	tests := []struct {
@@ -3778,7 +3983,7 @@ func (s *StoreSuite) TestPublish(c *gc.C) {
 		c.Assert(err, gc.IsNil)
 
 		// Publish the entity.
-		err = store.Publish(test.url, test.channels...)
+		err = store.Publish(test.url, nil, test.channels...)
 		if test.expectedErr != "" {
 			c.Assert(err, gc.ErrorMatches, test.expectedErr)
 			continue
@@ -3802,15 +4007,56 @@ func (s *StoreSuite) TestPublishWithFailedESInsert(c *gc.C) {
 
 	store := s.newStore(c, false)
 	defer store.Close()
-	store.ES = &SearchIndex{esdb, "no-index"}
+	store.ES = &SearchIndex{Database: esdb, Index: "no-index"}
 
 	url := router.MustNewResolvedURL("~charmers/precise/wordpress-12", -1)
 	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
 	c.Assert(err, gc.IsNil)
-	err = store.Publish(url, params.StableChannel)
+	err = store.Publish(url, nil, params.StableChannel)
 	c.Assert(err, gc.ErrorMatches, "cannot index cs:~charmers/precise/wordpress-12 to ElasticSearch: .*")
 }
 
+func (s *StoreSuite) TestDeleteEntity(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := router.MustNewResolvedURL("~charmers/precise/wordpress-12", -1)
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	entity, err := store.FindEntity(url, FieldSelector("blobname"))
+	c.Assert(err, gc.IsNil)
+
+	err = store.DeleteEntity(url)
+	c.Assert(err, gc.IsNil)
+
+	_, err = store.FindEntity(url, nil)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	_, _, err = store.BlobStore.Open(entity.BlobName)
+	c.Assert(err, gc.ErrorMatches, "resource.*not found")
+
+	err = store.DeleteEntity(url)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestDeleteEntityPublished(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := router.MustNewResolvedURL("~charmers/precise/wordpress-12", -1)
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(url, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+
+	err = store.DeleteEntity(url)
+	c.Assert(err, gc.ErrorMatches, "entity is currently published in channels stable")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrForbidden)
+
+	// The entity is still there.
+	_, err = store.FindEntity(url, nil)
+	c.Assert(err, gc.IsNil)
+}
+
 func entity(url, purl string) *mongodoc.Entity {
 	id := charm.MustParseURL(url)
 	var pid *charm.URL
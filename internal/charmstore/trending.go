@@ -0,0 +1,158 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/series"
+)
+
+// DefaultTrendingRefreshInterval holds the default interval between
+// refreshes of the recent download figures held in the search index.
+const DefaultTrendingRefreshInterval = 6 * time.Hour
+
+// refreshTrending updates the recent download figures held in the
+// search index, so that they decay even when the entities are not
+// downloaded. It is run periodically by the server, and stops early
+// when stop is closed.
+func refreshTrending(store *Store, stop <-chan struct{}) {
+	logger.Infof("refreshing recent download counts in search index")
+	n, err := store.refreshRecentDownloads(time.Now(), stop)
+	if errgo.Cause(err) == ErrStopped {
+		logger.Infof("recent download count refresh stopped")
+		return
+	}
+	if err != nil {
+		logger.Errorf("cannot refresh recent download counts: %v", err)
+		return
+	}
+	logger.Infof("refreshed recent download counts of %d entities", n)
+}
+
+// refreshRecentDownloads recalculates the RecentDownloads value of the
+// search documents for every base entity that has been downloaded
+// within RecentDownloadsPeriod of now, or that has a non-zero value in
+// the search index. Only that value is changed; the rest of each
+// document, and any search synchronisation checkpoint, is left alone.
+// It returns the number of base entities that were refreshed.
+func (s *Store) refreshRecentDownloads(now time.Time, stop <-chan struct{}) (int, error) {
+	if !s.ES.enabled() {
+		return 0, nil
+	}
+	candidates, err := s.recentlyDownloadedBaseEntities(now.Add(-RecentDownloadsPeriod))
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	indexed, err := s.ES.recentlyDownloaded()
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	for _, url := range indexed {
+		candidates[mongodoc.BaseURL(url).String()] = true
+	}
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	n := 0
+	for _, id := range ids {
+		if isStopped(stop) {
+			return n, errgo.WithCausef(nil, ErrStopped, "recent download refresh stopped after %d entities", n)
+		}
+		err := s.refreshBaseEntityRecentDownloads(charm.MustParseURL(id), now)
+		if errgo.Cause(err) == params.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return n, errgo.Mask(err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// recentlyDownloadedBaseEntities returns the set of base entity URLs,
+// as strings, with archive download counters recorded since the given
+// time.
+func (s *Store) recentlyDownloadedBaseEntities(since time.Time) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	prefix, err := s.stats.key(s.DB, []string{params.StatsArchiveDownload}, false)
+	if errgo.Cause(err) == params.ErrNotFound {
+		// Nothing has ever been downloaded.
+		return ids, nil
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get download statistics key")
+	}
+	var keys []string
+	err = s.DB.StatCounters().Find(bson.D{
+		{"k", bson.D{{"$regex", "^" + regexp.QuoteMeta(prefix)}}},
+		{"t", bson.D{{"$gte", timeToStamp(since)}}},
+	}).Distinct("k", &keys)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find recent download counters")
+	}
+	for _, key := range keys {
+		// The tokens are those of EntityStatsKey: kind, series,
+		// name, user and revision.
+		tokens, err := s.statsKeyTokens(key)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if len(tokens) < 4 || tokens[3] == "" {
+			continue
+		}
+		ids[fmt.Sprintf("cs:~%s/%s", tokens[3], tokens[2])] = true
+	}
+	return ids, nil
+}
+
+// refreshBaseEntityRecentDownloads updates the RecentDownloads value of
+// the search documents for the latest stable revisions of the base
+// entity with the given URL.
+func (s *Store) refreshBaseEntityRecentDownloads(url *charm.URL, now time.Time) error {
+	baseEntity, err := s.FindBaseEntity(url, FieldSelector("promulgated", "channelentities"))
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	updated := make(map[string]bool)
+	for urlSeries, url := range baseEntity.ChannelEntities[params.StableChannel] {
+		if !series.Series[urlSeries].SearchIndex || updated[url.String()] {
+			continue
+		}
+		updated[url.String()] = true
+		entity, err := s.FindEntity(&router.ResolvedURL{URL: *url}, FieldSelector("promulgated-url", "supportedseries"))
+		if err != nil {
+			return errgo.Notef(err, "cannot find %v", url)
+		}
+		if !baseEntity.Promulgated {
+			// As in searchDocFromEntity, only the latest
+			// promulgated base entity is indexed under its
+			// promulgated URL.
+			entity.PromulgatedURL = nil
+		}
+		recent, err := s.RecentDownloads(EntityResolvedURL(entity).PreferredURL(), now)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		for _, doc := range expandSearchDoc(&SearchDoc{Entity: entity}) {
+			if err := s.ES.updateRecentDownloads(doc.URL, recent); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"io"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// uploadExpiry holds the length of time that a chunked upload is
+// kept after it was created or last had a part added.
+var uploadExpiry = 24 * time.Hour
+
+// Default limits on chunked uploads, used when the
+// corresponding ServerParams fields are zero.
+const (
+	DefaultMaxOpenUploads    = 10
+	DefaultMinUploadPartSize = 5 << 20
+)
+
+// setUploadLimitDefaults sets any zero upload limits
+// in p to their default values.
+func (p *ServerParams) setUploadLimitDefaults() {
+	if p.MaxOpenUploads == 0 {
+		p.MaxOpenUploads = DefaultMaxOpenUploads
+	}
+	if p.MinUploadPartSize == 0 {
+		p.MinUploadPartSize = DefaultMinUploadPartSize
+	}
+}
+
+// NewUpload creates a new chunked archive upload owned by the given
+// user, which can only be committed to the base entity with the given
+// URL. Parts can be added to the upload with AddUploadPart until it
+// expires. The caller is responsible for checking that the owner may
+// upload to the base entity.
+//
+// If the owner already has the maximum number of open uploads, it
+// returns an error with a params.ErrForbidden cause. Uploads created
+// with an empty owner (by an administrator) are not limited.
+func (s *Store) NewUpload(owner string, baseURL *charm.URL) (*mongodoc.Upload, error) {
+	now := time.Now()
+	if max := s.pool.config.MaxOpenUploads; max > 0 && owner != "" {
+		n, err := s.DB.Uploads().Find(bson.D{
+			{"owner", owner},
+			{"expires", bson.D{{"$gt", now}}},
+		}).Count()
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot count open uploads")
+		}
+		if n >= max {
+			return nil, errgo.WithCausef(nil, params.ErrForbidden, "too many open uploads (maximum %d)", max)
+		}
+	}
+	u := &mongodoc.Upload{
+		Id:      bson.NewObjectId().Hex(),
+		Owner:   owner,
+		BaseURL: baseURL,
+		Expires: now.Add(uploadExpiry),
+	}
+	if err := s.DB.Uploads().Insert(u); err != nil {
+		return nil, errgo.Notef(err, "cannot insert upload")
+	}
+	return u, nil
+}
+
+// Upload returns the upload with the given id. If the upload does not
+// exist or has expired, it returns an error with a params.ErrNotFound
+// cause.
+func (s *Store) Upload(id string) (*mongodoc.Upload, error) {
+	var u mongodoc.Upload
+	err := s.DB.Uploads().Find(bson.D{
+		{"_id", id},
+		{"expires", bson.D{{"$gt", time.Now()}}},
+	}).One(&u)
+	if err == mgo.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", id)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get upload %q", id)
+	}
+	return &u, nil
+}
+
+// AddUploadPart reads a part of the upload with the given id from r,
+// which should have the given size and hash, and returns the updated
+// upload. Parts must be added in order, so the offset must be the
+// size of the upload so far. As a special case, the last part may be
+// sent again with the same offset, size and hash, which does nothing,
+// so that a client that did not see the response to a request can
+// retry it safely.
+//
+// Every part but the last must be at least the configured minimum
+// part size, so a part cannot be added after a smaller one.
+//
+// The following error causes may be returned:
+//
+//	params.ErrNotFound if the upload does not exist or has expired.
+//	params.ErrBadRequest if the offset or size are not valid, or the
+//		previous part was smaller than the minimum part size.
+//	params.ErrInvalidEntity if the upload would exceed the maximum archive size.
+func (s *Store) AddUploadPart(id string, offset int64, r io.Reader, size int64, hash string) (*mongodoc.Upload, error) {
+	if size <= 0 {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "empty upload part")
+	}
+	u, err := s.Upload(id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if offset != u.Size {
+		if n := len(u.Parts); n > 0 {
+			last := u.Parts[n-1]
+			if offset == u.Size-last.Size && size == last.Size && hash == last.Hash {
+				return u, nil
+			}
+		}
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "offset %d does not match upload size %d", offset, u.Size)
+	}
+	if n, min := len(u.Parts), s.pool.config.MinUploadPartSize; n > 0 && min > 0 && u.Parts[n-1].Size < min {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "previous part size %d is smaller than minimum %d; only the last part may be smaller", u.Parts[n-1].Size, min)
+	}
+	if err := s.checkArchiveSize(offset + size); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrInvalidEntity))
+	}
+	part := mongodoc.UploadPart{
+		BlobName: bson.NewObjectId().Hex(),
+		Hash:     hash,
+		Size:     size,
+	}
+	if err := s.BlobStore.PutUnchallenged(r, part.BlobName, size, hash); err != nil {
+		return nil, errgo.Notef(err, "cannot put upload part")
+	}
+	expires := time.Now().Add(uploadExpiry)
+	err = s.DB.Uploads().Update(bson.D{
+		{"_id", id},
+		{"size", offset},
+	}, bson.D{
+		{"$push", bson.D{{"parts", part}}},
+		{"$inc", bson.D{{"size", size}}},
+		{"$set", bson.D{{"expires", expires}}},
+	})
+	if err != nil {
+		if err1 := s.BlobStore.Remove(part.BlobName); err1 != nil {
+			logger.Errorf("cannot remove blob %s after error: %v", part.BlobName, err1)
+		}
+		if err == mgo.ErrNotFound {
+			return nil, errgo.Newf("upload %q was changed concurrently", id)
+		}
+		return nil, errgo.Notef(err, "cannot update upload %q", id)
+	}
+	u.Parts = append(u.Parts, part)
+	u.Size += size
+	u.Expires = expires
+	return u, nil
+}
+
+// OpenUpload returns a reader for the content of the given
+// upload, which holds all its parts in order.
+func (s *Store) OpenUpload(u *mongodoc.Upload) (blobstore.ReadSeekCloser, error) {
+	readers := make([]blobstore.ReadSeekCloser, 0, len(u.Parts))
+	for _, part := range u.Parts {
+		r, _, err := s.BlobStore.Open(part.BlobName)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, errgo.Notef(err, "cannot open upload part")
+		}
+		readers = append(readers, r)
+	}
+	return newMultiReadSeekCloser(readers...), nil
+}
+
+// RemoveUpload removes the upload with the given id and the blobs
+// holding its parts. If the upload does not exist, it returns an
+// error with a params.ErrNotFound cause.
+func (s *Store) RemoveUpload(id string) error {
+	var u mongodoc.Upload
+	_, err := s.DB.Uploads().FindId(id).Apply(mgo.Change{
+		Remove: true,
+	}, &u)
+	if err == mgo.ErrNotFound {
+		return errgo.WithCausef(nil, params.ErrNotFound, "upload %q not found", id)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot remove upload %q", id)
+	}
+	for _, part := range u.Parts {
+		// Any blob that cannot be removed will be
+		// removed by the blob garbage collector.
+		if err := s.BlobStore.Remove(part.BlobName); err != nil {
+			logger.Errorf("cannot remove blob %s of upload %s: %v", part.BlobName, id, err)
+		}
+	}
+	return nil
+}
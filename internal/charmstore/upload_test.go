@@ -0,0 +1,219 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type UploadSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&UploadSuite{})
+
+// newStore returns a new store that allows the small
+// upload parts used by the tests.
+func (s *UploadSuite) newStore(c *gc.C) *Store {
+	store := s.commonSuite.newStore(c, false)
+	store.pool.config.MinUploadPartSize = 1
+	return store
+}
+
+func (s *UploadSuite) addPart(c *gc.C, store *Store, id string, offset int64, content string) {
+	_, err := store.AddUploadPart(id, offset, strings.NewReader(content), int64(len(content)), hashOfString(content))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *UploadSuite) TestUpload(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+
+	u, err := store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Owner, gc.Equals, "bob")
+	c.Assert(u.BaseURL, jc.DeepEquals, charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(u.Size, gc.Equals, int64(0))
+
+	u, err = store.AddUploadPart(u.Id, 0, strings.NewReader("hello "), 6, hashOfString("hello "))
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Size, gc.Equals, int64(6))
+	c.Assert(u.Parts, gc.HasLen, 1)
+
+	// Sending the last part again does nothing.
+	u, err = store.AddUploadPart(u.Id, 0, strings.NewReader("hello "), 6, hashOfString("hello "))
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Size, gc.Equals, int64(6))
+	c.Assert(u.Parts, gc.HasLen, 1)
+
+	s.addPart(c, store, u.Id, 6, "world")
+
+	u, err = store.Upload(u.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Size, gc.Equals, int64(11))
+	c.Assert(u.Parts, gc.HasLen, 2)
+	r, err := store.OpenUpload(u)
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+	c.Assert(string(data), gc.Equals, "hello world")
+
+	// The parts are referenced, so they are not garbage collected.
+	result, err := store.CollectBlobGarbage(BlobGCParams{
+		GracePeriod: -time.Hour,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Orphans, gc.HasLen, 0)
+
+	err = store.RemoveUpload(u.Id)
+	c.Assert(err, gc.IsNil)
+	for _, part := range u.Parts {
+		c.Assert(blobExists(c, store, part.BlobName), gc.Equals, false)
+	}
+	_, err = store.Upload(u.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	err = store.RemoveUpload(u.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *UploadSuite) TestAddUploadPartErrors(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+
+	u, err := store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.addPart(c, store, u.Id, 0, "hello ")
+
+	_, err = store.AddUploadPart(u.Id, 3, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(err, gc.ErrorMatches, "offset 3 does not match upload size 6")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	// A different part at the offset of the last part is not allowed.
+	_, err = store.AddUploadPart(u.Id, 0, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(err, gc.ErrorMatches, "offset 0 does not match upload size 6")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	_, err = store.AddUploadPart(u.Id, 6, strings.NewReader(""), 0, hashOfString(""))
+	c.Assert(err, gc.ErrorMatches, "empty upload part")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	// A part that does not match its hash is rejected.
+	_, err = store.AddUploadPart(u.Id, 6, strings.NewReader("world"), 5, hashOfString("other"))
+	c.Assert(err, gc.ErrorMatches, "cannot put upload part: .*")
+
+	_, err = store.AddUploadPart("unknown", 0, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(err, gc.ErrorMatches, `upload "unknown" not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	u, err = store.Upload(u.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Size, gc.Equals, int64(6))
+}
+
+func (s *UploadSuite) TestUploadExpiry(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+
+	u, err := store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.addPart(c, store, u.Id, 0, "hello")
+
+	err = store.DB.Uploads().UpdateId(u.Id, bson.D{{"$set", bson.D{{"expires", time.Now().Add(-time.Minute)}}}})
+	c.Assert(err, gc.IsNil)
+	_, err = store.Upload(u.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	_, err = store.AddUploadPart(u.Id, 5, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// Adding a part extends the expiry time.
+	s.PatchValue(&uploadExpiry, time.Minute)
+	u, err = store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.PatchValue(&uploadExpiry, time.Hour)
+	s.addPart(c, store, u.Id, 0, "hello")
+	u, err = store.Upload(u.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Expires.After(time.Now().Add(59*time.Minute)), jc.IsTrue)
+}
+
+func (s *UploadSuite) TestAddUploadPartExceedsMaxArchiveSize(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+	store.pool.config.MaxArchiveSize = 10
+
+	u, err := store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.addPart(c, store, u.Id, 0, "hello ")
+	_, err = store.AddUploadPart(u.Id, 6, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(err, gc.ErrorMatches, "archive size 11 exceeds maximum 10")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrInvalidEntity)
+
+	u, err = store.Upload(u.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Parts, gc.HasLen, 1)
+}
+
+func (s *UploadSuite) TestMinUploadPartSize(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+	store.pool.config.MinUploadPartSize = 6
+
+	u, err := store.NewUpload("bob", charm.MustParseURL("cs:~bob/wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.addPart(c, store, u.Id, 0, "hello ")
+	s.addPart(c, store, u.Id, 6, "world")
+
+	// No part can be added after one smaller than the minimum.
+	_, err = store.AddUploadPart(u.Id, 11, strings.NewReader("!"), 1, hashOfString("!"))
+	c.Assert(err, gc.ErrorMatches, "previous part size 5 is smaller than minimum 6; only the last part may be smaller")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	// The last part can still be sent again.
+	u, err = store.AddUploadPart(u.Id, 6, strings.NewReader("world"), 5, hashOfString("world"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(u.Size, gc.Equals, int64(11))
+}
+
+func (s *UploadSuite) TestMaxOpenUploads(c *gc.C) {
+	store := s.newStore(c)
+	defer store.Close()
+	store.pool.config.MaxOpenUploads = 2
+
+	url := charm.MustParseURL("cs:~bob/wordpress")
+	var ids []string
+	for i := 0; i < 2; i++ {
+		u, err := store.NewUpload("bob", url)
+		c.Assert(err, gc.IsNil)
+		ids = append(ids, u.Id)
+	}
+	_, err := store.NewUpload("bob", url)
+	c.Assert(err, gc.ErrorMatches, `too many open uploads \(maximum 2\)`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrForbidden)
+
+	// Other users and administrators are not affected.
+	_, err = store.NewUpload("alice", url)
+	c.Assert(err, gc.IsNil)
+	_, err = store.NewUpload("", url)
+	c.Assert(err, gc.IsNil)
+
+	// Expired and removed uploads are not counted.
+	err = store.DB.Uploads().UpdateId(ids[0], bson.D{{"$set", bson.D{{"expires", time.Now().Add(-time.Minute)}}}})
+	c.Assert(err, gc.IsNil)
+	_, err = store.NewUpload("bob", url)
+	c.Assert(err, gc.IsNil)
+	err = store.RemoveUpload(ids[1])
+	c.Assert(err, gc.IsNil)
+	_, err = store.NewUpload("bob", url)
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,303 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+const (
+	// WebhookEventHeader holds the name of the HTTP header
+	// that holds the kind of change being notified.
+	WebhookEventHeader = "Charmstore-Event"
+
+	// WebhookSignatureHeader holds the name of the HTTP header
+	// that holds the signature of a webhook payload. The signature
+	// is of the form "sha256=hex" where hex is the hexadecimal
+	// HMAC-SHA256 of the payload keyed with the webhook secret.
+	WebhookSignatureHeader = "Charmstore-Signature"
+)
+
+var (
+	// webhookMaxAttempts holds the number of times the delivery of
+	// a webhook notification is attempted before giving up.
+	webhookMaxAttempts = 5
+
+	// webhookRetryDelay holds the time to wait before retrying a
+	// failed delivery. It doubles after each failed attempt.
+	webhookRetryDelay = 5 * time.Second
+
+	// webhookTimeout holds the maximum time a single delivery
+	// attempt may take.
+	webhookTimeout = 30 * time.Second
+
+	// webhookAddressAllowed reports whether webhook notifications
+	// may be delivered to the given address. It is a variable so
+	// that tests can deliver notifications to local servers.
+	webhookAddressAllowed = IsPublicAddress
+)
+
+// errWebhookAddressNotAllowed is the error cause used when a webhook
+// host resolves to an address that notifications are not delivered to.
+var errWebhookAddressNotAllowed = errgo.New("webhook address not allowed")
+
+// nonPublicNetworks holds the networks that are not publicly routable:
+// unspecified, loopback, link-local, private, shared, multicast and
+// reserved addresses.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// IsPublicAddress reports whether the given IP address is publicly
+// routable. Webhook notifications are never delivered to loopback,
+// link-local, private or otherwise reserved addresses, so that
+// webhooks cannot be used to reach services inside the network
+// of the charm store.
+func IsPublicAddress(ip net.IP) bool {
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialWebhook dials the given webhook address, refusing to connect
+// when the host resolves to an address that is not allowed. The check
+// is made at delivery time on the resolved address, so that it also
+// applies to redirects and to host names whose DNS records change
+// after the webhook has been registered.
+func dialWebhook(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(ips) == 0 {
+		return nil, errgo.Newf("no addresses found for %q", host)
+	}
+	for _, ip := range ips {
+		if !webhookAddressAllowed(ip) {
+			return nil, errgo.WithCausef(nil, errWebhookAddressNotAllowed, "webhook host %q resolves to non-public address %s", host, ip)
+		}
+	}
+	dialer := net.Dialer{
+		Timeout: webhookTimeout,
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.Dial(network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, errgo.Mask(err)
+}
+
+// WebhookPayload holds the JSON payload that is POSTed to
+// the webhooks of a base entity when one of its entities changes.
+type WebhookPayload struct {
+	// Kind holds the kind of change: one of "publish",
+	// "upload", "promulgate" or "unpromulgate".
+	Kind string
+
+	// Id holds the id of the entity that changed. For
+	// promulgation changes, this holds the base entity id.
+	Id *charm.URL
+
+	// Channels holds the channels affected by the change, if any.
+	Channels []params.Channel `json:",omitempty"`
+
+	// Time holds the time of the change.
+	Time time.Time
+}
+
+// SignWebhookPayload returns the value of the WebhookSignatureHeader
+// header for the given payload signed with the given secret.
+func SignWebhookPayload(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyWebhooks sends a notification of the given kind of change to
+// the entity with the given URL to all the webhooks registered on its
+// base entity. Notifications are delivered in the background, so
+// failures do not affect the operation that caused the change.
+func (s *Store) notifyWebhooks(kind mongodoc.EventKind, url *charm.URL, channels []params.Channel) {
+	baseEntity, err := s.FindBaseEntity(url, FieldSelector("webhooks"))
+	if err != nil {
+		logger.Errorf("cannot retrieve webhooks for %q: %v", url, err)
+		return
+	}
+	if len(baseEntity.Webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(WebhookPayload{
+		Kind:     string(kind),
+		Id:       url,
+		Channels: channels,
+		Time:     time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Errorf("cannot marshal webhook payload: %v", err)
+		return
+	}
+	for _, hook := range baseEntity.Webhooks {
+		s.pool.webhooks.send(hook, string(kind), body)
+	}
+}
+
+// webhookNotifier delivers webhook notifications in the background.
+type webhookNotifier struct {
+	client *http.Client
+
+	// closing is closed when the notifier is closed,
+	// to abandon any pending retries.
+	closing chan struct{}
+
+	// wg is used to wait for outstanding deliveries.
+	wg sync.WaitGroup
+}
+
+func newWebhookNotifier() *webhookNotifier {
+	return &webhookNotifier{
+		client: &http.Client{
+			// Note that the transport does not use any proxy
+			// from the environment, as that would bypass the
+			// address checks made by dialWebhook.
+			Transport: &http.Transport{
+				Dial:                dialWebhook,
+				TLSHandshakeTimeout: webhookTimeout,
+			},
+			Timeout: webhookTimeout,
+		},
+		closing: make(chan struct{}),
+	}
+}
+
+// send starts the delivery of the given payload to the given webhook.
+func (n *webhookNotifier) send(hook mongodoc.Webhook, kind string, body []byte) {
+	select {
+	case <-n.closing:
+		logger.Errorf("cannot notify webhook %q: notifier has been closed", hook.URL)
+		return
+	default:
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(hook, kind, body)
+	}()
+}
+
+// close abandons any pending retries and waits for
+// the outstanding deliveries to complete.
+func (n *webhookNotifier) close() {
+	close(n.closing)
+	n.wg.Wait()
+}
+
+// deliver POSTs the given payload to the given webhook, retrying
+// with an exponential backoff when the delivery fails.
+func (n *webhookNotifier) deliver(hook mongodoc.Webhook, kind string, body []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(hook, kind, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= webhookMaxAttempts {
+			logger.Errorf("cannot notify webhook %q after %d attempt(s): %v", hook.URL, attempt, err)
+			return
+		}
+		logger.Infof("cannot notify webhook %q (attempt %d), retrying in %v: %v", hook.URL, attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-n.closing:
+			return
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single attempt at delivering the given payload to the
+// given webhook. It reports whether a failed delivery should be retried.
+// Client errors returned by the webhook and deliveries to addresses that
+// are not allowed are not retried.
+func (n *webhookNotifier) post(hook mongodoc.Webhook, kind string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, kind)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(body, hook.Secret))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok && errgo.Cause(uerr.Err) == errWebhookAddressNotAllowed {
+			return false, errgo.Mask(err)
+		}
+		return true, errgo.Mask(err)
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return false, errgo.Newf("webhook returned status %q", resp.Status)
+	}
+	return true, errgo.Newf("webhook returned status %q", resp.Status)
+}
@@ -0,0 +1,307 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type WebhookSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&WebhookSuite{})
+
+func (s *WebhookSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&webhookRetryDelay, time.Millisecond)
+	// The test servers listen on the loopback address.
+	s.PatchValue(&webhookAddressAllowed, func(net.IP) bool { return true })
+}
+
+// webhookRequest holds a request received by a webhookServer.
+type webhookRequest struct {
+	event     string
+	signature string
+	payload   WebhookPayload
+	body      []byte
+}
+
+// webhookServer is an HTTP server that records the webhook
+// notifications it receives. It responds to the first failures
+// requests with the given status code.
+type webhookServer struct {
+	*httptest.Server
+	failures   int
+	failStatus int
+
+	mu       sync.Mutex
+	attempts int
+	received chan webhookRequest
+}
+
+func newWebhookServer(failures, failStatus int) *webhookServer {
+	srv := &webhookServer{
+		failures:   failures,
+		failStatus: failStatus,
+		received:   make(chan webhookRequest, 10),
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.serveHTTP))
+	return srv
+}
+
+func (srv *webhookServer) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	srv.mu.Lock()
+	srv.attempts++
+	fail := srv.attempts <= srv.failures
+	srv.mu.Unlock()
+	if fail {
+		w.WriteHeader(srv.failStatus)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		panic(err)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		panic(err)
+	}
+	srv.received <- webhookRequest{
+		event:     req.Header.Get(WebhookEventHeader),
+		signature: req.Header.Get(WebhookSignatureHeader),
+		payload:   payload,
+		body:      body,
+	}
+}
+
+func (srv *webhookServer) numAttempts() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.attempts
+}
+
+func (s *WebhookSuite) newPool(c *gc.C) *Pool {
+	p, err := NewPool(s.Session.DB("juju_test"), nil, nil, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	return p
+}
+
+func (s *WebhookSuite) setWebhooks(c *gc.C, store *Store, id *router.ResolvedURL, hooks ...mongodoc.Webhook) {
+	err := store.UpdateBaseEntity(id, bson.D{{"$set", bson.D{{"webhooks", hooks}}}})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *WebhookSuite) TestNotifications(c *gc.C) {
+	srv := newWebhookServer(0, 0)
+	defer srv.Close()
+	p := s.newPool(c)
+	defer p.Close()
+	store := p.Store()
+	defer store.Close()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{
+		URL:    srv.URL,
+		Secret: "secret",
+	})
+
+	beforeNotify := time.Now().Add(-time.Second)
+	err = store.Publish(id, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	r := s.assertReceived(c, srv)
+	c.Assert(r.event, gc.Equals, "publish")
+	c.Assert(r.signature, gc.Equals, SignWebhookPayload(r.body, "secret"))
+	c.Assert(r.payload.Time, jc.TimeBetween(beforeNotify, time.Now().Add(time.Second)))
+	r.payload.Time = time.Time{}
+	c.Assert(r.payload, jc.DeepEquals, WebhookPayload{
+		Kind:     "publish",
+		Id:       &id.URL,
+		Channels: []params.Channel{params.StableChannel},
+	})
+
+	err = store.SetPromulgated(id, true)
+	c.Assert(err, gc.IsNil)
+	r = s.assertReceived(c, srv)
+	c.Assert(r.event, gc.Equals, "promulgate")
+	c.Assert(r.payload.Id, jc.DeepEquals, charm.MustParseURL("~charmers/wordpress"))
+
+	id1 := router.MustNewResolvedURL("~charmers/precise/wordpress-1", -1)
+	err = store.AddCharmWithArchive(id1, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	r = s.assertReceived(c, srv)
+	c.Assert(r.event, gc.Equals, "upload")
+	c.Assert(r.payload.Id, jc.DeepEquals, &id1.URL)
+
+	// Changes to the permissions are not notified.
+	err = store.SetPerms(&id.URL, "stable.read", params.Everyone)
+	c.Assert(err, gc.IsNil)
+	s.assertNotReceived(c, srv)
+}
+
+func (s *WebhookSuite) TestNotificationWithoutSecretIsNotSigned(c *gc.C) {
+	srv := newWebhookServer(0, 0)
+	defer srv.Close()
+	p := s.newPool(c)
+	defer p.Close()
+	store := p.Store()
+	defer store.Close()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{URL: srv.URL})
+
+	err = store.Publish(id, nil, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+	r := s.assertReceived(c, srv)
+	c.Assert(r.signature, gc.Equals, "")
+}
+
+func (s *WebhookSuite) TestNotificationRetries(c *gc.C) {
+	srv := newWebhookServer(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+	p := s.newPool(c)
+	defer p.Close()
+	store := p.Store()
+	defer store.Close()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{URL: srv.URL})
+
+	err = store.Publish(id, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	r := s.assertReceived(c, srv)
+	c.Assert(r.event, gc.Equals, "publish")
+	c.Assert(srv.numAttempts(), gc.Equals, 3)
+}
+
+func (s *WebhookSuite) TestNotificationGivesUp(c *gc.C) {
+	srv := newWebhookServer(100, http.StatusInternalServerError)
+	defer srv.Close()
+	p := s.newPool(c)
+	store := p.Store()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{URL: srv.URL})
+
+	err = store.Publish(id, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	store.Close()
+
+	// Closing the pool waits for the delivery to finish.
+	p.Close()
+	c.Assert(srv.numAttempts(), gc.Equals, webhookMaxAttempts)
+}
+
+func (s *WebhookSuite) TestNotificationClientErrorIsNotRetried(c *gc.C) {
+	srv := newWebhookServer(100, http.StatusNotFound)
+	defer srv.Close()
+	p := s.newPool(c)
+	store := p.Store()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{URL: srv.URL})
+
+	err = store.Publish(id, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	store.Close()
+	p.Close()
+	c.Assert(srv.numAttempts(), gc.Equals, 1)
+}
+
+func (s *WebhookSuite) TestNotificationToNonPublicAddressIsRefused(c *gc.C) {
+	s.PatchValue(&webhookAddressAllowed, IsPublicAddress)
+	srv := newWebhookServer(0, 0)
+	defer srv.Close()
+	p := s.newPool(c)
+	store := p.Store()
+
+	id := router.MustNewResolvedURL("~charmers/precise/wordpress-0", -1)
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	s.setWebhooks(c, store, id, mongodoc.Webhook{URL: srv.URL})
+
+	err = store.Publish(id, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	store.Close()
+	p.Close()
+	c.Assert(srv.numAttempts(), gc.Equals, 0)
+}
+
+var isPublicAddressTests = []struct {
+	addr   string
+	expect bool
+}{
+	{"8.8.8.8", true},
+	{"91.189.92.150", true},
+	{"2001:4860:4860::8888", true},
+	{"0.0.0.0", false},
+	{"127.0.0.1", false},
+	{"10.1.2.3", false},
+	{"100.64.0.1", false},
+	{"169.254.169.254", false},
+	{"172.16.0.1", false},
+	{"172.31.255.255", false},
+	{"192.168.1.1", false},
+	{"224.0.0.1", false},
+	{"255.255.255.255", false},
+	{"::", false},
+	{"::1", false},
+	{"::ffff:127.0.0.1", false},
+	{"fd00::1", false},
+	{"fe80::1", false},
+	{"ff02::1", false},
+}
+
+func (s *WebhookSuite) TestIsPublicAddress(c *gc.C) {
+	for i, test := range isPublicAddressTests {
+		c.Logf("test %d: %s", i, test.addr)
+		ip := net.ParseIP(test.addr)
+		c.Assert(ip, gc.NotNil)
+		c.Assert(IsPublicAddress(ip), gc.Equals, test.expect)
+	}
+}
+
+func (s *WebhookSuite) assertReceived(c *gc.C, srv *webhookServer) webhookRequest {
+	select {
+	case r := <-srv.received:
+		return r
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for webhook notification")
+	}
+	panic("unreachable")
+}
+
+func (s *WebhookSuite) assertNotReceived(c *gc.C, srv *webhookServer) {
+	select {
+	case r := <-srv.received:
+		c.Fatalf("unexpected webhook notification %#v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -54,7 +54,8 @@
 // series and name, e.g. `/stats/counter/charm-bundle:trusty:juju-gui`.
 //
 // The results can be grouped by specifying the `by` query (possible values are
-// `day` and `week`), and time delimited using the `start` and `stop` queries.
+// `day`, `week` and `month`), and time delimited using the `start` and `stop`
+// queries. Passing `format=csv` returns the results as CSV rather than JSON.
 //
 // It is also possible to list the results by passing `list=1`. For example, a GET
 // call to `/stats/counter/charm-bundle:trusty:*?by=day&list=1` returns an
@@ -409,7 +409,7 @@ func (s *APISuite) addPublicCharm(c *gc.C, charmName, curl string) (*router.Reso
 func (s *APISuite) setPublic(c *gc.C, rurl *router.ResolvedURL) {
 	err := s.store.SetPerms(&rurl.URL, "stable.read", params.Everyone)
 	c.Assert(err, gc.IsNil)
-	err = s.store.Publish(rurl, params.StableChannel)
+	err = s.store.Publish(rurl, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 }
 
@@ -0,0 +1,737 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The mirror package replicates the entities held in one charm store
+// into another. Entities and resource revisions uploaded to the source
+// store are uploaded into the destination store, so that the usual
+// validation applies to the copied content. The published state of
+// the entities and the permissions, channel pointers and promulgation
+// state of the base entities are then brought in line with the source
+// store, whether or not anything new was uploaded. Where the store
+// provides an operation for a change, such as publishing or setting
+// permissions, it is used so that the destination store records the
+// usual events and audit entries and notifies its webhooks.
+package mirror // import "gopkg.in/juju/charmstore.v5-unstable/internal/mirror"
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+var logger = loggo.GetLogger("charmstore.internal.mirror")
+
+// auditUser holds the user recorded in the audit entries
+// for the changes made to the destination store.
+const auditUser = "admin"
+
+// Params holds the parameters for Run.
+type Params struct {
+	// Source holds the store to copy from.
+	Source *charmstore.Store
+
+	// Dest holds the store to copy to.
+	Dest *charmstore.Store
+
+	// Since holds the time after which entities must have been
+	// uploaded to be copied. The state of the base entities is
+	// synchronised regardless of it.
+	Since time.Time
+
+	// ResourcesSince holds the time after which resource revisions
+	// must have been uploaded to be copied. The resource revisions
+	// of the base entities whose entities are copied in the run are
+	// copied regardless of it.
+	ResourcesSince time.Time
+
+	// Owner, if not empty, restricts mirroring to the
+	// entities owned by the given user.
+	Owner string
+
+	// Series, if not empty, restricts mirroring to the entities
+	// supporting the given series, to the resources declared by
+	// those entities and to the channel pointers for that series.
+	Series string
+
+	// DryRun specifies that the changes that would be made to
+	// the destination store should be logged but not made.
+	DryRun bool
+}
+
+// Result holds the result of a mirroring run.
+type Result struct {
+	// Entities holds the number of entities uploaded.
+	Entities int
+
+	// Resources holds the number of resource revisions copied.
+	Resources int
+
+	// BaseEntities holds the number of base entities whose
+	// state was updated.
+	BaseEntities int
+
+	// LastUploadTime holds the latest upload time of the copied
+	// entities, or Since if none was copied. It can be used as
+	// Since in the next run.
+	LastUploadTime time.Time
+
+	// LastResourceUploadTime holds the latest upload time of the
+	// copied resource revisions, or ResourcesSince if none was
+	// copied. It can be used as ResourcesSince in the next run.
+	LastResourceUploadTime time.Time
+}
+
+// Run mirrors the source store into the destination store as
+// specified by p. Entities are copied in upload order, followed by
+// resource revisions, so that the state of the base entities, which
+// is synchronised last, can refer to anything copied in the run.
+func Run(p Params) (*Result, error) {
+	m := &mirror{
+		p: p,
+		result: Result{
+			LastUploadTime:         p.Since,
+			LastResourceUploadTime: p.ResourcesSince,
+		},
+		uploadedBaseURLs: make(map[charm.URL]bool),
+	}
+	if err := m.mirrorEntities(); err != nil {
+		return &m.result, errgo.Mask(err)
+	}
+	if err := m.mirrorResources(); err != nil {
+		return &m.result, errgo.Mask(err)
+	}
+	if err := m.syncBaseEntities(); err != nil {
+		return &m.result, errgo.Mask(err)
+	}
+	return &m.result, nil
+}
+
+// mirror holds the state of a mirroring run.
+type mirror struct {
+	p      Params
+	result Result
+
+	// uploadedBaseURLs holds the base URLs of
+	// the entities uploaded in the run.
+	uploadedBaseURLs map[charm.URL]bool
+}
+
+// mirrorEntities uploads the source entities uploaded
+// since m.p.Since to the destination store.
+func (m *mirror) mirrorEntities() error {
+	query := bson.D{{"uploadtime", bson.D{{"$gt", m.p.Since}}}}
+	if m.p.Owner != "" {
+		query = append(query, bson.DocElem{"user", m.p.Owner})
+	}
+	if m.p.Series != "" {
+		query = append(query, bson.DocElem{"supportedseries", m.p.Series})
+	}
+	iter := m.p.Source.DB.Entities().Find(query).Sort("uploadtime", "_id").Select(charmstore.FieldSelector(
+		"promulgated-url",
+		"baseurl",
+		"uploadtime",
+		"blobhash",
+		"size",
+	)).Iter()
+	defer iter.Close()
+	for {
+		var entity mongodoc.Entity
+		if !iter.Next(&entity) {
+			break
+		}
+		id := charmstore.EntityResolvedURL(&entity)
+		if m.p.DryRun {
+			logger.Infof("would mirror %v", id)
+			continue
+		}
+		if err := m.mirrorEntity(id, &entity); err != nil {
+			return errgo.Notef(err, "cannot mirror %v", id)
+		}
+		if entity.UploadTime.After(m.result.LastUploadTime) {
+			m.result.LastUploadTime = entity.UploadTime
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate entities")
+	}
+	return nil
+}
+
+// mirrorEntity uploads the archive of the given source entity to the
+// destination store, unless it is already there.
+func (m *mirror) mirrorEntity(id *router.ResolvedURL, entity *mongodoc.Entity) error {
+	blob, err := m.p.Source.OpenBlob(id)
+	if err != nil {
+		return errgo.Notef(err, "cannot open archive")
+	}
+	defer blob.Close()
+	err = m.p.Dest.UploadEntity(id, blob, entity.BlobHash, entity.Size, nil)
+	switch errgo.Cause(err) {
+	case nil:
+		logger.Infof("uploaded %v", id)
+		m.result.Entities++
+		m.uploadedBaseURLs[*entity.BaseURL] = true
+	case params.ErrDuplicateUpload:
+		logger.Debugf("%v already exists in destination store", id)
+	default:
+		return errgo.Notef(err, "cannot upload archive")
+	}
+	return nil
+}
+
+// mirrorResources copies the source resource revisions uploaded since
+// m.p.ResourcesSince, and those of the base entities whose entities
+// were uploaded in the run, to the destination store. Revisions of
+// resources whose base entity does not exist in the destination store
+// are not copied.
+func (m *mirror) mirrorResources() error {
+	query := bson.D{{"uploadtime", bson.D{{"$gt", m.p.ResourcesSince}}}}
+	if len(m.uploadedBaseURLs) > 0 {
+		baseURLs := make([]*charm.URL, 0, len(m.uploadedBaseURLs))
+		for u := range m.uploadedBaseURLs {
+			u := u
+			baseURLs = append(baseURLs, &u)
+		}
+		query = bson.D{{"$or", []bson.D{
+			query,
+			{{"baseurl", bson.D{{"$in", baseURLs}}}},
+		}}}
+	}
+	declared, err := m.declaredResources()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if declared != nil {
+		baseURLs := make([]*charm.URL, 0, len(declared))
+		for u := range declared {
+			u := u
+			baseURLs = append(baseURLs, &u)
+		}
+		query = append(query, bson.DocElem{"baseurl", bson.D{{"$in", baseURLs}}})
+	} else if m.p.Owner != "" {
+		query = append(query, bson.DocElem{"baseurl", bson.RegEx{
+			Pattern: "^" + regexp.QuoteMeta("cs:~"+m.p.Owner+"/"),
+		}})
+	}
+	iter := m.p.Source.DB.Resources().Find(query).Sort("uploadtime", "_id").Iter()
+	defer iter.Close()
+	for {
+		var res mongodoc.Resource
+		if !iter.Next(&res) {
+			break
+		}
+		if declared != nil && !declared[*res.BaseURL][res.Name] {
+			logger.Debugf("not mirroring resource %q revision %d of %v: not declared by a mirrored charm", res.Name, res.Revision, res.BaseURL)
+			continue
+		}
+		if err := m.mirrorResource(&res); err != nil {
+			return errgo.Notef(err, "cannot mirror resource %q revision %d of %v", res.Name, res.Revision, res.BaseURL)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate resources")
+	}
+	return nil
+}
+
+// declaredResources returns the names of the resources declared by
+// the source charms selected by m.p.Owner and m.p.Series, keyed by
+// base URL. It returns nil when m.p.Series is empty, as the resources
+// of all the selected base entities are then mirrored.
+func (m *mirror) declaredResources() (map[charm.URL]map[string]bool, error) {
+	if m.p.Series == "" {
+		return nil, nil
+	}
+	query := bson.D{
+		{"supportedseries", m.p.Series},
+		{"charmmeta", bson.D{{"$exists", true}}},
+	}
+	if m.p.Owner != "" {
+		query = append(query, bson.DocElem{"user", m.p.Owner})
+	}
+	iter := m.p.Source.DB.Entities().Find(query).Select(charmstore.FieldSelector("baseurl", "charmmeta")).Iter()
+	defer iter.Close()
+	declared := make(map[charm.URL]map[string]bool)
+	for {
+		var entity mongodoc.Entity
+		if !iter.Next(&entity) {
+			break
+		}
+		names := declared[*entity.BaseURL]
+		if names == nil {
+			names = make(map[string]bool)
+			declared[*entity.BaseURL] = names
+		}
+		for name := range entity.CharmMeta.Resources {
+			names[name] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate entities")
+	}
+	return declared, nil
+}
+
+// mirrorResource copies the given source resource revision to the
+// destination store, unless it is already there.
+func (m *mirror) mirrorResource(res *mongodoc.Resource) error {
+	n, err := m.p.Dest.DB.BaseEntities().FindId(res.BaseURL).Count()
+	if err != nil {
+		return errgo.Notef(err, "cannot check for %v in destination store", res.BaseURL)
+	}
+	if n == 0 {
+		logger.Debugf("not mirroring resource %q revision %d: %v not mirrored", res.Name, res.Revision, res.BaseURL)
+		return nil
+	}
+	n, err = m.p.Dest.DB.Resources().Find(bson.D{
+		{"baseurl", res.BaseURL},
+		{"name", res.Name},
+		{"revision", res.Revision},
+	}).Count()
+	if err != nil {
+		return errgo.Notef(err, "cannot check for resource in destination store")
+	}
+	if n > 0 {
+		logger.Debugf("resource %q revision %d of %v already exists in destination store", res.Name, res.Revision, res.BaseURL)
+		return nil
+	}
+	if m.p.DryRun {
+		logger.Infof("would mirror resource %q revision %d of %v", res.Name, res.Revision, res.BaseURL)
+		return nil
+	}
+	blob, err := m.p.Source.OpenResourceBlob(res)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer blob.Close()
+	if err := m.p.Dest.AddResourceRevision(res, blob); err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Infof("uploaded resource %q revision %d of %v", res.Name, res.Revision, res.BaseURL)
+	m.result.Resources++
+	if res.UploadTime.After(m.result.LastResourceUploadTime) {
+		m.result.LastResourceUploadTime = res.UploadTime
+	}
+	return nil
+}
+
+// syncBaseEntities brings the state of every base entity in the
+// destination store, and of its entities, in line with the source
+// store. Base entities that do not exist in the destination store
+// are ignored.
+func (m *mirror) syncBaseEntities() error {
+	var query bson.D
+	if m.p.Owner != "" {
+		query = bson.D{{"user", m.p.Owner}}
+	}
+	iter := m.p.Source.DB.BaseEntities().Find(query).Sort("_id").Iter()
+	defer iter.Close()
+	for {
+		var baseEntity mongodoc.BaseEntity
+		if !iter.Next(&baseEntity) {
+			break
+		}
+		dstBaseEntity, err := m.p.Dest.FindBaseEntity(baseEntity.URL, nil)
+		if errgo.Cause(err) == params.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		entitiesChanged, err := m.syncEntities(baseEntity.URL)
+		if err != nil {
+			return errgo.Notef(err, "cannot synchronise entities of %v", baseEntity.URL)
+		}
+		baseEntityChanged, err := m.syncBaseEntity(&baseEntity, dstBaseEntity)
+		if err != nil {
+			return errgo.Notef(err, "cannot synchronise base entity %v", baseEntity.URL)
+		}
+		if !entitiesChanged && !baseEntityChanged {
+			continue
+		}
+		m.result.BaseEntities++
+		if m.p.DryRun {
+			continue
+		}
+		if err := m.p.Dest.UpdateSearchBaseURL(baseEntity.URL); err != nil && errgo.Cause(err) != params.ErrNotFound {
+			return errgo.Notef(err, "cannot update search index for %v", baseEntity.URL)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate base entities")
+	}
+	return nil
+}
+
+// entityStateFields holds the entity fields that are synchronised
+// for existing entities.
+var entityStateFields = charmstore.FieldSelector(
+	"promulgated-url",
+	"development",
+	"stable",
+	"extrainfo",
+	"channelresources",
+)
+
+// publishChannels holds the channels that entities can be
+// published to, in the order they are synchronised.
+var publishChannels = []params.Channel{
+	params.DevelopmentChannel,
+	params.StableChannel,
+}
+
+// syncEntities brings the published state and extra information of
+// the entities with the given base URL in the destination store in
+// line with the source store, and reports whether anything was
+// changed. The entities are synchronised in upload order so that
+// the channel pointers set when publishing end up referring to the
+// latest published entities, as they do in the source store.
+func (m *mirror) syncEntities(baseURL *charm.URL) (bool, error) {
+	var srcEntities, dstEntities []*mongodoc.Entity
+	if err := m.p.Source.DB.Entities().Find(bson.D{{"baseurl", baseURL}}).Sort("uploadtime", "_id").Select(entityStateFields).All(&srcEntities); err != nil {
+		return false, errgo.Notef(err, "cannot get source entities")
+	}
+	if err := m.p.Dest.DB.Entities().Find(bson.D{{"baseurl", baseURL}}).Select(entityStateFields).All(&dstEntities); err != nil {
+		return false, errgo.Notef(err, "cannot get destination entities")
+	}
+	dst := make(map[string]*mongodoc.Entity, len(dstEntities))
+	for _, e := range dstEntities {
+		dst[e.URL.String()] = e
+	}
+	changed := false
+	for _, e := range srcEntities {
+		d := dst[e.URL.String()]
+		if d == nil {
+			continue
+		}
+		entityChanged, err := m.syncEntity(e, d)
+		if err != nil {
+			return false, errgo.Notef(err, "cannot synchronise %v", e.URL)
+		}
+		changed = changed || entityChanged
+	}
+	return changed, nil
+}
+
+// syncEntity brings the destination entity d in line with the source
+// entity e, and reports whether anything was changed. Entities are
+// published with Store.Publish so that the destination store records
+// the usual events and notifies its webhooks.
+func (m *mirror) syncEntity(e, d *mongodoc.Entity) (bool, error) {
+	var publish []params.Channel
+	var unpublish bson.D
+	for _, ch := range publishChannels {
+		switch {
+		case !published(e, ch):
+			if published(d, ch) {
+				unpublish = append(unpublish, bson.DocElem{string(ch), false})
+			}
+		case !published(d, ch):
+			publish = append(publish, ch)
+		case !equalOrEmpty(e.ChannelResources[ch], d.ChannelResources[ch]):
+			ok, err := m.resourcesExist(e.URL, e.ChannelResources[ch])
+			if err != nil {
+				return false, errgo.Mask(err)
+			}
+			if ok {
+				publish = append(publish, ch)
+			}
+		}
+	}
+	keys := changedKeys(e.ExtraInfo, d.ExtraInfo)
+	if len(publish) == 0 && len(unpublish) == 0 && len(keys) == 0 {
+		return false, nil
+	}
+	if m.p.DryRun {
+		logger.Infof("would update %v", e.URL)
+		return true, nil
+	}
+	id := charmstore.EntityResolvedURL(e)
+	for _, ch := range publish {
+		resources, err := m.publishedResources(e, ch)
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		if err := m.p.Dest.Publish(id, resources, ch); err != nil {
+			return false, errgo.Notef(err, "cannot publish to %s", ch)
+		}
+		m.p.Dest.AddAudit(audit.Entry{
+			User:      auditUser,
+			Op:        audit.OpPublish,
+			Entity:    &id.URL,
+			Channels:  []params.Channel{ch},
+			Resources: resources,
+		})
+	}
+	// The store has no operation to remove an entity from a channel
+	// or to replace its extra information, so those fields are set
+	// directly.
+	set := unpublish
+	if len(keys) > 0 {
+		set = append(set, bson.DocElem{"extrainfo", e.ExtraInfo})
+	}
+	if len(set) > 0 {
+		if err := m.p.Dest.UpdateEntity(id, bson.D{{"$set", set}}); err != nil {
+			return false, errgo.Mask(err)
+		}
+	}
+	for _, key := range keys {
+		m.p.Dest.AddAudit(audit.Entry{
+			User:   auditUser,
+			Op:     audit.OpSetExtraInfo,
+			Entity: &id.URL,
+			Key:    key,
+		})
+	}
+	logger.Infof("updated %v", e.URL)
+	return true, nil
+}
+
+// publishedResources returns the revisions of the resources published
+// with the source entity e in the given channel, keyed by resource
+// name. Resources are only included when all of them have been
+// mirrored to the destination store.
+func (m *mirror) publishedResources(e *mongodoc.Entity, ch params.Channel) (map[string]int, error) {
+	revisions := e.ChannelResources[ch]
+	if len(revisions) == 0 {
+		return nil, nil
+	}
+	ok, err := m.resourcesExist(e.URL, revisions)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	resources := make(map[string]int, len(revisions))
+	for _, r := range revisions {
+		resources[r.Name] = r.Revision
+	}
+	return resources, nil
+}
+
+// published reports whether the entity e is
+// published in the given channel.
+func published(e *mongodoc.Entity, ch params.Channel) bool {
+	switch ch {
+	case params.DevelopmentChannel:
+		return e.Development
+	case params.StableChannel:
+		return e.Stable
+	}
+	return false
+}
+
+// resourcesExist reports whether all the given resource revisions of
+// the entity with the given URL exist in the destination store.
+func (m *mirror) resourcesExist(url *charm.URL, revisions []mongodoc.ResourceRevision) (bool, error) {
+	for _, r := range revisions {
+		n, err := m.p.Dest.DB.Resources().Find(bson.D{
+			{"baseurl", mongodoc.BaseURL(url)},
+			{"name", r.Name},
+			{"revision", r.Revision},
+		}).Count()
+		if err != nil {
+			return false, errgo.Notef(err, "cannot check for resource in destination store")
+		}
+		if n == 0 {
+			logger.Debugf("not updating published resources of %v: resource %q revision %d not mirrored", url, r.Name, r.Revision)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// aclChannels holds the channels whose
+// ACLs are synchronised.
+var aclChannels = []params.Channel{
+	params.UnpublishedChannel,
+	params.DevelopmentChannel,
+	params.StableChannel,
+}
+
+// syncBaseEntity brings the destination base entity d in line with
+// the source base entity b, and reports whether anything was changed.
+// Permissions and promulgation are changed with Store.SetPerms and
+// Store.SetPromulgated so that the destination store records the
+// usual events. Channel pointers are only copied when the entity they
+// refer to exists in the destination store.
+func (m *mirror) syncBaseEntity(b, d *mongodoc.BaseEntity) (bool, error) {
+	type aclChange struct {
+		which string
+		acl   []string
+		entry *audit.ACL
+	}
+	var aclChanges []aclChange
+	for _, ch := range aclChannels {
+		src, dst := b.ChannelACLs[ch], d.ChannelACLs[ch]
+		if !equalOrEmpty(src.Read, dst.Read) {
+			aclChanges = append(aclChanges, aclChange{string(ch) + ".read", src.Read, &audit.ACL{Read: src.Read}})
+		}
+		if !equalOrEmpty(src.Write, dst.Write) {
+			aclChanges = append(aclChanges, aclChange{string(ch) + ".write", src.Write, &audit.ACL{Write: src.Write}})
+		}
+	}
+	var set, unset bson.D
+	keys := changedKeys(b.CommonInfo, d.CommonInfo)
+	if len(keys) > 0 {
+		set = append(set, bson.DocElem{"commoninfo", b.CommonInfo})
+	}
+	for _, ch := range channels(b.ChannelEntities, d.ChannelEntities) {
+		for _, s := range seriesNames(b.ChannelEntities[ch], d.ChannelEntities[ch]) {
+			if m.p.Series != "" && s != m.p.Series {
+				continue
+			}
+			field := fmt.Sprintf("channelentities.%s.%s", ch, s)
+			src, dst := b.ChannelEntities[ch][s], d.ChannelEntities[ch][s]
+			switch {
+			case src == nil:
+				unset = append(unset, bson.DocElem{field, ""})
+			case dst != nil && *src == *dst:
+			default:
+				n, err := m.p.Dest.DB.Entities().FindId(src).Count()
+				if err != nil {
+					return false, errgo.Notef(err, "cannot check for %v in destination store", src)
+				}
+				if n == 0 {
+					logger.Debugf("not pointing %v %s/%s at %v: entity not mirrored", b.URL, ch, s, src)
+					continue
+				}
+				set = append(set, bson.DocElem{field, src})
+			}
+		}
+	}
+	promulgate := b.Promulgated != d.Promulgated
+	if len(aclChanges) == 0 && len(set) == 0 && len(unset) == 0 && !promulgate {
+		return false, nil
+	}
+	if m.p.DryRun {
+		logger.Infof("would update base entity %v", b.URL)
+		return true, nil
+	}
+	id := &router.ResolvedURL{
+		URL:                 *b.URL,
+		PromulgatedRevision: -1,
+	}
+	for _, change := range aclChanges {
+		if err := m.p.Dest.SetPerms(b.URL, change.which, change.acl...); err != nil {
+			return false, errgo.Notef(err, "cannot set %s permissions", change.which)
+		}
+		m.p.Dest.AddAudit(audit.Entry{
+			User:   auditUser,
+			Op:     audit.OpSetPerm,
+			Entity: b.URL,
+			ACL:    change.entry,
+		})
+	}
+	// The channel pointers are normally set when the entities are
+	// published, and the store has no operation to set the others
+	// or the common information, so those fields are set directly.
+	var update bson.D
+	if len(set) > 0 {
+		update = append(update, bson.DocElem{"$set", set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.DocElem{"$unset", unset})
+	}
+	if len(update) > 0 {
+		if err := m.p.Dest.UpdateBaseEntity(id, update); err != nil {
+			return false, errgo.Mask(err)
+		}
+	}
+	for _, key := range keys {
+		m.p.Dest.AddAudit(audit.Entry{
+			User:   auditUser,
+			Op:     audit.OpSetCommonInfo,
+			Entity: b.URL,
+			Key:    key,
+		})
+	}
+	if promulgate {
+		if err := m.p.Dest.SetPromulgated(id, bool(b.Promulgated)); err != nil {
+			return false, errgo.Notef(err, "cannot set promulgation state")
+		}
+		op := audit.OpUnpromulgate
+		if b.Promulgated {
+			op = audit.OpPromulgate
+		}
+		m.p.Dest.AddAudit(audit.Entry{
+			User:   auditUser,
+			Op:     op,
+			Entity: b.URL,
+		})
+	}
+	logger.Infof("updated base entity %v", b.URL)
+	return true, nil
+}
+
+// changedKeys returns the keys whose values differ
+// between the maps a and b, in sorted order.
+func changedKeys(a, b map[string][]byte) []string {
+	var keys []string
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// equalOrEmpty reports whether the maps or slices a and b are deeply
+// equal, treating nil and empty values as equal.
+func equalOrEmpty(a, b interface{}) bool {
+	if reflect.ValueOf(a).Len() == 0 && reflect.ValueOf(b).Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// channels returns the channels present in either of the given
+// channel entity maps.
+func channels(a, b map[params.Channel]map[string]*charm.URL) []params.Channel {
+	var chs []params.Channel
+	for ch := range a {
+		chs = append(chs, ch)
+	}
+	for ch := range b {
+		if _, ok := a[ch]; !ok {
+			chs = append(chs, ch)
+		}
+	}
+	return chs
+}
+
+// seriesNames returns the series present in either of the given
+// series maps.
+func seriesNames(a, b map[string]*charm.URL) []string {
+	var names []string
+	for s := range a {
+		names = append(names, s)
+	}
+	for s := range b {
+		if _, ok := a[s]; !ok {
+			names = append(names, s)
+		}
+	}
+	return names
+}
@@ -0,0 +1,387 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mirror_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/mirror"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	jujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mirror"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type MirrorSuite struct {
+	jujutesting.IsolatedMgoSuite
+	src *charmstore.Store
+	dst *charmstore.Store
+}
+
+var _ = gc.Suite(&MirrorSuite{})
+
+func (s *MirrorSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+	s.src = s.newStore(c, "src", nil)
+	dstDB := s.Session.DB("dst")
+	s.dst = s.newStore(c, "dst", &charmstore.SearchIndex{
+		Backend: charmstore.NewMongoSearchBackend(dstDB),
+	})
+}
+
+func (s *MirrorSuite) TearDownTest(c *gc.C) {
+	s.src.Close()
+	s.src.Pool().Close()
+	s.dst.Close()
+	s.dst.Pool().Close()
+	s.IsolatedMgoSuite.TearDownTest(c)
+}
+
+func (s *MirrorSuite) newStore(c *gc.C, dbName string, si *charmstore.SearchIndex) *charmstore.Store {
+	pool, err := charmstore.NewPool(s.Session.DB(dbName), si, nil, charmstore.ServerParams{})
+	c.Assert(err, gc.IsNil)
+	return pool.Store()
+}
+
+func (s *MirrorSuite) addCharm(c *gc.C, id, name string, channels ...params.Channel) *router.ResolvedURL {
+	rurl := router.MustNewResolvedURL(id, -1)
+	err := s.src.AddCharmWithArchive(rurl, storetesting.Charms.CharmDir(name))
+	c.Assert(err, gc.IsNil)
+	if len(channels) > 0 {
+		err = s.src.Publish(rurl, nil, channels...)
+		c.Assert(err, gc.IsNil)
+	}
+	return rurl
+}
+
+func (s *MirrorSuite) TestMirror(c *gc.C) {
+	wordpress := s.addCharm(c, "~charmers/precise/wordpress-0", "wordpress", params.StableChannel)
+	s.addCharm(c, "~charmers/trusty/mysql-1", "mysql", params.DevelopmentChannel)
+
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 2)
+	c.Assert(result.BaseEntities, gc.Equals, 2)
+	c.Assert(result.LastUploadTime.IsZero(), gc.Equals, false)
+
+	for _, id := range []string{"~charmers/precise/wordpress-0", "~charmers/trusty/mysql-1"} {
+		s.assertEntityState(c, id)
+	}
+	s.assertBaseEntityState(c, "~charmers/wordpress")
+	s.assertBaseEntityState(c, "~charmers/mysql")
+
+	// The stable entity has been indexed for search.
+	doc, err := s.dst.ES.GetSearchDocument(&wordpress.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.URL, jc.DeepEquals, &wordpress.URL)
+
+	// Running again with the recorded upload time does nothing.
+	result, err = mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+		Since:  result.LastUploadTime,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 0)
+	c.Assert(result.BaseEntities, gc.Equals, 0)
+}
+
+func (s *MirrorSuite) TestSyncStateWithoutUploads(c *gc.C) {
+	wordpress := s.addCharm(c, "~charmers/precise/wordpress-0", "wordpress")
+	s.addCharm(c, "~charmers/trusty/mysql-1", "mysql", params.StableChannel)
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+	})
+	c.Assert(err, gc.IsNil)
+	since := result.LastUploadTime
+
+	// Publish, change the permissions and promulgate in the
+	// source store without uploading anything.
+	err = s.src.Publish(wordpress, nil, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	err = s.src.UpdateBaseEntity(wordpress, bson.D{{"$set", bson.D{
+		{"channelacls.stable.read", []string{"bob"}},
+	}}})
+	c.Assert(err, gc.IsNil)
+	err = s.src.SetPromulgated(router.MustNewResolvedURL("~charmers/trusty/mysql-1", -1), true)
+	c.Assert(err, gc.IsNil)
+
+	result, err = mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+		Since:  since,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 0)
+	c.Assert(result.BaseEntities, gc.Equals, 2)
+	c.Assert(result.LastUploadTime, gc.Equals, since)
+
+	s.assertEntityState(c, "~charmers/precise/wordpress-0")
+	s.assertBaseEntityState(c, "~charmers/wordpress")
+	s.assertBaseEntityState(c, "~charmers/mysql")
+	be, err := s.dst.FindBaseEntity(charm.MustParseURL("~charmers/wordpress"), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(be.ChannelACLs[params.StableChannel].Read, jc.DeepEquals, []string{"bob"})
+	be, err = s.dst.FindBaseEntity(charm.MustParseURL("~charmers/mysql"), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(be.Promulgated, gc.Equals, mongodoc.IntBool(true))
+
+	// The search index reflects the new state.
+	doc, err := s.dst.ES.GetSearchDocument(&wordpress.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.ReadACLs, jc.DeepEquals, []string{"bob"})
+
+	// The changes have been recorded as events and audit
+	// entries in the destination store.
+	s.assertEventCount(c, mongodoc.PublishEvent, &wordpress.URL, 1)
+	s.assertEventCount(c, mongodoc.PermEvent, charm.MustParseURL("~charmers/wordpress"), 1)
+	s.assertEventCount(c, mongodoc.PromulgateEvent, charm.MustParseURL("~charmers/mysql"), 1)
+	s.assertAuditCount(c, audit.OpPublish, &wordpress.URL, 1)
+	s.assertAuditCount(c, audit.OpSetPerm, charm.MustParseURL("~charmers/wordpress"), 1)
+	s.assertAuditCount(c, audit.OpPromulgate, charm.MustParseURL("~charmers/mysql"), 1)
+}
+
+func (s *MirrorSuite) TestMirrorResources(c *gc.C) {
+	id := s.addCharm(c, "~charmers/trusty/starsay-0", "starsay")
+	for _, content := range []string{"content 0", "content 1"} {
+		_, err := s.src.UploadResource(id, "for-store", strings.NewReader(content), hashOfString(content), int64(len(content)))
+		c.Assert(err, gc.IsNil)
+	}
+	err := s.src.Publish(id, map[string]int{"for-store": 0}, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 1)
+	c.Assert(result.Resources, gc.Equals, 2)
+	s.assertEntityState(c, "~charmers/trusty/starsay-0")
+
+	for rev, content := range []string{"content 0", "content 1"} {
+		res, err := s.dst.ResolveResource(id, "for-store", rev, params.NoChannel)
+		c.Assert(err, gc.IsNil)
+		s.assertResourceContent(c, res, content)
+	}
+	res, err := s.dst.ResolveResource(id, "for-store", -1, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 0)
+
+	// Publishing another resource revision in the source store
+	// is mirrored without any new upload.
+	err = s.src.Publish(id, map[string]int{"for-store": 1}, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	result, err = mirror.Run(mirror.Params{
+		Source:         s.src,
+		Dest:           s.dst,
+		Since:          result.LastUploadTime,
+		ResourcesSince: result.LastResourceUploadTime,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Resources, gc.Equals, 0)
+	c.Assert(result.BaseEntities, gc.Equals, 1)
+	res, err = s.dst.ResolveResource(id, "for-store", -1, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 1)
+}
+
+func (s *MirrorSuite) TestMirrorResourcesWithSeries(c *gc.C) {
+	// The trusty charm only declares the for-store resource,
+	// while the precise charm with the same base URL also
+	// declares for-install.
+	dir := storetesting.Charms.ClonedDir(c.MkDir(), "starsay")
+	err := ioutil.WriteFile(filepath.Join(dir.Path, "metadata.yaml"), []byte(`
+name: starsay
+summary: A test charm with a single resource.
+description: Does nothing.
+resources:
+  for-store:
+    type: file
+    filename: dummy.tgz
+`), 0666)
+	c.Assert(err, gc.IsNil)
+	ch, err := charm.ReadCharmDir(dir.Path)
+	c.Assert(err, gc.IsNil)
+	trusty := router.MustNewResolvedURL("~charmers/trusty/starsay-0", -1)
+	err = s.src.AddCharmWithArchive(trusty, ch)
+	c.Assert(err, gc.IsNil)
+	precise := s.addCharm(c, "~charmers/precise/starsay-1", "starsay")
+	s.uploadResource(c, trusty, "for-store", "store content")
+	s.uploadResource(c, precise, "for-install", "install content")
+
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+		Series: "trusty",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 1)
+	c.Assert(result.Resources, gc.Equals, 1)
+	res, err := s.dst.ResolveResource(trusty, "for-store", 0, params.NoChannel)
+	c.Assert(err, gc.IsNil)
+	s.assertResourceContent(c, res, "store content")
+	n, err := s.dst.DB.Resources().Find(bson.D{{"name", "for-install"}}).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *MirrorSuite) TestResourceProgressTrackedSeparately(c *gc.C) {
+	id := s.addCharm(c, "~charmers/trusty/starsay-0", "starsay")
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 1)
+	c.Assert(result.LastResourceUploadTime.IsZero(), gc.Equals, true)
+
+	// A resource revision uploaded before the latest entity is
+	// copied even though the entity upload time has moved past it.
+	s.uploadResource(c, id, "for-store", "content 0")
+	s.addCharm(c, "~charmers/trusty/mysql-0", "mysql")
+	entity, err := s.src.FindEntity(router.MustNewResolvedURL("~charmers/trusty/mysql-0", -1), charmstore.FieldSelector("uploadtime"))
+	c.Assert(err, gc.IsNil)
+	result, err = mirror.Run(mirror.Params{
+		Source:         s.src,
+		Dest:           s.dst,
+		Since:          entity.UploadTime,
+		ResourcesSince: result.LastResourceUploadTime,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 0)
+	c.Assert(result.Resources, gc.Equals, 1)
+	c.Assert(result.LastUploadTime, gc.Equals, entity.UploadTime)
+	c.Assert(result.LastResourceUploadTime.IsZero(), gc.Equals, false)
+}
+
+func (s *MirrorSuite) TestMirrorResourcesOfUploadedEntities(c *gc.C) {
+	id := s.addCharm(c, "~charmers/trusty/starsay-0", "starsay")
+	s.uploadResource(c, id, "for-store", "content 0")
+
+	// The resource revisions of the entities uploaded in the run
+	// are copied even when they are older than ResourcesSince.
+	since := time.Now().Add(time.Hour)
+	result, err := mirror.Run(mirror.Params{
+		Source:         s.src,
+		Dest:           s.dst,
+		ResourcesSince: since,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 1)
+	c.Assert(result.Resources, gc.Equals, 1)
+	c.Assert(result.LastResourceUploadTime.Equal(since), gc.Equals, true)
+}
+
+func (s *MirrorSuite) TestOwnerAndSeries(c *gc.C) {
+	s.addCharm(c, "~charmers/precise/wordpress-0", "wordpress", params.StableChannel)
+	s.addCharm(c, "~charmers/trusty/mysql-1", "mysql", params.StableChannel)
+	s.addCharm(c, "~bob/precise/varnish-0", "varnish", params.StableChannel)
+
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+		Owner:  "charmers",
+		Series: "precise",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 1)
+	n, err := s.dst.DB.Entities().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	s.assertEntityState(c, "~charmers/precise/wordpress-0")
+}
+
+func (s *MirrorSuite) TestDryRun(c *gc.C) {
+	s.addCharm(c, "~charmers/precise/wordpress-0", "wordpress", params.StableChannel)
+	result, err := mirror.Run(mirror.Params{
+		Source: s.src,
+		Dest:   s.dst,
+		DryRun: true,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result.Entities, gc.Equals, 0)
+	c.Assert(result.LastUploadTime.IsZero(), gc.Equals, true)
+	n, err := s.dst.DB.Entities().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *MirrorSuite) uploadResource(c *gc.C, id *router.ResolvedURL, name, content string) {
+	_, err := s.src.UploadResource(id, name, strings.NewReader(content), hashOfString(content), int64(len(content)))
+	c.Assert(err, gc.IsNil)
+}
+
+// assertEntityState checks that the published state of the entity
+// with the given id is the same in both stores.
+func (s *MirrorSuite) assertEntityState(c *gc.C, id string) {
+	fields := charmstore.FieldSelector("blobhash", "development", "stable", "channelresources")
+	rurl := router.MustNewResolvedURL(id, -1)
+	src, err := s.src.FindEntity(rurl, fields)
+	c.Assert(err, gc.IsNil)
+	dst, err := s.dst.FindEntity(rurl, fields)
+	c.Assert(err, gc.IsNil)
+	c.Assert(dst, jc.DeepEquals, src)
+}
+
+// assertBaseEntityState checks that the permissions, channel pointers
+// and promulgation state of the base entity with the given URL are
+// the same in both stores.
+func (s *MirrorSuite) assertBaseEntityState(c *gc.C, url string) {
+	fields := charmstore.FieldSelector("channelacls", "channelentities", "promulgated")
+	src, err := s.src.FindBaseEntity(charm.MustParseURL(url), fields)
+	c.Assert(err, gc.IsNil)
+	dst, err := s.dst.FindBaseEntity(charm.MustParseURL(url), fields)
+	c.Assert(err, gc.IsNil)
+	c.Assert(dst, jc.DeepEquals, src)
+}
+
+// assertEventCount checks that the destination store holds the
+// given number of events of the given kind for the given URL.
+func (s *MirrorSuite) assertEventCount(c *gc.C, kind mongodoc.EventKind, url *charm.URL, expect int) {
+	n, err := s.dst.DB.Events().Find(bson.D{{"kind", kind}, {"url", url}}).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, expect)
+}
+
+// assertAuditCount checks that the destination store holds the
+// given number of audit entries of the given operation for the
+// given URL.
+func (s *MirrorSuite) assertAuditCount(c *gc.C, op audit.Operation, url *charm.URL, expect int) {
+	n, err := s.dst.DB.Audits().Find(bson.D{{"op", op}, {"entity", url}}).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, expect)
+}
+
+func (s *MirrorSuite) assertResourceContent(c *gc.C, res *mongodoc.Resource, expect string) {
+	blob, err := s.dst.OpenResourceBlob(res)
+	c.Assert(err, gc.IsNil)
+	defer blob.Close()
+	data, err := ioutil.ReadAll(blob)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, expect)
+}
+
+func hashOfString(s string) string {
+	h := blobstore.NewHash()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -145,6 +145,12 @@ type Entity struct {
 	// Stable holds whether the entity has been published in the
 	// "stable" channel.
 	Stable bool
+
+	// ChannelResources holds, for each channel the entity has been
+	// published to, the revisions of the charm's resources that were
+	// published along with it. Resources without an entry in
+	// a channel resolve to their latest uploaded revision.
+	ChannelResources map[params.Channel][]ResourceRevision `json:",omitempty" bson:",omitempty"`
 }
 
 // PreferredURL returns the preferred way to refer to this entity. If
@@ -195,6 +201,33 @@ type BaseEntity struct {
 	// of series holding the currently published entity revision for
 	// that channel and series.
 	ChannelEntities map[params.Channel]map[string]*charm.URL
+
+	// Webhooks holds the webhooks that are notified when
+	// entities that use this base entity change.
+	Webhooks []Webhook `bson:",omitempty" json:",omitempty"`
+}
+
+// Webhook holds a URL that is sent notifications about
+// changes to the entities of a base entity.
+type Webhook struct {
+	// URL holds the URL that notifications are POSTed to.
+	URL string
+
+	// Secret holds the key used to sign the notifications sent to
+	// the webhook. If it is empty, notifications are not signed.
+	Secret string `bson:",omitempty" json:",omitempty"`
+}
+
+// FeaturedList holds the list of featured charms and bundles
+// curated by the charm store administrators. The list is held
+// in a single document so that it is always replaced as a whole.
+type FeaturedList struct {
+	// Id holds the id of the document.
+	Id string `bson:"_id"`
+
+	// URLs holds the base URLs of the featured charms
+	// and bundles, in order.
+	URLs []*charm.URL
 }
 
 // ACL holds lists of users and groups that are
@@ -236,6 +269,86 @@ func (f ZipFile) IsValid() bool {
 	return f != ZipFile{}
 }
 
+// Resource holds the in-database representation of a single revision
+// of a charm resource. Resource revisions are numbered independently
+// for each resource name of each base charm, starting at zero.
+type Resource struct {
+	// BaseURL holds the base URL of the charm that the
+	// resource belongs to (for instance, cs:~user/wordpress).
+	BaseURL *charm.URL
+
+	// Name holds the name of the resource as declared
+	// in the charm metadata.
+	Name string
+
+	// Revision holds the revision of the resource.
+	Revision int
+
+	// BlobHash holds the hash checksum of the resource blob,
+	// in hexadecimal format, as created by blobstore.NewHash.
+	BlobHash string
+
+	// Size holds the size of the resource blob.
+	Size int64
+
+	// BlobName holds the name that the resource blob is given
+	// in the blob store.
+	BlobName string
+
+	// UploadTime holds the time the resource revision was uploaded.
+	UploadTime time.Time
+}
+
+// ResourceRevision holds the revision of a
+// single named resource.
+type ResourceRevision struct {
+	// Name holds the name of the resource.
+	Name string
+
+	// Revision holds the revision of the resource.
+	Revision int
+}
+
+// Upload holds the in-database representation of a chunked
+// archive upload that has not yet been committed.
+type Upload struct {
+	// Id holds the unique id of the upload.
+	Id string `bson:"_id"`
+
+	// Owner holds the name of the user that created the upload.
+	// It is empty if the upload was created with admin credentials.
+	Owner string
+
+	// BaseURL holds the URL of the base entity that the upload
+	// was created for. The upload can only be committed to an
+	// entity with that base URL.
+	BaseURL *charm.URL
+
+	// Size holds the total size of the parts uploaded so far.
+	Size int64
+
+	// Parts holds the uploaded parts, in order.
+	Parts []UploadPart
+
+	// Expires holds the time after which the upload is
+	// discarded. It is extended whenever a part is added.
+	Expires time.Time
+}
+
+// UploadPart holds a single part of a chunked archive upload.
+type UploadPart struct {
+	// BlobName holds the name of the blob holding the
+	// content of the part.
+	BlobName string
+
+	// Hash holds the hash checksum of the part content,
+	// in hexadecimal format, as created by blobstore.NewHash.
+	Hash string
+
+	// Size holds the size of the part.
+	Size int64
+}
+
 // Log holds the in-database representation of a log message sent to the charm
 // store.
 type Log struct {
@@ -282,6 +395,58 @@ const (
 	_ LogType = iota
 	IngestionType
 	LegacyStatisticsType
+	IntegrityType
+)
+
+// Event holds the in-database representation of a change made to
+// the charm store, as recorded in the events collection. Events are
+// ordered by their ids, which are used as cursors when following the
+// store's changes.
+type Event struct {
+	// Id holds the sequence number of the event. Events
+	// are numbered in the order they are recorded.
+	Id int64 `bson:"_id"`
+
+	// Kind holds the kind of change that the event records.
+	Kind EventKind
+
+	// URL holds the URL of the entity that the event applies to.
+	// For events that apply to a base entity, such as promulgation
+	// and permission changes, this holds the base URL.
+	URL *charm.URL
+
+	// Channels holds the channels affected by the change. It is
+	// empty for changes that are not tied to a channel.
+	Channels []params.Channel `bson:",omitempty"`
+
+	// Time holds the time the change was made.
+	Time time.Time
+}
+
+// EventKind holds the kind of a change event.
+type EventKind string
+
+const (
+	// PublishEvent records the publishing of an entity
+	// to one or more channels.
+	PublishEvent EventKind = "publish"
+
+	// PromulgateEvent and UnpromulgateEvent record
+	// the promulgation of a base entity.
+	PromulgateEvent   EventKind = "promulgate"
+	UnpromulgateEvent EventKind = "unpromulgate"
+
+	// PermEvent records a change to the ACLs of
+	// a base entity in one or more channels.
+	PermEvent EventKind = "set-perm"
+
+	// DeleteEvent records the deletion of an entity.
+	DeleteEvent EventKind = "delete"
+
+	// UploadEvent represents the upload of an entity. Uploads
+	// are notified to webhooks but are not recorded in the
+	// events collection.
+	UploadEvent EventKind = "upload"
 )
 
 type MigrationName string
@@ -60,6 +60,10 @@ func HandleErrors(h ErrorHandler) http.Handler {
 	})
 }
 
+// ErrEventsExpired is the error code returned when some of the change
+// events requested have been removed because they were too old.
+const ErrEventsExpired params.ErrorCode = "events expired"
+
 var errorToResp httprequest.ErrorMapper = func(err error) (int, interface{}) {
 	status, body := errorToResp1(err)
 	logger.Infof("error response %d; %s", status, errgo.Details(err))
@@ -91,6 +95,8 @@ func errorToResp1(err error) (int, interface{}) {
 		status = http.StatusMethodNotAllowed
 	case params.ErrServiceUnavailable:
 		status = http.StatusServiceUnavailable
+	case ErrEventsExpired:
+		status = http.StatusGone
 	}
 	return status, errorBody
 }
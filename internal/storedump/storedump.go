@@ -0,0 +1,331 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// The storedump package implements the portable archive format used
+// to export entities from a charm store and import them into another.
+//
+// A dump is a tar archive. Each entity is stored as a directory
+// named after its id, holding the BSON-encoded mongodoc.Entity in
+// "entity.bson", the pre-v5 compatibility suffix of multi-series
+// charms in "archive.pre-v5-suffix" (when there is one) and the
+// entity's archive blob in "archive", in that order. The
+// BSON-encoded base entities follow all the entities, under
+// "base-entities".
+package storedump // import "gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+const (
+	entitiesDir     = "entities"
+	baseEntitiesDir = "base-entities"
+	entityFile      = "entity.bson"
+	suffixFile      = "archive.pre-v5-suffix"
+	archiveFile     = "archive"
+)
+
+// maxDocSize holds the maximum size of a BSON document in a dump.
+// This is the maximum size of a MongoDB document.
+const maxDocSize = 16 * 1024 * 1024
+
+// Writer writes a dump.
+type Writer struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+// NewWriter returns a Writer that writes a dump to w.
+// The Writer must be closed after use.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		tw:  tar.NewWriter(w),
+		now: time.Now(),
+	}
+}
+
+// WriteEntity writes the given entity to the dump. The archive
+// reader must provide the entity's archive blob, which must have
+// e.Size bytes. If the pre-v5 blob of the entity differs from its
+// archive blob, preV5Suffix must hold the data that is appended
+// to the archive blob to make the pre-v5 blob.
+func (w *Writer) WriteEntity(e *mongodoc.Entity, archive io.Reader, preV5Suffix []byte) error {
+	dir := entityDir(e)
+	if err := w.writeDoc(path.Join(dir, entityFile), e); err != nil {
+		return errgo.Mask(err)
+	}
+	if e.PreV5BlobHash != e.BlobHash {
+		if err := w.writeFile(path.Join(dir, suffixFile), bytes.NewReader(preV5Suffix), int64(len(preV5Suffix))); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if err := w.writeFile(path.Join(dir, archiveFile), archive, e.Size); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// WriteBaseEntity writes the given base entity to the dump.
+// Base entities must be written after all the entities.
+func (w *Writer) WriteBaseEntity(b *mongodoc.BaseEntity) error {
+	return errgo.Mask(w.writeDoc(path.Join(baseEntitiesDir, b.URL.Path()+".bson"), b))
+}
+
+// Close finishes writing the dump. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	return errgo.Mask(w.tw.Close())
+}
+
+func (w *Writer) writeDoc(name string, doc interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return errgo.Notef(err, "cannot marshal %s", name)
+	}
+	return errgo.Mask(w.writeFile(name, bytes.NewReader(data), int64(len(data))))
+}
+
+func (w *Writer) writeFile(name string, r io.Reader, size int64) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: w.now,
+	})
+	if err != nil {
+		return errgo.Notef(err, "cannot write header for %s", name)
+	}
+	n, err := io.Copy(w.tw, r)
+	if err != nil {
+		return errgo.Notef(err, "cannot write %s", name)
+	}
+	if n != size {
+		return errgo.Newf("cannot write %s: expected %d bytes, got %d", name, size, n)
+	}
+	return nil
+}
+
+// Item holds an item read from a dump. Exactly one of
+// Entity and BaseEntity is set.
+type Item struct {
+	// Entity holds the entity document.
+	Entity *mongodoc.Entity
+
+	// Archive reads the archive blob of the entity. Reading it
+	// returns an error instead of io.EOF when the blob or the
+	// pre-v5 blob do not match the hashes and sizes recorded
+	// in the entity. A reader that stops after the expected
+	// number of bytes never sees io.EOF, so VerifyArchive must
+	// be called once the archive has been read. Archive is only
+	// valid until the next call to Reader.Next.
+	Archive io.Reader
+
+	// PreV5Suffix holds the pre-v5 compatibility suffix of
+	// the entity, if any.
+	PreV5Suffix []byte
+
+	// BaseEntity holds the base entity document.
+	BaseEntity *mongodoc.BaseEntity
+
+	archive *verifyingReader
+}
+
+// VerifyArchive reads any part of the archive blob that has not yet
+// been read from Archive and checks that the blob and the pre-v5 blob
+// match the hashes and sizes recorded in the entity. It must be called
+// before the next call to Reader.Next.
+func (item *Item) VerifyArchive() error {
+	if item.archive == nil {
+		return errgo.New("item has no archive")
+	}
+	if _, err := io.Copy(ioutil.Discard, item.archive); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// Reader reads a dump.
+type Reader struct {
+	tr *tar.Reader
+}
+
+// NewReader returns a Reader that reads a dump from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		tr: tar.NewReader(r),
+	}
+}
+
+// Next returns the next item in the dump.
+// It returns io.EOF when there are no more items.
+func (r *Reader) Next() (*Item, error) {
+	hdr, err := r.tr.Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errgo.Notef(err, "cannot read dump")
+	}
+	switch {
+	case strings.HasPrefix(hdr.Name, baseEntitiesDir+"/"):
+		var b mongodoc.BaseEntity
+		if err := r.readDoc(hdr, &b); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return &Item{
+			BaseEntity: &b,
+		}, nil
+	case strings.HasPrefix(hdr.Name, entitiesDir+"/") && path.Base(hdr.Name) == entityFile:
+		return r.readEntity(hdr)
+	}
+	return nil, errgo.Newf("unexpected file %q in dump", hdr.Name)
+}
+
+// readEntity reads the entity whose document is in the
+// file with the given header.
+func (r *Reader) readEntity(hdr *tar.Header) (*Item, error) {
+	var e mongodoc.Entity
+	if err := r.readDoc(hdr, &e); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	dir := entityDir(&e)
+	if path.Dir(hdr.Name) != dir {
+		return nil, errgo.Newf("entity %v found in unexpected file %q", e.URL, hdr.Name)
+	}
+	item := &Item{
+		Entity: &e,
+	}
+	hdr, err := r.nextEntityFile(&e)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if hdr.Name == path.Join(dir, suffixFile) {
+		if e.PreV5BlobHash == e.BlobHash {
+			return nil, errgo.Newf("unexpected pre-v5 suffix for %v", e.URL)
+		}
+		item.PreV5Suffix, err = ioutil.ReadAll(r.tr)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read pre-v5 suffix for %v", e.URL)
+		}
+		hdr, err = r.nextEntityFile(&e)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	} else if e.PreV5BlobHash != e.BlobHash {
+		return nil, errgo.Newf("missing pre-v5 suffix for %v", e.URL)
+	}
+	if hdr.Name != path.Join(dir, archiveFile) {
+		return nil, errgo.Newf("unexpected file %q in dump (expected archive for %v)", hdr.Name, e.URL)
+	}
+	if hdr.Size != e.Size {
+		return nil, errgo.Newf("archive for %v has unexpected size %d (expected %d)", e.URL, hdr.Size, e.Size)
+	}
+	item.archive = &verifyingReader{
+		r:      r.tr,
+		entity: &e,
+		suffix: item.PreV5Suffix,
+		hash:   blobstore.NewHash(),
+	}
+	item.Archive = item.archive
+	return item, nil
+}
+
+// nextEntityFile returns the header of the next file, which
+// must belong to the given entity.
+func (r *Reader) nextEntityFile(e *mongodoc.Entity) (*tar.Header, error) {
+	hdr, err := r.tr.Next()
+	if err == io.EOF {
+		return nil, errgo.Newf("missing archive for %v", e.URL)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read dump")
+	}
+	return hdr, nil
+}
+
+func (r *Reader) readDoc(hdr *tar.Header, doc interface{}) error {
+	if hdr.Size > maxDocSize {
+		return errgo.Newf("%s is too large (%d bytes)", hdr.Name, hdr.Size)
+	}
+	data, err := ioutil.ReadAll(r.tr)
+	if err != nil {
+		return errgo.Notef(err, "cannot read %s", hdr.Name)
+	}
+	if err := bson.Unmarshal(data, doc); err != nil {
+		return errgo.Notef(err, "cannot unmarshal %s", hdr.Name)
+	}
+	return nil
+}
+
+// verifyingReader reads an archive blob, checking that it and
+// the pre-v5 blob formed by appending the suffix have the hashes
+// and sizes recorded in the entity.
+type verifyingReader struct {
+	r      io.Reader
+	entity *mongodoc.Entity
+	suffix []byte
+	hash   hash.Hash
+	n      int64
+
+	// err holds the error returned when the
+	// end of the archive has been reached.
+	err error
+}
+
+// Read implements io.Reader.
+func (r *verifyingReader) Read(buf []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(buf)
+	r.hash.Write(buf[:n])
+	r.n += int64(n)
+	if err == io.EOF {
+		if verr := r.verify(); verr != nil {
+			err = verr
+		}
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *verifyingReader) verify() error {
+	e := r.entity
+	if r.n != e.Size {
+		return errgo.Newf("archive for %v has unexpected size %d (expected %d)", e.URL, r.n, e.Size)
+	}
+	if hash := fmt.Sprintf("%x", r.hash.Sum(nil)); hash != e.BlobHash {
+		return errgo.Newf("archive for %v has unexpected hash %s (expected %s)", e.URL, hash, e.BlobHash)
+	}
+	if len(r.suffix) == 0 {
+		return nil
+	}
+	r.hash.Write(r.suffix)
+	if size := r.n + int64(len(r.suffix)); size != e.PreV5BlobSize {
+		return errgo.Newf("pre-v5 archive for %v has unexpected size %d (expected %d)", e.URL, size, e.PreV5BlobSize)
+	}
+	if hash := fmt.Sprintf("%x", r.hash.Sum(nil)); hash != e.PreV5BlobHash {
+		return errgo.Newf("pre-v5 archive for %v has unexpected hash %s (expected %s)", e.URL, hash, e.PreV5BlobHash)
+	}
+	return nil
+}
+
+// entityDir returns the directory holding the files
+// of the given entity.
+func entityDir(e *mongodoc.Entity) string {
+	return path.Join(entitiesDir, e.URL.Path())
+}
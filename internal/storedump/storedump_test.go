@@ -0,0 +1,211 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storedump_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storedump"
+)
+
+type storedumpSuite struct{}
+
+var _ = gc.Suite(&storedumpSuite{})
+
+func (s *storedumpSuite) TestRoundTrip(c *gc.C) {
+	e1 := newEntity("~charmers/precise/wordpress-1", "wordpress archive", "")
+	e2 := newEntity("~charmers/mysql-2", "mysql archive", "mysql suffix")
+	b := &mongodoc.BaseEntity{
+		URL:         charm.MustParseURL("~charmers/wordpress"),
+		User:        "charmers",
+		Name:        "wordpress",
+		Promulgated: true,
+		ChannelACLs: map[params.Channel]mongodoc.ACL{
+			params.StableChannel: {
+				Read:  []string{"everyone"},
+				Write: []string{"charmers"},
+			},
+		},
+		ChannelEntities: map[params.Channel]map[string]*charm.URL{
+			params.StableChannel: {
+				"precise": charm.MustParseURL("~charmers/precise/wordpress-1"),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	w := storedump.NewWriter(&buf)
+	err := w.WriteEntity(e1, strings.NewReader("wordpress archive"), nil)
+	c.Assert(err, gc.IsNil)
+	err = w.WriteEntity(e2, strings.NewReader("mysql archive"), []byte("mysql suffix"))
+	c.Assert(err, gc.IsNil)
+	err = w.WriteBaseEntity(b)
+	c.Assert(err, gc.IsNil)
+	err = w.Close()
+	c.Assert(err, gc.IsNil)
+
+	r := storedump.NewReader(&buf)
+	item, err := r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.Entity, jc.DeepEquals, e1)
+	c.Assert(item.PreV5Suffix, gc.HasLen, 0)
+	data, err := ioutil.ReadAll(item.Archive)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "wordpress archive")
+
+	item, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.Entity, jc.DeepEquals, e2)
+	c.Assert(string(item.PreV5Suffix), gc.Equals, "mysql suffix")
+	data, err = ioutil.ReadAll(item.Archive)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "mysql archive")
+
+	item, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.Entity, gc.IsNil)
+	c.Assert(item.BaseEntity, jc.DeepEquals, b)
+
+	_, err = r.Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (s *storedumpSuite) TestArchiveUnreadUntilNext(c *gc.C) {
+	var buf bytes.Buffer
+	w := storedump.NewWriter(&buf)
+	err := w.WriteEntity(newEntity("~charmers/precise/wordpress-1", "archive", ""), strings.NewReader("archive"), nil)
+	c.Assert(err, gc.IsNil)
+	err = w.WriteEntity(newEntity("~charmers/precise/wordpress-2", "archive", ""), strings.NewReader("archive"), nil)
+	c.Assert(err, gc.IsNil)
+	err = w.Close()
+	c.Assert(err, gc.IsNil)
+
+	// An archive does not need to be read before the next item.
+	r := storedump.NewReader(&buf)
+	_, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	item, err := r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(item.Entity.URL, jc.DeepEquals, charm.MustParseURL("~charmers/precise/wordpress-2"))
+}
+
+var readErrorTests = []struct {
+	about       string
+	entity      *mongodoc.Entity
+	archive     string
+	suffix      string
+	expectError string
+}{{
+	about:       "archive hash mismatch",
+	entity:      newEntity("~charmers/precise/wordpress-1", "original", ""),
+	archive:     "modified",
+	expectError: `archive for cs:~charmers/precise/wordpress-1 has unexpected hash .*`,
+}, {
+	about:       "pre-v5 hash mismatch",
+	entity:      newEntity("~charmers/mysql-1", "archive", "suffix"),
+	archive:     "archive",
+	suffix:      "suffiX",
+	expectError: `pre-v5 archive for cs:~charmers/mysql-1 has unexpected hash .*`,
+}}
+
+func (s *storedumpSuite) TestReadErrors(c *gc.C) {
+	for i, test := range readErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var buf bytes.Buffer
+		w := storedump.NewWriter(&buf)
+		err := w.WriteEntity(test.entity, strings.NewReader(test.archive), []byte(test.suffix))
+		c.Assert(err, gc.IsNil)
+		err = w.Close()
+		c.Assert(err, gc.IsNil)
+
+		item, err := storedump.NewReader(&buf).Next()
+		c.Assert(err, gc.IsNil)
+		_, err = ioutil.ReadAll(item.Archive)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (s *storedumpSuite) TestVerifyArchive(c *gc.C) {
+	for i, test := range readErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var buf bytes.Buffer
+		w := storedump.NewWriter(&buf)
+		err := w.WriteEntity(test.entity, strings.NewReader(test.archive), []byte(test.suffix))
+		c.Assert(err, gc.IsNil)
+		err = w.Close()
+		c.Assert(err, gc.IsNil)
+
+		// Read exactly the expected number of bytes, as
+		// the store does when uploading an archive.
+		item, err := storedump.NewReader(&buf).Next()
+		c.Assert(err, gc.IsNil)
+		_, err = io.CopyN(ioutil.Discard, item.Archive, item.Entity.Size)
+		c.Assert(err, gc.IsNil)
+		err = item.VerifyArchive()
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (s *storedumpSuite) TestVerifyArchiveSuccess(c *gc.C) {
+	var buf bytes.Buffer
+	w := storedump.NewWriter(&buf)
+	err := w.WriteEntity(newEntity("~charmers/mysql-1", "archive", "suffix"), strings.NewReader("archive"), []byte("suffix"))
+	c.Assert(err, gc.IsNil)
+	err = w.Close()
+	c.Assert(err, gc.IsNil)
+
+	item, err := storedump.NewReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	_, err = io.CopyN(ioutil.Discard, item.Archive, 3)
+	c.Assert(err, gc.IsNil)
+	err = item.VerifyArchive()
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *storedumpSuite) TestWriteSizeMismatch(c *gc.C) {
+	w := storedump.NewWriter(ioutil.Discard)
+	err := w.WriteEntity(newEntity("~charmers/precise/wordpress-1", "archive", ""), strings.NewReader("arch"), nil)
+	c.Assert(err, gc.ErrorMatches, `cannot write entities/~charmers/precise/wordpress-1/archive: .*`)
+}
+
+// newEntity returns an entity with the given id whose archive
+// blob holds the given content. If suffix is not empty, the
+// entity's pre-v5 blob is made by appending it to the archive.
+func newEntity(id, archive, suffix string) *mongodoc.Entity {
+	url := charm.MustParseURL(id)
+	e := &mongodoc.Entity{
+		URL:                 url,
+		BaseURL:             mongodoc.BaseURL(url),
+		User:                url.User,
+		Name:                url.Name,
+		Revision:            url.Revision,
+		Series:              url.Series,
+		BlobHash:            hashOf(archive),
+		Size:                int64(len(archive)),
+		PreV5BlobHash:       hashOf(archive + suffix),
+		PreV5BlobSize:       int64(len(archive + suffix)),
+		PromulgatedRevision: -1,
+	}
+	if url.Series != "" {
+		e.SupportedSeries = []string{url.Series}
+	}
+	return e
+}
+
+func hashOf(s string) string {
+	h := blobstore.NewHash()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -138,8 +138,12 @@ func newReqHandler() ReqHandler {
 	// Delete new endpoints that we don't want to provide in v4.
 	delete(handlers.Id, "publish")
 	delete(handlers.Meta, "published")
-	delete(handlers.Id, "resources")
+	delete(handlers.Id, "resources/")
 	delete(handlers.Meta, "resources")
+	delete(handlers.Global, "audit")
+	delete(handlers.Global, "changes/events")
+	delete(handlers.Global, "search/featured")
+	delete(handlers.Meta, "webhooks")
 
 	h.Router = router.New(handlers, h)
 	return h
@@ -18,8 +18,12 @@ import (
 func (h ReqHandler) serveArchive(v5ServeArchive router.IdHandler) router.IdHandler {
 	get := h.ResolvedIdHandler(h.serveGetArchive)
 	return func(id *charm.URL, w http.ResponseWriter, req *http.Request) error {
-		if req.Method == "GET" {
+		switch req.Method {
+		case "GET":
 			return get(id, w, req)
+		case "DELETE":
+			// Deleting entities is only supported in v5.
+			return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
 		}
 		return v5ServeArchive(id, w, req)
 	}
@@ -563,7 +563,7 @@ func (s *ArchiveSuite) TestPostBundle(c *gc.C) {
 	} {
 		err := s.store.AddCharmWithArchive(rurl, storetesting.Charms.CharmArchive(c.MkDir(), rurl.URL.Name))
 		c.Assert(err, gc.IsNil)
-		err = s.store.Publish(rurl, params.StableChannel)
+		err = s.store.Publish(rurl, nil, params.StableChannel)
 		c.Assert(err, gc.IsNil)
 	}
 
@@ -598,9 +598,10 @@ func (s *ArchiveSuite) TestPostHashMismatch(c *gc.C) {
 		Body:         bytes.NewReader(content),
 		Username:     testUsername,
 		Password:     testPassword,
-		ExpectStatus: http.StatusInternalServerError,
+		ExpectStatus: http.StatusBadRequest,
 		ExpectBody: params.Error{
-			Message: "cannot put archive blob: hash mismatch",
+			Message: "archive hash mismatch",
+			Code:    params.ErrInvalidEntity,
 		},
 	})
 }
@@ -610,11 +611,11 @@ func invalidZip() io.ReadSeeker {
 }
 
 func (s *ArchiveSuite) TestPostInvalidCharmZip(c *gc.C) {
-	s.assertCannotUpload(c, "~charmers/precise/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read charm archive: zip: not a valid zip file")
+	s.assertCannotUpload(c, "~charmers/precise/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read archive: zip: not a valid zip file")
 }
 
 func (s *ArchiveSuite) TestPostInvalidBundleZip(c *gc.C) {
-	s.assertCannotUpload(c, "~charmers/bundle/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read bundle archive: zip: not a valid zip file")
+	s.assertCannotUpload(c, "~charmers/bundle/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read archive: zip: not a valid zip file")
 }
 
 var postInvalidCharmMetadataTests = []struct {
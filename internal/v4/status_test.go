@@ -107,6 +107,11 @@ func (s *APISuite) TestStatus(c *gc.C) {
 			Value:  "started: " + statisticsStart.Format(time.RFC3339) + ", completed: " + statisticsEnd.Format(time.RFC3339),
 			Passed: true,
 		},
+		"blob_integrity": {
+			Name:   "Blob integrity",
+			Value:  "no blob integrity scrub recorded",
+			Passed: true,
+		},
 	})
 }
 
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -201,6 +202,8 @@ func RouterHandlers(h *ReqHandler) *router.Handlers {
 	authId := h.AuthIdHandler
 	return &router.Handlers{
 		Global: map[string]http.Handler{
+			"audit":                router.HandleJSON(h.serveAudit),
+			"changes/events":       router.HandleJSON(h.serveChangesEvents),
 			"changes/published":    router.HandleJSON(h.serveChangesPublished),
 			"debug":                http.HandlerFunc(h.serveDebug),
 			"debug/pprof/":         newPprofHandler(h),
@@ -209,11 +212,14 @@ func RouterHandlers(h *ReqHandler) *router.Handlers {
 			"log":                  router.HandleErrors(h.serveLog),
 			"logout":               http.HandlerFunc(logout),
 			"search":               router.HandleJSON(h.serveSearch),
-			"search/interesting":   http.HandlerFunc(h.serveSearchInteresting),
+			"search/featured":      router.HandleJSON(h.serveSearchFeatured),
+			"search/interesting":   router.HandleJSON(h.serveSearchInteresting),
 			"set-auth-cookie":      router.HandleErrors(h.serveSetAuthCookie),
 			"stats/":               router.NotFoundHandler(),
-			"stats/counter/":       router.HandleJSON(h.serveStatsCounter),
+			"stats/counter/":       router.HandleErrors(h.serveStatsCounter),
 			"stats/update":         router.HandleErrors(h.serveStatsUpdate),
+			"upload":               router.HandleErrors(h.serveUpload),
+			"upload/":              router.HandleErrors(h.serveUpload),
 			"macaroon":             router.HandleJSON(h.serveMacaroon),
 			"delegatable-macaroon": router.HandleJSON(h.serveDelegatableMacaroon),
 			"whoami":               router.HandleJSON(h.serveWhoAmI),
@@ -227,7 +233,7 @@ func RouterHandlers(h *ReqHandler) *router.Handlers {
 			"publish":     resolveId(h.servePublish),
 			"promulgate":  resolveId(h.serveAdminPromulgate),
 			"readme":      resolveId(authId(h.serveReadMe), "contents", "blobname"),
-			"resources":   resolveId(authId(h.serveResources)),
+			"resources/":  resolveId(authId(h.serveResources)),
 		},
 		Meta: map[string]router.BulkIncludeHandler{
 			"archive-size":         h.EntityHandler(h.metaArchiveSize, "size"),
@@ -273,12 +279,13 @@ func RouterHandlers(h *ReqHandler) *router.Handlers {
 			"perm":             h.puttableBaseEntityHandler(h.metaPerm, h.putMetaPerm, "channelacls"),
 			"perm/":            h.puttableBaseEntityHandler(h.metaPermWithKey, h.putMetaPermWithKey, "channelacls"),
 			"promulgated":      h.baseEntityHandler(h.metaPromulgated, "promulgated"),
-			"resources":        h.EntityHandler(h.metaResources, "charmmeta"),
+			"resources":        h.EntityHandler(h.metaResources, "charmmeta", "channelresources"),
 			"revision-info":    router.SingleIncludeHandler(h.metaRevisionInfo),
 			"stats":            h.EntityHandler(h.metaStats),
 			"supported-series": h.EntityHandler(h.metaSupportedSeries, "supportedseries"),
 			"tags":             h.EntityHandler(h.metaTags, "charmmeta", "bundledata"),
 			"terms":            h.EntityHandler(h.metaTerms, "charmmeta"),
+			"webhooks":         h.puttableBaseEntityHandler(h.metaWebhooks, h.putMetaWebhooks, "webhooks"),
 
 			// endpoints not yet implemented:
 			// "color": router.SingleIncludeHandler(h.metaColor),
@@ -470,10 +477,36 @@ func (h *ReqHandler) updateBaseEntity(id *router.ResolvedURL, fields map[string]
 	if err := h.Store.UpdateBaseEntity(id, entityUpdateOp(fields)); err != nil {
 		return errgo.Notef(err, "cannot update base entity %q", id)
 	}
+	if chans := aclChannels(fields); len(chans) > 0 {
+		if err := h.Store.AddEvent(mongodoc.PermEvent, mongodoc.BaseURL(&id.URL), chans...); err != nil {
+			return errgo.Mask(err)
+		}
+	}
 	h.processEntries(entries)
 	return nil
 }
 
+// aclChannels returns the channels, in sorted order, whose
+// ACLs are changed by updating the given base entity fields.
+func aclChannels(fields map[string]interface{}) []params.Channel {
+	found := make(map[string]bool)
+	var chans []string
+	for name := range fields {
+		parts := strings.Split(name, ".")
+		if len(parts) < 2 || parts[0] != "channelacls" || found[parts[1]] {
+			continue
+		}
+		found[parts[1]] = true
+		chans = append(chans, parts[1])
+	}
+	sort.Strings(chans)
+	result := make([]params.Channel, len(chans))
+	for i, ch := range chans {
+		result[i] = params.Channel(ch)
+	}
+	return result
+}
+
 func (h *ReqHandler) updateEntity(id *router.ResolvedURL, fields map[string]interface{}, entries []audit.Entry) error {
 	err := h.Store.UpdateEntity(id, entityUpdateOp(fields))
 	if err != nil {
@@ -903,11 +936,21 @@ func (h *ReqHandler) putMetaExtraInfo(id *router.ResolvedURL, path string, val *
 			return err
 		}
 	}
+	chans, err := h.entityAuditChannels(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
 	for key, val := range fields {
+		e := &audit.Entry{
+			Op:       audit.OpSetExtraInfo,
+			Entity:   &id.URL,
+			Channels: chans,
+			Key:      key,
+		}
 		if val == nil {
-			updater.UpdateField("extrainfo."+key, nil, nil)
+			updater.UpdateField("extrainfo."+key, nil, e)
 		} else {
-			updater.UpdateField("extrainfo."+key, *val, nil)
+			updater.UpdateField("extrainfo."+key, *val, e)
 		}
 	}
 	return nil
@@ -922,12 +965,22 @@ func (h *ReqHandler) putMetaExtraInfoWithKey(id *router.ResolvedURL, path string
 	if err := checkExtraInfoKey(key, "extra-info"); err != nil {
 		return err
 	}
+	chans, err := h.entityAuditChannels(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	e := &audit.Entry{
+		Op:       audit.OpSetExtraInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      key,
+	}
 	// If the user puts null, we treat that as if they want to
 	// delete the field.
 	if val == nil || bytes.Equal(*val, nullBytes) {
-		updater.UpdateField("extrainfo."+key, nil, nil)
+		updater.UpdateField("extrainfo."+key, nil, e)
 	} else {
-		updater.UpdateField("extrainfo."+key, *val, nil)
+		updater.UpdateField("extrainfo."+key, *val, e)
 	}
 	return nil
 }
@@ -970,11 +1023,21 @@ func (h *ReqHandler) putMetaCommonInfo(id *router.ResolvedURL, path string, val
 			return err
 		}
 	}
+	chans, err := h.baseEntityAuditChannels(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
 	for key, val := range fields {
+		e := &audit.Entry{
+			Op:       audit.OpSetCommonInfo,
+			Entity:   &id.URL,
+			Channels: chans,
+			Key:      key,
+		}
 		if val == nil {
-			updater.UpdateField("commoninfo."+key, nil, nil)
+			updater.UpdateField("commoninfo."+key, nil, e)
 		} else {
-			updater.UpdateField("commoninfo."+key, *val, nil)
+			updater.UpdateField("commoninfo."+key, *val, e)
 		}
 	}
 	return nil
@@ -987,16 +1050,63 @@ func (h *ReqHandler) putMetaCommonInfoWithKey(id *router.ResolvedURL, path strin
 	if err := checkExtraInfoKey(key, "common-info"); err != nil {
 		return err
 	}
+	chans, err := h.baseEntityAuditChannels(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	e := &audit.Entry{
+		Op:       audit.OpSetCommonInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      key,
+	}
 	// If the user puts null, we treat that as if they want to
 	// delete the field.
 	if val == nil || bytes.Equal(*val, nullBytes) {
-		updater.UpdateField("commoninfo."+key, nil, nil)
+		updater.UpdateField("commoninfo."+key, nil, e)
 	} else {
-		updater.UpdateField("commoninfo."+key, *val, nil)
+		updater.UpdateField("commoninfo."+key, *val, e)
 	}
 	return nil
 }
 
+// entityAuditChannels returns the channels affected by a change to
+// the entity with the given id, for recording in an audit entry. An
+// entity is always in the unpublished channel, and also in any channel
+// it has been published to.
+func (h *ReqHandler) entityAuditChannels(id *router.ResolvedURL) ([]params.Channel, error) {
+	entity, err := h.Cache.Entity(&id.URL, charmstore.FieldSelector("development", "stable"))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	chans := []params.Channel{params.UnpublishedChannel}
+	if entity.Development {
+		chans = append(chans, params.DevelopmentChannel)
+	}
+	if entity.Stable {
+		chans = append(chans, params.StableChannel)
+	}
+	return chans, nil
+}
+
+// baseEntityAuditChannels returns the channels affected by a change
+// to the base entity of the entity with the given id, for recording in
+// an audit entry: the unpublished channel and any other channel that
+// holds a published revision of the base entity.
+func (h *ReqHandler) baseEntityAuditChannels(id *router.ResolvedURL) ([]params.Channel, error) {
+	baseEntity, err := h.Cache.BaseEntity(&id.URL, charmstore.FieldSelector("channelentities"))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	chans := []params.Channel{params.UnpublishedChannel}
+	for _, ch := range []params.Channel{params.DevelopmentChannel, params.StableChannel} {
+		if len(baseEntity.ChannelEntities[ch]) > 0 {
+			chans = append(chans, ch)
+		}
+	}
+	return chans, nil
+}
+
 func checkExtraInfoKey(key string, field string) error {
 	if strings.ContainsAny(key, "./$") {
 		return errgo.WithCausef(nil, params.ErrBadRequest, "bad key for "+field)
@@ -1402,15 +1512,15 @@ func (h *ReqHandler) servePublish(id *router.ResolvedURL, w http.ResponseWriter,
 		}
 	}
 
-	// TODO(ericsnow) Actually handle the resources.
-	if len(publish.Resources) > 0 {
-		return errNotImplemented
-	}
-
-	if err := h.Store.Publish(id, chans...); err != nil {
+	if err := h.Store.Publish(id, publish.Resources, chans...); err != nil {
 		return errgo.NoteMask(err, "cannot publish charm or bundle", errgo.Is(params.ErrNotFound))
 	}
-	// TODO add publish audit
+	h.addAudit(audit.Entry{
+		Op:        audit.OpPublish,
+		Entity:    &id.URL,
+		Channels:  chans,
+		Resources: publish.Resources,
+	})
 	return nil
 }
 
@@ -6,6 +6,7 @@ package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -107,6 +108,11 @@ type metaEndpoint struct {
 	// assertCheckData holds a function that will be used to check that
 	// the get function returns sane data for checkURL.
 	assertCheckData func(c *gc.C, data interface{})
+
+	// writerOnly specifies that the endpoint can only be read by
+	// users with write access to the entity, so it is requested
+	// as the admin user.
+	writerOnly bool
 }
 
 const (
@@ -546,20 +552,18 @@ var metaEndpoints = []metaEndpoint{{
 		if entity.URL.Series == "bundle" {
 			return []params.Resource{}, nil
 		}
-		// TODO(ericsnow) Switch to store.ListResources() once it exists.
-		resources, err := basicListResources(entity)
+		docs, err := store.ListResources(entity, params.StableChannel)
 		if err != nil {
-			return resources, err
+			return nil, err
 		}
 		// Apparently the router's "isNull" check treats empty slices
 		// as nil...
-		if len(resources) == 0 {
+		if len(docs) == 0 {
 			return nil, nil
 		}
 		var results []params.Resource
-		for _, res := range resources {
-			result := params.Resource2API(res)
-			results = append(results, result)
+		for _, doc := range docs {
+			results = append(results, resourceParams(entity.CharmMeta.Resources[doc.Name], doc))
 		}
 		return results, nil
 	},
@@ -586,6 +590,29 @@ var metaEndpoints = []metaEndpoint{{
 			Origin:      "upload",
 		}})
 	},
+}, {
+	name: "webhooks",
+	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
+		entity, err := store.FindBaseEntity(&url.URL, charmstore.FieldSelector("webhooks"))
+		if err != nil {
+			return nil, err
+		}
+		if len(entity.Webhooks) == 0 {
+			return nil, nil
+		}
+		var hooks []v5.Webhook
+		for _, hook := range entity.Webhooks {
+			hooks = append(hooks, v5.Webhook{URL: hook.URL})
+		}
+		return hooks, nil
+	},
+	checkURL: newResolvedURL("cs:~charmers/precise/wordpress-23", 23),
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, jc.DeepEquals, []v5.Webhook{{
+			URL: "http://example.com/hook",
+		}})
+	},
+	writerOnly: true,
 }, {
 	name: "published",
 	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
@@ -609,20 +636,30 @@ var metaEndpoints = []metaEndpoint{{
 	},
 }}
 
-func basicListResources(entity *mongodoc.Entity) ([]resource.Resource, error) {
-	var resources []resource.Resource
-	for _, meta := range entity.CharmMeta.Resources {
-		// We use an origin of "upload" since charms cannot be uploaded yet.
-		resOrigin := resource.OriginUpload
-		res := resource.Resource{
+// resourceParams returns the expected API representation
+// of the given resource.
+func resourceParams(meta resource.Meta, doc *mongodoc.Resource) params.Resource {
+	if doc.Revision == -1 {
+		return params.Resource2API(resource.Resource{
 			Meta:   meta,
-			Origin: resOrigin,
-			// Revision, Fingerprint, and Size are not set.
-		}
-		resources = append(resources, res)
+			Origin: resource.OriginUpload,
+		})
 	}
-	resource.Sort(resources)
-	return resources, nil
+	hash, err := hex.DecodeString(doc.BlobHash)
+	if err != nil {
+		panic(err)
+	}
+	fp, err := resource.NewFingerprint(hash)
+	if err != nil {
+		panic(err)
+	}
+	return params.Resource2API(resource.Resource{
+		Meta:        meta,
+		Origin:      resource.OriginStore,
+		Revision:    doc.Revision,
+		Fingerprint: fp,
+		Size:        doc.Size,
+	})
 }
 
 // TestEndpointGet tries to ensure that the endpoint
@@ -702,6 +739,11 @@ func (s *APISuite) addTestEntities(c *gc.C) []*router.ResolvedURL {
 		s.assertPutAsAdmin(c, key, "value "+e.URL.String())
 		s.assertPutAsAdmin(c, commonkey, "value "+e.URL.String())
 	}
+	// Register a webhook on one of the base entities.
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/webhooks", []v5.Webhook{{
+		URL:    "http://example.com/hook",
+		Secret: "secret",
+	}})
 	return testEntities
 }
 
@@ -720,21 +762,29 @@ func (s *APISuite) TestMetaEndpointsSingle(c *gc.C) {
 			}
 			c.Assert(err, gc.IsNil)
 			c.Logf("	expected data for %q: %#v", url, expectData)
+			p := httptesting.JSONCallParams{
+				Handler: s.srv,
+				URL:     storeURL(path),
+			}
+			if ep.writerOnly {
+				p.Username = testUsername
+				p.Password = testPassword
+			} else {
+				p.Do = bakeryDo(nil)
+			}
 			if isNull(expectData) {
-				httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
-					Handler:      s.srv,
-					URL:          storeURL(path),
-					ExpectStatus: http.StatusNotFound,
-					ExpectBody: params.Error{
-						Message: params.ErrMetadataNotFound.Error(),
-						Code:    params.ErrMetadataNotFound,
-					},
-				})
+				p.ExpectStatus = http.StatusNotFound
+				p.ExpectBody = params.Error{
+					Message: params.ErrMetadataNotFound.Error(),
+					Code:    params.ErrMetadataNotFound,
+				}
+				httptesting.AssertJSONCall(c, p)
 				continue
 			}
 			tested = true
 			c.Logf("	path %q: %#v", url, path)
-			s.assertGet(c, path, expectData)
+			p.ExpectBody = expectData
+			httptesting.AssertJSONCall(c, p)
 		}
 		if !tested {
 			c.Errorf("endpoint %q is null for all endpoints, so is not properly tested", ep.name)
@@ -855,7 +905,7 @@ func (s *APISuite) TestMetaPublished(c *gc.C) {
 		err := s.store.AddEntityWithArchive(id, test.entity)
 		c.Assert(err, gc.IsNil)
 		if len(test.channels) > 0 {
-			err = s.store.Publish(id, test.channels...)
+			err = s.store.Publish(id, nil, test.channels...)
 			c.Assert(err, gc.IsNil)
 		}
 		err = s.store.SetPerms(&id.URL, "unpublished.read", params.Everyone)
@@ -1021,7 +1071,7 @@ func (s *APISuite) TestMetaPerm(c *gc.C) {
 
 	// Publish one of the revisions to development, then PUT to meta/perm
 	// and check that the development ACLs have changed.
-	err := s.store.Publish(newResolvedURL("~charmers/precise/wordpress-23", 23), params.DevelopmentChannel)
+	err := s.store.Publish(newResolvedURL("~charmers/precise/wordpress-23", 23), nil, params.DevelopmentChannel)
 	c.Assert(err, gc.IsNil)
 
 	s.doAsUser("bob", func() {
@@ -1073,7 +1123,7 @@ func (s *APISuite) TestMetaPerm(c *gc.C) {
 	})
 	// Publish wordpress-1 to stable and check that the stable ACLs
 	// have changed.
-	err = s.store.Publish(newResolvedURL("~charmers/trusty/wordpress-1", 1), params.StableChannel)
+	err = s.store.Publish(newResolvedURL("~charmers/trusty/wordpress-1", 1), nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 
 	// The stable permissions only allow charmers currently, so act as
@@ -1717,6 +1767,10 @@ func (s *APISuite) TestMetaEndpointsAny(c *gc.C) {
 				// endpoint not relevant.
 				continue
 			}
+			if ep.writerOnly {
+				// The request is made anonymously.
+				continue
+			}
 			flags = append(flags, "include="+ep.name)
 			val, err := ep.get(s.store, url)
 			if err != nil && ep.isExcluded(url) {
@@ -2128,7 +2182,7 @@ func (s *APISuite) TestServeExpandId(c *gc.C) {
 	s.addPublicCharmFromRepo(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-47", 47))
 	err := s.store.AddCharmWithArchive(newResolvedURL("cs:~charmers/trusty/wordpress-48", 48), storetesting.NewCharm(nil))
 	c.Assert(err, gc.IsNil)
-	err = s.store.Publish(newResolvedURL("cs:~charmers/trusty/wordpress-48", 48), params.DevelopmentChannel)
+	err = s.store.Publish(newResolvedURL("cs:~charmers/trusty/wordpress-48", 48), nil, params.DevelopmentChannel)
 	c.Assert(err, gc.IsNil)
 	s.addPublicCharmFromRepo(c, "multi-series", newResolvedURL("cs:~charmers/wordpress-5", 49))
 
@@ -3141,7 +3195,7 @@ func (s *APISuite) TestPublishSuccess(c *gc.C) {
 	id0 := newResolvedURL("cs:~bob/precise/wordpress-0", -1)
 	err := s.store.AddCharmWithArchive(id0, storetesting.NewCharm(nil))
 	c.Assert(err, gc.IsNil)
-	err = s.store.Publish(id0, params.DevelopmentChannel, params.StableChannel)
+	err = s.store.Publish(id0, params.DevelopmentChannel, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 
 	// Add an unpublished entity.
@@ -3321,7 +3375,7 @@ func (s *APISuite) TestURLChannelResolving(c *gc.C) {
 		err := s.store.AddCharmWithArchive(add.id, storetesting.NewCharm(nil))
 		c.Assert(err, gc.IsNil)
 		if add.channel != params.UnpublishedChannel {
-			err = s.store.Publish(add.id, add.channel)
+			err = s.store.Publish(add.id, nil, add.channel)
 			c.Assert(err, gc.IsNil)
 		}
 	}
@@ -3856,6 +3910,96 @@ func (s *APISuite) TestPromulgate(c *gc.C) {
 	}
 }
 
+func (s *APISuite) TestPublishAudit(c *gc.C) {
+	id := newResolvedURL("~charmers/trusty/wordpress-0", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/wordpress-0/publish"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Username: testUsername,
+		Password: testPassword,
+		Body: strings.NewReader(mustMarshalJSON(params.PublishRequest{
+			Channels: []params.Channel{params.DevelopmentChannel, params.StableChannel},
+		})),
+	})
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:     "admin",
+		Op:       audit.OpPublish,
+		Entity:   charm.MustParseURL("~charmers/trusty/wordpress-0"),
+		Channels: []params.Channel{params.DevelopmentChannel, params.StableChannel},
+	}})
+}
+
+func (s *APISuite) TestPutInfoAudit(c *gc.C) {
+	id := newResolvedURL("~charmers/precise/wordpress-23", 23)
+	s.addPublicCharmFromRepo(c, "wordpress", id)
+
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.assertPutAsAdmin(c, "precise/wordpress-23/meta/extra-info/foo", "bar")
+	s.assertPutAsAdmin(c, "precise/wordpress-23/meta/common-info/baz", nil)
+	s.assertPutAsAdmin(c, "precise/wordpress-23/meta/common-info", map[string]string{
+		"homepage": "http://wordpress.org",
+	})
+	chans := []params.Channel{params.UnpublishedChannel, params.StableChannel}
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:     "admin",
+		Op:       audit.OpSetExtraInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      "foo",
+	}, {
+		User:     "admin",
+		Op:       audit.OpSetCommonInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      "baz",
+	}, {
+		User:     "admin",
+		Op:       audit.OpSetCommonInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      "homepage",
+	}})
+}
+
+func (s *APISuite) TestPutInfoAuditUnpublished(c *gc.C) {
+	id := newResolvedURL("~charmers/precise/wordpress-23", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/extra-info/foo", "bar")
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/common-info/baz", "bar")
+	chans := []params.Channel{params.UnpublishedChannel}
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:     "admin",
+		Op:       audit.OpSetExtraInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      "foo",
+	}, {
+		User:     "admin",
+		Op:       audit.OpSetCommonInfo,
+		Entity:   &id.URL,
+		Channels: chans,
+		Key:      "baz",
+	}})
+}
+
 func (s *APISuite) TestEndpointRequiringBaseEntityWithPromulgatedId(c *gc.C) {
 	// Add a promulgated charm.
 	url := newResolvedURL("~charmers/precise/wordpress-23", 23)
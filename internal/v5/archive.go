@@ -21,6 +21,7 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
@@ -35,6 +36,9 @@ import (
 // DELETE id/archive
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#delete-idarchive
 //
+// POST id/archive?hash=sha384hash&upload=upload-id
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#committing-a-chunked-upload
+//
 // PUT id/archive?hash=sha384hash
 // This is like POST except that it puts the archive to a known revision
 // rather than choosing a new one. As this feature is to support legacy
@@ -43,7 +47,11 @@ import (
 func (h *ReqHandler) serveArchive(id *charm.URL, w http.ResponseWriter, req *http.Request) error {
 	resolveId := h.ResolvedIdHandler
 	switch req.Method {
-	// TODO: support DELETE when it is understood how that interacts with channels.
+	case "DELETE":
+		if id.Revision == -1 {
+			return badRequestf(nil, "revision not specified in entity URL %q", id)
+		}
+		return resolveId(h.AuthIdHandler(h.serveDeleteArchive))(id, w, req)
 	case "GET":
 		return resolveId(h.serveGetArchive)(id, w, req)
 	case "POST", "PUT":
@@ -53,6 +61,10 @@ func (h *ReqHandler) serveArchive(id *charm.URL, w http.ResponseWriter, req *htt
 		// is uploaded if we already know that the request is going to
 		// fail, but it is necessary to prevent some failures.
 		//
+		// Clients can avoid sending a large archive in one request
+		// by using a chunked upload (see serveUpload), so that only
+		// the current part need be sent again after a failure.
+		//
 		// TODO: investigate using 100-Continue statuses to prevent
 		// unnecessary uploads.
 		defer io.Copy(ioutil.Discard, req.Body)
@@ -128,9 +140,13 @@ func (h *ReqHandler) SendEntityArchive(id *router.ResolvedURL, w http.ResponseWr
 
 func (h *ReqHandler) serveDeleteArchive(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
 	if err := h.Store.DeleteEntity(id); err != nil {
-		return errgo.NoteMask(err, fmt.Sprintf("cannot delete %q", id.PreferredURL()), errgo.Is(params.ErrNotFound))
+		return errgo.NoteMask(err, fmt.Sprintf("cannot delete %q", id.PreferredURL()), errgo.Is(params.ErrNotFound), errgo.Is(params.ErrForbidden))
 	}
 	h.Store.IncCounterAsync(charmstore.EntityStatsKey(&id.URL, params.StatsArchiveDelete))
+	h.addAudit(audit.Entry{
+		Op:     audit.OpDelete,
+		Entity: &id.URL,
+	})
 	return nil
 }
 
@@ -158,9 +174,11 @@ func (h *ReqHandler) servePostArchive(id *charm.URL, w http.ResponseWriter, req
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
+	blob, size, upload, err := h.uploadedArchive(id, req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
 	}
+	defer blob.Close()
 
 	oldURL, oldHash, err := h.latestRevisionInfo(id)
 	if err != nil && errgo.Cause(err) != params.ErrNotFound {
@@ -169,6 +187,7 @@ func (h *ReqHandler) servePostArchive(id *charm.URL, w http.ResponseWriter, req
 	if oldHash == hash {
 		// The hash matches the hash of the latest revision, so
 		// no need to upload anything.
+		h.removeUpload(upload)
 		return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 			Id:            &oldURL.URL,
 			PromulgatedId: oldURL.PromulgatedURL(),
@@ -187,13 +206,18 @@ func (h *ReqHandler) servePostArchive(id *charm.URL, w http.ResponseWriter, req
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	if err := h.Store.UploadEntity(rid, req.Body, hash, req.ContentLength, nil); err != nil {
+	if err := h.Store.UploadEntity(rid, blob, hash, size, nil); err != nil {
 		return errgo.Mask(err,
 			errgo.Is(params.ErrDuplicateUpload),
 			errgo.Is(params.ErrEntityIdNotAllowed),
 			errgo.Is(params.ErrInvalidEntity),
 		)
 	}
+	h.removeUpload(upload)
+	h.addAudit(audit.Entry{
+		Op:     audit.OpUploadArchive,
+		Entity: &rid.URL,
+	})
 	return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id:            &rid.URL,
 		PromulgatedId: rid.PromulgatedURL(),
@@ -215,9 +239,6 @@ func (h *ReqHandler) servePutArchive(id *charm.URL, w http.ResponseWriter, req *
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
-	}
 	var chans []params.Channel
 	for _, c := range req.Form["channel"] {
 		c := params.Channel(c)
@@ -255,13 +276,24 @@ func (h *ReqHandler) servePutArchive(id *charm.URL, w http.ResponseWriter, req *
 		}
 		rid.PromulgatedRevision = pid.Revision
 	}
-	if err := h.Store.UploadEntity(rid, req.Body, hash, req.ContentLength, chans); err != nil {
+	blob, size, upload, err := h.uploadedArchive(id, req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	defer blob.Close()
+	if err := h.Store.UploadEntity(rid, blob, hash, size, chans); err != nil {
 		return errgo.Mask(err,
 			errgo.Is(params.ErrDuplicateUpload),
 			errgo.Is(params.ErrEntityIdNotAllowed),
 			errgo.Is(params.ErrInvalidEntity),
 		)
 	}
+	h.removeUpload(upload)
+	h.addAudit(audit.Entry{
+		Op:       audit.OpUploadArchive,
+		Entity:   &rid.URL,
+		Channels: chans,
+	})
 	return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id:            &rid.URL,
 		PromulgatedId: rid.PromulgatedURL(),
@@ -34,6 +34,7 @@ import (
 	"gopkg.in/macaroon.v1"
 	"gopkg.in/mgo.v2/bson"
 
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
@@ -485,6 +486,26 @@ func (s *ArchiveSuite) TestPutCharmWithChannel(c *gc.C) {
 	s.assertUploadCharm(c, "PUT", newResolvedURL("~charmers/precise/juju-gui-2", -1), "wordpress", []params.Channel{params.StableChannel, params.DevelopmentChannel})
 }
 
+func (s *ArchiveSuite) TestUploadAudit(c *gc.C) {
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-0", -1), "wordpress", nil)
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	s.assertUpload(c, "PUT", newResolvedURL("~charmers/precise/juju-gui-3", -1), ch.Path, []params.Channel{params.StableChannel})
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:   "admin",
+		Op:     audit.OpUploadArchive,
+		Entity: charm.MustParseURL("~charmers/precise/wordpress-0"),
+	}, {
+		User:     "admin",
+		Op:       audit.OpUploadArchive,
+		Entity:   charm.MustParseURL("~charmers/precise/juju-gui-3"),
+		Channels: []params.Channel{params.StableChannel},
+	}})
+}
+
 func (s *ArchiveSuite) TestPutCharmWithInvalidChannel(c *gc.C) {
 	s.assertUploadCharmError(
 		c,
@@ -602,7 +623,7 @@ func (s *ArchiveSuite) TestPostBundle(c *gc.C) {
 	} {
 		err := s.store.AddCharmWithArchive(rurl, storetesting.Charms.CharmArchive(c.MkDir(), rurl.URL.Name))
 		c.Assert(err, gc.IsNil)
-		err = s.store.Publish(rurl, params.StableChannel)
+		err = s.store.Publish(rurl, nil, params.StableChannel)
 		c.Assert(err, gc.IsNil)
 	}
 
@@ -637,9 +658,10 @@ func (s *ArchiveSuite) TestPostHashMismatch(c *gc.C) {
 		Body:         bytes.NewReader(content),
 		Username:     testUsername,
 		Password:     testPassword,
-		ExpectStatus: http.StatusInternalServerError,
+		ExpectStatus: http.StatusBadRequest,
 		ExpectBody: params.Error{
-			Message: "cannot put archive blob: hash mismatch",
+			Message: "archive hash mismatch",
+			Code:    params.ErrInvalidEntity,
 		},
 	})
 }
@@ -649,11 +671,26 @@ func invalidZip() io.ReadSeeker {
 }
 
 func (s *ArchiveSuite) TestPostInvalidCharmZip(c *gc.C) {
-	s.assertCannotUpload(c, "~charmers/precise/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read charm archive: zip: not a valid zip file")
+	s.assertCannotUpload(c, "~charmers/precise/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read archive: zip: not a valid zip file")
 }
 
 func (s *ArchiveSuite) TestPostInvalidBundleZip(c *gc.C) {
-	s.assertCannotUpload(c, "~charmers/bundle/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read bundle archive: zip: not a valid zip file")
+	s.assertCannotUpload(c, "~charmers/bundle/wordpress", invalidZip(), http.StatusBadRequest, params.ErrInvalidEntity, "cannot read archive: zip: not a valid zip file")
+}
+
+func (s *ArchiveSuite) TestPostZipBomb(c *gc.C) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "metadata.yaml",
+		Method: zip.Deflate,
+	})
+	c.Assert(err, gc.IsNil)
+	_, err = w.Write(make([]byte, 10<<20))
+	c.Assert(err, gc.IsNil)
+	err = zw.Close()
+	c.Assert(err, gc.IsNil)
+	s.assertCannotUpload(c, "~charmers/precise/wordpress", bytes.NewReader(buf.Bytes()), http.StatusBadRequest, params.ErrInvalidEntity, fmt.Sprintf("archive compression ratio %d exceeds maximum 100", (10<<20)/buf.Len()))
 }
 
 var postInvalidCharmMetadataTests = []struct {
@@ -1170,29 +1207,60 @@ func (s *ArchiveSuite) TestDelete(c *gc.C) {
 	err = s.store.DB.Entities().FindId(&url.URL).Select(bson.D{{"blobname", 1}}).One(&entity)
 	c.Assert(err, gc.IsNil)
 
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+
 	// Delete the charm using the API.
 	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
-		Handler:      s.srv,
-		URL:          storeURL(id + "/archive"),
-		Method:       "DELETE",
-		Username:     testUsername,
-		Password:     testPassword,
-		ExpectStatus: http.StatusMethodNotAllowed,
-		ExpectBody: params.Error{
-			Message: `DELETE not allowed`,
-			Code:    params.ErrMethodNotAllowed,
-		},
+		Handler:  s.srv,
+		URL:      storeURL(id + "/archive"),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
 	})
 
-	// TODO(mhilton) reinstate this check when DELETE is re-enabled.
-	//	// The entity has been deleted.
-	//	count, err := s.store.DB.Entities().FindId(url).Count()
-	//	c.Assert(err, gc.IsNil)
-	//	c.Assert(count, gc.Equals, 0)
-	//
-	//	// The blob has been deleted.
-	//	_, _, err = s.store.BlobStore.Open(entity.BlobName)
-	//	c.Assert(err, gc.ErrorMatches, "resource.*not found")
+	// The entity has been deleted.
+	count, err := s.store.DB.Entities().FindId(&url.URL).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+
+	// The blob has been deleted.
+	_, _, err = s.store.BlobStore.Open(entity.BlobName)
+	c.Assert(err, gc.ErrorMatches, "resource.*not found")
+
+	// The deletion has been audited.
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:   "admin",
+		Op:     audit.OpDelete,
+		Entity: &url.URL,
+	}})
+}
+
+func (s *ArchiveSuite) TestDeleteMultiSeriesCharm(c *gc.C) {
+	// Add a multi-series charm, which has a compatibility blob
+	// for pre-v5 clients as well as the main archive blob.
+	url := newResolvedURL("~charmers/multi-series-1", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("multi-series"))
+	c.Assert(err, gc.IsNil)
+	var entity mongodoc.Entity
+	err = s.store.DB.Entities().FindId(&url.URL).Select(bson.D{{"blobname", 1}}).One(&entity)
+	c.Assert(err, gc.IsNil)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/multi-series-1/archive"),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
+	})
+
+	// Both blobs have been deleted.
+	_, _, err = s.store.BlobStore.Open(entity.BlobName)
+	c.Assert(err, gc.ErrorMatches, "resource.*not found")
+	_, _, err = s.store.BlobStore.Open(entity.BlobName + ".pre-v5-suffix")
+	c.Assert(err, gc.ErrorMatches, "resource.*not found")
 }
 
 func (s *ArchiveSuite) TestDeleteSpecificCharm(c *gc.C) {
@@ -1206,16 +1274,11 @@ func (s *ArchiveSuite) TestDeleteSpecificCharm(c *gc.C) {
 
 	// Delete the second charm using the API.
 	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
-		Handler:      s.srv,
-		URL:          storeURL("~charmers/utopic/mysql-42/archive"),
-		Method:       "DELETE",
-		Username:     testUsername,
-		Password:     testPassword,
-		ExpectStatus: http.StatusMethodNotAllowed,
-		ExpectBody: params.Error{
-			Message: `DELETE not allowed`,
-			Code:    params.ErrMethodNotAllowed,
-		},
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/utopic/mysql-42/archive"),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
 	})
 
 	// The other two charms are still present in the database.
@@ -1238,71 +1301,128 @@ func (s *ArchiveSuite) TestDeleteNotFound(c *gc.C) {
 		Method:       "DELETE",
 		Username:     testUsername,
 		Password:     testPassword,
-		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectStatus: http.StatusNotFound,
 		ExpectBody: params.Error{
-			Message: `DELETE not allowed`,
-			Code:    params.ErrMethodNotAllowed,
+			Message: `no matching charm or bundle for cs:~charmers/utopic/no-such-0`,
+			Code:    params.ErrNotFound,
 		},
 	})
 }
 
-// TODO(mhilton) reinstate this test when DELETE is re-enabled.
-//func (s *ArchiveSuite) TestDeleteError(c *gc.C) {
-//	// Add a charm to the database (not including the archive).
-//	id := "~charmers/utopic/mysql-42"
-//	url := newResolvedURL(id, -1)
-//	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
-//	c.Assert(err, gc.IsNil)
-//
-//	err = s.store.DB.Entities().UpdateId(&url.URL, bson.M{
-//		"$set": bson.M{
-//			"blobname": "no-such-name",
-//		},
-//	})
-//	c.Assert(err, gc.IsNil)
-//	// TODO update entity to change BlobName to "no-such-name"
-//
-//	// Try to delete the charm using the API.
-//	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
-//		Handler:      s.srv,
-//		URL:          storeURL(id + "/archive"),
-//		Method:       "DELETE",
-//		Username:     testUsername,
-//		Password:     testPassword,
-//		ExpectStatus: http.StatusInternalServerError,
-//		ExpectBody: params.Error{
-//			Message: `cannot delete "cs:~charmers/utopic/mysql-42": cannot remove blob no-such-name: resource at path "global/no-such-name" not found`,
-//		},
-//	})
-//}
-
-// TODO(mhilton) reinstate this test when DELETE is re-enabled
-//.func (s *ArchiveSuite) TestDeleteCounters(c *gc.C) {
-//	if !storetesting.MongoJSEnabled() {
-//		c.Skip("MongoDB JavaScript not available")
-//	}
-//
-//	// Add a charm to the database (including the archive).
-//	id := "~charmers/utopic/mysql-42"
-//	err := s.store.AddCharmWithArchive(
-//		newResolvedURL(id, -1),
-//		storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
-//	c.Assert(err, gc.IsNil)
-//
-//	// Delete the charm using the API.
-//	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
-//		Handler:  s.srv,
-//		Method:   "DELETE",
-//		URL:      storeURL(id + "/archive"),
-//		Username: testUsername,
-//		Password: testPassword,
-//	})
-//	c.Assert(rec.Code, gc.Equals, http.StatusOK)
-//
-//	// Check that the delete count for the entity has been updated.
-//	key := []string{params.StatsArchiveDelete, "utopic", "mysql", "charmers", "42"}
-//	stats.CheckCounterSum(c, s.store, key, false, 1)
-//}
+func (s *ArchiveSuite) TestDeleteNoRevision(c *gc.C) {
+	err := s.store.AddCharmWithArchive(
+		newResolvedURL("~charmers/utopic/mysql-42", -1),
+		storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/utopic/mysql/archive"),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Message: `revision not specified in entity URL "cs:~charmers/utopic/mysql"`,
+			Code:    params.ErrBadRequest,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestDeletePublished(c *gc.C) {
+	url := newResolvedURL("~charmers/utopic/mysql-42", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(url, nil, params.DevelopmentChannel, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+
+	// The current revision in a channel cannot be deleted.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/utopic/mysql-42/archive"),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody: params.Error{
+			Message: `cannot delete "cs:~charmers/utopic/mysql-42": entity is currently published in channels development, stable`,
+			Code:    params.ErrForbidden,
+		},
+	})
+
+	// Once a newer revision has been published to the same
+	// channels, the old revision can be deleted.
+	url1 := newResolvedURL("~charmers/utopic/mysql-43", -1)
+	err = s.store.AddCharmWithArchive(url1, storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(url1, nil, params.DevelopmentChannel, params.StableChannel)
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/utopic/mysql-42/archive"),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
+	})
+	count, err := s.store.DB.Entities().FindId(&url.URL).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(count, gc.Equals, 0)
+}
+
+func (s *ArchiveSuite) TestDeleteError(c *gc.C) {
+	// Add a charm to the database (not including the archive).
+	id := "~charmers/utopic/mysql-42"
+	url := newResolvedURL(id, -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+
+	err = s.store.DB.Entities().UpdateId(&url.URL, bson.M{
+		"$set": bson.M{
+			"blobname": "no-such-name",
+		},
+	})
+	c.Assert(err, gc.IsNil)
+
+	// Try to delete the charm using the API.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL(id + "/archive"),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusInternalServerError)
+	var perr params.Error
+	err = json.Unmarshal(rec.Body.Bytes(), &perr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(perr.Message, gc.Matches, `cannot delete "cs:~charmers/utopic/mysql-42": cannot remove blob no-such-name: .*not found`)
+}
+
+func (s *ArchiveSuite) TestDeleteCounters(c *gc.C) {
+	if !storetesting.MongoJSEnabled() {
+		c.Skip("MongoDB JavaScript not available")
+	}
+
+	// Add a charm to the database (including the archive).
+	id := "~charmers/utopic/mysql-42"
+	err := s.store.AddCharmWithArchive(
+		newResolvedURL(id, -1),
+		storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+
+	// Delete the charm using the API.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		Method:   "DELETE",
+		URL:      storeURL(id + "/archive"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+
+	// Check that the delete count for the entity has been updated.
+	key := []string{params.StatsArchiveDelete, "utopic", "mysql", "charmers", "42"}
+	stats.CheckCounterSum(c, s.store, key, false, 1)
+}
 
 type basicAuthArchiveSuite struct {
 	commonSuite
@@ -1314,15 +1434,14 @@ func (s *basicAuthArchiveSuite) TestPostAuthErrors(c *gc.C) {
 	s.checkAuthErrors(c, "POST", "~charmers/utopic/django/archive")
 }
 
-// TODO(mhilton) reinstate this test when DELETE is re-enabled.
-//func (s *basicAuthArchiveSuite) TestDeleteAuthErrors(c *gc.C) {
-//	err := s.store.AddCharmWithArchive(
-//		newResolvedURL("~charmers/utopic/django-42", 42),
-//		storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"),
-//	)
-//	c.Assert(err, gc.IsNil)
-//	s.checkAuthErrors(c, "DELETE", "utopic/django-42/archive")
-//}
+func (s *basicAuthArchiveSuite) TestDeleteAuthErrors(c *gc.C) {
+	err := s.store.AddCharmWithArchive(
+		newResolvedURL("~charmers/utopic/django-42", 42),
+		storetesting.Charms.CharmArchive(c.MkDir(), "wordpress"),
+	)
+	c.Assert(err, gc.IsNil)
+	s.checkAuthErrors(c, "DELETE", "utopic/django-42/archive")
+}
 
 func (s *basicAuthArchiveSuite) TestPostErrorReadsFully(c *gc.C) {
 	h := s.handler(c)
@@ -0,0 +1,80 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+// maxAuditLimit holds the maximum number of audit
+// entries returned by a single audit request.
+var maxAuditLimit = 1000
+
+// GET /audit[?user=user][&op=op][&entity=id][&after=time][&before=time][&skip=n][&limit=n]
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#get-audit
+func (h *ReqHandler) serveAudit(_ http.Header, req *http.Request) (interface{}, error) {
+	// Only admins may read the audit log.
+	if _, err := h.authorize(req, nil, true, nil); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if req.Method != "GET" {
+		return nil, errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	var filter charmstore.AuditFilter
+	var err error
+	filter.Limit, err = intValue(req.Form.Get("limit"), 1, maxAuditLimit)
+	if err != nil {
+		return nil, badRequestf(err, "invalid limit value")
+	}
+	if filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+	filter.Skip, err = intValue(req.Form.Get("skip"), 0, 0)
+	if err != nil {
+		return nil, badRequestf(err, "invalid skip value")
+	}
+	filter.User = req.Form.Get("user")
+	filter.Op = audit.Operation(req.Form.Get("op"))
+	if id := req.Form.Get("entity"); id != "" {
+		filter.Entity, err = charm.ParseURL(id)
+		if err != nil {
+			return nil, badRequestf(err, "invalid entity value")
+		}
+	}
+	if filter.After, err = parseAuditTime(req.Form.Get("after")); err != nil {
+		return nil, badRequestf(err, "invalid after value")
+	}
+	if filter.Before, err = parseAuditTime(req.Form.Get("before")); err != nil {
+		return nil, badRequestf(err, "invalid before value")
+	}
+	entries, err := h.Store.AuditEntries(filter)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for i := range entries {
+		entries[i].Time = entries[i].Time.UTC()
+	}
+	return entries, nil
+}
+
+// parseAuditTime parses a time in RFC3339 format. The
+// zero time is returned if s is empty.
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errgo.Mask(err)
+	}
+	return t, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+)
+
+type auditSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&auditSuite{})
+
+func (s *auditSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+var auditEntries = []audit.Entry{{
+	User:   "bob",
+	Op:     audit.OpSetPerm,
+	Entity: charm.MustParseURL("cs:~bob/trusty/wordpress-0"),
+	ACL: &audit.ACL{
+		Read: []string{"everyone"},
+	},
+}, {
+	User:     "alice",
+	Op:       audit.OpPublish,
+	Entity:   charm.MustParseURL("cs:~bob/trusty/wordpress-1"),
+	Channels: []params.Channel{params.StableChannel},
+}, {
+	User:     "bob",
+	Op:       audit.OpPublish,
+	Entity:   charm.MustParseURL("cs:~bob/trusty/wordpress-1"),
+	Channels: []params.Channel{params.DevelopmentChannel},
+}}
+
+var getAuditTests = []struct {
+	about         string
+	querystring   string
+	expectEntries []int
+}{{
+	about:         "all entries",
+	expectEntries: []int{0, 1, 2},
+}, {
+	about:         "filter by user",
+	querystring:   "?user=bob",
+	expectEntries: []int{0, 2},
+}, {
+	about:         "filter by op",
+	querystring:   "?op=publish",
+	expectEntries: []int{1, 2},
+}, {
+	about:         "filter by entity",
+	querystring:   "?entity=~bob/trusty/wordpress-1",
+	expectEntries: []int{1, 2},
+}, {
+	about:         "filter by user and op",
+	querystring:   "?user=alice&op=publish",
+	expectEntries: []int{1},
+}, {
+	about:         "skip and limit",
+	querystring:   "?skip=1&limit=1",
+	expectEntries: []int{1},
+}, {
+	about:         "time range excluding all entries",
+	querystring:   "?before=2000-01-01T00:00:00Z",
+	expectEntries: []int{},
+}}
+
+func (s *auditSuite) TestGetAudit(c *gc.C) {
+	beforeAdding := time.Now().Add(-time.Second)
+	for _, e := range auditEntries {
+		s.store.AddAudit(e)
+	}
+	afterAdding := time.Now().Add(time.Second)
+
+	for i, test := range getAuditTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler:  s.srv,
+			URL:      storeURL("audit" + test.querystring),
+			Username: testUsername,
+			Password: testPassword,
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+
+		var entries []audit.Entry
+		err := json.Unmarshal(rec.Body.Bytes(), &entries)
+		c.Assert(err, gc.IsNil)
+
+		// Check and then reset the entry times so that the
+		// entries can be more easily compared.
+		for i := range entries {
+			c.Assert(entries[i].Time, jc.TimeBetween(beforeAdding, afterAdding))
+			entries[i].Time = time.Time{}
+		}
+		expect := make([]audit.Entry, len(test.expectEntries))
+		for i, n := range test.expectEntries {
+			expect[i] = auditEntries[n]
+		}
+		c.Assert(entries, jc.DeepEquals, expect)
+	}
+}
+
+var getAuditErrorsTests = []struct {
+	about         string
+	querystring   string
+	expectMessage string
+}{{
+	about:         "invalid limit",
+	querystring:   "?limit=0",
+	expectMessage: "invalid limit value: value must be >= 1",
+}, {
+	about:         "invalid skip",
+	querystring:   "?skip=-1",
+	expectMessage: "invalid skip value: value must be >= 0",
+}, {
+	about:         "invalid entity",
+	querystring:   "?entity=no-such:reference",
+	expectMessage: `invalid entity value: charm or bundle URL has invalid schema: "no-such:reference"`,
+}, {
+	about:         "invalid after time",
+	querystring:   "?after=yesterday",
+	expectMessage: `invalid after value: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+}, {
+	about:         "invalid before time",
+	querystring:   "?before=tomorrow",
+	expectMessage: `invalid before value: parsing time "tomorrow" as "2006-01-02T15:04:05Z07:00": cannot parse "tomorrow" as "2006"`,
+}}
+
+func (s *auditSuite) TestGetAuditErrors(c *gc.C) {
+	for i, test := range getAuditErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("audit" + test.querystring),
+			Username:     testUsername,
+			Password:     testPassword,
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: test.expectMessage,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}
+
+func (s *auditSuite) TestGetAuditLimitIsClamped(c *gc.C) {
+	s.PatchValue(v5.MaxAuditLimit, 2)
+	for _, e := range auditEntries {
+		s.store.AddAudit(e)
+	}
+	for _, query := range []string{"", "?limit=3", "?limit=1000000"} {
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler:  s.srv,
+			URL:      storeURL("audit" + query),
+			Username: testUsername,
+			Password: testPassword,
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+		var entries []audit.Entry
+		err := json.Unmarshal(rec.Body.Bytes(), &entries)
+		c.Assert(err, gc.IsNil)
+		c.Assert(entries, gc.HasLen, 2, gc.Commentf("query %q", query))
+	}
+}
+
+func (s *auditSuite) TestGetAuditMethodNotAllowed(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("audit"),
+		Method:       "POST",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: params.Error{
+			Message: "POST not allowed",
+			Code:    params.ErrMethodNotAllowed,
+		},
+	})
+}
+
+func (s *auditSuite) TestGetAuditUnauthorizedError(c *gc.C) {
+	s.AssertEndpointAuth(c, httptesting.JSONCallParams{
+		URL:          storeURL("audit"),
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   []audit.Entry{},
+	})
+}
@@ -102,6 +102,10 @@ type commonSuite struct {
 	// maxMgoSessions specifies the value that will be given
 	// to config.MaxMgoSessions when calling charmstore.NewServer.
 	maxMgoSessions int
+
+	// minUploadPartSize specifies the value that will be given
+	// to config.MinUploadPartSize when calling charmstore.NewServer.
+	minUploadPartSize int64
 }
 
 func (s *commonSuite) SetUpSuite(c *gc.C) {
@@ -151,10 +155,11 @@ func (s *commonSuite) TearDownTest(c *gc.C) {
 // startServer creates a new charmstore server.
 func (s *commonSuite) startServer(c *gc.C) {
 	config := charmstore.ServerParams{
-		AuthUsername:     testUsername,
-		AuthPassword:     testPassword,
-		StatsCacheMaxAge: time.Nanosecond,
-		MaxMgoSessions:   s.maxMgoSessions,
+		AuthUsername:      testUsername,
+		AuthPassword:      testPassword,
+		StatsCacheMaxAge:  time.Nanosecond,
+		MaxMgoSessions:    s.maxMgoSessions,
+		MinUploadPartSize: s.minUploadPartSize,
 	}
 	keyring := bakery.NewPublicKeyRing()
 	if s.enableIdentity {
@@ -225,7 +230,7 @@ func (s *commonSuite) addPublicCharm(c *gc.C, ch charm.Charm, rurl *router.Resol
 func (s *commonSuite) setPublic(c *gc.C, rurl *router.ResolvedURL) {
 	err := s.store.SetPerms(&rurl.URL, "stable.read", params.Everyone)
 	c.Assert(err, gc.IsNil)
-	err = s.store.Publish(rurl, params.StableChannel)
+	err = s.store.Publish(rurl, nil, params.StableChannel)
 	c.Assert(err, gc.IsNil)
 }
 
@@ -0,0 +1,148 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// ChangesEventsResponse holds the response from a
+// changes/events request.
+type ChangesEventsResponse struct {
+	// Events holds the change events, oldest first.
+	Events []ChangeEvent
+
+	// Cursor holds the cursor to pass as the "since"
+	// parameter to retrieve subsequent events.
+	Cursor string
+}
+
+// ChangeEvent holds a single change event returned
+// from a changes/events request.
+type ChangeEvent struct {
+	// Kind holds the kind of change, for instance "publish".
+	Kind string
+
+	// Id holds the id of the entity, or base entity,
+	// that the change applies to.
+	Id *charm.URL
+
+	// Channels holds the channels affected by the change.
+	Channels []params.Channel `json:",omitempty"`
+
+	// Time holds the time the change was made.
+	Time time.Time
+}
+
+// maxEventsLimit holds the maximum number of events
+// returned by a single changes/events request.
+var maxEventsLimit = 1000
+
+// GET changes/events[?since=cursor][&channel=channel][&limit=count]
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#get-changesevents
+func (h *ReqHandler) serveChangesEvents(_ http.Header, req *http.Request) (interface{}, error) {
+	limit, err := intValue(req.Form.Get("limit"), 1, maxEventsLimit)
+	if err != nil {
+		return nil, badRequestf(err, "invalid limit value")
+	}
+	if limit > maxEventsLimit {
+		limit = maxEventsLimit
+	}
+	var sinceId int64
+	since := req.Form.Get("since")
+	if since != "" {
+		sinceId, err = strconv.ParseInt(since, 10, 64)
+		if err != nil || sinceId < 0 {
+			return nil, badRequestf(nil, "invalid since value %q", since)
+		}
+	}
+	if sinceId > 0 {
+		// Following the feed from a cursor whose subsequent
+		// events have expired would silently skip changes.
+		expired, err := h.Store.EventsExpired(sinceId)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot check events")
+		}
+		if expired {
+			return nil, errgo.WithCausef(nil, router.ErrEventsExpired, "events since %d have expired", sinceId)
+		}
+	}
+	channel := params.Channel(req.Form.Get("channel"))
+	switch channel {
+	case params.NoChannel, params.UnpublishedChannel, params.DevelopmentChannel, params.StableChannel:
+	default:
+		return nil, badRequestf(nil, "invalid channel value %q", channel)
+	}
+	resp := ChangesEventsResponse{
+		Events: []ChangeEvent{},
+		Cursor: since,
+	}
+	iter := h.Store.EventsQuery(sinceId, channel).Iter()
+	for len(resp.Events) < limit {
+		var event mongodoc.Event
+		if !iter.Next(&event) {
+			break
+		}
+		// The cursor moves past events that the current user
+		// cannot see, so that following requests do not
+		// examine them again.
+		resp.Cursor = strconv.FormatInt(event.Id, 10)
+		if !h.canReadEvent(&event, req) {
+			continue
+		}
+		resp.Events = append(resp.Events, ChangeEvent{
+			Kind:     string(event.Kind),
+			Id:       event.URL,
+			Channels: event.Channels,
+			Time:     event.Time.UTC(),
+		})
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve events")
+	}
+	return resp, nil
+}
+
+// canReadEvent reports whether the given request is allowed to see
+// the given event. An event is visible when its base entity is readable
+// in any of the channels affected by the change. Promulgation changes
+// are checked against the stable channel and other changes that are not
+// tied to a channel, such as deletions, against the unpublished channel.
+func (h *ReqHandler) canReadEvent(event *mongodoc.Event, req *http.Request) bool {
+	baseEntity, err := h.Cache.BaseEntity(event.URL, charmstore.FieldSelector("channelacls"))
+	if err != nil {
+		if errgo.Cause(err) != params.ErrNotFound {
+			logger.Errorf("cannot retrieve base entity %q for authorization: %v", event.URL, err)
+		}
+		// Only admins can see events for base entities that no
+		// longer exist.
+		_, err := h.authorize(req, nil, true, nil)
+		return err == nil
+	}
+	chans := event.Channels
+	if len(chans) == 0 {
+		switch event.Kind {
+		case mongodoc.PromulgateEvent, mongodoc.UnpromulgateEvent:
+			chans = []params.Channel{params.StableChannel}
+		default:
+			chans = []params.Channel{params.UnpublishedChannel}
+		}
+	}
+	for _, ch := range chans {
+		if _, err := h.authorize(req, baseEntity.ChannelACLs[ch].Read, false, nil); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+)
+
+type eventsSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&eventsSuite{})
+
+func (s *eventsSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *eventsSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.PatchValue(&charmstore.EventVisibilityDelay, time.Duration(0))
+}
+
+// addEntities adds a public charm published to the stable channel and
+// a private charm published to the development channel.
+func (s *eventsSuite) addEntities(c *gc.C) {
+	s.addPublicCharmFromRepo(c, "wordpress", newResolvedURL("~charmers/trusty/wordpress-0", -1))
+	id := newResolvedURL("~bob/trusty/mysql-0", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(id, nil, params.DevelopmentChannel)
+	c.Assert(err, gc.IsNil)
+}
+
+var getChangesEventsTests = []struct {
+	about        string
+	querystring  string
+	asAdmin      bool
+	expectEvents []v5.ChangeEvent
+}{{
+	about:   "all events as admin",
+	asAdmin: true,
+	expectEvents: []v5.ChangeEvent{{
+		Kind:     "set-perm",
+		Id:       charm.MustParseURL("cs:~charmers/wordpress"),
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Kind:     "publish",
+		Id:       charm.MustParseURL("cs:~charmers/trusty/wordpress-0"),
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Kind:     "publish",
+		Id:       charm.MustParseURL("cs:~bob/trusty/mysql-0"),
+		Channels: []params.Channel{params.DevelopmentChannel},
+	}},
+}, {
+	about: "events for entities that cannot be read are omitted",
+	expectEvents: []v5.ChangeEvent{{
+		Kind:     "set-perm",
+		Id:       charm.MustParseURL("cs:~charmers/wordpress"),
+		Channels: []params.Channel{params.StableChannel},
+	}, {
+		Kind:     "publish",
+		Id:       charm.MustParseURL("cs:~charmers/trusty/wordpress-0"),
+		Channels: []params.Channel{params.StableChannel},
+	}},
+}, {
+	about:       "filter by channel",
+	querystring: "?channel=development",
+	asAdmin:     true,
+	expectEvents: []v5.ChangeEvent{{
+		Kind:     "publish",
+		Id:       charm.MustParseURL("cs:~bob/trusty/mysql-0"),
+		Channels: []params.Channel{params.DevelopmentChannel},
+	}},
+}, {
+	about:       "limit",
+	querystring: "?limit=1",
+	asAdmin:     true,
+	expectEvents: []v5.ChangeEvent{{
+		Kind:     "set-perm",
+		Id:       charm.MustParseURL("cs:~charmers/wordpress"),
+		Channels: []params.Channel{params.StableChannel},
+	}},
+}}
+
+func (s *eventsSuite) TestGetChangesEvents(c *gc.C) {
+	beforeAdding := time.Now().Add(-time.Second)
+	s.addEntities(c)
+	afterAdding := time.Now().Add(time.Second)
+
+	for i, test := range getChangesEventsTests {
+		c.Logf("test %d: %s", i, test.about)
+		resp := s.getChangesEvents(c, test.querystring, test.asAdmin)
+		for i := range resp.Events {
+			c.Assert(resp.Events[i].Time, jc.TimeBetween(beforeAdding, afterAdding))
+			resp.Events[i].Time = time.Time{}
+		}
+		c.Assert(resp.Events, jc.DeepEquals, test.expectEvents)
+	}
+}
+
+func (s *eventsSuite) TestGetChangesEventsFollowCursor(c *gc.C) {
+	s.addEntities(c)
+
+	var kinds []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		resp := s.getChangesEvents(c, "?limit=1&since="+cursor, true)
+		if len(resp.Events) == 0 {
+			// The cursor does not move when there are no more events.
+			c.Assert(resp.Cursor, gc.Equals, cursor)
+			break
+		}
+		c.Assert(resp.Cursor, gc.Not(gc.Equals), cursor)
+		cursor = resp.Cursor
+		kinds = append(kinds, resp.Events[0].Kind)
+	}
+	c.Assert(kinds, jc.DeepEquals, []string{"set-perm", "publish", "publish"})
+	c.Assert(cursor, gc.Equals, "3")
+
+	// New events are returned from the last cursor.
+	err := s.store.SetPromulgated(newResolvedURL("~charmers/trusty/wordpress-0", -1), true)
+	c.Assert(err, gc.IsNil)
+	resp := s.getChangesEvents(c, "?since="+cursor, true)
+	c.Assert(resp.Events, gc.HasLen, 1)
+	c.Assert(resp.Events[0].Kind, gc.Equals, "promulgate")
+	c.Assert(resp.Events[0].Id, jc.DeepEquals, charm.MustParseURL("cs:~charmers/wordpress"))
+}
+
+func (s *eventsSuite) TestGetChangesEventsExpired(c *gc.C) {
+	s.addEntities(c)
+
+	// Simulate the expiry of the oldest events.
+	_, err := s.store.DB.Events().RemoveAll(bson.D{{"_id", bson.D{{"$lte", 2}}}})
+	c.Assert(err, gc.IsNil)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("changes/events?since=1"),
+		ExpectStatus: http.StatusGone,
+		ExpectBody: params.Error{
+			Message: "events since 1 have expired",
+			Code:    router.ErrEventsExpired,
+		},
+	})
+
+	// Following from the last expired event misses nothing.
+	resp := s.getChangesEvents(c, "?since=2", true)
+	c.Assert(resp.Events, gc.HasLen, 1)
+	c.Assert(resp.Cursor, gc.Equals, "3")
+
+	// Without a cursor, the retained events are returned.
+	resp = s.getChangesEvents(c, "", true)
+	c.Assert(resp.Events, gc.HasLen, 1)
+}
+
+func (s *eventsSuite) TestGetChangesEventsLimitIsClamped(c *gc.C) {
+	s.PatchValue(v5.MaxEventsLimit, 2)
+	s.addEntities(c)
+	for _, query := range []string{"", "?limit=3", "?limit=1000000"} {
+		resp := s.getChangesEvents(c, query, true)
+		c.Assert(resp.Events, gc.HasLen, 2, gc.Commentf("query %q", query))
+	}
+}
+
+func (s *eventsSuite) TestGetChangesEventsPutPerm(c *gc.C) {
+	id := newResolvedURL("~charmers/trusty/wordpress-0", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.NewCharm(nil))
+	c.Assert(err, gc.IsNil)
+	s.assertPutAsAdmin(c, "~charmers/trusty/wordpress-0/meta/perm", params.PermRequest{
+		Read:  []string{"bob"},
+		Write: []string{"bob"},
+	})
+	resp := s.getChangesEvents(c, "", true)
+	c.Assert(resp.Events, gc.HasLen, 1)
+	c.Assert(resp.Events[0].Kind, gc.Equals, "set-perm")
+	c.Assert(resp.Events[0].Id, jc.DeepEquals, charm.MustParseURL("cs:~charmers/wordpress"))
+	c.Assert(resp.Events[0].Channels, jc.DeepEquals, []params.Channel{params.UnpublishedChannel})
+}
+
+var getChangesEventsErrorsTests = []struct {
+	about         string
+	querystring   string
+	expectMessage string
+}{{
+	about:         "invalid limit",
+	querystring:   "?limit=0",
+	expectMessage: "invalid limit value: value must be >= 1",
+}, {
+	about:         "invalid cursor",
+	querystring:   "?since=bad-wolf",
+	expectMessage: `invalid since value "bad-wolf"`,
+}, {
+	about:         "negative cursor",
+	querystring:   "?since=-1",
+	expectMessage: `invalid since value "-1"`,
+}, {
+	about:         "invalid channel",
+	querystring:   "?channel=no-such",
+	expectMessage: `invalid channel value "no-such"`,
+}}
+
+func (s *eventsSuite) TestGetChangesEventsErrors(c *gc.C) {
+	for i, test := range getChangesEventsErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("changes/events" + test.querystring),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: test.expectMessage,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}
+
+func (s *eventsSuite) getChangesEvents(c *gc.C, querystring string, asAdmin bool) v5.ChangesEventsResponse {
+	p := httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("changes/events" + querystring),
+	}
+	if asAdmin {
+		p.Username = testUsername
+		p.Password = testPassword
+	}
+	rec := httptesting.DoRequest(c, p)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.Bytes()))
+	var resp v5.ChangesEventsResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	return resp
+}
@@ -7,6 +7,8 @@ var (
 	ProcessIcon          = processIcon
 	ErrProbablyNotXML    = errProbablyNotXML
 	TestAddAuditCallback = &testAddAuditCallback
+	MaxAuditLimit        = &maxAuditLimit
+	MaxEventsLimit       = &maxEventsLimit
 
 	GetNewPromulgatedRevision = (*ReqHandler).getNewPromulgatedRevision
 
@@ -0,0 +1,157 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"encoding/json"
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+)
+
+type interestingSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&interestingSuite{})
+
+func (s *interestingSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *interestingSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	s.addPublicCharmFromRepo(c, "wordpress", newResolvedURL("~charmers/precise/wordpress-23", 23))
+	s.addPublicCharmFromRepo(c, "mysql", newResolvedURL("~bob/trusty/mysql-1", -1))
+	s.addPublicCharmFromRepo(c, "varnish", newResolvedURL("~bob/trusty/varnish-2", -1))
+	err := s.store.SetPromulgated(newResolvedURL("~charmers/precise/wordpress-23", 23), true)
+	c.Assert(err, gc.IsNil)
+	err = s.store.RefreshInteresting()
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *interestingSuite) TestInteresting(c *gc.C) {
+	s.assertGet(c, "search/interesting?include=archive-size", params.SearchResponse{
+		Total: 3,
+		Results: []params.EntityResult{{
+			Id:   charm.MustParseURL("precise/wordpress-23"),
+			Meta: map[string]interface{}{"archive-size": s.archiveSize(c, "~charmers/precise/wordpress-23")},
+		}, {
+			Id:   charm.MustParseURL("~bob/trusty/mysql-1"),
+			Meta: map[string]interface{}{"archive-size": s.archiveSize(c, "~bob/trusty/mysql-1")},
+		}, {
+			Id:   charm.MustParseURL("~bob/trusty/varnish-2"),
+			Meta: map[string]interface{}{"archive-size": s.archiveSize(c, "~bob/trusty/varnish-2")},
+		}},
+	})
+}
+
+func (s *interestingSuite) TestInterestingWithFeaturedAndLimit(c *gc.C) {
+	s.assertPutAsAdmin(c, "search/featured", []string{"~bob/varnish"})
+	s.assertGet(c, "search/interesting?limit=2", params.SearchResponse{
+		Total: 3,
+		Results: []params.EntityResult{{
+			Id: charm.MustParseURL("~bob/trusty/varnish-2"),
+		}, {
+			Id: charm.MustParseURL("precise/wordpress-23"),
+		}},
+	})
+}
+
+func (s *interestingSuite) TestInterestingInvalidParameters(c *gc.C) {
+	for query, expectMessage := range map[string]string{
+		"limit=0":    "invalid limit parameter: expected integer greater than zero",
+		"text=mysql": "invalid parameter: text",
+	} {
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("search/interesting?" + query),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: expectMessage,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}
+
+func (s *interestingSuite) TestPutAndGetFeatured(c *gc.C) {
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.assertPutAsAdmin(c, "search/featured", []string{"~bob/trusty/varnish-2", "~charmers/wordpress"})
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User: "admin",
+		Op:   audit.OpSetFeatured,
+	}})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("search/featured"),
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: []*charm.URL{
+			charm.MustParseURL("~bob/varnish"),
+			charm.MustParseURL("~charmers/wordpress"),
+		},
+	})
+}
+
+func (s *interestingSuite) TestPutFeaturedErrors(c *gc.C) {
+	for body, expect := range map[string]params.Error{
+		`["~bob/no-such"]`: {
+			Message: `base entity not found`,
+			Code:    params.ErrNotFound,
+		},
+		`["wordpress"]`: {
+			Message: `featured entity id "cs:wordpress" does not specify a user`,
+			Code:    params.ErrBadRequest,
+		},
+	} {
+		var urls []string
+		err := json.Unmarshal([]byte(body), &urls)
+		c.Assert(err, gc.IsNil)
+		status := http.StatusBadRequest
+		if expect.Code == params.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:  s.srv,
+			URL:      storeURL("search/featured"),
+			Method:   "PUT",
+			Username: testUsername,
+			Password: testPassword,
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			JSONBody:     urls,
+			ExpectStatus: status,
+			ExpectBody:   expect,
+		})
+	}
+}
+
+func (s *interestingSuite) TestFeaturedUnauthorizedError(c *gc.C) {
+	s.AssertEndpointAuth(c, httptesting.JSONCallParams{
+		URL:          storeURL("search/featured"),
+		ExpectStatus: http.StatusOK,
+		ExpectBody:   []*charm.URL{},
+	})
+}
+
+// archiveSize returns the archive-size metadata of the entity with
+// the given id.
+func (s *interestingSuite) archiveSize(c *gc.C, id string) params.ArchiveSizeResponse {
+	entity, err := s.store.FindEntity(newResolvedURL(id, -1), nil)
+	c.Assert(err, gc.IsNil)
+	return params.ArchiveSizeResponse{Size: entity.Size}
+}
@@ -159,6 +159,10 @@ func writeString(w io.Writer, content string) error {
 	return err
 }
 
+// integrityType holds the API log type of the logs recorded
+// by the blob integrity scrubber.
+const integrityType params.LogType = "integrity"
+
 // TODO (frankban): use slices instead of maps for the data structures below.
 var (
 	// mongodocLogLevels maps internal mongodoc log levels to API ones.
@@ -177,11 +181,13 @@ var (
 	mongodocLogTypes = map[mongodoc.LogType]params.LogType{
 		mongodoc.IngestionType:        params.IngestionType,
 		mongodoc.LegacyStatisticsType: params.LegacyStatisticsType,
+		mongodoc.IntegrityType:        integrityType,
 	}
 	// paramsLogTypes maps API params log types to internal mongodoc ones.
 	paramsLogTypes = map[params.LogType]mongodoc.LogType{
 		params.IngestionType:        mongodoc.IngestionType,
 		params.LegacyStatisticsType: mongodoc.LegacyStatisticsType,
+		integrityType:               mongodoc.IntegrityType,
 	}
 )
 
@@ -218,6 +218,7 @@ var paramsLogLevels = map[params.LogLevel]mongodoc.LogLevel{
 var paramsLogTypes = map[params.LogType]mongodoc.LogType{
 	params.IngestionType:        mongodoc.IngestionType,
 	params.LegacyStatisticsType: mongodoc.LegacyStatisticsType,
+	"integrity":                 mongodoc.IntegrityType,
 }
 
 func (s *logSuite) TestGetLogs(c *gc.C) {
@@ -4,9 +4,15 @@
 package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
 
 import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 
+	"github.com/juju/httprequest"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v6-unstable/resource"
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
@@ -28,33 +34,51 @@ func (h *ReqHandler) metaResources(entity *mongodoc.Entity, id *router.ResolvedU
 	}
 
 	// TODO(ericsnow) Handle flags.
-	// TODO(ericsnow) Use h.Store.ListResources() once that exists.
-	resources, err := basicListResources(entity)
+	ch, err := h.entityChannel(id)
 	if err != nil {
-		return nil, err
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	docs, err := h.Store.ListResources(entity, ch)
+	if err != nil {
+		return nil, errgo.Mask(err)
 	}
 	var results []params.Resource
-	for _, res := range resources {
-		result := params.Resource2API(res)
-		results = append(results, result)
+	for _, doc := range docs {
+		res, err := resourceFromDoc(entity.CharmMeta.Resources[doc.Name], doc)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		results = append(results, params.Resource2API(res))
 	}
 	return results, nil
 }
 
-func basicListResources(entity *mongodoc.Entity) ([]resource.Resource, error) {
-	var resources []resource.Resource
-	for _, meta := range entity.CharmMeta.Resources {
-		// We use an origin of "upload" since resources cannot be uploaded yet.
-		resOrigin := resource.OriginUpload
-		res := resource.Resource{
+// resourceFromDoc returns the resource described by the given metadata
+// and resource revision document. Resources that have never been
+// uploaded (with a revision of -1) are reported with an "upload" origin
+// and no revision, fingerprint or size.
+func resourceFromDoc(meta resource.Meta, doc *mongodoc.Resource) (resource.Resource, error) {
+	if doc.Revision == -1 {
+		return resource.Resource{
 			Meta:   meta,
-			Origin: resOrigin,
-			// Revision, Fingerprint, and Size are not set.
-		}
-		resources = append(resources, res)
+			Origin: resource.OriginUpload,
+		}, nil
+	}
+	hash, err := hex.DecodeString(doc.BlobHash)
+	if err != nil {
+		return resource.Resource{}, errgo.Notef(err, "invalid hash for resource %q", doc.Name)
+	}
+	fp, err := resource.NewFingerprint(hash)
+	if err != nil {
+		return resource.Resource{}, errgo.Notef(err, "invalid fingerprint for resource %q", doc.Name)
 	}
-	resource.Sort(resources)
-	return resources, nil
+	return resource.Resource{
+		Meta:        meta,
+		Origin:      resource.OriginStore,
+		Revision:    doc.Revision,
+		Fingerprint: fp,
+		Size:        doc.Size,
+	}, nil
 }
 
 // POST id/resources/name
@@ -78,9 +102,72 @@ func (h *ReqHandler) serveResources(id *router.ResolvedURL, w http.ResponseWrite
 }
 
 func (h *ReqHandler) serveDownloadResource(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
-	return errNotImplemented
+	name, revision, err := parseResourcePath(req.URL.Path)
+	if err != nil {
+		return errgo.WithCausef(err, params.ErrNotFound, "")
+	}
+	ch, err := h.entityChannel(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	res, err := h.Store.ResolveResource(id, name, revision, ch)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	blob, err := h.Store.OpenResourceBlob(res)
+	if err != nil {
+		return errgo.Notef(err, "cannot open resource blob")
+	}
+	defer blob.Close()
+	header := w.Header()
+	setArchiveCacheControl(header, h.isPublic(id))
+	header.Set(params.ContentHashHeader, blob.Hash)
+	header.Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, req, "", res.UploadTime, blob)
+	return nil
 }
 
 func (h *ReqHandler) serveUploadResource(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
-	return errNotImplemented
+	// Make sure we consume the full request body, before responding.
+	// See serveArchive for the rationale.
+	defer io.Copy(ioutil.Discard, req.Body)
+	name, revision, err := parseResourcePath(req.URL.Path)
+	if err != nil {
+		return badRequestf(err, "invalid resource path")
+	}
+	if revision != -1 {
+		return badRequestf(nil, "revision specified, but should not be specified")
+	}
+	hash := req.Form.Get("hash")
+	if hash == "" {
+		return badRequestf(nil, "hash parameter not specified")
+	}
+	if req.ContentLength == -1 {
+		return badRequestf(nil, "Content-Length not specified")
+	}
+	res, err := h.Store.UploadResource(id, name, req.Body, hash, req.ContentLength)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrForbidden))
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, &params.ResourceUploadResponse{
+		Revision: res.Revision,
+	})
+}
+
+// parseResourcePath parses a path of the form "/name[/revision]",
+// as found after the resources element of a resources request.
+// If there is no revision, the returned revision is -1.
+func parseResourcePath(path string) (name string, revision int, err error) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		return "", -1, errgo.Newf("invalid resource path %q", path)
+	}
+	if len(parts) == 1 {
+		return parts[0], -1, nil
+	}
+	revision, err = strconv.Atoi(parts[1])
+	if err != nil || revision < 0 {
+		return "", -1, errgo.Newf("invalid resource revision %q", parts[1])
+	}
+	return parts[0], revision, nil
 }
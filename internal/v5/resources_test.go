@@ -0,0 +1,311 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable/resource"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type ResourceSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&ResourceSuite{})
+
+func (s *ResourceSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *ResourceSuite) TestUploadAndDownload(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+
+	for i, content := range []string{"first content", "second content"} {
+		s.assertUploadResource(c, id, "for-store", content, i)
+	}
+
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store", "second content")
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store/0", "first content")
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store/1", "second content")
+}
+
+func (s *ResourceSuite) TestMetaResources(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	s.assertUploadResource(c, id, "for-store", "first content", 0)
+	s.assertUploadResource(c, id, "for-store", "second content", 1)
+
+	content := "second content"
+	fp, err := resource.GenerateFingerprint(strings.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/starsay-0/meta/resources"),
+		ExpectBody: []params.Resource{{
+			Name:        "for-install",
+			Type:        "file",
+			Path:        "initial.tgz",
+			Description: "get things started",
+			Origin:      "upload",
+		}, {
+			Name:        "for-store",
+			Type:        "file",
+			Path:        "dummy.tgz",
+			Description: "One line that is useful when operators need to push it.",
+			Origin:      "store",
+			Revision:    1,
+			Fingerprint: fp.Bytes(),
+			Size:        int64(len(content)),
+		}, {
+			Name:        "for-upload",
+			Type:        "file",
+			Path:        "config.xml",
+			Description: "Who uses xml anymore?",
+			Origin:      "upload",
+		}},
+	})
+}
+
+func (s *ResourceSuite) TestDownloadNotFound(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	s.assertUploadResource(c, id, "for-store", "content", 0)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/trusty/starsay-0/resources/for-install"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `resource "for-install" not found`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/trusty/starsay-0/resources/for-store/1"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `resource "for-store" revision 1 not found`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/trusty/starsay-0/resources/for-store/bad"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `invalid resource revision "bad"`,
+		},
+	})
+}
+
+func (s *ResourceSuite) TestPublishWithResources(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	s.assertUploadResource(c, id, "for-store", "first content", 0)
+	s.assertUploadResource(c, id, "for-store", "second content", 1)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/starsay-0/publish"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Username: testUsername,
+		Password: testPassword,
+		Body: strings.NewReader(mustMarshalJSON(params.PublishRequest{
+			Channels:  []params.Channel{params.StableChannel},
+			Resources: map[string]int{"for-store": 0},
+		})),
+	})
+
+	// The published revision is used when no revision is specified.
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store", "first content")
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store?channel=stable", "first content")
+	s.assertDownloadResource(c, "~charmers/trusty/starsay-0/resources/for-store/1", "second content")
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/starsay-0/meta/resources"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resources []params.Resource
+	err := json.Unmarshal(rec.Body.Bytes(), &resources)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resources, gc.HasLen, 3)
+	c.Assert(resources[1].Name, gc.Equals, "for-store")
+	c.Assert(resources[1].Revision, gc.Equals, 0)
+}
+
+func (s *ResourceSuite) TestPublishWithUnknownResource(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	s.assertUploadResource(c, id, "for-store", "content", 0)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/trusty/starsay-0/publish"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Username: testUsername,
+		Password: testPassword,
+		Body: strings.NewReader(mustMarshalJSON(params.PublishRequest{
+			Channels:  []params.Channel{params.StableChannel},
+			Resources: map[string]int{"for-store": 1},
+		})),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `cannot publish charm or bundle: resource "for-store" revision 1 not found`,
+		},
+	})
+}
+
+var uploadResourceErrorsTests = []struct {
+	about        string
+	path         string
+	content      string
+	hash         string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "revision specified",
+	path:         "~charmers/trusty/starsay-0/resources/for-store/3",
+	content:      "content",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "revision specified, but should not be specified",
+	},
+}, {
+	about:        "no hash",
+	path:         "~charmers/trusty/starsay-0/resources/for-store",
+	content:      "content",
+	hash:         "-",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "hash parameter not specified",
+	},
+}, {
+	about:        "unknown resource",
+	path:         "~charmers/trusty/starsay-0/resources/no-such",
+	content:      "content",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `charm cs:~charmers/trusty/starsay-0 has no resource named "no-such"`,
+	},
+}, {
+	about:        "unknown charm",
+	path:         "~charmers/trusty/no-such-0/resources/for-store",
+	content:      "content",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `no matching charm or bundle for cs:~charmers/trusty/no-such-0`,
+	},
+}}
+
+func (s *ResourceSuite) TestUploadErrors(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	for i, test := range uploadResourceErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		path := test.path
+		switch test.hash {
+		case "":
+			path += "?hash=" + hashOfBytes([]byte(test.content))
+		case "-":
+		default:
+			path += "?hash=" + test.hash
+		}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:       s.srv,
+			URL:           storeURL(path),
+			Method:        "POST",
+			ContentLength: int64(len(test.content)),
+			Body:          strings.NewReader(test.content),
+			Username:      testUsername,
+			Password:      testPassword,
+			ExpectStatus:  test.expectStatus,
+			ExpectBody:    test.expectBody,
+		})
+	}
+}
+
+func (s *ResourceSuite) TestUploadUnauthorized(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	content := "content"
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL("~charmers/trusty/starsay-0/resources/for-store?hash=" + hashOfBytes([]byte(content))),
+		Method:        "POST",
+		ContentLength: int64(len(content)),
+		Body:          strings.NewReader(content),
+		Do:            bakeryDo(nil),
+		ExpectStatus:  http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "bob"`,
+		},
+	})
+}
+
+func (s *ResourceSuite) TestMethodNotAllowed(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/trusty/starsay-0", -1)
+	s.addPublicCharm(c, storetesting.Charms.CharmDir("starsay"), id)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/trusty/starsay-0/resources/for-store"),
+		Method:       "PUT",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: params.Error{
+			Code:    params.ErrMethodNotAllowed,
+			Message: "PUT not allowed",
+		},
+	})
+}
+
+func (s *ResourceSuite) assertUploadResource(c *gc.C, id *router.ResolvedURL, name, content string, expectRevision int) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL(fmt.Sprintf("%s/resources/%s?hash=%s", id.URL.Path(), name, hashOfBytes([]byte(content)))),
+		Method:        "POST",
+		ContentLength: int64(len(content)),
+		Body:          strings.NewReader(content),
+		Username:      testUsername,
+		Password:      testPassword,
+		ExpectBody: params.ResourceUploadResponse{
+			Revision: expectRevision,
+		},
+	})
+}
+
+func (s *ResourceSuite) assertDownloadResource(c *gc.C, path, expectContent string) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL(path),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, expectContent)
+	c.Assert(rec.Header().Get(params.ContentHashHeader), gc.Equals, hashOfBytes([]byte(expectContent)))
+	c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "application/octet-stream")
+}
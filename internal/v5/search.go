@@ -4,14 +4,18 @@
 package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/juju/utils/parallel"
 	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
 
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
@@ -19,13 +23,21 @@ import (
 
 const maxConcurrency = 20
 
-// GET search[?text=text][&autocomplete=1][&filter=value…][&limit=limit][&include=meta][&skip=count][&sort=field[+dir]]
+// GET search[?text=text][&autocomplete=1][&fuzzy=1][&filter=value…][&limit=limit][&include=meta][&skip=count][&sort=field[+dir]][&facets=facet[,facet…]]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-search
 func (h *ReqHandler) serveSearch(_ http.Header, req *http.Request) (interface{}, error) {
 	sp, err := ParseSearchParams(req)
 	if err != nil {
 		return "", err
 	}
+	h.addSearchAuth(&sp, req)
+	return h.Search(sp, req)
+}
+
+// addSearchAuth sets the Admin and Groups fields of sp according to
+// the authorization of the given request. Requests that cannot be
+// authorized are granted no privileges.
+func (h *ReqHandler) addSearchAuth(sp *charmstore.SearchParams, req *http.Request) {
 	auth, err := h.CheckRequest(req, nil, OpOther)
 	if err != nil {
 		logger.Infof("authorization failed on search request, granting no privileges: %v", err)
@@ -39,7 +51,25 @@ func (h *ReqHandler) serveSearch(_ http.Header, req *http.Request) (interface{},
 		}
 		sp.Groups = append(sp.Groups, groups...)
 	}
-	return h.Search(sp, req)
+}
+
+// SearchResponse holds the response from a search request. It holds
+// the same fields as params.SearchResponse, together with the facet
+// counts requested with the facets parameter and any entity names
+// suggested as alternative spellings of the search text.
+type SearchResponse struct {
+	SearchTime  time.Duration
+	Total       int
+	Results     []params.EntityResult
+	Facets      map[string][]FacetCount `json:",omitempty"`
+	Suggestions []string                `json:",omitempty"`
+}
+
+// FacetCount holds the number of charms and bundles matching
+// a search with a given value of a facet.
+type FacetCount struct {
+	Value string
+	Count int
 }
 
 // Search performs the search specified by SearchParams. If sp
@@ -51,11 +81,26 @@ func (h *ReqHandler) Search(sp charmstore.SearchParams, req *http.Request) (inte
 	if err != nil {
 		return nil, errgo.Notef(err, "error performing search")
 	}
-	return params.SearchResponse{
-		SearchTime: results.SearchTime,
-		Total:      results.Total,
-		Results:    h.addMetaData(results.Results, sp.Include, req),
-	}, nil
+	resp := SearchResponse{
+		SearchTime:  results.SearchTime,
+		Total:       results.Total,
+		Results:     h.addMetaData(results.Results, sp.Include, req),
+		Suggestions: results.Suggestions,
+	}
+	if len(results.Facets) > 0 {
+		resp.Facets = make(map[string][]FacetCount, len(results.Facets))
+		for facet, buckets := range results.Facets {
+			counts := make([]FacetCount, len(buckets))
+			for i, b := range buckets {
+				counts[i] = FacetCount{
+					Value: b.Value,
+					Count: b.Count,
+				}
+			}
+			resp.Facets[facet] = counts
+		}
+	}
+	return resp, nil
 }
 
 // addMetaData adds the requested meta data with the include list.
@@ -103,8 +148,77 @@ func (h *ReqHandler) addMetaData(results []*mongodoc.Entity, include []string, r
 
 // GET search/interesting[?limit=limit][&include=meta]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-searchinteresting
-func (h *ReqHandler) serveSearchInteresting(w http.ResponseWriter, req *http.Request) {
-	router.WriteError(w, errNotImplemented)
+func (h *ReqHandler) serveSearchInteresting(_ http.Header, req *http.Request) (interface{}, error) {
+	var sp charmstore.SearchParams
+	var err error
+	for k, v := range req.Form {
+		switch k {
+		case "limit":
+			sp.Limit, err = strconv.Atoi(v[0])
+			if err != nil {
+				return nil, badRequestf(err, "invalid limit parameter: could not parse integer")
+			}
+			if sp.Limit < 1 {
+				return nil, badRequestf(nil, "invalid limit parameter: expected integer greater than zero")
+			}
+		case "include":
+			for _, s := range v {
+				if s != "" {
+					sp.Include = append(sp.Include, s)
+				}
+			}
+		default:
+			return nil, badRequestf(nil, "invalid parameter: %s", k)
+		}
+	}
+	h.addSearchAuth(&sp, req)
+	results, err := h.Store.Interesting(sp)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find interesting entities")
+	}
+	return params.SearchResponse{
+		SearchTime: results.SearchTime,
+		Total:      results.Total,
+		Results:    h.addMetaData(results.Results, sp.Include, req),
+	}, nil
+}
+
+// GET search/featured
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#get-searchfeatured
+//
+// PUT search/featured
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#put-searchfeatured
+func (h *ReqHandler) serveSearchFeatured(_ http.Header, req *http.Request) (interface{}, error) {
+	// Only admins may curate the featured entities.
+	if _, err := h.authorize(req, nil, true, nil); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	switch req.Method {
+	case "GET":
+		urls, err := h.Store.Featured()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return urls, nil
+	case "PUT":
+		var urls []*charm.URL
+		if err := json.NewDecoder(req.Body).Decode(&urls); err != nil {
+			return nil, badRequestf(err, "cannot unmarshal featured entities")
+		}
+		for _, url := range urls {
+			if url == nil || url.User == "" {
+				return nil, badRequestf(nil, "featured entity id %q does not specify a user", url)
+			}
+		}
+		if err := h.Store.SetFeatured(urls); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		h.addAudit(audit.Entry{
+			Op: audit.OpSetFeatured,
+		})
+		return nil, nil
+	}
+	return nil, errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
 }
 
 // ParseSearchParms extracts the search paramaters from the request
@@ -120,6 +234,11 @@ func ParseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 			if err != nil {
 				return charmstore.SearchParams{}, badRequestf(err, "invalid autocomplete parameter")
 			}
+		case "fuzzy":
+			sp.Fuzzy, err = router.ParseBool(v[0])
+			if err != nil {
+				return charmstore.SearchParams{}, badRequestf(err, "invalid fuzzy parameter")
+			}
 		case "limit":
 			sp.Limit, err = strconv.Atoi(v[0])
 			if err != nil {
@@ -134,7 +253,7 @@ func ParseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 					sp.Include = append(sp.Include, s)
 				}
 			}
-		case "description", "name", "owner", "provides", "requires", "series", "summary", "tags", "type":
+		case "action", "config-option", "description", "name", "owner", "provides", "requires", "series", "summary", "tags", "type":
 			if sp.Filters == nil {
 				sp.Filters = make(map[string][]string)
 			}
@@ -165,6 +284,10 @@ func ParseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 			if err != nil {
 				return charmstore.SearchParams{}, badRequestf(err, "invalid sort field")
 			}
+		case "facets":
+			if err := sp.ParseFacets(v...); err != nil {
+				return charmstore.SearchParams{}, badRequestf(err, "invalid facets parameter")
+			}
 		default:
 			return charmstore.SearchParams{}, badRequestf(nil, "invalid parameter: %s", k)
 		}
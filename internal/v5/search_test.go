@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/juju/loggo"
 	jc "github.com/juju/testing/checkers"
@@ -105,6 +106,16 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 		about:       "invalid autocomplete",
 		query:       "autocomplete=true",
 		expectError: `invalid autocomplete parameter: unexpected bool value "true" (must be "0" or "1")`,
+	}, {
+		about: "fuzzy",
+		query: "fuzzy=1",
+		expectParams: charmstore.SearchParams{
+			Fuzzy: true,
+		},
+	}, {
+		about:       "invalid fuzzy",
+		query:       "fuzzy=true",
+		expectError: `invalid fuzzy parameter: unexpected bool value "true" (must be "0" or "1")`,
 	}, {
 		about: "limit",
 		query: "limit=20",
@@ -177,6 +188,22 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 				"requires": {"text"},
 			},
 		},
+	}, {
+		about: "config-option filter",
+		query: "config-option=text",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"config-option": {"text"},
+			},
+		},
+	}, {
+		about: "action filter",
+		query: "action=text",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"action": {"text"},
+			},
+		},
 	}, {
 		about: "series filter",
 		query: "series=text",
@@ -241,6 +268,16 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 		about:       "promulgated filter - bad",
 		query:       "promulgated=bad",
 		expectError: `invalid promulgated filter parameter: unexpected bool value "bad" (must be "0" or "1")`,
+	}, {
+		about: "facets",
+		query: "facets=series,owner&facets=type",
+		expectParams: charmstore.SearchParams{
+			Facets: []string{"series", "owner", "type"},
+		},
+	}, {
+		about:       "invalid facets",
+		query:       "facets=series,bad",
+		expectError: `invalid facets parameter: unrecognized facet "bad"`,
 	}}
 	for i, test := range tests {
 		c.Logf("test %d. %s", i, test.about)
@@ -287,6 +324,13 @@ func (s *SearchSuite) TestSuccessfulSearches(c *gc.C) {
 			exportTestCharms["wordpress"],
 			exportTestBundles["wordpress-simple"],
 		},
+	}, {
+		about: "fuzzy search",
+		query: "text=wordprss&fuzzy=1",
+		results: []*router.ResolvedURL{
+			exportTestCharms["wordpress"],
+			exportTestBundles["wordpress-simple"],
+		},
 	}, {
 		about: "blank text search",
 		query: "text=",
@@ -327,6 +371,12 @@ func (s *SearchSuite) TestSuccessfulSearches(c *gc.C) {
 		results: []*router.ResolvedURL{
 			exportTestCharms["wordpress"],
 		},
+	}, {
+		about: "config-option filter search",
+		query: "config-option=blog-title",
+		results: []*router.ResolvedURL{
+			exportTestCharms["wordpress"],
+		},
 	}, {
 		about: "series filter search",
 		query: "series=trusty",
@@ -679,6 +729,55 @@ func (s *SearchSuite) TestSorting(c *gc.C) {
 	}
 }
 
+func (s *SearchSuite) TestFacets(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?type=charm&facets=owner,type&limit=1"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var sr v5.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Total, gc.Equals, 3)
+	c.Assert(sr.Results, gc.HasLen, 1)
+	c.Assert(sr.Facets, jc.DeepEquals, map[string][]v5.FacetCount{
+		"owner": {
+			{Value: "charmers", Count: 1},
+			{Value: "foo", Count: 1},
+			{Value: "openstack-charmers", Count: 1},
+		},
+		"type": {
+			{Value: "charm", Count: 3},
+		},
+	})
+}
+
+func (s *SearchSuite) TestNoFacetsInResponse(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?limit=1"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resp map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	_, ok := resp["Facets"]
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *SearchSuite) TestSuggestions(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?text=wordprss"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resp v5.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.Total, gc.Equals, 0)
+	c.Assert(resp.Suggestions, jc.DeepEquals, []string{"wordpress"})
+}
+
 func (s *SearchSuite) TestSortUnsupportedField(c *gc.C) {
 	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
 		Handler: s.srv,
@@ -723,6 +822,39 @@ func (s *SearchSuite) TestDownloadsBoost(c *gc.C) {
 	c.Assert(sr.Results[2].Id.Name, gc.Equals, "mysql")
 }
 
+func (s *SearchSuite) TestSortTrending(c *gc.C) {
+	patchLegacyDownloadCountsEnabled(s.AddCleanup, false)
+	now := time.Now()
+	// Old downloads count for less than recent ones.
+	downloads := map[string][]time.Time{
+		"mysql":     {now.Add(-20 * 24 * time.Hour), now.Add(-20 * 24 * time.Hour), now.Add(-20 * 24 * time.Hour)},
+		"wordpress": {now},
+		"varnish":   {now, now},
+	}
+	for n, times := range downloads {
+		url := newResolvedURL("cs:~trending-test/trusty/x-1", -1)
+		url.URL.Name = n
+		s.addPublicCharm(c, getSearchCharm(n), url)
+		for _, t := range times {
+			err := s.store.IncrementDownloadCountsAtTime(url, t)
+			c.Assert(err, gc.IsNil)
+		}
+	}
+	err := s.esSuite.ES.RefreshIndex(s.esSuite.TestIndex)
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?owner=trending-test&sort=-trending"),
+	})
+	var sr params.SearchResponse
+	err = json.Unmarshal(rec.Body.Bytes(), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Results, gc.HasLen, 3)
+	c.Assert(sr.Results[0].Id.Name, gc.Equals, "varnish")
+	c.Assert(sr.Results[1].Id.Name, gc.Equals, "wordpress")
+	c.Assert(sr.Results[2].Id.Name, gc.Equals, "mysql")
+}
+
 // TODO(mhilton) remove this test when removing legacy counts logic.
 func (s *SearchSuite) TestLegacyStatsUpdatesSearch(c *gc.C) {
 	patchLegacyDownloadCountsEnabled(s.AddCleanup, true)
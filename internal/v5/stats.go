@@ -4,12 +4,15 @@
 package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/juju/httprequest"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
 
@@ -40,9 +43,35 @@ func parseDateRange(form url.Values) (start, stop time.Time, err error) {
 	return
 }
 
-// GET stats/counter/key[:key]...?[by=unit]&start=date][&stop=date][&list=1]
+// GET stats/counter/key[:key]...?[by=unit]&start=date][&stop=date][&list=1][&format=format]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-statscounter
-func (h *ReqHandler) serveStatsCounter(_ http.Header, r *http.Request) (interface{}, error) {
+func (h *ReqHandler) serveStatsCounter(w http.ResponseWriter, r *http.Request) error {
+	format := r.Form.Get("format")
+	switch format {
+	case "", "json", "csv":
+	default:
+		return badRequestf(nil, "invalid 'format' value %q", format)
+	}
+	items, err := h.statsCounter(r)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if format != "csv" {
+		return httprequest.WriteJSON(w, http.StatusOK, items)
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Key", "Date", "Count"})
+	for _, item := range items {
+		cw.Write([]string{item.Key, item.Date, strconv.FormatInt(item.Count, 10)})
+	}
+	cw.Flush()
+	return errgo.Mask(cw.Error())
+}
+
+// statsCounter returns the statistics specified by the given
+// stats/counter request.
+func (h *ReqHandler) statsCounter(r *http.Request) ([]params.Statistic, error) {
 	base := strings.TrimPrefix(r.URL.Path, "/")
 	if strings.Index(base, "/") > 0 {
 		return nil, errgo.WithCausef(nil, params.ErrNotFound, "invalid key")
@@ -58,6 +87,8 @@ func (h *ReqHandler) serveStatsCounter(_ http.Header, r *http.Request) (interfac
 		by = charmstore.ByDay
 	case "week":
 		by = charmstore.ByWeek
+	case "month":
+		by = charmstore.ByMonth
 	default:
 		return nil, badRequestf(nil, "invalid 'by' value %q", v)
 	}
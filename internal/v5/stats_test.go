@@ -77,6 +77,11 @@ func (s *StatsSuite) TestServerStatsStatus(c *gc.C) {
 		status:  http.StatusBadRequest,
 		message: `invalid 'stop' value "3": parsing time "3" as "2006-01-02": cannot parse "3" as "2006"`,
 		code:    params.ErrBadRequest,
+	}, {
+		path:    "stats/counter/any?format=xml",
+		status:  http.StatusBadRequest,
+		message: `invalid 'format' value "xml"`,
+		code:    params.ErrBadRequest,
 	}}
 	for i, test := range tests {
 		c.Logf("test %d. %s", i, test.path)
@@ -656,6 +661,80 @@ func (s *StatsSuite) TestStatsCounterBy(c *gc.C) {
 	}
 }
 
+func (s *StatsSuite) TestStatsCounterByMonth(c *gc.C) {
+	incs := []struct {
+		key []string
+		t   time.Time
+	}{
+		{[]string{"a", "b"}, time.Date(2012, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{[]string{"a", "b"}, time.Date(2012, time.January, 31, 23, 59, 0, 0, time.UTC)},
+		{[]string{"a", "c"}, time.Date(2012, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{[]string{"a", "b"}, time.Date(2012, time.February, 29, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, inc := range incs {
+		err := s.store.IncCounterAtTime(inc.key, inc.t)
+		c.Assert(err, gc.IsNil)
+	}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("stats/counter/a:*?by=month&list=1"),
+		ExpectBody: []params.Statistic{{
+			Key:   "a:b",
+			Date:  "2012-01-01",
+			Count: 2,
+		}, {
+			Key:   "a:b",
+			Date:  "2012-02-01",
+			Count: 1,
+		}, {
+			Key:   "a:c",
+			Date:  "2012-02-01",
+			Count: 1,
+		}},
+	})
+}
+
+func (s *StatsSuite) TestStatsCounterCSV(c *gc.C) {
+	incs := []struct {
+		key []string
+		day int
+	}{
+		{[]string{"a", "b"}, 1},
+		{[]string{"a", "b"}, 1},
+		{[]string{"a", "c"}, 1},
+		{[]string{"a", "b"}, 3},
+	}
+	for i, inc := range incs {
+		t := time.Date(2012, time.May, inc.day, 0, 0, 0, 0, time.UTC)
+		t = t.Add(time.Duration(i) * charmstore.StatsGranularity)
+		err := s.store.IncCounterAtTime(inc.key, t)
+		c.Assert(err, gc.IsNil)
+	}
+	tests := []struct {
+		path       string
+		expectBody string
+	}{{
+		path:       "stats/counter/a:*?format=csv",
+		expectBody: "Key,Date,Count\n,,4\n",
+	}, {
+		path:       "stats/counter/a:*?format=csv&by=day&list=1",
+		expectBody: "Key,Date,Count\na:b,2012-05-01,2\na:c,2012-05-01,1\na:b,2012-05-03,1\n",
+	}, {
+		path:       "stats/counter/x?format=csv",
+		expectBody: "Key,Date,Count\n,,0\n",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d. %s", i, test.path)
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL(test.path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK)
+		c.Assert(rec.Header().Get("Content-Type"), gc.Equals, "text/csv; charset=utf-8")
+		c.Assert(rec.Body.String(), gc.Equals, test.expectBody)
+	}
+}
+
 func (s *StatsSuite) TestStatsEnabled(c *gc.C) {
 	statsEnabled := func(url string) bool {
 		req, _ := http.NewRequest("GET", url, nil)
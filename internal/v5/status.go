@@ -15,6 +15,7 @@ import (
 	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
 	"gopkg.in/mgo.v2/bson"
 
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 )
 
@@ -39,6 +40,7 @@ func (h *ReqHandler) serveDebugStatus(_ http.Header, req *http.Request) (interfa
 			mongodoc.LegacyStatisticsType,
 			params.LegacyStatisticsImportStart, params.LegacyStatisticsImportComplete,
 		),
+		h.checkBlobIntegrity,
 	), nil
 }
 
@@ -105,6 +107,36 @@ func (h *ReqHandler) checkBaseEntities() (key string, result debugstatus.CheckRe
 	return resultKey, result
 }
 
+func (h *ReqHandler) checkBlobIntegrity() (key string, result debugstatus.CheckResult) {
+	resultKey := "blob_integrity"
+	result.Name = "Blob integrity"
+	start, end, err := h.findTimesInLogs(mongodoc.IntegrityType, charmstore.BlobScrubStart, charmstore.BlobScrubComplete)
+	if err != nil {
+		result.Value = err.Error()
+		return resultKey, result
+	}
+	if start.IsZero() {
+		result.Value = "no blob integrity scrub recorded"
+		result.Passed = true
+		return resultKey, result
+	}
+
+	// Count the archives that failed the check since
+	// the most recent scrub started.
+	failed, err := h.Store.DB.Logs().Find(bson.D{
+		{"level", mongodoc.ErrorLevel},
+		{"type", mongodoc.IntegrityType},
+		{"time", bson.D{{"$gte", start}}},
+	}).Count()
+	if err != nil {
+		result.Value = "Cannot count integrity failures: " + err.Error()
+		return resultKey, result
+	}
+	result.Value = fmt.Sprintf("started: %s, completed: %s, failed archives: %d", start.Format(time.RFC3339), end.Format(time.RFC3339), failed)
+	result.Passed = failed == 0
+	return resultKey, result
+}
+
 func (h *ReqHandler) checkLogs(
 	resultKey, resultName string,
 	logType mongodoc.LogType,
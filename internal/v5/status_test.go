@@ -107,6 +107,11 @@ func (s *APISuite) TestStatus(c *gc.C) {
 			Value:  "started: " + statisticsStart.Format(time.RFC3339) + ", completed: " + statisticsEnd.Format(time.RFC3339),
 			Passed: true,
 		},
+		"blob_integrity": {
+			Name:   "Blob integrity",
+			Value:  "no blob integrity scrub recorded",
+			Passed: true,
+		},
 	})
 }
 
@@ -214,6 +219,39 @@ func (s *APISuite) TestStatusLegacyStatisticsMultipleLogs(c *gc.C) {
 	})
 }
 
+func (s *APISuite) TestStatusBlobIntegrity(c *gc.C) {
+	now := time.Now()
+	for _, log := range []struct {
+		level mongodoc.LogLevel
+		msg   string
+		time  time.Time
+	}{
+		{mongodoc.InfoLevel, "blob integrity scrub started", now.Add(-3 * time.Hour)},
+		{mongodoc.ErrorLevel, "archive of cs:~charmers/precise/wordpress-0 failed integrity check", now.Add(-150 * time.Minute)},
+		{mongodoc.InfoLevel, "blob integrity scrub completed: 3 archives checked, 1 failed", now.Add(-2 * time.Hour)},
+		{mongodoc.InfoLevel, "blob integrity scrub started", now.Add(-1 * time.Hour)},
+		{mongodoc.ErrorLevel, "archive of cs:~charmers/precise/mysql-0 failed integrity check", now.Add(-50 * time.Minute)},
+		{mongodoc.InfoLevel, "blob integrity scrub completed: 3 archives checked, 1 failed", now.Add(-30 * time.Minute)},
+	} {
+		data, err := json.Marshal(log.msg)
+		c.Assert(err, gc.IsNil)
+		s.addLog(c, &mongodoc.Log{
+			Data:  data,
+			Level: log.level,
+			Type:  mongodoc.IntegrityType,
+			Time:  log.time,
+		})
+	}
+	// Only the failure found by the most recent scrub is counted.
+	s.AssertDebugStatus(c, false, map[string]params.DebugStatus{
+		"blob_integrity": {
+			Name:   "Blob integrity",
+			Value:  "started: " + now.Add(-1*time.Hour).Format(time.RFC3339) + ", completed: " + now.Add(-30*time.Minute).Format(time.RFC3339) + ", failed archives: 1",
+			Passed: false,
+		},
+	})
+}
+
 func (s *APISuite) TestStatusBaseEntitiesError(c *gc.C) {
 	// Add a base entity without any corresponding entities.
 	entity := &mongodoc.BaseEntity{
@@ -0,0 +1,183 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// UploadInfo holds information on a chunked archive upload
+// as returned from the upload endpoints.
+type UploadInfo struct {
+	// Id holds the id of the upload, used to add parts
+	// and to commit the upload with id/archive.
+	Id string
+
+	// Size holds the total size of the parts uploaded so far,
+	// which is also the offset of the next part.
+	Size int64
+
+	// Expires holds the time after which the upload will be
+	// discarded if no more parts have been added.
+	Expires time.Time
+}
+
+// POST /upload?id=entity-id
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#post-upload
+//
+// GET /upload/upload-id
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#get-uploadupload-id
+//
+// PUT /upload/upload-id?offset=offset&hash=sha384hash
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#put-uploadupload-id
+//
+// DELETE /upload/upload-id
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#delete-uploadupload-id
+func (h *ReqHandler) serveUpload(w http.ResponseWriter, req *http.Request) error {
+	// Make sure we consume the full request body, before responding.
+	defer io.Copy(ioutil.Discard, req.Body)
+	uploadId := strings.TrimPrefix(req.URL.Path, "/")
+	if uploadId == "" {
+		if req.Method != "POST" {
+			return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+		}
+		return h.serveNewUpload(w, req)
+	}
+	auth, err := h.authorize(req, []string{params.Everyone}, true, nil)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	u, err := h.upload(uploadId, auth)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	switch req.Method {
+	case "GET":
+		return httprequest.WriteJSON(w, http.StatusOK, uploadInfo(u))
+	case "PUT":
+		return h.putUploadPart(u, w, req)
+	case "DELETE":
+		if err := h.Store.RemoveUpload(u.Id); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+}
+
+// serveNewUpload creates a new upload for the entity specified by
+// the id parameter, which the user must have permission to upload.
+func (h *ReqHandler) serveNewUpload(w http.ResponseWriter, req *http.Request) error {
+	idStr := req.Form.Get("id")
+	if idStr == "" {
+		return badRequestf(nil, "id parameter not specified")
+	}
+	id, err := charm.ParseURL(idStr)
+	if err != nil {
+		return badRequestf(err, "invalid id parameter %q", idStr)
+	}
+	if err := h.authorizeUpload(id, req); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	u, err := h.Store.NewUpload(h.auth.Username, mongodoc.BaseURL(id.WithChannel("")))
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrForbidden))
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, uploadInfo(u))
+}
+
+func (h *ReqHandler) putUploadPart(u *mongodoc.Upload, w http.ResponseWriter, req *http.Request) error {
+	offsetStr := req.Form.Get("offset")
+	if offsetStr == "" {
+		return badRequestf(nil, "offset parameter not specified")
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil {
+		return badRequestf(nil, "invalid offset parameter %q", offsetStr)
+	}
+	hash := req.Form.Get("hash")
+	if hash == "" {
+		return badRequestf(nil, "hash parameter not specified")
+	}
+	if req.ContentLength == -1 {
+		return badRequestf(nil, "Content-Length not specified")
+	}
+	u, err = h.Store.AddUploadPart(u.Id, offset, req.Body, req.ContentLength, hash)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrInvalidEntity))
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, uploadInfo(u))
+}
+
+// upload returns the upload with the given id, checking that
+// it is owned by the user with the given authorization.
+func (h *ReqHandler) upload(uploadId string, auth authorization) (*mongodoc.Upload, error) {
+	u, err := h.Store.Upload(uploadId)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if !auth.Admin && u.Owner != auth.Username {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "upload %q is owned by another user", uploadId)
+	}
+	return u, nil
+}
+
+// uploadedArchive returns the archive content for an upload request
+// of the entity with the given id and its size. If the request has an
+// upload parameter, the content is read from that chunked upload, which
+// must have been created for the entity and is also returned; otherwise
+// it is read from the request body. The returned reader should be
+// closed after use.
+func (h *ReqHandler) uploadedArchive(id *charm.URL, req *http.Request) (io.ReadCloser, int64, *mongodoc.Upload, error) {
+	uploadId := req.Form.Get("upload")
+	if uploadId == "" {
+		if req.ContentLength == -1 {
+			return nil, 0, nil, badRequestf(nil, "Content-Length not specified")
+		}
+		return ioutil.NopCloser(req.Body), req.ContentLength, nil, nil
+	}
+	u, err := h.upload(uploadId, h.auth)
+	if err != nil {
+		return nil, 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	if baseURL := mongodoc.BaseURL(id.WithChannel("")); u.BaseURL == nil || u.BaseURL.String() != baseURL.String() {
+		return nil, 0, nil, badRequestf(nil, "upload %q was not created for %v", uploadId, baseURL)
+	}
+	r, err := h.Store.OpenUpload(u)
+	if err != nil {
+		return nil, 0, nil, errgo.Mask(err)
+	}
+	return r, u.Size, u, nil
+}
+
+// removeUpload removes the given chunked upload once its content
+// has been stored. It does nothing if u is nil.
+func (h *ReqHandler) removeUpload(u *mongodoc.Upload) {
+	if u == nil {
+		return
+	}
+	if err := h.Store.RemoveUpload(u.Id); err != nil {
+		logger.Errorf("cannot remove upload %s: %v", u.Id, err)
+	}
+}
+
+func uploadInfo(u *mongodoc.Upload) *UploadInfo {
+	return &UploadInfo{
+		Id:      u.Id,
+		Size:    u.Size,
+		Expires: u.Expires,
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+)
+
+type uploadSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&uploadSuite{})
+
+func (s *uploadSuite) SetUpSuite(c *gc.C) {
+	// Allow the small parts used by the tests.
+	s.minUploadPartSize = 1
+	s.commonSuite.SetUpSuite(c)
+}
+
+// doAsUser makes a request to the given path as the given user.
+func (s *uploadSuite) doAsUser(c *gc.C, user, method, path string, body []byte) *httptest.ResponseRecorder {
+	return httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:       s.srv,
+		Do:            s.bakeryDoAsUser(c, user),
+		URL:           storeURL(path),
+		Method:        method,
+		ContentLength: int64(len(body)),
+		Body:          bytes.NewReader(body),
+	})
+}
+
+// assertUploadInfo asserts that the given response holds
+// information on the upload with the given id and size.
+func assertUploadInfo(c *gc.C, rec *httptest.ResponseRecorder, id string, size int64) v5.UploadInfo {
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body))
+	var info v5.UploadInfo
+	err := json.Unmarshal(rec.Body.Bytes(), &info)
+	c.Assert(err, gc.IsNil)
+	if id != "" {
+		c.Assert(info.Id, gc.Equals, id)
+	}
+	c.Assert(info.Id, gc.Not(gc.Equals), "")
+	c.Assert(info.Size, gc.Equals, size)
+	c.Assert(info.Expires.IsZero(), gc.Equals, false)
+	return info
+}
+
+func (s *uploadSuite) TestChunkedUpload(c *gc.C) {
+	blob, hash := getBlob(storetesting.Charms.CharmDir("wordpress"))
+	data := blob.Bytes()
+
+	info := assertUploadInfo(c, s.doAsUser(c, "charmers", "POST", "upload?id=~charmers/wordpress", nil), "", 0)
+	uploadPath := "upload/" + info.Id
+
+	// Upload the archive in three parts.
+	third := len(data) / 3
+	parts := [][]byte{data[:third], data[third : 2*third], data[2*third:]}
+	offset := 0
+	for _, part := range parts {
+		path := fmt.Sprintf("%s?offset=%d&hash=%s", uploadPath, offset, hashOfBytes(part))
+		offset += len(part)
+		assertUploadInfo(c, s.doAsUser(c, "charmers", "PUT", path, part), info.Id, int64(offset))
+	}
+
+	// Sending the last part again has no effect.
+	path := fmt.Sprintf("%s?offset=%d&hash=%s", uploadPath, 2*third, hashOfBytes(parts[2]))
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "PUT", path, parts[2]), info.Id, int64(len(data)))
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "GET", uploadPath, nil), info.Id, int64(len(data)))
+
+	// Commit the upload.
+	rec := s.doAsUser(c, "charmers", "POST", "~charmers/precise/wordpress/archive?hash="+hash+"&upload="+info.Id, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body))
+	c.Assert(rec.Body.String(), jc.JSONEquals, params.ArchiveUploadResponse{
+		Id: charm.MustParseURL("~charmers/precise/wordpress-0"),
+	})
+	entity, err := s.store.FindEntity(newResolvedURL("~charmers/precise/wordpress-0", -1), charmstore.FieldSelector("blobhash", "size"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.BlobHash, gc.Equals, hash)
+	c.Assert(entity.Size, gc.Equals, int64(len(data)))
+
+	// The upload has been removed.
+	rec = s.doAsUser(c, "charmers", "GET", uploadPath, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusNotFound)
+	n, err := s.store.DB.Uploads().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *uploadSuite) TestChunkedUploadWithPut(c *gc.C) {
+	blob, hash := getBlob(storetesting.Charms.CharmDir("wordpress"))
+	data := blob.Bytes()
+	info := assertUploadInfo(c, s.doAsUser(c, "charmers", "POST", "upload?id=~charmers/wordpress", nil), "", 0)
+	path := fmt.Sprintf("upload/%s?offset=0&hash=%s", info.Id, hash)
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "PUT", path, data), info.Id, int64(len(data)))
+
+	rec := s.doAsUser(c, "charmers", "PUT", "~charmers/precise/wordpress-3/archive?hash="+hash+"&upload="+info.Id, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body))
+	c.Assert(rec.Body.String(), jc.JSONEquals, params.ArchiveUploadResponse{
+		Id: charm.MustParseURL("~charmers/precise/wordpress-3"),
+	})
+}
+
+func (s *uploadSuite) TestCommitWithWrongHashKeepsUpload(c *gc.C) {
+	blob, _ := getBlob(storetesting.Charms.CharmDir("wordpress"))
+	data := blob.Bytes()
+	info := assertUploadInfo(c, s.doAsUser(c, "charmers", "POST", "upload?id=~charmers/wordpress", nil), "", 0)
+	path := fmt.Sprintf("upload/%s?offset=0&hash=%s", info.Id, hashOfBytes(data))
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "PUT", path, data), info.Id, int64(len(data)))
+
+	rec := s.doAsUser(c, "charmers", "POST", "~charmers/precise/wordpress/archive?hash="+hashOfBytes(nil)+"&upload="+info.Id, nil)
+	c.Assert(rec.Code, gc.Not(gc.Equals), http.StatusOK)
+
+	// The upload is kept so that the commit can be retried.
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "GET", "upload/"+info.Id, nil), info.Id, int64(len(data)))
+}
+
+func (s *uploadSuite) TestCommitToAnotherEntity(c *gc.C) {
+	blob, hash := getBlob(storetesting.Charms.CharmDir("wordpress"))
+	data := blob.Bytes()
+	info := assertUploadInfo(c, s.doAsUser(c, "charmers", "POST", "upload?id=~charmers/wordpress", nil), "", 0)
+	path := fmt.Sprintf("upload/%s?offset=0&hash=%s", info.Id, hash)
+	assertUploadInfo(c, s.doAsUser(c, "charmers", "PUT", path, data), info.Id, int64(len(data)))
+
+	rec := s.doAsUser(c, "charmers", "POST", "~charmers/precise/mysql/archive?hash="+hash+"&upload="+info.Id, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusBadRequest)
+	c.Assert(rec.Body.String(), jc.JSONEquals, params.Error{
+		Message: fmt.Sprintf("upload %q was not created for cs:~charmers/mysql", info.Id),
+		Code:    params.ErrBadRequest,
+	})
+}
+
+var newUploadErrorsTests = []struct {
+	about         string
+	user          string
+	path          string
+	expectStatus  int
+	expectMessage string
+	expectCode    params.ErrorCode
+}{{
+	about:         "no id",
+	user:          "bob",
+	path:          "upload",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "id parameter not specified",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "invalid id",
+	user:          "bob",
+	path:          "upload?id=bad:wolf",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `invalid id parameter "bad:wolf": .*`,
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "no user in id",
+	user:          "bob",
+	path:          "upload?id=wordpress",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `user not specified in entity upload URL "cs:wordpress"`,
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "no write permission",
+	user:          "bob",
+	path:          "upload?id=~charmers/wordpress",
+	expectStatus:  http.StatusUnauthorized,
+	expectMessage: `unauthorized: access denied for user "bob"`,
+	expectCode:    params.ErrUnauthorized,
+}}
+
+func (s *uploadSuite) TestNewUploadErrors(c *gc.C) {
+	for i, test := range newUploadErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := s.doAsUser(c, test.user, "POST", test.path, nil)
+		c.Assert(rec.Code, gc.Equals, test.expectStatus, gc.Commentf("body: %s", rec.Body))
+		var perr params.Error
+		err := json.Unmarshal(rec.Body.Bytes(), &perr)
+		c.Assert(err, gc.IsNil)
+		c.Assert(perr.Message, gc.Matches, test.expectMessage)
+		c.Assert(perr.Code, gc.Equals, test.expectCode)
+	}
+	n, err := s.store.DB.Uploads().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *uploadSuite) TestTooManyOpenUploads(c *gc.C) {
+	for i := 0; i < charmstore.DefaultMaxOpenUploads; i++ {
+		assertUploadInfo(c, s.doAsUser(c, "bob", "POST", "upload?id=~bob/wordpress", nil), "", 0)
+	}
+	rec := s.doAsUser(c, "bob", "POST", "upload?id=~bob/mysql", nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusForbidden)
+	c.Assert(rec.Body.String(), jc.JSONEquals, params.Error{
+		Message: fmt.Sprintf("too many open uploads (maximum %d)", charmstore.DefaultMaxOpenUploads),
+		Code:    params.ErrForbidden,
+	})
+}
+
+func (s *uploadSuite) TestDeleteUpload(c *gc.C) {
+	info := assertUploadInfo(c, s.doAsUser(c, "bob", "POST", "upload?id=~bob/wordpress", nil), "", 0)
+	part := []byte("some data")
+	path := fmt.Sprintf("upload/%s?offset=0&hash=%s", info.Id, hashOfBytes(part))
+	assertUploadInfo(c, s.doAsUser(c, "bob", "PUT", path, part), info.Id, int64(len(part)))
+
+	rec := s.doAsUser(c, "bob", "DELETE", "upload/"+info.Id, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body))
+	rec = s.doAsUser(c, "bob", "GET", "upload/"+info.Id, nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusNotFound)
+}
+
+func (s *uploadSuite) TestUploadOwnedByAnotherUser(c *gc.C) {
+	info := assertUploadInfo(c, s.doAsUser(c, "bob", "POST", "upload?id=~bob/wordpress", nil), "", 0)
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		rec := s.doAsUser(c, "alice", method, "upload/"+info.Id+"?offset=0&hash=x", []byte("x"))
+		c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized, gc.Commentf("method %s", method))
+	}
+	// An administrator can access the upload.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload/" + info.Id),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	assertUploadInfo(c, rec, info.Id, 0)
+}
+
+var uploadErrorsTests = []struct {
+	about         string
+	method        string
+	path          string
+	body          string
+	expectStatus  int
+	expectMessage string
+	expectCode    params.ErrorCode
+}{{
+	about:         "no offset",
+	method:        "PUT",
+	path:          "?hash=x",
+	body:          "data",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "offset parameter not specified",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "invalid offset",
+	method:        "PUT",
+	path:          "?offset=x&hash=x",
+	body:          "data",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: `invalid offset parameter "x"`,
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "no hash",
+	method:        "PUT",
+	path:          "?offset=0",
+	body:          "data",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "hash parameter not specified",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "wrong offset",
+	method:        "PUT",
+	path:          "?offset=5&hash=x",
+	body:          "data",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "offset 5 does not match upload size 0",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "empty part",
+	method:        "PUT",
+	path:          "?offset=0&hash=x",
+	expectStatus:  http.StatusBadRequest,
+	expectMessage: "empty upload part",
+	expectCode:    params.ErrBadRequest,
+}, {
+	about:         "method not allowed",
+	method:        "POST",
+	expectStatus:  http.StatusMethodNotAllowed,
+	expectMessage: "POST not allowed",
+	expectCode:    params.ErrMethodNotAllowed,
+}}
+
+func (s *uploadSuite) TestUploadErrors(c *gc.C) {
+	info := assertUploadInfo(c, s.doAsUser(c, "bob", "POST", "upload?id=~bob/wordpress", nil), "", 0)
+	for i, test := range uploadErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		rec := s.doAsUser(c, "bob", test.method, "upload/"+info.Id+test.path, []byte(test.body))
+		c.Assert(rec.Code, gc.Equals, test.expectStatus, gc.Commentf("body: %s", rec.Body))
+		c.Assert(rec.Body.String(), jc.JSONEquals, params.Error{
+			Message: test.expectMessage,
+			Code:    test.expectCode,
+		})
+	}
+}
+
+func (s *uploadSuite) TestUploadNotFound(c *gc.C) {
+	rec := s.doAsUser(c, "bob", "GET", "upload/unknown", nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusNotFound)
+	c.Assert(rec.Body.String(), jc.JSONEquals, params.Error{
+		Message: `upload "unknown" not found`,
+		Code:    params.ErrNotFound,
+	})
+	rec = s.doAsUser(c, "charmers", "POST", "~charmers/precise/wordpress/archive?hash=x&upload=unknown", nil)
+	c.Assert(rec.Code, gc.Equals, http.StatusNotFound)
+}
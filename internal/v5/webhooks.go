@@ -0,0 +1,111 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// Webhook holds a webhook as sent to and returned from
+// the meta/webhooks endpoint.
+type Webhook struct {
+	// URL holds the URL that notifications are POSTed to.
+	URL string
+
+	// Secret holds the key used to sign notifications.
+	// It is never returned by the charm store.
+	Secret string `json:",omitempty"`
+}
+
+// GET id/meta/webhooks
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#get-idmetawebhooks
+func (h *ReqHandler) metaWebhooks(entity *mongodoc.BaseEntity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	// The webhook URLs may reveal details of the services that
+	// receive them, so only users that can change the webhooks
+	// are allowed to see them.
+	acls, err := h.entityACLs(id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if _, err := h.authorize(req, acls.Write, true, id); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	hooks := make([]Webhook, len(entity.Webhooks))
+	for i, hook := range entity.Webhooks {
+		// Do not reveal the secrets.
+		hooks[i] = Webhook{
+			URL: hook.URL,
+		}
+	}
+	return hooks, nil
+}
+
+// PUT id/meta/webhooks
+// https://github.com/juju/charmstore/blob/v5/docs/API.md#put-idmetawebhooks
+func (h *ReqHandler) putMetaWebhooks(id *router.ResolvedURL, path string, val *json.RawMessage, updater *router.FieldUpdater, req *http.Request) error {
+	var hooks []Webhook
+	if err := json.Unmarshal(*val, &hooks); err != nil {
+		return badRequestf(err, "cannot unmarshal webhooks")
+	}
+	docs := make([]mongodoc.Webhook, len(hooks))
+	for i, hook := range hooks {
+		if err := checkWebhookURL(hook.URL); err != nil {
+			return badRequestf(err, "invalid webhook URL %q", hook.URL)
+		}
+		docs[i] = mongodoc.Webhook{
+			URL:    hook.URL,
+			Secret: hook.Secret,
+		}
+	}
+	var fieldVal interface{}
+	if len(docs) > 0 {
+		fieldVal = docs
+	}
+	updater.UpdateField("webhooks", fieldVal, &audit.Entry{
+		Op:     audit.OpSetWebhooks,
+		Entity: &id.URL,
+	})
+	return nil
+}
+
+// checkWebhookURL checks that the given webhook URL is an absolute
+// HTTP or HTTPS URL that does not refer to a local or private address.
+// Host names are checked again when notifications are delivered,
+// after they have been resolved.
+func checkWebhookURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errgo.New("URL scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errgo.New("URL has no host")
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if strings.ToLower(host) == "localhost" {
+		return errgo.New("URL host must not be local")
+	}
+	if ip := net.ParseIP(host); ip != nil && !charmstore.IsPublicAddress(ip) {
+		return errgo.New("URL host must not be a local or private address")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v5_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v2-unstable/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v5"
+)
+
+type webhooksSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&webhooksSuite{})
+
+func (s *webhooksSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *webhooksSuite) TestGetWebhooksRequiresWriteAccess(c *gc.C) {
+	id := newResolvedURL("~charmers/precise/wordpress-23", 23)
+	s.addPublicCharmFromRepo(c, "wordpress", id)
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/webhooks", []v5.Webhook{{
+		URL: "http://example.com/hook",
+	}})
+
+	// Users that can write to the entity can see the webhooks.
+	s.doAsUser("charmers", func() {
+		s.assertGet(c, "~charmers/precise/wordpress-23/meta/webhooks", []v5.Webhook{{
+			URL: "http://example.com/hook",
+		}})
+	})
+
+	// Users that can only read the entity cannot.
+	s.doAsUser("bob", func() {
+		s.assertGetIsUnauthorized(c, "~charmers/precise/wordpress-23/meta/webhooks", `unauthorized: access denied for user "bob"`)
+	})
+}
+
+func (s *webhooksSuite) TestPutAndGetWebhooks(c *gc.C) {
+	id := newResolvedURL("~charmers/precise/wordpress-23", 23)
+	s.addPublicCharmFromRepo(c, "wordpress", id)
+
+	var calledEntities []audit.Entry
+	s.PatchValue(v5.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/webhooks", []v5.Webhook{{
+		URL:    "http://example.com/hook1",
+		Secret: "secret",
+	}, {
+		URL: "https://example.com/hook2",
+	}})
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:   "admin",
+		Op:     audit.OpSetWebhooks,
+		Entity: &id.URL,
+	}})
+
+	// The webhooks are stored in the base entity.
+	entity, err := s.store.FindBaseEntity(&id.URL, charmstore.FieldSelector("webhooks"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Webhooks, jc.DeepEquals, []mongodoc.Webhook{{
+		URL:    "http://example.com/hook1",
+		Secret: "secret",
+	}, {
+		URL: "https://example.com/hook2",
+	}})
+
+	// The secrets are not returned.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress-23/meta/webhooks"),
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: []v5.Webhook{{
+			URL: "http://example.com/hook1",
+		}, {
+			URL: "https://example.com/hook2",
+		}},
+	})
+
+	// Putting an empty list removes the webhooks.
+	s.assertPutAsAdmin(c, "~charmers/precise/wordpress-23/meta/webhooks", []v5.Webhook{})
+	entity, err = s.store.FindBaseEntity(charm.MustParseURL("~charmers/wordpress"), charmstore.FieldSelector("webhooks"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Webhooks, gc.HasLen, 0)
+}
+
+var putWebhooksErrorsTests = []struct {
+	about         string
+	hooks         []v5.Webhook
+	expectMessage string
+}{{
+	about: "unsupported scheme",
+	hooks: []v5.Webhook{{
+		URL: "ftp://example.com/hook",
+	}},
+	expectMessage: `invalid webhook URL "ftp://example.com/hook": URL scheme must be http or https`,
+}, {
+	about: "relative URL",
+	hooks: []v5.Webhook{{
+		URL: "/hook",
+	}},
+	expectMessage: `invalid webhook URL "/hook": URL scheme must be http or https`,
+}, {
+	about: "no host",
+	hooks: []v5.Webhook{{
+		URL: "http:///hook",
+	}},
+	expectMessage: `invalid webhook URL "http:///hook": URL has no host`,
+}, {
+	about: "localhost",
+	hooks: []v5.Webhook{{
+		URL: "http://localhost:8080/hook",
+	}},
+	expectMessage: `invalid webhook URL "http://localhost:8080/hook": URL host must not be local`,
+}, {
+	about: "loopback address",
+	hooks: []v5.Webhook{{
+		URL: "http://127.0.0.1/hook",
+	}},
+	expectMessage: `invalid webhook URL "http://127.0.0.1/hook": URL host must not be a local or private address`,
+}, {
+	about: "link-local address",
+	hooks: []v5.Webhook{{
+		URL: "http://169.254.169.254/latest/meta-data",
+	}},
+	expectMessage: `invalid webhook URL "http://169.254.169.254/latest/meta-data": URL host must not be a local or private address`,
+}, {
+	about: "private IPv6 address",
+	hooks: []v5.Webhook{{
+		URL: "https://[fd00::1]:8443/hook",
+	}},
+	expectMessage: `invalid webhook URL "https://[fd00::1]:8443/hook": URL host must not be a local or private address`,
+}}
+
+func (s *webhooksSuite) TestPutWebhooksErrors(c *gc.C) {
+	s.addPublicCharmFromRepo(c, "wordpress", newResolvedURL("~charmers/precise/wordpress-23", 23))
+	for i, test := range putWebhooksErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		body, err := json.Marshal(test.hooks)
+		c.Assert(err, gc.IsNil)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:  s.srv,
+			URL:      storeURL("~charmers/precise/wordpress-23/meta/webhooks"),
+			Method:   "PUT",
+			Username: testUsername,
+			Password: testPassword,
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Body:         bytes.NewReader(body),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Message: test.expectMessage,
+				Code:    params.ErrBadRequest,
+			},
+		})
+	}
+}